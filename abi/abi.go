@@ -0,0 +1,291 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package abi implements the Solidity contract ABI: the parsing of JSON ABIs
+// and the encoding of calls, return values, events and errors. Addresses are
+// encoded with the width of evm.Address.
+package abi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/lyonnee/evm"
+)
+
+// ABI holds the methods, events and errors of a contract.
+type ABI struct {
+	Constructor Method
+	Methods     map[string]Method
+	Events      map[string]Event
+	Errors      map[string]Error
+
+	// Fallback and Receive are the special functions of the contract, their
+	// Type being Fallback and Receive if the contract defines them.
+	Fallback Method
+	Receive  Method
+}
+
+var _ evm.ErrorDecoder = (*ABI)(nil)
+
+// JSON returns a parsed ABI from its JSON description.
+func JSON(reader io.Reader) (ABI, error) {
+	dec := json.NewDecoder(reader)
+
+	var abi ABI
+	if err := dec.Decode(&abi); err != nil {
+		return ABI{}, err
+	}
+	return abi, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (abi *ABI) UnmarshalJSON(data []byte) error {
+	var fields []struct {
+		Type            string
+		Name            string
+		Inputs          []Argument
+		Outputs         []Argument
+		StateMutability string
+		Anonymous       bool
+
+		// Deprecated fields, replaced by StateMutability
+		Constant bool
+		Payable  bool
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	abi.Methods = make(map[string]Method)
+	abi.Events = make(map[string]Event)
+	abi.Errors = make(map[string]Error)
+	for _, field := range fields {
+		mutability := field.StateMutability
+		if mutability == "" {
+			switch {
+			case field.Constant:
+				mutability = "view"
+			case field.Payable:
+				mutability = "payable"
+			default:
+				mutability = "nonpayable"
+			}
+		}
+		switch field.Type {
+		case "constructor":
+			abi.Constructor = NewMethod("", "", Constructor, mutability, field.Inputs, nil)
+		case "function", "":
+			name := overloadedName(field.Name, func(s string) bool { _, ok := abi.Methods[s]; return ok })
+			abi.Methods[name] = NewMethod(name, field.Name, Function, mutability, field.Inputs, field.Outputs)
+		case "fallback":
+			abi.Fallback = NewMethod("", "", Fallback, mutability, nil, nil)
+		case "receive":
+			abi.Receive = NewMethod("", "", Receive, mutability, nil, nil)
+		case "event":
+			name := overloadedName(field.Name, func(s string) bool { _, ok := abi.Events[s]; return ok })
+			abi.Events[name] = NewEvent(name, field.Name, field.Anonymous, field.Inputs)
+		case "error":
+			// Errors are not overloaded in practice, the last one wins
+			abi.Errors[field.Name] = NewError(field.Name, field.Inputs)
+		default:
+			return fmt.Errorf("abi: could not recognize type %v of field %v", field.Type, field.Name)
+		}
+	}
+	return nil
+}
+
+// overloadedName returns the first name of rawName, rawName0, rawName1... not
+// taken yet.
+func overloadedName(rawName string, taken func(string) bool) string {
+	name := rawName
+	for i := 0; taken(name); i++ {
+		name = rawName + strconv.Itoa(i)
+	}
+	return name
+}
+
+// Pack returns the call data of the named method with the given arguments,
+// the selector followed by the encoded arguments. The empty name packs the
+// constructor arguments, to be appended to the contract code.
+func (abi ABI) Pack(name string, args ...interface{}) ([]byte, error) {
+	if name == "" {
+		return abi.Constructor.Inputs.Pack(args...)
+	}
+	method, ok := abi.Methods[name]
+	if !ok {
+		return nil, fmt.Errorf("abi: method '%s' not found", name)
+	}
+	enc, err := method.Inputs.Pack(args...)
+	if err != nil {
+		return nil, err
+	}
+	return append(evm.CopyBytes(method.ID), enc...), nil
+}
+
+// Unpack decodes the return values of the named method, or the non-indexed
+// arguments of the named event.
+func (abi ABI) Unpack(name string, data []byte) ([]interface{}, error) {
+	if method, ok := abi.Methods[name]; ok {
+		return method.Outputs.Unpack(data)
+	}
+	if event, ok := abi.Events[name]; ok {
+		return event.Inputs.NonIndexed().Unpack(data)
+	}
+	return nil, fmt.Errorf("abi: could not locate named method or event: %s", name)
+}
+
+// MethodById returns the method with the given selector, the first 4 bytes of
+// the call data.
+func (abi *ABI) MethodById(sigdata []byte) (*Method, error) {
+	if len(sigdata) < 4 {
+		return nil, fmt.Errorf("abi: data too short (%d bytes) for abi method lookup", len(sigdata))
+	}
+	for _, method := range abi.Methods {
+		if bytes.Equal(method.ID, sigdata[:4]) {
+			return &method, nil
+		}
+	}
+	return nil, fmt.Errorf("abi: no method with id: %#x", sigdata[:4])
+}
+
+// EventByID returns the event with the given topic, the first topic of its
+// logs.
+func (abi *ABI) EventByID(topic evm.Hash) (*Event, error) {
+	for _, event := range abi.Events {
+		if event.ID == topic && !event.Anonymous {
+			return &event, nil
+		}
+	}
+	return nil, fmt.Errorf("abi: no event with id: %#x", topic)
+}
+
+// ErrorByID returns the error with the given selector.
+func (abi *ABI) ErrorByID(sig [4]byte) (*Error, error) {
+	for _, e := range abi.Errors {
+		if e.ID == sig {
+			return &e, nil
+		}
+	}
+	return nil, fmt.Errorf("abi: no error with id: %#x", sig)
+}
+
+// DecodeError implements evm.ErrorDecoder, formatting the custom errors of
+// the ABI as Name(arg1, arg2...).
+func (abi *ABI) DecodeError(data []byte) (string, bool) {
+	if len(data) < 4 {
+		return "", false
+	}
+	e, err := abi.ErrorByID([4]byte(data[:4]))
+	if err != nil {
+		return "", false
+	}
+	values, err := e.Unpack(data)
+	if err != nil {
+		return e.Name, true
+	}
+	args := make([]string, len(values))
+	for i, value := range values {
+		args[i] = formatValue(e.Inputs[i].Type, value)
+	}
+	return e.Name + "(" + strings.Join(args, ", ") + ")", true
+}
+
+// formatValue returns a readable form of a decoded value of type t.
+func formatValue(t Type, v interface{}) string {
+	switch t.T {
+	case AddressTy:
+		addr := v.(evm.Address)
+		return "0x" + addr.Hex()
+	case StringTy:
+		return strconv.Quote(v.(string))
+	case BytesTy, FixedBytesTy, FunctionTy:
+		return "0x" + evm.Bytes2Hex(v.([]byte))
+	case SliceTy, ArrayTy, TupleTy:
+		elems := v.([]interface{})
+		values := make([]string, len(elems))
+		for i, elem := range elems {
+			typ := t.Elem
+			if t.T == TupleTy {
+				typ = t.TupleElems[i]
+			}
+			values[i] = formatValue(*typ, elem)
+		}
+		if t.T == TupleTy {
+			return "(" + strings.Join(values, ", ") + ")"
+		}
+		return "[" + strings.Join(values, ", ") + "]"
+	}
+	return fmt.Sprint(v)
+}
+
+// UnpackLog decodes the arguments of an event log into a map keyed by the
+// argument names, unnamed arguments being keyed by their position as argN.
+// The indexed arguments of the reference types, strings, bytes, arrays and
+// tuples, are decoded as the evm.Hash of their value held by the topic.
+func (abi *ABI) UnpackLog(log *evm.Log) (*Event, map[string]interface{}, error) {
+	if len(log.Topics) == 0 {
+		return nil, nil, errors.New("abi: log without topics, anonymous events are not supported")
+	}
+	event, err := abi.EventByID(log.Topics[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		values  = make(map[string]interface{})
+		topics  = log.Topics[1:]
+		data    []interface{}
+		indexed int
+	)
+	for _, arg := range event.Inputs {
+		if arg.Indexed {
+			indexed++
+		}
+	}
+	if indexed != len(topics) {
+		return nil, nil, fmt.Errorf("abi: topic count mismatch: got %d for %d indexed arguments", len(topics), indexed)
+	}
+	if nonIndexed := event.Inputs.NonIndexed(); len(nonIndexed) > 0 {
+		if data, err = nonIndexed.Unpack(log.Data); err != nil {
+			return nil, nil, err
+		}
+	}
+	for i, arg := range event.Inputs {
+		name := arg.Name
+		if name == "" {
+			name = "arg" + strconv.Itoa(i)
+		}
+		if !arg.Indexed {
+			values[name], data = data[0], data[1:]
+			continue
+		}
+		topic := topics[0]
+		topics = topics[1:]
+		switch arg.Type.T {
+		case StringTy, BytesTy, SliceTy, ArrayTy, TupleTy:
+			values[name] = topic
+		default:
+			if values[name], err = unpack(arg.Type, topic[:]); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	return event, values, nil
+}
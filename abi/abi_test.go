@@ -0,0 +1,331 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package abi
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+
+	gethabi "github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/lyonnee/evm"
+)
+
+const testABI = `[
+	{"type": "constructor", "inputs": [{"name": "supply", "type": "uint256"}]},
+	{"type": "function", "name": "transfer", "stateMutability": "nonpayable",
+		"inputs": [{"name": "to", "type": "address"}, {"name": "amount", "type": "uint256"}],
+		"outputs": [{"name": "", "type": "bool"}]},
+	{"type": "function", "name": "transfer", "inputs": [{"name": "amount", "type": "uint256"}], "outputs": []},
+	{"type": "function", "name": "mixed", "stateMutability": "pure", "inputs": [
+		{"name": "a", "type": "uint8"},
+		{"name": "b", "type": "int256"},
+		{"name": "c", "type": "bool"},
+		{"name": "d", "type": "bytes"},
+		{"name": "e", "type": "string"},
+		{"name": "f", "type": "bytes4"},
+		{"name": "g", "type": "uint256[]"},
+		{"name": "h", "type": "string[2]"},
+		{"name": "i", "type": "tuple[]", "components": [{"name": "x", "type": "uint64"}, {"name": "y", "type": "bytes"}]},
+		{"name": "j", "type": "int8[2][]"},
+		{"name": "k", "type": "tuple", "components": [{"name": "x", "type": "uint16"}, {"name": "y", "type": "bytes32"}]}
+	], "outputs": []},
+	{"type": "event", "name": "Transfer", "inputs": [
+		{"name": "from", "type": "address", "indexed": true},
+		{"name": "to", "type": "address", "indexed": true},
+		{"name": "memo", "type": "string", "indexed": true},
+		{"name": "value", "type": "uint256", "indexed": false},
+		{"name": "", "type": "bytes", "indexed": false}
+	]},
+	{"type": "error", "name": "InsufficientBalance", "inputs": [
+		{"name": "who", "type": "address"}, {"name": "need", "type": "uint256[]"}, {"name": "why", "type": "string"}
+	]},
+	{"type": "fallback"},
+	{"type": "receive", "stateMutability": "payable"}
+]`
+
+func parseTestABI(t *testing.T) ABI {
+	t.Helper()
+	abi, err := JSON(strings.NewReader(testABI))
+	if err != nil {
+		t.Fatalf("failed to parse ABI: %v", err)
+	}
+	return abi
+}
+
+func TestNewType(t *testing.T) {
+	for _, tt := range []struct {
+		typ        string
+		components []ArgumentMarshaling
+		want       string
+	}{
+		{typ: "uint", want: "uint256"},
+		{typ: "int8", want: "int8"},
+		{typ: "bytes", want: "bytes"},
+		{typ: "bytes32", want: "bytes32"},
+		{typ: "address[3][]", want: "address[3][]"},
+		{typ: "tuple[2]", components: []ArgumentMarshaling{{Type: "uint"}, {Type: "string[]"}}, want: "(uint256,string[])[2]"},
+		{typ: "uint7"},
+		{typ: "uint264"},
+		{typ: "bytes33"},
+		{typ: "bool8"},
+		{typ: "uint256[0]"},
+		{typ: "[]"},
+		{typ: "tuple"},
+		{typ: "fixed128x18"},
+	} {
+		typ, err := NewType(tt.typ, tt.components)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.typ)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.typ, err)
+			continue
+		}
+		if typ.String() != tt.want {
+			t.Errorf("%s: canonical form mismatch: have %s, want %s", tt.typ, typ, tt.want)
+		}
+	}
+}
+
+func TestParseABI(t *testing.T) {
+	abi := parseTestABI(t)
+	if len(abi.Methods) != 3 || len(abi.Events) != 1 || len(abi.Errors) != 1 {
+		t.Fatalf("entry count mismatch: %d methods, %d events, %d errors", len(abi.Methods), len(abi.Events), len(abi.Errors))
+	}
+	transfer := abi.Methods["transfer"]
+	if transfer.Sig != "transfer(address,uint256)" || evm.Bytes2Hex(transfer.ID) != "a9059cbb" {
+		t.Errorf("transfer mismatch: %v %x", transfer.Sig, transfer.ID)
+	}
+	if overload := abi.Methods["transfer0"]; overload.RawName != "transfer" || overload.Sig != "transfer(uint256)" {
+		t.Errorf("overloaded method mismatch: %v", overload)
+	}
+	if sig := abi.Methods["mixed"].Sig; sig != "mixed(uint8,int256,bool,bytes,string,bytes4,uint256[],string[2],(uint64,bytes)[],int8[2][],(uint16,bytes32))" {
+		t.Errorf("signature mismatch: %s", sig)
+	}
+	if !abi.Methods["mixed"].IsConstant() || abi.Methods["transfer"].IsConstant() {
+		t.Error("state mutability mismatch")
+	}
+	if abi.Fallback.Type != Fallback || abi.Receive.Type != Receive || !abi.Receive.IsPayable() {
+		t.Error("special functions mismatch")
+	}
+	want := evm.Keccak256Hash([]byte("Transfer(address,address,string,uint256,bytes)"))
+	if event, err := abi.EventByID(want); err != nil || event.Name != "Transfer" {
+		t.Errorf("event lookup failed: %v", err)
+	}
+	if method, err := abi.MethodById(abi.Methods["transfer0"].ID); err != nil || method.Name != "transfer0" {
+		t.Errorf("method lookup failed: %v", err)
+	}
+	if _, err := abi.MethodById([]byte{1, 2, 3, 4}); err == nil {
+		t.Error("unknown method found")
+	}
+}
+
+func TestPackMatchesGeth(t *testing.T) {
+	type item struct {
+		X uint64
+		Y []byte
+	}
+	type pair struct {
+		X uint16
+		Y [32]byte
+	}
+	args := []interface{}{
+		uint8(200),
+		big.NewInt(-12345),
+		true,
+		[]byte("dynamic bytes longer than one word of the encoding"),
+		"string",
+		[4]byte{1, 2, 3, 4},
+		[]*big.Int{big.NewInt(1), big.NewInt(2), big.NewInt(3)},
+		[2]string{"a", "bc"},
+		[]item{{1, []byte{1}}, {2, nil}},
+		[][2]int8{{-1, 1}, {-128, 127}},
+		pair{7, [32]byte{0xff}},
+	}
+	abi := parseTestABI(t)
+	have, err := abi.Pack("mixed", args...)
+	if err != nil {
+		t.Fatalf("pack failed: %v", err)
+	}
+	geth, err := gethabi.JSON(strings.NewReader(testABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := geth.Pack("mixed", args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(have, want) {
+		t.Fatalf("encoding mismatch:\nhave %x\nwant %x", have, want)
+	}
+
+	values, err := abi.Methods["mixed"].Inputs.Unpack(have[4:])
+	if err != nil {
+		t.Fatalf("unpack failed: %v", err)
+	}
+	wantValues := []interface{}{
+		big.NewInt(200),
+		big.NewInt(-12345),
+		true,
+		[]byte("dynamic bytes longer than one word of the encoding"),
+		"string",
+		[]byte{1, 2, 3, 4},
+		[]interface{}{big.NewInt(1), big.NewInt(2), big.NewInt(3)},
+		[]interface{}{"a", "bc"},
+		[]interface{}{[]interface{}{big.NewInt(1), []byte{1}}, []interface{}{big.NewInt(2), []byte{}}},
+		[]interface{}{[]interface{}{big.NewInt(-1), big.NewInt(1)}, []interface{}{big.NewInt(-128), big.NewInt(127)}},
+		[]interface{}{big.NewInt(7), append([]byte{0xff}, make([]byte, 31)...)},
+	}
+	if !reflect.DeepEqual(values, wantValues) {
+		t.Errorf("unpacked values mismatch:\nhave %v\nwant %v", values, wantValues)
+	}
+	// Packing the decoded values gives back the encoding
+	if again, err := abi.Pack("mixed", values...); err != nil || !bytes.Equal(again, have) {
+		t.Errorf("repacking mismatch: %v", err)
+	}
+}
+
+func TestPackAddress(t *testing.T) {
+	abi := parseTestABI(t)
+	to := evm.BytesToAddr(evm.Hex2Bytes("ff0102030405060708090a0b0c0d0e0f10111213"))
+	enc, err := abi.Pack("transfer", to, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The address word holds the whole configured address width
+	if want := evm.LeftPadBytes(to.Bytes(), 32); !bytes.Equal(enc[4:36], want) {
+		t.Errorf("address encoding mismatch: have %x, want %x", enc[4:36], want)
+	}
+	values, err := abi.Methods["transfer"].Inputs.Unpack(enc[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values[0] != to || values[1].(*big.Int).Int64() != 5 {
+		t.Errorf("unpacked values mismatch: %v", values)
+	}
+	if _, err := abi.Pack("transfer", to.Bytes(), 5); err == nil {
+		t.Error("packed bytes as an address")
+	}
+}
+
+func TestPackErrors(t *testing.T) {
+	abi := parseTestABI(t)
+	for _, args := range [][]interface{}{
+		{evm.Address{}},
+		{evm.Address{}, -1},
+		{evm.Address{}, "1"},
+		{evm.Address{}, new(big.Int).Lsh(big.NewInt(1), 256)},
+	} {
+		if _, err := abi.Pack("transfer", args...); err == nil {
+			t.Errorf("%v: expected error", args)
+		}
+	}
+	if _, err := abi.Pack("missing"); err == nil {
+		t.Error("packed an unknown method")
+	}
+	args := Arguments{{Type: Type{T: IntTy, Size: 8}}}
+	for _, v := range []int{128, -129} {
+		if _, err := args.Pack(v); err == nil {
+			t.Errorf("int8 %d: expected error", v)
+		}
+	}
+}
+
+func TestUnpackErrors(t *testing.T) {
+	abi := parseTestABI(t)
+	word := func(b ...byte) []byte { return evm.LeftPadBytes(b, 32) }
+	for name, tt := range map[string]struct {
+		method string
+		data   []byte
+	}{
+		"empty":          {"transfer", nil},
+		"short":          {"transfer", word(1)[:31]},
+		"bool":           {"transfer", word(2)},
+		"string offset":  {"mixed", append(make([]byte, 4*32), word(0xff, 0xff)...)},
+		"uint8 overflow": {"mixed", word(1, 0)},
+	} {
+		inputs := abi.Methods[tt.method].Inputs
+		if tt.method == "transfer" {
+			inputs = abi.Methods[tt.method].Outputs
+		}
+		if _, err := inputs.Unpack(tt.data); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	// Slice lengths are bounded by the data
+	slice := Arguments{{Type: Type{T: SliceTy, Elem: &Type{T: UintTy, Size: 256}}}}
+	if _, err := slice.Unpack(append(word(32), word(0xff, 0xff, 0xff, 0xff)...)); err == nil {
+		t.Error("unpacked a slice longer than the data")
+	}
+}
+
+func TestUnpackLog(t *testing.T) {
+	abi := parseTestABI(t)
+	event := abi.Events["Transfer"]
+	from, to := evm.BytesToAddr([]byte("from")), evm.BytesToAddr([]byte("to"))
+	data, err := event.Inputs.NonIndexed().Pack(big.NewInt(42), []byte{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	memo := evm.Keccak256Hash([]byte("memo"))
+	log := &evm.Log{
+		Topics: []evm.Hash{event.ID, evm.BytesToHash(from.Bytes()), evm.BytesToHash(to.Bytes()), memo},
+		Data:   data,
+	}
+	have, values, err := abi.UnpackLog(log)
+	if err != nil {
+		t.Fatalf("unpack failed: %v", err)
+	}
+	want := map[string]interface{}{"from": from, "to": to, "memo": memo, "value": big.NewInt(42), "arg4": []byte{1, 2}}
+	if have.Name != "Transfer" || !reflect.DeepEqual(values, want) {
+		t.Errorf("log mismatch: have %s %v, want %v", have.Name, values, want)
+	}
+	if unpacked, err := abi.Unpack("Transfer", data); err != nil || len(unpacked) != 2 {
+		t.Errorf("unpack of the event data failed: %v", err)
+	}
+	log.Topics = log.Topics[:3]
+	if _, _, err := abi.UnpackLog(log); err == nil {
+		t.Error("unpacked a log with missing topics")
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	abi := parseTestABI(t)
+	e := abi.Errors["InsufficientBalance"]
+	who := evm.BytesToAddr([]byte{0xaa})
+	args, err := e.Inputs.Pack(who, []int{1, 2}, "low")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append(e.ID[:], args...)
+	want := "execution reverted: InsufficientBalance(0x" + who.Hex() + `, [1, 2], "low")`
+	if err := evm.NewRevertError(data, &abi); err.Error() != want {
+		t.Errorf("revert mismatch: have %q, want %q", err.Error(), want)
+	}
+	if values, err := e.Unpack(data); err != nil || values[2] != "low" {
+		t.Errorf("error unpack failed: %v", err)
+	}
+	if _, ok := abi.DecodeError([]byte{1, 2, 3, 4}); ok {
+		t.Error("decoded an unknown error")
+	}
+}
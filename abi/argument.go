@@ -0,0 +1,97 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package abi
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Argument is a named and typed input or output of a method, event or error.
+type Argument struct {
+	Name    string
+	Type    Type
+	Indexed bool // Indexed is only used by events
+}
+
+// Arguments is the list of arguments of a method, event or error.
+type Arguments []Argument
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (argument *Argument) UnmarshalJSON(data []byte) error {
+	var arg ArgumentMarshaling
+	if err := json.Unmarshal(data, &arg); err != nil {
+		return fmt.Errorf("abi: failed to unmarshal argument: %w", err)
+	}
+	typ, err := NewType(arg.Type, arg.Components)
+	if err != nil {
+		return err
+	}
+	argument.Name, argument.Type, argument.Indexed = arg.Name, typ, arg.Indexed
+	return nil
+}
+
+// NonIndexed returns the arguments which are not indexed, the ones encoded in
+// the data of an event log.
+func (arguments Arguments) NonIndexed() Arguments {
+	var ret Arguments
+	for _, arg := range arguments {
+		if !arg.Indexed {
+			ret = append(ret, arg)
+		}
+	}
+	return ret
+}
+
+// types returns the types of the arguments.
+func (arguments Arguments) types() []*Type {
+	types := make([]*Type, len(arguments))
+	for i := range arguments {
+		types[i] = &arguments[i].Type
+	}
+	return types
+}
+
+// Pack returns the encoding of the argument values.
+func (arguments Arguments) Pack(args ...interface{}) ([]byte, error) {
+	if len(args) != len(arguments) {
+		return nil, fmt.Errorf("abi: argument count mismatch: got %d for %d", len(args), len(arguments))
+	}
+	return packTuple(arguments.types(), args)
+}
+
+// Unpack decodes the argument values from their encoding, see Type for their
+// Go representation.
+func (arguments Arguments) Unpack(data []byte) ([]interface{}, error) {
+	if len(data) == 0 && len(arguments) != 0 {
+		return nil, errShortData
+	}
+	return unpackTuple(arguments.types(), data)
+}
+
+// UnpackIntoMap decodes the argument values into a map keyed by the argument
+// names.
+func (arguments Arguments) UnpackIntoMap(v map[string]interface{}, data []byte) error {
+	values, err := arguments.Unpack(data)
+	if err != nil {
+		return err
+	}
+	for i, arg := range arguments {
+		v[arg.Name] = values[i]
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package abi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lyonnee/evm"
+)
+
+// FunctionType is the kind of a method.
+type FunctionType int
+
+const (
+	Constructor FunctionType = iota
+	Fallback
+	Receive
+	Function
+)
+
+// Method is a callable function of a contract.
+type Method struct {
+	// Name is the method name used for packing, overloaded methods being
+	// disambiguated with a numeric suffix, while RawName is the original
+	// name used in the signature.
+	Name    string
+	RawName string
+	Type    FunctionType
+
+	StateMutability string
+	Inputs          Arguments
+	Outputs         Arguments
+
+	Sig string // Canonical signature, e.g. transfer(address,uint256)
+	ID  []byte // Selector, the first 4 bytes of the signature hash
+}
+
+// NewMethod creates a method, computing its signature and selector.
+func NewMethod(name, rawName string, funType FunctionType, mutability string, inputs, outputs Arguments) Method {
+	m := Method{
+		Name:            name,
+		RawName:         rawName,
+		Type:            funType,
+		StateMutability: mutability,
+		Inputs:          inputs,
+		Outputs:         outputs,
+	}
+	if funType == Function {
+		m.Sig = signature(rawName, inputs)
+		m.ID = evm.Keccak256([]byte(m.Sig))[:4]
+	}
+	return m
+}
+
+// IsConstant reports whether the method doesn't modify the state.
+func (method Method) IsConstant() bool {
+	return method.StateMutability == "view" || method.StateMutability == "pure"
+}
+
+// IsPayable reports whether the method accepts value.
+func (method Method) IsPayable() bool {
+	return method.StateMutability == "payable"
+}
+
+func (method Method) String() string {
+	return fmt.Sprintf("function %v returns(%v)", method.Sig, types(method.Outputs))
+}
+
+// Event is an event a contract may emit.
+type Event struct {
+	// Name is the event name used for lookups, overloaded events being
+	// disambiguated with a numeric suffix, while RawName is the original
+	// name used in the signature.
+	Name      string
+	RawName   string
+	Anonymous bool
+	Inputs    Arguments
+
+	Sig string   // Canonical signature, e.g. Transfer(address,address,uint256)
+	ID  evm.Hash // First topic of the logs, the signature hash
+}
+
+// NewEvent creates an event, computing its signature and topic.
+func NewEvent(name, rawName string, anonymous bool, inputs Arguments) Event {
+	sig := signature(rawName, inputs)
+	return Event{
+		Name:      name,
+		RawName:   rawName,
+		Anonymous: anonymous,
+		Inputs:    inputs,
+		Sig:       sig,
+		ID:        evm.Keccak256Hash([]byte(sig)),
+	}
+}
+
+func (e Event) String() string {
+	return "event " + e.Sig
+}
+
+// Error is a custom error a contract may revert with.
+type Error struct {
+	Name   string
+	Inputs Arguments
+
+	Sig string  // Canonical signature, e.g. InsufficientBalance(uint256,uint256)
+	ID  [4]byte // Selector, the first 4 bytes of the signature hash
+}
+
+// NewError creates an error, computing its signature and selector.
+func NewError(name string, inputs Arguments) Error {
+	e := Error{Name: name, Inputs: inputs, Sig: signature(name, inputs)}
+	copy(e.ID[:], evm.Keccak256([]byte(e.Sig)))
+	return e
+}
+
+// Unpack decodes the arguments of the error from revert data.
+func (e Error) Unpack(data []byte) ([]interface{}, error) {
+	if len(data) < 4 || [4]byte(data[:4]) != e.ID {
+		return nil, fmt.Errorf("abi: data is not a %s error", e.Name)
+	}
+	return e.Inputs.Unpack(data[4:])
+}
+
+func (e Error) String() string {
+	return "error " + e.Sig
+}
+
+// signature returns the canonical signature of a method, event or error.
+func signature(name string, inputs Arguments) string {
+	return name + "(" + types(inputs) + ")"
+}
+
+// types returns the comma separated canonical types of the arguments.
+func types(args Arguments) string {
+	types := make([]string, len(args))
+	for i, arg := range args {
+		types[i] = arg.Type.String()
+	}
+	return strings.Join(types, ",")
+}
@@ -0,0 +1,229 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package abi
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/holiman/uint256"
+	"github.com/lyonnee/evm"
+)
+
+// tt256 is 2^256, offsetting the negative integers in two's complement.
+var tt256 = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// wordSize is the size of the ABI encoding slots.
+const wordSize = 32
+
+// packNum returns the 32 bytes two's complement encoding of n.
+func packNum(n *big.Int) []byte {
+	if n.Sign() < 0 {
+		n = new(big.Int).Add(tt256, n)
+	}
+	return evm.LeftPadBytes(n.Bytes(), wordSize)
+}
+
+// packBytes returns b right padded to a multiple of the word size.
+func packBytes(b []byte) []byte {
+	return evm.RightPadBytes(b, (len(b)+wordSize-1)/wordSize*wordSize)
+}
+
+// pack returns the encoding of v as a value of type t. The encoding of the
+// dynamic types is the tail referenced by the head of their enclosing tuple.
+func pack(t Type, v interface{}) ([]byte, error) {
+	switch t.T {
+	case IntTy, UintTy:
+		n, err := toBig(v)
+		if err != nil {
+			return nil, err
+		}
+		if !inRange(t, n) {
+			return nil, fmt.Errorf("abi: %v out of range for %v", n, t)
+		}
+		return packNum(n), nil
+	case BoolTy:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, typeError(t, v)
+		}
+		if b {
+			return packNum(big.NewInt(1)), nil
+		}
+		return make([]byte, wordSize), nil
+	case AddressTy:
+		addr, ok := v.(evm.Address)
+		if !ok {
+			return nil, typeError(t, v)
+		}
+		return evm.LeftPadBytes(addr.Bytes(), wordSize), nil
+	case FixedBytesTy, FunctionTy:
+		b, ok := toBytes(v)
+		if !ok || len(b) != t.Size {
+			return nil, typeError(t, v)
+		}
+		return evm.RightPadBytes(b, wordSize), nil
+	case StringTy:
+		s, ok := v.(string)
+		if !ok {
+			return nil, typeError(t, v)
+		}
+		return append(packNum(big.NewInt(int64(len(s)))), packBytes([]byte(s))...), nil
+	case BytesTy:
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, typeError(t, v)
+		}
+		return append(packNum(big.NewInt(int64(len(b)))), packBytes(b)...), nil
+	case SliceTy, ArrayTy:
+		elems, ok := toElems(v)
+		if !ok || (t.T == ArrayTy && len(elems) != t.Size) {
+			return nil, typeError(t, v)
+		}
+		types := make([]*Type, len(elems))
+		for i := range types {
+			types[i] = t.Elem
+		}
+		enc, err := packTuple(types, elems)
+		if err != nil {
+			return nil, err
+		}
+		if t.T == SliceTy {
+			enc = append(packNum(big.NewInt(int64(len(elems)))), enc...)
+		}
+		return enc, nil
+	case TupleTy:
+		fields, ok := toFields(v)
+		if !ok || len(fields) != len(t.TupleElems) {
+			return nil, typeError(t, v)
+		}
+		return packTuple(t.TupleElems, fields)
+	}
+	return nil, fmt.Errorf("abi: unknown type %v", t)
+}
+
+// packTuple returns the encoding of the values of the given types, their
+// heads followed by the tails of the dynamic ones.
+func packTuple(types []*Type, values []interface{}) ([]byte, error) {
+	headLen := 0
+	for _, t := range types {
+		headLen += headSize(*t)
+	}
+	var head, tail []byte
+	for i, t := range types {
+		enc, err := pack(*t, values[i])
+		if err != nil {
+			return nil, err
+		}
+		if isDynamic(*t) {
+			head = append(head, packNum(big.NewInt(int64(headLen+len(tail))))...)
+			tail = append(tail, enc...)
+		} else {
+			head = append(head, enc...)
+		}
+	}
+	return append(head, tail...), nil
+}
+
+// inRange reports whether n fits the integer type t.
+func inRange(t Type, n *big.Int) bool {
+	if t.T == UintTy {
+		return n.Sign() >= 0 && n.BitLen() <= t.Size
+	}
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(t.Size-1))
+	return n.Cmp(limit) < 0 && n.Cmp(limit.Neg(limit)) >= 0
+}
+
+// toBig converts the Go integers to a big.Int.
+func toBig(v interface{}) (*big.Int, error) {
+	switch n := v.(type) {
+	case *big.Int:
+		if n == nil {
+			return nil, fmt.Errorf("abi: nil integer")
+		}
+		return n, nil
+	case big.Int:
+		return &n, nil
+	case *uint256.Int:
+		return n.ToBig(), nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return new(big.Int).SetUint64(rv.Uint()), nil
+	}
+	return nil, fmt.Errorf("abi: cannot use %T as an integer", v)
+}
+
+// toBytes converts byte slices and arrays, such as evm.Hash, to a slice.
+func toBytes(v interface{}) ([]byte, bool) {
+	rv := reflect.ValueOf(v)
+	switch {
+	case rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() == reflect.Uint8:
+		return rv.Bytes(), true
+	case rv.Kind() == reflect.Array && rv.Type().Elem().Kind() == reflect.Uint8:
+		b := make([]byte, rv.Len())
+		reflect.Copy(reflect.ValueOf(b), rv)
+		return b, true
+	}
+	return nil, false
+}
+
+// toElems converts the Go slices and arrays to the list of their elements.
+func toElems(v interface{}) ([]interface{}, bool) {
+	if elems, ok := v.([]interface{}); ok {
+		return elems, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	elems := make([]interface{}, rv.Len())
+	for i := range elems {
+		elems[i] = rv.Index(i).Interface()
+	}
+	return elems, true
+}
+
+// toFields converts a tuple, given as the list of its fields or as a struct
+// whose exported fields are in order, to the list of its fields.
+func toFields(v interface{}) ([]interface{}, bool) {
+	if fields, ok := v.([]interface{}); ok {
+		return fields, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, false
+	}
+	var fields []interface{}
+	for i := 0; i < rv.NumField(); i++ {
+		if rv.Type().Field(i).IsExported() {
+			fields = append(fields, rv.Field(i).Interface())
+		}
+	}
+	return fields, true
+}
+
+func typeError(t Type, v interface{}) error {
+	return fmt.Errorf("abi: cannot use %T as type %v", v, t)
+}
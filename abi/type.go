@@ -0,0 +1,209 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package abi
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Type kinds
+const (
+	IntTy byte = iota
+	UintTy
+	BoolTy
+	StringTy
+	SliceTy
+	ArrayTy
+	TupleTy
+	AddressTy
+	FixedBytesTy
+	BytesTy
+	FunctionTy
+)
+
+// Type is an ABI type, elementary or composite.
+//
+// Values are decoded as *big.Int for the integers, bool, evm.Address, string,
+// []byte for bytes, bytesN and function, and []interface{} for the arrays,
+// slices and tuples. Packing also accepts the Go integer types and
+// *uint256.Int, byte arrays such as evm.Hash for bytesN, any slice or array
+// for the arrays and slices, and structs with their exported fields in order
+// for the tuples.
+type Type struct {
+	T    byte  // Kind of the type
+	Size int   // Bits of the integers, bytes of bytesN, length of the arrays
+	Elem *Type // Element type of the slices and arrays
+
+	TupleElems    []*Type  // Field types of the tuples
+	TupleRawNames []string // Field names of the tuples
+
+	stringKind string // Canonical form of the type, used in signatures
+}
+
+// ArgumentMarshaling is the JSON description of an argument or tuple field.
+type ArgumentMarshaling struct {
+	Name         string               `json:"name"`
+	Type         string               `json:"type"`
+	InternalType string               `json:"internalType,omitempty"`
+	Components   []ArgumentMarshaling `json:"components,omitempty"`
+	Indexed      bool                 `json:"indexed,omitempty"`
+}
+
+var typeRegex = regexp.MustCompile(`^([a-z]+)([0-9]*)$`)
+
+// NewType parses an ABI type, components describing the fields of tuples.
+func NewType(t string, components []ArgumentMarshaling) (Type, error) {
+	// Arrays and slices, the outermost dimension being the last one
+	if strings.HasSuffix(t, "]") {
+		i := strings.LastIndex(t, "[")
+		if i <= 0 {
+			return Type{}, fmt.Errorf("invalid array type %q", t)
+		}
+		elem, err := NewType(t[:i], components)
+		if err != nil {
+			return Type{}, err
+		}
+		typ := Type{Elem: &elem}
+		if size := t[i+1 : len(t)-1]; size == "" {
+			typ.T = SliceTy
+			typ.stringKind = elem.stringKind + "[]"
+		} else {
+			n, err := strconv.Atoi(size)
+			if err != nil || n <= 0 {
+				return Type{}, fmt.Errorf("invalid array size in %q", t)
+			}
+			typ.T, typ.Size = ArrayTy, n
+			typ.stringKind = elem.stringKind + "[" + size + "]"
+		}
+		return typ, nil
+	}
+	if t == "tuple" {
+		if len(components) == 0 {
+			return Type{}, errors.New("tuple without components")
+		}
+		typ := Type{T: TupleTy}
+		names := make([]string, len(components))
+		for i, c := range components {
+			elem, err := NewType(c.Type, c.Components)
+			if err != nil {
+				return Type{}, err
+			}
+			typ.TupleElems = append(typ.TupleElems, &elem)
+			typ.TupleRawNames = append(typ.TupleRawNames, c.Name)
+			names[i] = elem.stringKind
+		}
+		typ.stringKind = "(" + strings.Join(names, ",") + ")"
+		return typ, nil
+	}
+	match := typeRegex.FindStringSubmatch(t)
+	if match == nil {
+		return Type{}, fmt.Errorf("invalid type %q", t)
+	}
+	var (
+		name = match[1]
+		size = -1
+	)
+	if match[2] != "" {
+		n, err := strconv.Atoi(match[2])
+		if err != nil {
+			return Type{}, fmt.Errorf("invalid type %q", t)
+		}
+		size = n
+	}
+	switch name {
+	case "int", "uint":
+		if size == -1 {
+			size = 256
+		}
+		if size == 0 || size > 256 || size%8 != 0 {
+			return Type{}, fmt.Errorf("invalid integer size in %q", t)
+		}
+		kind := IntTy
+		if name == "uint" {
+			kind = UintTy
+		}
+		return Type{T: kind, Size: size, stringKind: name + strconv.Itoa(size)}, nil
+	case "bytes":
+		if size == -1 {
+			return Type{T: BytesTy, stringKind: "bytes"}, nil
+		}
+		if size == 0 || size > 32 {
+			return Type{}, fmt.Errorf("invalid bytes size in %q", t)
+		}
+		return Type{T: FixedBytesTy, Size: size, stringKind: t}, nil
+	}
+	if size != -1 {
+		return Type{}, fmt.Errorf("invalid type %q", t)
+	}
+	switch name {
+	case "bool":
+		return Type{T: BoolTy, stringKind: t}, nil
+	case "address":
+		return Type{T: AddressTy, stringKind: t}, nil
+	case "string":
+		return Type{T: StringTy, stringKind: t}, nil
+	case "function":
+		return Type{T: FunctionTy, Size: 24, stringKind: t}, nil
+	}
+	return Type{}, fmt.Errorf("unsupported type %q", t)
+}
+
+// String returns the canonical form of the type, as used in signatures.
+func (t Type) String() string {
+	return t.stringKind
+}
+
+// isDynamic reports whether the type is encoded in the tail of its enclosing
+// tuple, its head being an offset.
+func isDynamic(t Type) bool {
+	switch t.T {
+	case StringTy, BytesTy, SliceTy:
+		return true
+	case ArrayTy:
+		return isDynamic(*t.Elem)
+	case TupleTy:
+		for _, elem := range t.TupleElems {
+			if isDynamic(*elem) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// headSize returns the size of the head of the type in its enclosing tuple,
+// static arrays and tuples being encoded in place.
+func headSize(t Type) int {
+	if isDynamic(t) {
+		return 32
+	}
+	switch t.T {
+	case ArrayTy:
+		return t.Size * headSize(*t.Elem)
+	case TupleTy:
+		size := 0
+		for _, elem := range t.TupleElems {
+			size += headSize(*elem)
+		}
+		return size
+	}
+	return 32
+}
@@ -0,0 +1,143 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package abi
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/lyonnee/evm"
+)
+
+var errShortData = errors.New("abi: data too short")
+
+// readUint reads an offset or length word not exceeding limit.
+func readUint(word []byte, limit int) (int, error) {
+	n := new(big.Int).SetBytes(word[:wordSize])
+	if !n.IsUint64() || n.Uint64() > uint64(limit) {
+		return 0, fmt.Errorf("abi: offset or length %v out of bounds (%d)", n, limit)
+	}
+	return int(n.Uint64()), nil
+}
+
+// unpackTuple decodes the values of the given types from the encoding of
+// their tuple, the tails of the dynamic values being referenced by offsets
+// from the start of data.
+func unpackTuple(types []*Type, data []byte) ([]interface{}, error) {
+	var (
+		values = make([]interface{}, len(types))
+		offset = 0
+	)
+	for i, t := range types {
+		size := headSize(*t)
+		if offset+size > len(data) {
+			return nil, errShortData
+		}
+		var (
+			value interface{}
+			err   error
+		)
+		if isDynamic(*t) {
+			var start int
+			if start, err = readUint(data[offset:], len(data)); err != nil {
+				return nil, err
+			}
+			value, err = unpack(*t, data[start:])
+		} else {
+			value, err = unpack(*t, data[offset:offset+size])
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+		offset += size
+	}
+	return values, nil
+}
+
+// unpack decodes a value of type t from its encoding at the start of data.
+func unpack(t Type, data []byte) (interface{}, error) {
+	switch t.T {
+	case SliceTy, ArrayTy:
+		n := t.Size
+		if t.T == SliceTy {
+			if len(data) < wordSize {
+				return nil, errShortData
+			}
+			var err error
+			if n, err = readUint(data, (len(data)-wordSize)/headSize(*t.Elem)); err != nil {
+				return nil, err
+			}
+			data = data[wordSize:]
+		}
+		types := make([]*Type, n)
+		for i := range types {
+			types[i] = t.Elem
+		}
+		return unpackTuple(types, data)
+	case TupleTy:
+		return unpackTuple(t.TupleElems, data)
+	}
+	if len(data) < wordSize {
+		return nil, errShortData
+	}
+	word := data[:wordSize]
+	switch t.T {
+	case IntTy, UintTy:
+		n := new(big.Int).SetBytes(word)
+		if t.T == IntTy && word[0]&0x80 != 0 {
+			n.Sub(n, tt256)
+		}
+		if !inRange(t, n) {
+			return nil, fmt.Errorf("abi: improperly encoded %v value", t)
+		}
+		return n, nil
+	case BoolTy:
+		if !isZero(word[:wordSize-1]) || word[wordSize-1] > 1 {
+			return nil, errors.New("abi: improperly encoded boolean value")
+		}
+		return word[wordSize-1] == 1, nil
+	case AddressTy:
+		if !isZero(word[:wordSize-evm.AddressLength]) {
+			return nil, errors.New("abi: improperly encoded address value")
+		}
+		return evm.BytesToAddr(word), nil
+	case FixedBytesTy, FunctionTy:
+		return evm.CopyBytes(word[:t.Size]), nil
+	case StringTy, BytesTy:
+		n, err := readUint(word, len(data)-wordSize)
+		if err != nil {
+			return nil, err
+		}
+		content := evm.CopyBytes(data[wordSize : wordSize+n])
+		if t.T == StringTy {
+			return string(content), nil
+		}
+		return content, nil
+	}
+	return nil, fmt.Errorf("abi: unknown type %v", t)
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
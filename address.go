@@ -18,12 +18,15 @@ package evm
 
 import (
 	"encoding/hex"
+	"fmt"
 
 	"github.com/ethereum/go-ethereum/rlp"
 )
 
-const AddressLength int = 32
-
+// Address is an account address of AddressLength bytes. Values longer than
+// that are truncated to their rightmost bytes and shorter ones are left padded
+// with zeros, which is also how the EVM masks the addresses it pops from the
+// stack (BALANCE, EXTCODE*, CALL*, SELFDESTRUCT).
 type Address [AddressLength]byte
 
 func (a Address) Bytes() []byte {
@@ -41,6 +44,22 @@ func (a Address) Hex() string {
 	return hex.EncodeToString(a.Bytes())
 }
 
+// MarshalText encodes the address as 0x prefixed hex.
+func (a Address) MarshalText() ([]byte, error) {
+	return []byte("0x" + a.Hex()), nil
+}
+
+// UnmarshalText decodes a hex string of at most AddressLength bytes, left
+// padding shorter values.
+func (a *Address) UnmarshalText(input []byte) error {
+	b, err := decodeFixedHex(input, AddressLength)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %w", input, err)
+	}
+	*a = BytesToAddr(b)
+	return nil
+}
+
 var NilAddr Address = Address{}
 
 func BytesToAddr(b []byte) Address {
@@ -53,11 +72,60 @@ func AddrToBytes(a Address) []byte {
 	return a.Bytes()
 }
 
+// CreateAddress returns the address of a contract created by a with the given
+// nonce, keccak256(rlp([a, nonce])).
 func CreateAddress(a []byte, nonce uint64) Address {
-	data, _ := rlp.EncodeToBytes([]interface{}{a, nonce})
-	return BytesToAddr(Keccak256(data))
+	return createAddress(KeccakHasher{}, a, nonce)
 }
 
+// CreateAddress2 returns the address of a contract created by a with CREATE2,
+// keccak256(0xff ++ a ++ salt ++ inithash) as specified by EIP-1014, inithash
+// being the hash of the init code.
 func CreateAddress2(a []byte, salt [32]byte, inithash []byte) Address {
-	return BytesToAddr(Keccak256([]byte{0xff}, a, salt[:], inithash))
+	return createAddress2(KeccakHasher{}, a, salt, inithash)
+}
+
+func createAddress(hasher Hasher, a []byte, nonce uint64) Address {
+	data, _ := rlp.EncodeToBytes([]interface{}{a, nonce})
+	return BytesToAddr(HashWith(hasher, data).Bytes())
+}
+
+func createAddress2(hasher Hasher, a []byte, salt [32]byte, inithash []byte) Address {
+	return BytesToAddr(HashWith(hasher, []byte{0xff}, a, salt[:], inithash).Bytes())
+}
+
+// AddressDeriver derives the addresses of the contracts deployed by CREATE
+// and CREATE2. Chains with a different account model can replace the
+// Ethereum scheme through Config.AddressDeriver.
+type AddressDeriver interface {
+	// CreateAddress returns the address of the contract created by caller
+	// holding the given nonce.
+	CreateAddress(caller Address, nonce uint64) Address
+	// CreateAddress2 returns the address of the contract created by caller
+	// with the given salt and init code hash.
+	CreateAddress2(caller Address, salt Hash, initCodeHash Hash) Address
+}
+
+// EthereumAddressDeriver is the default AddressDeriver, deriving the
+// addresses as CreateAddress and CreateAddress2 do.
+type EthereumAddressDeriver struct {
+	// Hasher replaces Keccak-256 in the derivation when set.
+	Hasher Hasher
+}
+
+func (d EthereumAddressDeriver) hasher() Hasher {
+	if d.Hasher == nil {
+		return KeccakHasher{}
+	}
+	return d.Hasher
+}
+
+// CreateAddress implements AddressDeriver.
+func (d EthereumAddressDeriver) CreateAddress(caller Address, nonce uint64) Address {
+	return createAddress(d.hasher(), caller.Bytes(), nonce)
+}
+
+// CreateAddress2 implements AddressDeriver.
+func (d EthereumAddressDeriver) CreateAddress2(caller Address, salt Hash, initCodeHash Hash) Address {
+	return createAddress2(d.hasher(), caller.Bytes(), salt, initCodeHash.Bytes())
 }
@@ -0,0 +1,177 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build addr20
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	gethvm "github.com/ethereum/go-ethereum/core/vm"
+	gethparams "github.com/ethereum/go-ethereum/params"
+	"github.com/holiman/uint256"
+)
+
+func TestCreateAddressMainnet(t *testing.T) {
+	sender := HexToAddress("6ac7ea33f8831ea9dcc53393aaa88b25a785dbf0")
+	for nonce, want := range []string{
+		"cd234a471b72ba2f1ccf0a70fcaba648a5eecd8d",
+		"343c43a37d37dff08ae8c4a11544c718abb4fcf8",
+		"f778b86fa74e846c4f0a1fbd1335fe81c00a0c91",
+		"fffd933a0bc612844eaf0c6fe3e5b8e9b6c1d19c",
+	} {
+		if have := CreateAddress(sender.Bytes(), uint64(nonce)); have.Hex() != want {
+			t.Errorf("nonce %d: address mismatch: have %s, want %s", nonce, have.Hex(), want)
+		}
+	}
+}
+
+func TestCreateAddress2Mainnet(t *testing.T) {
+	// Examples of EIP-1014
+	for i, tt := range []struct {
+		origin   string
+		salt     string
+		initcode string
+		want     string
+	}{
+		{"0000000000000000000000000000000000000000", "00", "00", "4d1a2e2bb4f88f0250f26ffff098b0b30b26bf38"},
+		{"deadbeef00000000000000000000000000000000", "00", "00", "b928f69bb1d91cd65274e3c79d8986362984fda3"},
+		{"deadbeef00000000000000000000000000000000", "000000000000000000000000feed000000000000000000000000000000000000", "00", "d04116cdd17bebe565eb2422f2497e06cc1c9833"},
+		{"00000000000000000000000000000000deadbeef", "cafebabe", "deadbeef", "60f3f640a8508fc6a86d45df051962668e1e8ac7"},
+		{"0000000000000000000000000000000000000000", "00", "", "e33c0c7f7df4809055c3eba6c09cfe4baf1bd9e0"},
+	} {
+		salt := BytesToHash(FromHex(tt.salt))
+		have := CreateAddress2(FromHex(tt.origin), salt, Keccak256(FromHex(tt.initcode)))
+		if have.Hex() != tt.want {
+			t.Errorf("example %d: address mismatch: have %s, want %s", i, have.Hex(), tt.want)
+		}
+	}
+}
+
+func TestCreate2OpcodeMainnet(t *testing.T) {
+	vm, _ := newTransitionEnv(t, istanbulChainConfig, nil, 0)
+	// Example 3 of EIP-1014, run through the EVM
+	caller := HexToAddress("00000000000000000000000000000000deadbeef")
+	salt := new(uint256.Int).SetBytes(FromHex("cafebabe"))
+	_, addr, _, _ := vm.Create2(AccountRef(caller), FromHex("deadbeef"), 100000, new(big.Int), salt)
+	if want := "60f3f640a8508fc6a86d45df051962668e1e8ac7"; addr.Hex() != want {
+		t.Errorf("address mismatch: have %s, want %s", addr.Hex(), want)
+	}
+}
+
+var mainnetTestConfig = &gethparams.ChainConfig{
+	ChainID:             big.NewInt(1),
+	HomesteadBlock:      big.NewInt(0),
+	EIP150Block:         big.NewInt(0),
+	EIP155Block:         big.NewInt(0),
+	EIP158Block:         big.NewInt(0),
+	ByzantiumBlock:      big.NewInt(0),
+	ConstantinopleBlock: big.NewInt(0),
+	PetersburgBlock:     big.NewInt(0),
+	IstanbulBlock:       big.NewInt(0),
+	MuirGlacierBlock:    big.NewInt(0),
+	BerlinBlock:         big.NewInt(0),
+	LondonBlock:         big.NewInt(0),
+	ArrowGlacierBlock:   big.NewInt(0),
+	GrayGlacierBlock:    big.NewInt(0),
+}
+
+// TestMainnetEquivalence runs code dealing with addresses both here and on
+// go-ethereum's interpreter, and checks that the outputs, the gas used and
+// the resulting state roots match.
+func TestMainnetEquivalence(t *testing.T) {
+	var (
+		origin   = HexToAddress("a94f5374fce5edbc8e2a8697c15331677e6ebf0b")
+		receiver = HexToAddress("095e7baea6a6c7c4c2dfeb977efac326af552d87")
+		other    = "00000000000000000000000000000000000000ff"
+		// other, with garbage in the 12 upper bytes of the stack word
+		dirty = "7f" + "deadbeefdeadbeefdeadbeef" + other
+	)
+	for i, code := range []string{
+		// ADDRESS CALLER ORIGIN, returned as three words
+		"30600052336020524160405260606000f3",
+		// BALANCE and EXTCODEHASH of a dirty address
+		dirty + "31600052" + dirty + "3f60205260406000f3",
+		// CALL a dirty address with value, return its new balance
+		"6000600060006000600a" + dirty + "61fffff1" + dirty + "3160005260206000f3",
+		// CREATE, return the new address
+		"6c63deadbeef6000526004601cf3600052600d60136000f060005260206000f3",
+		// CREATE2, return the new address
+		"6c63deadbeef6000526004601cf3600052602a600d60136000f560005260206000f3",
+		// SELFDESTRUCT to a dirty address
+		dirty + "ff",
+	} {
+		code := FromHex(code)
+
+		newState := func() *state.StateDB {
+			statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+			statedb.AddBalance(toGethAddr(origin), big.NewInt(1e18))
+			statedb.AddBalance(toGethAddr(receiver), big.NewInt(1e18))
+			statedb.SetCode(toGethAddr(receiver), code)
+			statedb.SetCode(common.HexToAddress(other), []byte{0x00})
+			statedb.AddBalance(common.HexToAddress(other), big.NewInt(7))
+			statedb.Finalise(true)
+			return statedb
+		}
+
+		// Execution on this module
+		statedb := newState()
+		vm := NewEVM(BlockContext{
+			CanTransfer: CanTransfer,
+			Transfer:    Transfer,
+			BlockNumber: big.NewInt(1),
+			Difficulty:  new(big.Int),
+			BaseFee:     new(big.Int),
+		}, TxContext{Origin: origin, GasPrice: new(big.Int)}, &StateDBImpl{db: statedb}, testChainConfig, Config{})
+		output, gasLeft, err := vm.Call(AccountRef(origin), receiver, nil, 1000000, new(big.Int))
+
+		// Execution on go-ethereum
+		refdb := newState()
+		refvm := gethvm.NewEVM(gethvm.BlockContext{
+			CanTransfer: func(db gethvm.StateDB, addr common.Address, amount *big.Int) bool {
+				return db.GetBalance(addr).Cmp(amount) >= 0
+			},
+			Transfer: func(db gethvm.StateDB, sender, recipient common.Address, amount *big.Int) {
+				db.SubBalance(sender, amount)
+				db.AddBalance(recipient, amount)
+			},
+			BlockNumber: big.NewInt(1),
+			Difficulty:  new(big.Int),
+			BaseFee:     new(big.Int),
+		}, gethvm.TxContext{Origin: toGethAddr(origin), GasPrice: new(big.Int)}, refdb, mainnetTestConfig, gethvm.Config{})
+		refOutput, refGasLeft, refErr := refvm.Call(gethvm.AccountRef(toGethAddr(origin)), toGethAddr(receiver), nil, 1000000, new(big.Int))
+
+		if (err == nil) != (refErr == nil) {
+			t.Errorf("code %d: error mismatch: have %v, want %v", i, err, refErr)
+		}
+		if !bytes.Equal(output, refOutput) {
+			t.Errorf("code %d: output mismatch: have %x, want %x", i, output, refOutput)
+		}
+		if gasLeft != refGasLeft {
+			t.Errorf("code %d: gas mismatch: have %d, want %d", i, gasLeft, refGasLeft)
+		}
+		if root, want := statedb.IntermediateRoot(true), refdb.IntermediateRoot(true); root != want {
+			t.Errorf("code %d: state root mismatch: have %x, want %x", i, root, want)
+		}
+	}
+}
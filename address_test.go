@@ -0,0 +1,118 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+// create2Initcode deploys the runtime code 0xdeadbeef.
+var create2Initcode = Hex2Bytes("63deadbeef6000526004601cf3")
+
+func TestBytesToAddrTruncation(t *testing.T) {
+	long := bytes.Repeat([]byte{0xff}, 8)
+	long = append(long, bytes.Repeat([]byte{0x11}, AddressLength)...)
+	if have := BytesToAddr(long); !bytes.Equal(have.Bytes(), long[8:]) {
+		t.Errorf("long input not truncated: have %x", have)
+	}
+	if have := BytesToAddr([]byte{0x01}); have.Bytes()[AddressLength-1] != 0x01 || !allZero(have.Bytes()[:AddressLength-1]) {
+		t.Errorf("short input not left padded: have %x", have)
+	}
+}
+
+func TestCreate2HashesInitcode(t *testing.T) {
+	vm, db := newTransitionEnv(t, istanbulChainConfig, nil, 0)
+	salt := uint256.NewInt(0x2a)
+
+	_, addr, _, err := vm.Create2(AccountRef(stSender), create2Initcode, 100000, new(big.Int), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CreateAddress2(stSender.Bytes(), salt.Bytes32(), Keccak256(create2Initcode))
+	if addr != want {
+		t.Errorf("address mismatch: have %x, want %x", addr, want)
+	}
+	if code := db.GetCode(addr); !bytes.Equal(code, Hex2Bytes("deadbeef")) {
+		t.Errorf("code mismatch: have %x", code)
+	}
+	// The same salt with another init code must deploy elsewhere
+	_, other, _, err := vm.Create2(AccountRef(stSender), append(create2Initcode, 0x00), 100000, new(big.Int), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == addr {
+		t.Errorf("init code not part of the address %x", addr)
+	}
+}
+
+func TestCreate2Opcode(t *testing.T) {
+	vm, db := newTransitionEnv(t, istanbulChainConfig, nil, 0)
+	// MSTORE the init code, CREATE2 with salt 0x2a, return the address
+	creator := BytesToAddr([]byte("creator"))
+	db.SetCode(creator, Hex2Bytes("6c63deadbeef6000526004601cf3600052602a600d60136000f560005260206000f3"))
+
+	ret, _, err := vm.Call(AccountRef(stSender), creator, nil, 100000, new(big.Int))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := CreateAddress2(creator.Bytes(), uint256.NewInt(0x2a).Bytes32(), Keccak256(create2Initcode))
+	if have := BytesToAddr(ret); have != want {
+		t.Errorf("address mismatch: have %x, want %x", have, want)
+	}
+}
+
+// sequentialDeriver hands out consecutive addresses.
+type sequentialDeriver struct {
+	next byte
+}
+
+func (d *sequentialDeriver) CreateAddress(caller Address, nonce uint64) Address {
+	d.next++
+	return BytesToAddr([]byte{0xc0, d.next})
+}
+
+func (d *sequentialDeriver) CreateAddress2(caller Address, salt Hash, initCodeHash Hash) Address {
+	d.next++
+	return BytesToAddr([]byte{0xc2, d.next})
+}
+
+func TestCustomAddressDeriver(t *testing.T) {
+	base, db := newTransitionEnv(t, istanbulChainConfig, nil, 0)
+	vm := NewEVM(base.Context, TxContext{}, db, istanbulChainConfig, Config{AddressDeriver: &sequentialDeriver{}})
+
+	_, addr, _, err := vm.Create(AccountRef(stSender), create2Initcode, 100000, new(big.Int))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := BytesToAddr([]byte{0xc0, 1}); addr != want {
+		t.Errorf("CREATE address mismatch: have %x, want %x", addr, want)
+	}
+	_, addr, _, err = vm.Create2(AccountRef(stSender), create2Initcode, 100000, new(big.Int), uint256.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := BytesToAddr([]byte{0xc2, 2}); addr != want {
+		t.Errorf("CREATE2 address mismatch: have %x, want %x", addr, want)
+	}
+	if code := db.GetCode(addr); !bytes.Equal(code, Hex2Bytes("deadbeef")) {
+		t.Errorf("code mismatch: have %x", code)
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"math/big"
+
+	"github.com/lyonnee/evm/params"
+)
+
+// MaxBlobGasPerBlock returns the blob gas a block can consume in the fork
+// configured by the rules, EIP-7691 raising it from Prague.
+func MaxBlobGasPerBlock(rules params.Rules) uint64 {
+	if rules.IsPrague {
+		return params.MaxBlobGasPerBlockPrague
+	}
+	return params.MaxBlobGasPerBlock
+}
+
+// CalcBlobFee returns the price of a unit of blob gas in a block with the
+// given excess blob gas (EIP-4844), with the update fraction of the fork
+// configured by the rules.
+func CalcBlobFee(rules params.Rules, excessBlobGas uint64) *big.Int {
+	fraction := uint64(params.BlobTxBlobGaspriceUpdateFraction)
+	if rules.IsPrague {
+		fraction = params.BlobTxBlobGaspriceUpdateFractionPrague
+	}
+	return fakeExponential(big.NewInt(params.BlobTxMinBlobGasprice), new(big.Int).SetUint64(excessBlobGas), new(big.Int).SetUint64(fraction))
+}
+
+// fakeExponential approximates factor * e ** (numerator / denominator) using
+// Taylor expansion.
+func fakeExponential(factor, numerator, denominator *big.Int) *big.Int {
+	var (
+		output = new(big.Int)
+		accum  = new(big.Int).Mul(factor, denominator)
+	)
+	for i := 1; accum.Sign() > 0; i++ {
+		output.Add(output, accum)
+
+		accum.Mul(accum, numerator)
+		accum.Div(accum, denominator)
+		accum.Div(accum, big.NewInt(int64(i)))
+	}
+	return output.Div(output, denominator)
+}
@@ -0,0 +1,115 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"encoding/hex"
+)
+
+const (
+	// BloomByteLength is the number of bytes of a logs bloom filter.
+	BloomByteLength = 256
+	// BloomBitLength is the number of bits of a logs bloom filter.
+	BloomBitLength = 8 * BloomByteLength
+)
+
+// Bloom is the 2048-bit logs bloom filter of receipts and blocks. Every log
+// adds its address and topics, each setting three bits taken from their
+// Keccak-256 hash.
+type Bloom [BloomByteLength]byte
+
+// Add adds data to the filter.
+func (b *Bloom) Add(data []byte) {
+	var hashBuf [6]byte
+	i1, v1, i2, v2, i3, v3 := bloomValues(data, hashBuf[:])
+	b[i1] |= v1
+	b[i2] |= v2
+	b[i3] |= v3
+}
+
+// Test reports whether data may have been added to the filter.
+func (b Bloom) Test(data []byte) bool {
+	var hashBuf [6]byte
+	i1, v1, i2, v2, i3, v3 := bloomValues(data, hashBuf[:])
+	return v1 == v1&b[i1] && v2 == v2&b[i2] && v3 == v3&b[i3]
+}
+
+// Or merges the filter other into b.
+func (b *Bloom) Or(other Bloom) {
+	for i := range b {
+		b[i] |= other[i]
+	}
+}
+
+// Bytes returns the filter as a byte slice.
+func (b Bloom) Bytes() []byte {
+	return b[:]
+}
+
+// MarshalText encodes the filter as 0x prefixed hex.
+func (b Bloom) MarshalText() ([]byte, error) {
+	return []byte("0x" + hex.EncodeToString(b[:])), nil
+}
+
+// UnmarshalText decodes a hex encoded filter.
+func (b *Bloom) UnmarshalText(input []byte) error {
+	dec, err := decodeFixedHex(input, BloomByteLength)
+	if err != nil {
+		return err
+	}
+	copy(b[BloomByteLength-len(dec):], dec)
+	return nil
+}
+
+// bloomValues returns the bytes of the filter and the bits within them set by
+// data, hashBuf holding the first six bytes of its hash.
+func bloomValues(data []byte, hashBuf []byte) (uint, byte, uint, byte, uint, byte) {
+	sha := NewKeccakState()
+	sha.Write(data)
+	sha.Read(hashBuf)
+	// The actual bits to flip
+	v1 := byte(1 << (hashBuf[1] & 0x7))
+	v2 := byte(1 << (hashBuf[3] & 0x7))
+	v3 := byte(1 << (hashBuf[5] & 0x7))
+	// The indices for the bytes to OR in
+	i1 := BloomByteLength - uint((uint16(hashBuf[0])<<8|uint16(hashBuf[1]))&2047)>>3 - 1
+	i2 := BloomByteLength - uint((uint16(hashBuf[2])<<8|uint16(hashBuf[3]))&2047)>>3 - 1
+	i3 := BloomByteLength - uint((uint16(hashBuf[4])<<8|uint16(hashBuf[5]))&2047)>>3 - 1
+	return i1, v1, i2, v2, i3, v3
+}
+
+// LogsBloom returns the filter of the addresses and topics of logs.
+func LogsBloom(logs []Log) Bloom {
+	var bloom Bloom
+	for _, log := range logs {
+		bloom.Add(log.Address.Bytes())
+		for _, topic := range log.Topics {
+			bloom.Add(topic.Bytes())
+		}
+	}
+	return bloom
+}
+
+// CreateBloom returns the filter of a block, merging the ones of its
+// receipts.
+func CreateBloom(receipts []*Receipt) Bloom {
+	var bloom Bloom
+	for _, receipt := range receipts {
+		bloom.Or(receipt.Bloom)
+	}
+	return bloom
+}
@@ -0,0 +1,219 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/debugger"
+)
+
+const debugHelp = `Commands:
+  s, step                     execute the instruction, stepping into calls
+  n, next                     execute the instruction, stepping over calls
+  o, out                      run until the current call returns
+  c, continue                 run until a breakpoint
+  b, break pc <pc> [address]  break at a pc, of the code of address if given
+  b, break op <name> [address]
+                              break at an opcode
+  b, break addr <address>     break on entering the code of address
+  b, break slot <slot> [address]
+                              break on an SLOAD or SSTORE of a storage slot
+  bl, breakpoints             list the breakpoints
+  d, delete <id>              delete a breakpoint
+  stack                       print the stack
+  mem, memory                 print the memory
+  ret, returndata             print the return data of the last call
+  q, quit                     run to completion without pausing
+  h, help                     print this help`
+
+func debugCmd(args []string, stdout, stderr io.Writer) error {
+	return debugRun(args, os.Stdin, stdout, stderr)
+}
+
+// debugRun runs the debug command, reading the debugger commands from stdin.
+func debugRun(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var f runFlags
+	fs := flag.NewFlagSet("debug", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: evm debug [flags] [code]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "The debug command runs arbitrary EVM code as the run command does, pausing")
+		fmt.Fprintln(stderr, "on its first instruction to step through it. The tracing flags are ignored.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+	f.register(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if f.code == "" && fs.NArg() > 0 {
+		f.code = fs.Arg(0)
+	}
+
+	d := debugger.New()
+	_, execFunc, err := prepareRun(&f, d)
+	if err != nil {
+		return err
+	}
+	var (
+		output  []byte
+		gasLeft uint64
+		execErr error
+	)
+	pause := d.Start(func() {
+		output, gasLeft, execErr = execFunc()
+	})
+	writePause(stdout, pause)
+
+	scanner := bufio.NewScanner(stdin)
+	for pause != nil {
+		fmt.Fprint(stdout, "> ")
+		if !scanner.Scan() {
+			d.Stop()
+			fmt.Fprintln(stdout)
+			break
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch cmd, args := fields[0], fields[1:]; cmd {
+		case "s", "step":
+			pause = d.Step()
+			writePause(stdout, pause)
+		case "n", "next":
+			pause = d.StepOver()
+			writePause(stdout, pause)
+		case "o", "out":
+			pause = d.StepOut()
+			writePause(stdout, pause)
+		case "c", "continue":
+			pause = d.Continue()
+			writePause(stdout, pause)
+		case "b", "break":
+			bp, err := parseBreakpoint(args)
+			if err == nil {
+				bp.ID, err = d.AddBreakpoint(bp)
+			}
+			if err != nil {
+				fmt.Fprintln(stdout, "Error:", err)
+				continue
+			}
+			fmt.Fprintln(stdout, "breakpoint", bp)
+		case "bl", "breakpoints":
+			for _, bp := range d.Breakpoints() {
+				fmt.Fprintln(stdout, bp)
+			}
+		case "d", "delete":
+			id, err := strconv.Atoi(strings.TrimPrefix(strings.Join(args, ""), "#"))
+			if err != nil || !d.RemoveBreakpoint(id) {
+				fmt.Fprintf(stdout, "Error: no breakpoint %q\n", strings.Join(args, " "))
+			}
+		case "stack":
+			data := pause.Scope.Stack.Data()
+			for i := len(data) - 1; i >= 0; i-- {
+				fmt.Fprintf(stdout, "%08d  %s\n", len(data)-i-1, data[i].Hex())
+			}
+		case "mem", "memory":
+			fmt.Fprint(stdout, hex.Dump(pause.Scope.Memory.Data()))
+		case "ret", "returndata":
+			fmt.Fprint(stdout, hex.Dump(pause.ReturnData))
+		case "q", "quit":
+			d.Stop()
+			pause = nil
+		case "h", "help":
+			fmt.Fprintln(stdout, debugHelp)
+		default:
+			fmt.Fprintf(stdout, "Unknown command %q, type 'help' for the commands\n", cmd)
+		}
+	}
+	writeResult(stdout, output, f.gas-gasLeft, execErr)
+	return nil
+}
+
+// writePause prints the instruction the execution is paused on.
+func writePause(w io.Writer, pause *debugger.Pause) {
+	if pause == nil {
+		fmt.Fprintln(w, "execution completed")
+		return
+	}
+	if pause.Breakpoint != nil {
+		fmt.Fprintln(w, "breakpoint", pause.Breakpoint)
+	}
+	fmt.Fprintf(w, "[%d] %#x pc=%08d %-16v gas=%v cost=%v\n", pause.Depth, pause.CodeAddress().Bytes(), pause.PC, pause.Op, pause.Gas, pause.Cost)
+	if pause.Err != nil {
+		fmt.Fprintln(w, "ERROR:", pause.Err)
+	}
+}
+
+// parseBreakpoint parses the arguments of the break command.
+func parseBreakpoint(args []string) (debugger.Breakpoint, error) {
+	var bp debugger.Breakpoint
+	if len(args) < 2 {
+		return bp, errors.New("usage: break pc|op|addr|slot <value> [address]")
+	}
+	kind, value, rest := args[0], args[1], args[2:]
+	switch kind {
+	case "pc":
+		pc, err := strconv.ParseUint(value, 0, 64)
+		if err != nil {
+			return bp, fmt.Errorf("invalid pc %q", value)
+		}
+		bp.Kind, bp.PC = debugger.BreakPC, pc
+	case "op":
+		op := evm.StringToOp(strings.ToUpper(value))
+		if op.String() != strings.ToUpper(value) {
+			return bp, fmt.Errorf("unknown opcode %q", value)
+		}
+		bp.Kind, bp.Op = debugger.BreakOp, op
+	case "addr":
+		rest = args[1:]
+		bp.Kind = debugger.BreakAddress
+	case "slot":
+		slot, ok := new(big.Int).SetString(value, 0)
+		if !ok || slot.Sign() < 0 || slot.BitLen() > 256 {
+			return bp, fmt.Errorf("invalid slot %q", value)
+		}
+		bp.Kind, bp.Slot = debugger.BreakStorage, evm.BytesToHash(slot.Bytes())
+	default:
+		return bp, fmt.Errorf("unknown breakpoint kind %q", kind)
+	}
+	switch len(rest) {
+	case 0:
+	case 1:
+		addr := evm.HexToAddress(rest[0])
+		bp.Address = &addr
+	default:
+		return bp, errors.New("too many arguments")
+	}
+	return bp, nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/memstate"
+	"github.com/lyonnee/evm/params"
+)
+
+// genesis is the subset of a genesis file used to seed the execution: the
+// chain configuration, the block environment and the initial accounts.
+type genesis struct {
+	Config     *params.ChainConfig   `json:"config"`
+	Coinbase   evm.Address           `json:"coinbase"`
+	Number     math.HexOrDecimal64   `json:"number"`
+	Timestamp  math.HexOrDecimal64   `json:"timestamp"`
+	GasLimit   math.HexOrDecimal64   `json:"gasLimit"`
+	Difficulty *math.HexOrDecimal256 `json:"difficulty"`
+	BaseFee    *math.HexOrDecimal256 `json:"baseFeePerGas"`
+	Mixhash    evm.Hash              `json:"mixHash"`
+	Alloc      memstate.GenesisAlloc `json:"alloc"`
+}
+
+// readJSON decodes the given JSON file into v.
+func readJSON(path string, v interface{}) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := json.NewDecoder(file).Decode(v); err != nil {
+		return fmt.Errorf("invalid JSON file %s: %v", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,342 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	goruntime "runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/logger"
+	"github.com/lyonnee/evm/memstate"
+	"github.com/lyonnee/evm/params"
+)
+
+// runFlags are the options of the run command.
+type runFlags struct {
+	code      string
+	codeFile  string
+	input     string
+	inputFile string
+	value     big.Int
+	price     big.Int
+	gas       uint64
+	sender    string
+	receiver  string
+	create    bool
+
+	fork        string
+	chainConfig string
+	prestate    string
+
+	json         bool
+	debug        bool
+	noMemory     bool
+	noStack      bool
+	noStorage    bool
+	noReturnData bool
+	statDump     bool
+	bench        bool
+}
+
+func (f *runFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.code, "code", "", "EVM code as hex")
+	fs.StringVar(&f.codeFile, "codefile", "", "file containing EVM code as hex, '-' reads from stdin")
+	fs.StringVar(&f.input, "input", "", "call input as hex")
+	fs.StringVar(&f.inputFile, "inputfile", "", "file containing the call input as hex")
+	fs.TextVar(&f.value, "value", new(big.Int), "value set for the call")
+	fs.TextVar(&f.price, "price", new(big.Int), "gas price set for the call")
+	fs.Uint64Var(&f.gas, "gas", 10000000000, "gas limit for the call")
+	fs.StringVar(&f.sender, "sender", "", "the transaction origin (default \"sender\")")
+	fs.StringVar(&f.receiver, "receiver", "", "the address holding the code (default \"receiver\")")
+	fs.BoolVar(&f.create, "create", false, "run the code as initcode of a contract creation")
+
+	fs.StringVar(&f.fork, "fork", "Shanghai", "fork rules to run with, one of "+strings.Join(params.AvailableForks(), ", "))
+	fs.StringVar(&f.chainConfig, "chainconfig", "", "chain configuration JSON file, overrides --fork")
+	fs.StringVar(&f.prestate, "prestate", "", "genesis JSON file seeding the state, block environment and chain configuration")
+
+	fs.BoolVar(&f.json, "json", false, "output an EIP-3155 JSON trace to stdout")
+	fs.BoolVar(&f.debug, "debug", false, "output the full trace and the logs to stderr")
+	fs.BoolVar(&f.noMemory, "nomemory", false, "disable memory output")
+	fs.BoolVar(&f.noStack, "nostack", false, "disable stack output")
+	fs.BoolVar(&f.noStorage, "nostorage", false, "disable storage output")
+	fs.BoolVar(&f.noReturnData, "noreturndata", false, "disable return data output")
+	fs.BoolVar(&f.statDump, "statdump", false, "print gas, time and allocation statistics to stderr")
+	fs.BoolVar(&f.bench, "bench", false, "benchmark the execution, implies --statdump")
+}
+
+type execStats struct {
+	time           time.Duration // The execution time.
+	allocs         int64         // The number of heap allocations during execution.
+	bytesAllocated int64         // The cumulative number of bytes allocated during execution.
+}
+
+func timedExec(bench bool, execFunc func() ([]byte, uint64, error)) (output []byte, gasLeft uint64, stats execStats, err error) {
+	if bench {
+		result := testing.Benchmark(func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				output, gasLeft, err = execFunc()
+			}
+		})
+
+		// Get the average execution time from the benchmarking result.
+		stats.time = time.Duration(result.NsPerOp())
+		stats.allocs = result.AllocsPerOp()
+		stats.bytesAllocated = result.AllocedBytesPerOp()
+	} else {
+		var memStatsBefore, memStatsAfter goruntime.MemStats
+		goruntime.ReadMemStats(&memStatsBefore)
+		startTime := time.Now()
+		output, gasLeft, err = execFunc()
+		stats.time = time.Since(startTime)
+		goruntime.ReadMemStats(&memStatsAfter)
+		stats.allocs = int64(memStatsAfter.Mallocs - memStatsBefore.Mallocs)
+		stats.bytesAllocated = int64(memStatsAfter.TotalAlloc - memStatsBefore.TotalAlloc)
+	}
+
+	return output, gasLeft, stats, err
+}
+
+// readHex loads hex data given inline or from a file, '-' denoting stdin.
+func readHex(inline, path string) ([]byte, error) {
+	data := []byte(inline)
+	if path != "" {
+		var err error
+		if path == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("0x")) {
+		data = data[2:]
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("invalid input length for hex data (%d)", len(data))
+	}
+	code, err := hex.DecodeString(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex data: %w", err)
+	}
+	return code, nil
+}
+
+// loadChainConfig resolves the chain configuration from the flags, the
+// genesis configuration taking precedence over the fork name.
+func loadChainConfig(f *runFlags, gen *genesis) (*params.ChainConfig, error) {
+	if f.chainConfig != "" {
+		config := new(params.ChainConfig)
+		if err := readJSON(f.chainConfig, config); err != nil {
+			return nil, err
+		}
+		return config, nil
+	}
+	if gen.Config != nil {
+		return gen.Config, nil
+	}
+	return params.ForkConfig(f.fork)
+}
+
+// prepareRun sets up the state and the EVM traced by tracer the run flags
+// describe, and returns the function executing the code.
+func prepareRun(f *runFlags, tracer evm.EVMLogger) (*memstate.StateDB, func() ([]byte, uint64, error), error) {
+	gen := new(genesis)
+	if f.prestate != "" {
+		if err := readJSON(f.prestate, gen); err != nil {
+			return nil, nil, err
+		}
+	}
+	chainConfig, err := loadChainConfig(f, gen)
+	if err != nil {
+		return nil, nil, err
+	}
+	code, err := readHex(f.code, f.codeFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not load code: %v", err)
+	}
+	input, err := readHex(f.input, f.inputFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not load input: %v", err)
+	}
+
+	var (
+		statedb  = memstate.NewFromAlloc(gen.Alloc)
+		sender   = evm.BytesToAddr([]byte("sender"))
+		receiver = evm.BytesToAddr([]byte("receiver"))
+	)
+	if f.sender != "" {
+		sender = evm.HexToAddress(f.sender)
+	}
+	if f.receiver != "" {
+		receiver = evm.HexToAddress(f.receiver)
+	}
+	statedb.CreateAccount(sender)
+
+	blockCtx := evm.BlockContext{
+		CanTransfer: evm.CanTransfer,
+		Transfer:    evm.Transfer,
+		GetHash: func(n uint64) evm.Hash {
+			return evm.Keccak256Hash([]byte(new(big.Int).SetUint64(n).String()))
+		},
+		Coinbase:    gen.Coinbase,
+		GasLimit:    uint64(gen.GasLimit),
+		BlockNumber: new(big.Int).SetUint64(uint64(gen.Number)),
+		Time:        uint64(gen.Timestamp),
+		Difficulty:  new(big.Int),
+		BaseFee:     new(big.Int),
+	}
+	if blockCtx.GasLimit == 0 {
+		blockCtx.GasLimit = f.gas
+	}
+	if gen.Difficulty != nil {
+		blockCtx.Difficulty = (*big.Int)(gen.Difficulty)
+	}
+	if gen.BaseFee != nil {
+		blockCtx.BaseFee = (*big.Int)(gen.BaseFee)
+	}
+	if chainConfig.IsMergedFromGenesis() {
+		random := gen.Mixhash
+		blockCtx.Random = &random
+	}
+	txCtx := evm.TxContext{
+		Origin:   sender,
+		GasPrice: &f.price,
+	}
+	vm := evm.NewEVM(blockCtx, txCtx, statedb, chainConfig, evm.Config{Tracer: tracer})
+
+	// Warm the accounts as a transaction would (EIP-2929, EIP-3651)
+	rules := vm.ChainRules()
+	if rules.IsBerlin {
+		statedb.AddAddressToAccessList(sender)
+		if !f.create {
+			statedb.AddAddressToAccessList(receiver)
+		}
+		for _, addr := range vm.ActivePrecompiles() {
+			statedb.AddAddressToAccessList(addr)
+		}
+		if rules.IsShanghai {
+			statedb.AddAddressToAccessList(blockCtx.Coinbase)
+		}
+	}
+
+	var execFunc func() ([]byte, uint64, error)
+	if f.create {
+		initcode := append(code, input...)
+		execFunc = func() ([]byte, uint64, error) {
+			output, _, gasLeft, err := vm.Create(evm.AccountRef(sender), initcode, f.gas, &f.value)
+			return output, gasLeft, err
+		}
+	} else {
+		if len(code) > 0 {
+			statedb.SetCode(receiver, code)
+		}
+		execFunc = func() ([]byte, uint64, error) {
+			return vm.Call(evm.AccountRef(sender), receiver, input, f.gas, &f.value)
+		}
+	}
+
+	return statedb, execFunc, nil
+}
+
+func runCmd(args []string, stdout, stderr io.Writer) error {
+	var f runFlags
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: evm run [flags] [code]")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "The run command runs arbitrary EVM code against an in-memory state.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+	f.register(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if f.code == "" && fs.NArg() > 0 {
+		f.code = fs.Arg(0)
+	}
+
+	logconfig := &logger.Config{
+		EnableMemory:     !f.noMemory,
+		DisableStack:     f.noStack,
+		DisableStorage:   f.noStorage,
+		EnableReturnData: !f.noReturnData,
+	}
+	var (
+		tracer      evm.EVMLogger
+		debugLogger *logger.StructLogger
+	)
+	if f.json {
+		tracer = logger.NewJSONLogger(logconfig, stdout)
+	} else if f.debug {
+		debugLogger = logger.NewStructLogger(logconfig)
+		tracer = debugLogger
+	}
+	statedb, execFunc, err := prepareRun(&f, tracer)
+	if err != nil {
+		return err
+	}
+
+	output, leftOverGas, stats, err := timedExec(f.bench, execFunc)
+
+	if f.debug {
+		if debugLogger != nil {
+			fmt.Fprintln(stderr, "#### TRACE ####")
+			logger.WriteTrace(stderr, debugLogger.StructLogs())
+		}
+		fmt.Fprintln(stderr, "#### LOGS ####")
+		logger.WriteLogs(stderr, statedb.Logs())
+	}
+	if f.bench || f.statDump {
+		fmt.Fprintf(stderr, `EVM gas used:    %d
+execution time:  %v
+allocations:     %d
+allocated bytes: %d
+`, f.gas-leftOverGas, stats.time, stats.allocs, stats.bytesAllocated)
+	}
+	if !f.json {
+		writeResult(stdout, output, f.gas-leftOverGas, err)
+	}
+	return nil
+}
+
+// writeResult prints the output, gas used and error of an execution.
+func writeResult(w io.Writer, output []byte, gasUsed uint64, err error) {
+	fmt.Fprintf(w, "%#x\n", output)
+	fmt.Fprintf(w, "gas used: %d\n", gasUsed)
+	if err != nil {
+		fmt.Fprintf(w, "error: %v\n", evm.WrapRevert(output, err))
+	}
+}
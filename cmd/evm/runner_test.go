@@ -0,0 +1,138 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lyonnee/evm"
+)
+
+func TestRunCmd(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	// PUSH1 0x2a PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+	if err := runCmd([]string{"--code", "602a60005260206000f3"}, &stdout, &stderr); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	want := "0x000000000000000000000000000000000000000000000000000000000000002a\ngas used: 18\n"
+	if stdout.String() != want {
+		t.Errorf("output mismatch: have %q, want %q", stdout.String(), want)
+	}
+}
+
+func TestRunCmdInvalidCode(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	for _, code := range []string{"6001zz", "600"} {
+		if err := runCmd([]string{"--code", code}, &stdout, &stderr); err == nil {
+			t.Errorf("code %s: invalid hex accepted", code)
+		}
+	}
+}
+
+func TestRunCmdJSON(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if err := runCmd([]string{"--json", "--fork", "Istanbul", "6001600101"}, &stdout, &stderr); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("line count mismatch: have %d, want 5\n%s", len(lines), stdout.String())
+	}
+	if !strings.Contains(lines[2], `"opName":"ADD"`) || !strings.Contains(lines[4], `"gasUsed":"0x9"`) {
+		t.Errorf("unexpected trace:\n%s", stdout.String())
+	}
+}
+
+func TestRunCmdPrestate(t *testing.T) {
+	dir := t.TempDir()
+	prestate := filepath.Join(dir, "genesis.json")
+	// The receiver returns its balance and the value of slot 1
+	genesis := `{
+		"config": {"chainId": 1, "homesteadBlock": 0, "eip150Block": 0, "eip155Block": 0, "eip158Block": 0, "byzantiumBlock": 0},
+		"number": "0x10",
+		"alloc": {
+			"0x00000000000000000000000000000000000000aa": {
+				"balance": "0x3",
+				"code": "0x600154303160005260205260406000f3",
+				"storage": {"0x01": "0x07"}
+			}
+		}
+	}`
+	if err := os.WriteFile(prestate, []byte(genesis), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	args := []string{"--prestate", prestate, "--receiver", "0xaa"}
+	if err := runCmd(args, &stdout, &stderr); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	want := "0x" + strings.Repeat("0", 63) + "3" + strings.Repeat("0", 63) + "7"
+	if have := strings.SplitN(stdout.String(), "\n", 2)[0]; have != want {
+		t.Errorf("output mismatch: have %s, want %s", have, want)
+	}
+}
+
+func TestStateTestCmd(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	scheme := "native"
+	if evm.AddressLength == 20 {
+		scheme = "ethereum"
+	}
+	fixture := filepath.Join("..", "..", "tests", "testdata", scheme, "add11.json")
+	if err := stateTestCmd([]string{"--fork", "London", fixture}, &stdout, &stderr); err != nil {
+		t.Fatalf("statetest failed: %v\n%s", err, stdout.String())
+	}
+	var results []StatetestResult
+	if err := json.Unmarshal(stdout.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("result count mismatch: have %d, want 2", len(results))
+	}
+	for _, result := range results {
+		if !result.Pass || result.Fork != "London" {
+			t.Errorf("unexpected result %+v", result)
+		}
+	}
+}
+
+func TestDebugCmd(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	script := strings.Join([]string{"break op MSTORE", "continue", "stack", "step", "memory", "delete 1", "bogus", "continue"}, "\n")
+	if err := debugRun([]string{"602a60005260206000f3"}, strings.NewReader(script), &stdout, &stderr); err != nil {
+		t.Fatalf("debug failed: %v", err)
+	}
+	out := stdout.String()
+	for _, want := range []string{
+		"breakpoint #1 op MSTORE\n",
+		"pc=00000004 MSTORE",
+		"00000000  0x0\n00000001  0x2a\n",
+		"pc=00000005 PUSH1",
+		"00 00 00 00 00 00 00 2a  |...............*|",
+		`Unknown command "bogus"`,
+		"execution completed\n0x000000000000000000000000000000000000000000000000000000000000002a\ngas used: 18\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output misses %q:\n%s", want, out)
+		}
+	}
+}
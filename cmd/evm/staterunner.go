@@ -0,0 +1,128 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/logger"
+	"github.com/lyonnee/evm/memstate"
+	"github.com/lyonnee/evm/tests"
+)
+
+// StatetestResult contains the execution status after running a state test, any
+// error that might have occurred and a dump of the final state if requested.
+type StatetestResult struct {
+	Name  string                 `json:"name"`
+	Pass  bool                   `json:"pass"`
+	Root  *evm.Hash              `json:"stateRoot,omitempty"`
+	Fork  string                 `json:"fork"`
+	Index int                    `json:"index"`
+	Error string                 `json:"error,omitempty"`
+	State *memstate.GenesisAlloc `json:"state,omitempty"`
+}
+
+func stateTestCmd(args []string, stdout, stderr io.Writer) error {
+	var (
+		fork      string
+		trace     bool
+		dump      bool
+		noMemory  bool
+		noStack   bool
+		noStorage bool
+	)
+	fs := flag.NewFlagSet("statetest", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: evm statetest [flags] <file>")
+		fmt.Fprintln(stderr)
+		fmt.Fprintln(stderr, "The statetest command executes the GeneralStateTests fixtures of the given file.")
+		fmt.Fprintln(stderr)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&fork, "fork", "", "only run the post-states of the given fork")
+	fs.BoolVar(&trace, "json", false, "output an EIP-3155 JSON trace to stderr")
+	fs.BoolVar(&dump, "dump", false, "include the post-state in the results")
+	fs.BoolVar(&noMemory, "nomemory", false, "disable memory output")
+	fs.BoolVar(&noStack, "nostack", false, "disable stack output")
+	fs.BoolVar(&noStorage, "nostorage", false, "disable storage output")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected a single fixture file")
+	}
+	var fixtures map[string]tests.StateTest
+	if err := readJSON(fs.Arg(0), &fixtures); err != nil {
+		return err
+	}
+
+	cfg := evm.Config{}
+	if trace {
+		cfg.Tracer = logger.NewJSONLogger(&logger.Config{
+			EnableMemory:   !noMemory,
+			DisableStack:   noStack,
+			DisableStorage: noStorage,
+		}, stderr)
+	}
+	names := make([]string, 0, len(fixtures))
+	for name := range fixtures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	results := make([]StatetestResult, 0)
+	failed := 0
+	for _, name := range names {
+		test := fixtures[name]
+		for _, st := range test.Subtests() {
+			if fork != "" && st.Fork != fork {
+				continue
+			}
+			result := StatetestResult{Name: name, Fork: st.Fork, Index: st.Index, Pass: true}
+			statedb, root, err := test.Run(st, cfg)
+			if statedb != nil {
+				result.Root = &root
+				if dump {
+					alloc := statedb.Dump()
+					result.State = &alloc
+				}
+			}
+			if err != nil {
+				result.Pass, result.Error = false, err.Error()
+				failed++
+			}
+			results = append(results, result)
+		}
+	}
+	out, _ := json.MarshalIndent(results, "", "  ")
+	fmt.Fprintln(stdout, string(out))
+	if failed > 0 {
+		return fmt.Errorf("%d of %d tests failed", failed, len(results))
+	}
+	return nil
+}
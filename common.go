@@ -18,6 +18,7 @@ package evm
 
 import (
 	"encoding/hex"
+	"errors"
 	"math"
 
 	"github.com/holiman/uint256"
@@ -82,6 +83,17 @@ func allZero(b []byte) bool {
 	return true
 }
 
+// CopyBytes returns an exact copy of the provided bytes.
+func CopyBytes(b []byte) (copiedBytes []byte) {
+	if b == nil {
+		return nil
+	}
+	copiedBytes = make([]byte, len(b))
+	copy(copiedBytes, b)
+
+	return
+}
+
 // RightPadBytes zero-pads slice to the right up to length l.
 func rightPadBytes(slice []byte, l int) []byte {
 	if l <= len(slice) {
@@ -114,6 +126,27 @@ func FromHex(s string) []byte {
 	}
 	return Hex2Bytes(s)
 }
+
+// decodeFixedHex decodes an optionally 0x prefixed hex string holding at most
+// max bytes.
+func decodeFixedHex(input []byte, max int) ([]byte, error) {
+	s := string(input)
+	if has0xPrefix(s) {
+		s = s[2:]
+	}
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > max {
+		return nil, errors.New("hex string too long")
+	}
+	return b, nil
+}
+
 func has0xPrefix(str string) bool {
 	return len(str) >= 2 && str[0] == '0' && (str[1] == 'x' || str[1] == 'X')
 }
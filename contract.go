@@ -43,13 +43,23 @@ type Contract struct {
 	jumpdests map[Hash]bitvec // Aggregated result of JUMPDEST analysis.
 	analysis  bitvec          // Locally cached result of JUMPDEST analysis
 
-	Code     []byte
-	CodeHash Hash
-	CodeAddr *Address
-	Input    []byte
+	Code      []byte
+	Container *Container // Parsed code of EOF contracts, nil for legacy code
+	CodeHash  Hash
+	CodeAddr  *Address
+	Input     []byte
 
 	Gas   uint64
 	value *big.Int
+
+	section     uint64          // Code section executed by an EOF contract
+	returnStack []returnContext // Return addresses of CALLF
+}
+
+// returnContext is the code section and the pc RETF returns to.
+type returnContext struct {
+	section uint64
+	pc      uint64
 }
 
 // 返回合约调用者的地址
@@ -161,6 +171,34 @@ func (c *Contract) GetOp(n uint64) OpCode {
 	return STOP
 }
 
+// CodeAt returns the given code section of an EOF contract, or the code of a
+// legacy contract.
+func (c *Contract) CodeAt(section uint64) []byte {
+	if c.Container == nil {
+		return c.Code
+	}
+	return c.Container.Code[section]
+}
+
+// CodeSection returns the code section an EOF contract is executing, always 0
+// for legacy code.
+func (c *Contract) CodeSection() uint64 {
+	return c.section
+}
+
+// sectionCode returns the code of the section being executed.
+func (c *Contract) sectionCode() []byte {
+	return c.CodeAt(c.section)
+}
+
+// opAt returns the n'th opcode of the code section being executed.
+func (c *Contract) opAt(n uint64) OpCode {
+	if code := c.sectionCode(); n < uint64(len(code)) {
+		return OpCode(code[n])
+	}
+	return STOP
+}
+
 // NewContract returns a new contract environment for the execution of EVM.
 func NewContract(caller ContractRef, object ContractRef, value *big.Int, gas uint64) *Contract {
 	c := &Contract{CallerAddress: caller.Address(), caller: caller, self: object}
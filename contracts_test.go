@@ -19,10 +19,17 @@ package evm
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math/big"
 	"os"
 	"testing"
 	"time"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/lyonnee/evm/params"
 )
 
 // precompiledTest defines the input/output pairs for precompiled contract tests.
@@ -309,6 +316,117 @@ func TestPrecompiledBLS12381PairingFail(t *testing.T)    { testJsonFail("blsPair
 func TestPrecompiledBLS12381MapG1Fail(t *testing.T)      { testJsonFail("blsMapG1", "f11", t) }
 func TestPrecompiledBLS12381MapG2Fail(t *testing.T)      { testJsonFail("blsMapG2", "f12", t) }
 
+// The Prague operations return the same results as the draft ones, G1Mul and
+// G2Mul being served by the multi-scalar multiplications.
+func TestPrecompiledBLS12381Prague(t *testing.T) {
+	tests := []struct{ name, addr string }{
+		{"blsG1Add", "0b"},
+		{"blsG1Mul", "0c"},
+		{"blsG1MultiExp", "0c"},
+		{"blsG2Add", "0d"},
+		{"blsG2Mul", "0e"},
+		{"blsG2MultiExp", "0e"},
+		{"blsPairing", "0f"},
+		{"blsMapG1", "10"},
+		{"blsMapG2", "11"},
+	}
+	for _, tt := range tests {
+		vectors, err := loadJson(tt.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := PrecompiledContractsPrague[HexToAddress(tt.addr)]
+		for _, test := range vectors {
+			in := Hex2Bytes(test.Input)
+			res, _, err := RunPrecompiledContract(p, in, p.RequiredGas(in))
+			if err != nil {
+				t.Errorf("%s %s: %v", tt.name, test.Name, err)
+			} else if Bytes2Hex(res) != test.Expected {
+				t.Errorf("%s %s: result mismatch: have %x, want %s", tt.name, test.Name, res, test.Expected)
+			}
+		}
+	}
+}
+
+func TestPrecompiledBLS12381PragueGas(t *testing.T) {
+	tests := []struct {
+		addr string
+		size int
+		want uint64
+	}{
+		{"0b", 256, 375},
+		{"0c", 160, 12000},
+		{"0c", 2 * 160, 2 * 12000 * 949 / 1000},
+		{"0c", 200 * 160, 200 * 12000 * 519 / 1000},
+		{"0d", 512, 600},
+		{"0e", 288, 22500},
+		{"0e", 2 * 288, 2 * 22500},
+		{"0e", 3 * 288, 3 * 22500 * 923 / 1000},
+		{"0e", 200 * 288, 200 * 22500 * 524 / 1000},
+		{"0f", 384, 37700 + 32600},
+		{"0f", 2 * 384, 37700 + 2*32600},
+		{"10", 64, 5500},
+		{"11", 128, 23800},
+	}
+	for _, tt := range tests {
+		p := PrecompiledContractsPrague[HexToAddress(tt.addr)]
+		if have := p.RequiredGas(make([]byte, tt.size)); have != tt.want {
+			t.Errorf("%s with %d bytes: gas mismatch: have %d, want %d", tt.addr, tt.size, have, tt.want)
+		}
+	}
+}
+
+// The Prague multi-scalar multiplication rejects points outside of the
+// prime order subgroup, the addition accepts any point on the curve.
+func TestPrecompiledBLS12381PragueSubgroup(t *testing.T) {
+	// Find a point on y^2 = x^3 + 4, which is outside of the subgroup with
+	// overwhelming probability given the size of the cofactor.
+	modulus, _ := new(big.Int).SetString("1a0111ea397fe69a4b1ba7b6434bacd764774b84f38512bf6730d2a0f6b0f6241eabfffeb153ffffb9feffffffffaaab", 16)
+	exp := new(big.Int).Rsh(new(big.Int).Add(modulus, big.NewInt(1)), 2)
+	var x, y *big.Int
+	for i := int64(1); ; i++ {
+		x = big.NewInt(i)
+		rhs := new(big.Int).Exp(x, big.NewInt(3), modulus)
+		rhs.Add(rhs, big.NewInt(4)).Mod(rhs, modulus)
+		y = new(big.Int).Exp(rhs, exp, modulus)
+		if new(big.Int).Exp(y, big.NewInt(2), modulus).Cmp(rhs) == 0 {
+			break
+		}
+	}
+	point := make([]byte, 128)
+	x.FillBytes(point[16:64])
+	y.FillBytes(point[80:128])
+
+	add := PrecompiledContractsPrague[BytesToAddr([]byte{0x0b})]
+	if _, err := add.Run(append(CopyBytes(point), point...)); err != nil {
+		t.Errorf("addition failed: %v", err)
+	}
+	msm := PrecompiledContractsPrague[BytesToAddr([]byte{0x0c})]
+	input := append(CopyBytes(point), make([]byte, 32)...)
+	input[159] = 1
+	if _, err := msm.Run(input); err != errBLS12381G1PointSubgroup {
+		t.Errorf("error mismatch: have %v, want %v", err, errBLS12381G1PointSubgroup)
+	}
+	// The draft operation did not check the subgroup.
+	if _, err := allPrecompiles[BytesToAddr([]byte{0x0f, 0x0c})].Run(input); err != nil {
+		t.Errorf("draft multiplication failed: %v", err)
+	}
+}
+
+func TestActivePrecompilesPrague(t *testing.T) {
+	config, err := params.ForkConfig("Prague")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := config.Rules(new(big.Int), true, 0)
+	if have, want := len(ActivePrecompiles(rules)), 17; have != want {
+		t.Errorf("precompile count mismatch: have %d, want %d", have, want)
+	}
+	if _, ok := precompiledContracts(rules)[BytesToAddr([]byte{0x11})]; !ok {
+		t.Error("map to G2 not active")
+	}
+}
+
 func loadJson(name string) ([]precompiledTest, error) {
 	data, err := os.ReadFile(fmt.Sprintf("testdata/precompiles/%v.json", name))
 	if err != nil {
@@ -367,3 +485,151 @@ func BenchmarkPrecompiledBLS12381G2MultiExpWorstCase(b *testing.B) {
 	}
 	benchmarkPrecompiled("0f", testcase, b)
 }
+
+// counterPrecompile is a stateful precompile counting its calls in the
+// storage of the account it runs in the context of, and remembering the
+// environment of the last call.
+type counterPrecompile struct {
+	last PrecompileContext
+}
+
+func (c *counterPrecompile) RequiredGas(input []byte) uint64 { return 100 }
+
+func (c *counterPrecompile) Run(input []byte) ([]byte, error) {
+	return nil, errors.New("stateful precompile run without context")
+}
+
+func (c *counterPrecompile) RunStateful(ctx *PrecompileContext, input []byte) ([]byte, error) {
+	c.last = *ctx
+	if ctx.ReadOnly {
+		return nil, ErrWriteProtection
+	}
+	if !ctx.UseGas(5000) {
+		return nil, ErrOutOfGas
+	}
+	key := Hash{}
+	count := new(big.Int).SetBytes(ctx.StateDB.GetState(ctx.StorageAddress, key).Bytes())
+	count.Add(count, big.NewInt(1))
+	ctx.StateDB.SetState(ctx.StorageAddress, key, BytesToHash(count.Bytes()))
+	return count.Bytes(), nil
+}
+
+func TestStatefulPrecompile(t *testing.T) {
+	var (
+		caller  = BytesToAddr([]byte("caller"))
+		counter = BytesToAddr([]byte{0xc0, 0x01})
+		p       = new(counterPrecompile)
+	)
+	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	db := &StateDBImpl{db: statedb}
+	db.AddBalance(caller, big.NewInt(100))
+
+	precompiles := DefaultPrecompiles(allEthashProtocolChanges.Rules(new(big.Int), false, 0))
+	precompiles[counter] = p
+	vmctx := BlockContext{CanTransfer: CanTransfer, Transfer: Transfer, BlockNumber: new(big.Int)}
+	evm := NewEVM(vmctx, TxContext{}, db, allEthashProtocolChanges, Config{Precompiles: precompiles})
+
+	ret, gas, err := evm.Call(AccountRef(caller), counter, nil, 10000, big.NewInt(7))
+	if err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if !bytes.Equal(ret, []byte{1}) || gas != 10000-100-5000 {
+		t.Errorf("call result mismatch: have %x gas %d", ret, gas)
+	}
+	if p.last.Caller != caller || p.last.Value.Cmp(big.NewInt(7)) != 0 || p.last.ReadOnly {
+		t.Errorf("call environment mismatch: %+v", p.last)
+	}
+	if p.last.CallType != CALL || p.last.StorageAddress != counter {
+		t.Errorf("call context mismatch: %v in %x", p.last.CallType, p.last.StorageAddress)
+	}
+	if have := db.GetBalance(counter); have.Cmp(big.NewInt(7)) != 0 {
+		t.Errorf("value not transferred: have %v", have)
+	}
+
+	// Static calls must be flagged read-only and failures reverted.
+	_, gas, err = evm.StaticCall(AccountRef(caller), counter, nil, 10000)
+	if err != ErrWriteProtection || gas != 0 || !p.last.ReadOnly {
+		t.Errorf("static call mismatch: err %v gas %d readOnly %v", err, gas, p.last.ReadOnly)
+	}
+	if p.last.CallType != STATICCALL || p.last.StorageAddress != counter {
+		t.Errorf("static call context mismatch: %v in %x", p.last.CallType, p.last.StorageAddress)
+	}
+	// Out of gas within the precompile reverts the storage write.
+	if _, _, err = evm.Call(AccountRef(caller), counter, nil, 1000, new(big.Int)); err != ErrOutOfGas {
+		t.Errorf("expected out of gas, have %v", err)
+	}
+	if have := db.GetState(counter, Hash{}); have != BytesToHash([]byte{1}) {
+		t.Errorf("counter mismatch: have %x", have)
+	}
+
+	// Delegate calls see the caller and value of the parent frame, and run
+	// in the context of the parent.
+	parentAddr := BytesToAddr([]byte("parent"))
+	parent := NewContract(AccountRef(caller), AccountRef(parentAddr), big.NewInt(3), 10000)
+	if _, _, err = evm.DelegateCall(parent, counter, nil, 10000); err != nil {
+		t.Fatalf("delegate call failed: %v", err)
+	}
+	if p.last.Caller != caller || p.last.Value.Cmp(big.NewInt(3)) != 0 || p.last.Address != counter {
+		t.Errorf("delegate call environment mismatch: %+v", p.last)
+	}
+	if p.last.CallType != DELEGATECALL || p.last.StorageAddress != parentAddr {
+		t.Errorf("delegate call context mismatch: %v in %x", p.last.CallType, p.last.StorageAddress)
+	}
+	if have := db.GetState(parentAddr, Hash{}); have != BytesToHash([]byte{1}) {
+		t.Errorf("parent counter mismatch: have %x", have)
+	}
+	// Callcode runs in the context of the caller, with its own value.
+	if _, _, err = evm.CallCode(AccountRef(caller), counter, nil, 10000, big.NewInt(2)); err != nil {
+		t.Fatalf("callcode failed: %v", err)
+	}
+	if p.last.Caller != caller || p.last.Value.Cmp(big.NewInt(2)) != 0 || p.last.Address != counter {
+		t.Errorf("callcode environment mismatch: %+v", p.last)
+	}
+	if p.last.CallType != CALLCODE || p.last.StorageAddress != caller {
+		t.Errorf("callcode context mismatch: %v in %x", p.last.CallType, p.last.StorageAddress)
+	}
+	if have := db.GetState(caller, Hash{}); have != BytesToHash([]byte{1}) {
+		t.Errorf("caller counter mismatch: have %x", have)
+	}
+	// The precompile's own storage was only written by the plain call.
+	if have := db.GetState(counter, Hash{}); have != BytesToHash([]byte{1}) {
+		t.Errorf("counter mismatch: have %x", have)
+	}
+}
+
+func TestPrecompilesOverride(t *testing.T) {
+	custom := BytesToAddr([]byte{0xc0, 0x01})
+	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	vmctx := BlockContext{CanTransfer: CanTransfer, Transfer: Transfer, BlockNumber: new(big.Int)}
+
+	evm := NewEVM(vmctx, TxContext{}, &StateDBImpl{db: statedb}, allEthashProtocolChanges, Config{
+		Precompiles: map[Address]PrecompiledContract{custom: &dataCopy{}},
+	})
+	if active := evm.ActivePrecompiles(); len(active) != 1 || active[0] != custom {
+		t.Fatalf("active precompiles mismatch: %v", active)
+	}
+	if _, ok := evm.precompile(BytesToAddr([]byte{1})); ok {
+		t.Error("overridden fork precompile still active")
+	}
+	ret, _, err := evm.Call(AccountRef(NilAddr), custom, []byte{0xde, 0xad}, 1000, new(big.Int))
+	if err != nil || !bytes.Equal(ret, []byte{0xde, 0xad}) {
+		t.Errorf("custom precompile mismatch: have %x, %v", ret, err)
+	}
+	// The package level sets are left untouched.
+	if _, ok := PrecompiledContractsBerlin[custom]; ok {
+		t.Error("global precompile set modified")
+	}
+	// The fork set extended with the custom contract is reported in order.
+	precompiles := DefaultPrecompiles(evm.chainRules)
+	precompiles[custom] = &dataCopy{}
+	evm = NewEVM(vmctx, TxContext{}, &StateDBImpl{db: statedb}, allEthashProtocolChanges, Config{Precompiles: precompiles})
+	active := evm.ActivePrecompiles()
+	if len(active) != len(precompiles) || active[len(active)-1] != custom {
+		t.Fatalf("active precompiles mismatch: %v", active)
+	}
+	for i := 1; i < len(active); i++ {
+		if bytes.Compare(active[i-1][:], active[i][:]) >= 0 {
+			t.Errorf("active precompiles out of order: %x before %x", active[i-1], active[i])
+		}
+	}
+}
@@ -17,11 +17,17 @@
 package evm
 
 import (
+	"errors"
+	"fmt"
 	"hash"
+	"math/big"
 
 	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/lyonnee/evm/params"
 )
 
+// KeccakState wraps a hash state, Read reading the digest without the
+// allocation of Sum. Despite its name it is the state of any Hasher.
 type KeccakState interface {
 	hash.Hash
 	Read([]byte) (int, error)
@@ -45,3 +51,92 @@ func Keccak256(data ...[]byte) []byte {
 	d.Read(b)
 	return b
 }
+
+// Hasher provides the hash function of the EVM. It backs the KECCAK256
+// instruction, the code hashes compared by the EVM and the derivation of
+// contract addresses. A StateDB used along a custom Hasher must hash the
+// codes with it as well.
+type Hasher interface {
+	// NewState returns a new hash state producing HashLength byte digests.
+	NewState() KeccakState
+	// Gas returns the constant gas of the KECCAK256 instruction and the gas
+	// charged per hashed word, the latter applying to CREATE2 as well.
+	Gas() (constant, word uint64)
+}
+
+// KeccakHasher is the Ethereum Hasher, Keccak-256.
+type KeccakHasher struct{}
+
+// NewState implements Hasher.
+func (KeccakHasher) NewState() KeccakState { return NewKeccakState() }
+
+// Gas implements Hasher.
+func (KeccakHasher) Gas() (uint64, uint64) { return params.Keccak256Gas, params.Keccak256WordGas }
+
+type customHasher struct {
+	newHash      func() hash.Hash
+	gas, wordGas uint64
+}
+
+// NewHasher returns a Hasher built on the given hash constructor, charging
+// gas per KECCAK256 instruction and wordGas per hashed word. It panics if
+// the digests aren't HashLength bytes long.
+func NewHasher(newHash func() hash.Hash, gas, wordGas uint64) Hasher {
+	if size := newHash().Size(); size != HashLength {
+		panic(fmt.Sprintf("hasher digest size %d, want %d", size, HashLength))
+	}
+	return &customHasher{newHash: newHash, gas: gas, wordGas: wordGas}
+}
+
+func (h *customHasher) NewState() KeccakState {
+	state := h.newHash()
+	if s, ok := state.(KeccakState); ok {
+		return s
+	}
+	return readableState{state}
+}
+
+func (h *customHasher) Gas() (uint64, uint64) { return h.gas, h.wordGas }
+
+// readableState implements Read for the hash states lacking it.
+type readableState struct {
+	hash.Hash
+}
+
+func (s readableState) Read(out []byte) (int, error) {
+	return copy(out, s.Sum(nil)), nil
+}
+
+// HashWith hashes the concatenation of data with the given Hasher.
+func HashWith(hasher Hasher, data ...[]byte) (h Hash) {
+	d := hasher.NewState()
+	for _, b := range data {
+		d.Write(b)
+	}
+	d.Read(h[:])
+	return h
+}
+
+// errInvalidSignature is returned for signature values ecrecoverAddress
+// rejects.
+var errInvalidSignature = errors.New("invalid signature values")
+
+// ecrecoverAddress recovers the address of the signer of hash from the
+// signature values, v being the recovery id. With homestead set, s must be in
+// the lower half of the curve order as required for transactions.
+func ecrecoverAddress(hash []byte, v byte, r, s *big.Int, homestead bool) (Address, error) {
+	if !crypto.ValidateSignatureValues(v, r, s, homestead) {
+		return Address{}, errInvalidSignature
+	}
+	sig := make([]byte, 65)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:64])
+	// v needs to be at the end for libsecp256k1
+	sig[64] = v
+	pubKey, err := crypto.Ecrecover(hash, sig)
+	if err != nil {
+		return Address{}, err
+	}
+	// the first byte of pubkey is bitcoin heritage
+	return BytesToAddr(crypto.Keccak256(pubKey[1:])[12:]), nil
+}
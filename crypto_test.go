@@ -0,0 +1,102 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/holiman/uint256"
+)
+
+// sha256Hasher stands in for a national hash standard, its states lack Read.
+var sha256Hasher = NewHasher(sha256.New, 60, 12)
+
+func sha256Sum(data ...[]byte) []byte {
+	h := sha256.New()
+	for _, b := range data {
+		h.Write(b)
+	}
+	return h.Sum(nil)
+}
+
+func TestHashWith(t *testing.T) {
+	if have, want := HashWith(KeccakHasher{}, []byte("abc")), Keccak256Hash([]byte("abc")); have != want {
+		t.Errorf("keccak mismatch: have %x, want %x", have, want)
+	}
+	if have, want := HashWith(sha256Hasher, []byte("a"), []byte("bc")), sha256Sum([]byte("abc")); !bytes.Equal(have[:], want) {
+		t.Errorf("sha256 mismatch: have %x, want %x", have, want)
+	}
+}
+
+func TestHasherOpcode(t *testing.T) {
+	// PUSH1 0x2a PUSH1 0 MSTORE PUSH1 32 PUSH1 0 KECCAK256 PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+	code := Hex2Bytes("602a600052602060002060005260206000f3")
+	word := BytesToHash([]byte{0x2a})
+
+	run := func(hasher Hasher) ([]byte, uint64) {
+		base, db := newTransitionEnv(t, istanbulChainConfig, nil, 0)
+		db.SetCode(stContract, code)
+		vm := NewEVM(base.Context, TxContext{}, db, istanbulChainConfig, Config{Hasher: hasher})
+		ret, gasLeft, err := vm.Call(AccountRef(stSender), stContract, nil, 100000, new(big.Int))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ret, 100000 - gasLeft
+	}
+	ret, keccakGas := run(nil)
+	if want := Keccak256(word[:]); !bytes.Equal(ret, want) {
+		t.Errorf("keccak output mismatch: have %x, want %x", ret, want)
+	}
+	ret, sha256Gas := run(sha256Hasher)
+	if want := sha256Sum(word[:]); !bytes.Equal(ret, want) {
+		t.Errorf("sha256 output mismatch: have %x, want %x", ret, want)
+	}
+	// 30 more constant gas, 6 more for the single word
+	if sha256Gas != keccakGas+36 {
+		t.Errorf("gas mismatch: have %d, want %d", sha256Gas, keccakGas+36)
+	}
+}
+
+func TestHasherAddressDerivation(t *testing.T) {
+	base, db := newTransitionEnv(t, istanbulChainConfig, nil, 0)
+	vm := NewEVM(base.Context, TxContext{}, db, istanbulChainConfig, Config{Hasher: sha256Hasher})
+
+	nonce := db.GetNonce(stSender)
+	_, addr, _, err := vm.Create(AccountRef(stSender), create2Initcode, 100000, new(big.Int))
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc, _ := rlp.EncodeToBytes([]interface{}{stSender.Bytes(), nonce})
+	if want := BytesToAddr(sha256Sum(enc)); addr != want {
+		t.Errorf("CREATE address mismatch: have %x, want %x", addr, want)
+	}
+
+	salt := uint256.NewInt(7)
+	_, addr, _, err = vm.Create2(AccountRef(stSender), create2Initcode, 100000, new(big.Int), salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saltBytes := salt.Bytes32()
+	want := BytesToAddr(sha256Sum([]byte{0xff}, stSender.Bytes(), saltBytes[:], sha256Sum(create2Initcode)))
+	if addr != want {
+		t.Errorf("CREATE2 address mismatch: have %x, want %x", addr, want)
+	}
+}
@@ -0,0 +1,351 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package debugger implements an interactive debugger of the EVM, pausing
+// the interpreter loop on breakpoints and stepping through the executed code.
+//
+// The Debugger is the tracer of the EVM it debugs. The execution runs in a
+// goroutine of its own, blocked while paused, and is driven by the methods
+// of the Debugger, which are not safe for concurrent use:
+//
+//	d := debugger.New()
+//	vm := evm.NewEVM(blockCtx, txCtx, statedb, config, evm.Config{Tracer: d})
+//	pause := d.Start(func() { ret, gas, err = vm.Call(...) })
+//	for pause != nil {
+//		// inspect pause.Scope
+//		pause = d.Step()
+//	}
+package debugger
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/lyonnee/evm"
+)
+
+// BreakpointKind is the condition a Breakpoint pauses on.
+type BreakpointKind int
+
+const (
+	BreakPC      BreakpointKind = iota // an instruction at a pc
+	BreakOp                            // an opcode
+	BreakAddress                       // the entry of the code of an address
+	BreakStorage                       // an SLOAD or SSTORE of a storage slot
+)
+
+// Breakpoint pauses the execution when its condition is met.
+type Breakpoint struct {
+	ID   int // set by AddBreakpoint
+	Kind BreakpointKind
+	PC   uint64     // BreakPC
+	Op   evm.OpCode // BreakOp
+	Slot evm.Hash   // BreakStorage
+
+	// Address restricts the breakpoint to the code of an address, or to the
+	// storage of an account for BreakStorage. It is required by
+	// BreakAddress, which pauses on the first instruction of every call
+	// running the code.
+	Address *evm.Address
+}
+
+func (b Breakpoint) String() string {
+	var s string
+	switch b.Kind {
+	case BreakPC:
+		s = fmt.Sprintf("pc %d", b.PC)
+	case BreakOp:
+		s = fmt.Sprintf("op %v", b.Op)
+	case BreakAddress:
+		return fmt.Sprintf("#%d address %#x", b.ID, b.Address.Bytes())
+	case BreakStorage:
+		s = fmt.Sprintf("slot %#x", b.Slot.Bytes())
+	}
+	if b.Address != nil {
+		s += fmt.Sprintf(" in %#x", b.Address.Bytes())
+	}
+	return fmt.Sprintf("#%d %s", b.ID, s)
+}
+
+// Pause is the state of the execution paused before an instruction. The
+// scope is live, it is only valid until the execution is resumed.
+type Pause struct {
+	PC         uint64
+	Op         evm.OpCode
+	Gas        uint64 // gas available before the instruction
+	Cost       uint64 // gas cost of the instruction
+	Scope      *evm.ScopeContext
+	ReturnData []byte // return data of the last call
+	Depth      int    // call depth, 1 in the outermost call
+	Err        error  // error failing the instruction, if any
+
+	// Breakpoint is the breakpoint hit, nil if the execution was paused by
+	// a step.
+	Breakpoint *Breakpoint
+}
+
+// CodeAddress returns the address of the executed code, which differs from
+// the address of the contract for DELEGATECALL and CALLCODE.
+func (p *Pause) CodeAddress() evm.Address {
+	return codeAddress(p.Scope.Contract)
+}
+
+func codeAddress(contract *evm.Contract) evm.Address {
+	if contract.CodeAddr != nil {
+		return *contract.CodeAddr
+	}
+	return contract.Address()
+}
+
+// mode is the way the execution is resumed.
+type mode int
+
+const (
+	modeContinue mode = iota // until a breakpoint
+	modeStep                 // until the next instruction
+	modeStepOver             // until the next instruction of the same call or a parent
+	modeStepOut              // until the next instruction of a parent call
+)
+
+// Debugger is an EVMLogger pausing the execution it traces.
+type Debugger struct {
+	breakpoints []Breakpoint
+	nextID      int
+
+	// Execution state, only accessed by the execution goroutine while it
+	// runs and by the controlling one while it is paused
+	mode     mode
+	depth    int  // call depth of the last pause
+	entered  bool // a call was entered, its first instruction is next
+	detached bool // the debugger no longer pauses
+
+	running bool
+	pauses  chan *Pause
+	resume  chan struct{}
+	done    chan struct{}
+}
+
+var _ evm.EVMLogger = (*Debugger)(nil)
+
+// New returns a Debugger with no breakpoints.
+func New() *Debugger {
+	return &Debugger{nextID: 1}
+}
+
+// AddBreakpoint adds the breakpoint and returns its ID.
+func (d *Debugger) AddBreakpoint(bp Breakpoint) (int, error) {
+	if bp.Kind == BreakAddress && bp.Address == nil {
+		return 0, errors.New("address breakpoint without address")
+	}
+	bp.ID = d.nextID
+	d.nextID++
+	d.breakpoints = append(d.breakpoints, bp)
+	return bp.ID, nil
+}
+
+// RemoveBreakpoint removes the breakpoint with the given ID, returning
+// whether it existed.
+func (d *Debugger) RemoveBreakpoint(id int) bool {
+	for i, bp := range d.breakpoints {
+		if bp.ID == id {
+			d.breakpoints = append(d.breakpoints[:i], d.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Breakpoints returns the breakpoints, in the order they were added.
+func (d *Debugger) Breakpoints() []Breakpoint {
+	return append([]Breakpoint(nil), d.breakpoints...)
+}
+
+// Running reports whether an execution is in progress, paused.
+func (d *Debugger) Running() bool {
+	return d.running
+}
+
+// Start runs the execution in a goroutine, the EVM being traced by the
+// Debugger, and pauses it on its first instruction. It returns the pause,
+// or nil if the execution completed without executing any code.
+func (d *Debugger) Start(run func()) *Pause {
+	if d.running {
+		panic("debugger: execution already in progress")
+	}
+	d.mode, d.depth, d.entered, d.detached = modeStep, 0, false, false
+	d.running = true
+	d.pauses = make(chan *Pause)
+	d.resume = make(chan struct{})
+	d.done = make(chan struct{})
+	go func() {
+		defer close(d.done)
+		run()
+	}()
+	return d.wait()
+}
+
+// Step executes the current instruction and pauses on the next one, in the
+// called code for the instructions entering a call.
+func (d *Debugger) Step() *Pause {
+	return d.proceed(modeStep)
+}
+
+// StepOver executes the current instruction, including the call it makes,
+// and pauses on the next instruction of the current call, or of its parent
+// if the current call ends.
+func (d *Debugger) StepOver() *Pause {
+	return d.proceed(modeStepOver)
+}
+
+// StepOut runs until the current call returns and pauses on the next
+// instruction of its parent.
+func (d *Debugger) StepOut() *Pause {
+	return d.proceed(modeStepOut)
+}
+
+// Continue runs until a breakpoint is hit.
+func (d *Debugger) Continue() *Pause {
+	return d.proceed(modeContinue)
+}
+
+// Stop detaches the debugger, letting the execution run to completion
+// without pausing, and waits for it.
+func (d *Debugger) Stop() {
+	if !d.running {
+		return
+	}
+	d.detached = true
+	d.resume <- struct{}{}
+	<-d.done
+	d.running = false
+}
+
+// proceed resumes the execution in the given mode, returning the next pause
+// or nil if the execution completed. The breakpoints are hit in all modes.
+func (d *Debugger) proceed(m mode) *Pause {
+	if !d.running {
+		return nil
+	}
+	d.mode = m
+	d.resume <- struct{}{}
+	return d.wait()
+}
+
+func (d *Debugger) wait() *Pause {
+	select {
+	case pause := <-d.pauses:
+		return pause
+	case <-d.done:
+		d.running = false
+		return nil
+	}
+}
+
+// hit returns a copy of the first breakpoint matching the instruction, if
+// any, so that the pause isn't affected by a later removal.
+func (d *Debugger) hit(pc uint64, op evm.OpCode, scope *evm.ScopeContext, entry bool) *Breakpoint {
+	for i := range d.breakpoints {
+		bp := d.breakpoints[i]
+		if bp.Address != nil {
+			addr := codeAddress(scope.Contract)
+			if bp.Kind == BreakStorage {
+				addr = scope.Contract.Address()
+			}
+			if addr != *bp.Address {
+				continue
+			}
+		}
+		switch bp.Kind {
+		case BreakPC:
+			if pc == bp.PC {
+				return &bp
+			}
+		case BreakOp:
+			if op == bp.Op {
+				return &bp
+			}
+		case BreakAddress:
+			if entry {
+				return &bp
+			}
+		case BreakStorage:
+			if (op == evm.SLOAD || op == evm.SSTORE) && scope.Stack.Len() > 0 && evm.Hash(scope.Stack.Back(0).Bytes32()) == bp.Slot {
+				return &bp
+			}
+		}
+	}
+	return nil
+}
+
+// CaptureState pauses the execution before the instruction if a breakpoint
+// is hit or a step ends, until it is resumed.
+func (d *Debugger) CaptureState(pc uint64, op evm.OpCode, gas, cost uint64, scope *evm.ScopeContext, rData []byte, depth int, err error) {
+	entry := d.entered
+	d.entered = false
+	if d.detached {
+		return
+	}
+	bp := d.hit(pc, op, scope, entry)
+	switch {
+	case bp != nil:
+	case d.mode == modeStep:
+	case d.mode == modeStepOver && depth <= d.depth:
+	case d.mode == modeStepOut && depth < d.depth:
+	default:
+		return
+	}
+	d.depth = depth
+	d.pauses <- &Pause{
+		PC:         pc,
+		Op:         op,
+		Gas:        gas,
+		Cost:       cost,
+		Scope:      scope,
+		ReturnData: rData,
+		Depth:      depth,
+		Err:        err,
+		Breakpoint: bp,
+	}
+	<-d.resume
+}
+
+// CaptureStart marks the entry of the outermost call.
+func (d *Debugger) CaptureStart(env *evm.EVM, from evm.Address, to evm.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	d.entered = true
+}
+
+// CaptureEnter marks the entry of a call.
+func (d *Debugger) CaptureEnter(typ evm.OpCode, from evm.Address, to evm.Address, input []byte, gas uint64, value *big.Int) {
+	d.entered = true
+}
+
+// CaptureExit clears the entry of calls executing no code.
+func (d *Debugger) CaptureExit(output []byte, gasUsed uint64, err error) {
+	d.entered = false
+}
+
+// CaptureEnd clears the entry of calls executing no code.
+func (d *Debugger) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	d.entered = false
+}
+
+func (d *Debugger) CaptureTxStart(gasLimit uint64) {}
+
+func (d *Debugger) CaptureTxEnd(restGas uint64) {}
+
+func (d *Debugger) CaptureFault(pc uint64, op evm.OpCode, gas, cost uint64, scope *evm.ScopeContext, depth int, err error) {
+}
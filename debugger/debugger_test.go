@@ -0,0 +1,193 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package debugger
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/memstate"
+	"github.com/lyonnee/evm/params"
+)
+
+var (
+	sender = evm.BytesToAddr([]byte("sender"))
+	caller = evm.BytesToAddr([]byte("caller"))
+	callee = evm.BytesToAddr([]byte("callee"))
+
+	// PUSH1 0 (x5) PUSHn callee GAS CALL POP, PUSH1 1 PUSH1 0 SSTORE STOP
+	callerCode = append(append(evm.Hex2Bytes("60006000600060006000"), push(callee)...), evm.Hex2Bytes("5af150600160005500")...)
+	// PUSH1 7 PUSH1 3 SSTORE STOP
+	calleeCode = evm.Hex2Bytes("600760035500")
+
+	// pc of the CALL and the POP following it in the caller
+	callPC = uint64(12 + evm.AddressLength)
+	popPC  = callPC + 1
+)
+
+func push(addr evm.Address) []byte {
+	return append([]byte{byte(evm.PUSH1) + byte(evm.AddressLength-1)}, addr[:]...)
+}
+
+// debugCall returns a Debugger tracing an EVM and the function calling the
+// caller with it.
+func debugCall(t *testing.T) (*Debugger, *memstate.StateDB, func()) {
+	t.Helper()
+	config, err := params.ForkConfig("Cancun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb := memstate.NewFromAlloc(memstate.GenesisAlloc{
+		caller: {Code: callerCode, Balance: new(big.Int)},
+		callee: {Code: calleeCode, Balance: new(big.Int)},
+	})
+	blockCtx := evm.BlockContext{
+		CanTransfer: evm.CanTransfer,
+		Transfer:    evm.Transfer,
+		BlockNumber: big.NewInt(1),
+		Difficulty:  new(big.Int),
+		BaseFee:     new(big.Int),
+	}
+	statedb.AddAddressToAccessList(caller)
+	d := New()
+	vm := evm.NewEVM(blockCtx, evm.TxContext{Origin: sender, GasPrice: new(big.Int)}, statedb, config, evm.Config{Tracer: d})
+	run := func() {
+		if _, _, err := vm.Call(evm.AccountRef(sender), caller, nil, 100000, new(big.Int)); err != nil {
+			t.Errorf("call failed: %v", err)
+		}
+	}
+	return d, statedb, run
+}
+
+func checkPause(t *testing.T, pause *Pause, pc uint64, op evm.OpCode, depth int) {
+	t.Helper()
+	if pause == nil {
+		t.Fatalf("execution completed, want a pause at pc %d", pc)
+	}
+	if pause.PC != pc || pause.Op != op || pause.Depth != depth {
+		t.Fatalf("pause mismatch: have pc %d %v depth %d, want pc %d %v depth %d", pause.PC, pause.Op, pause.Depth, pc, op, depth)
+	}
+}
+
+func TestStepping(t *testing.T) {
+	d, statedb, run := debugCall(t)
+
+	// The execution starts paused
+	checkPause(t, d.Start(run), 0, evm.PUSH1, 1)
+	checkPause(t, d.Step(), 2, evm.PUSH1, 1)
+	if _, err := d.AddBreakpoint(Breakpoint{Kind: BreakOp, Op: evm.CALL}); err != nil {
+		t.Fatal(err)
+	}
+	pause := d.Continue()
+	checkPause(t, pause, callPC, evm.CALL, 1)
+	if pause.Breakpoint == nil || pause.Breakpoint.ID != 1 {
+		t.Errorf("breakpoint mismatch: have %v", pause.Breakpoint)
+	}
+	if have := pause.Scope.Stack.Len(); have != 7 {
+		t.Errorf("stack size mismatch: have %d, want 7", have)
+	}
+	// Stepping into the call
+	pause = d.Step()
+	checkPause(t, pause, 0, evm.PUSH1, 2)
+	if pause.CodeAddress() != callee {
+		t.Errorf("code address mismatch: have %x, want %x", pause.CodeAddress(), callee)
+	}
+	checkPause(t, d.Step(), 2, evm.PUSH1, 2)
+	// and out of it, the callee having run
+	checkPause(t, d.StepOut(), popPC, evm.POP, 1)
+	if have := statedb.GetState(callee, evm.BytesToHash([]byte{3})); have != evm.BytesToHash([]byte{7}) {
+		t.Errorf("callee storage mismatch: have %x", have)
+	}
+	if d.Continue() != nil || d.Running() {
+		t.Fatal("execution not completed")
+	}
+	if have := statedb.GetState(caller, evm.Hash{}); have != evm.BytesToHash([]byte{1}) {
+		t.Errorf("caller storage mismatch: have %x", have)
+	}
+
+	// Stepping over the call
+	d, _, run = debugCall(t)
+	d.AddBreakpoint(Breakpoint{Kind: BreakPC, PC: callPC, Address: &caller})
+	checkPause(t, d.Start(run), 0, evm.PUSH1, 1)
+	checkPause(t, d.Continue(), callPC, evm.CALL, 1)
+	pause = d.StepOver()
+	checkPause(t, pause, popPC, evm.POP, 1)
+	if have := pause.Scope.Stack.Back(0); have.Uint64() != 1 {
+		t.Errorf("call status mismatch: have %v", have)
+	}
+}
+
+func TestBreakpoints(t *testing.T) {
+	slot := evm.BytesToHash([]byte{3})
+	other := evm.BytesToAddr([]byte("other"))
+	tests := []struct {
+		name  string
+		bp    Breakpoint
+		pc    uint64
+		op    evm.OpCode
+		depth int
+	}{
+		{"pc", Breakpoint{Kind: BreakPC, PC: 4}, 4, evm.PUSH1, 1},
+		{"pc in callee", Breakpoint{Kind: BreakPC, PC: 4, Address: &callee}, 4, evm.SSTORE, 2},
+		{"op", Breakpoint{Kind: BreakOp, Op: evm.SSTORE}, 4, evm.SSTORE, 2},
+		{"address", Breakpoint{Kind: BreakAddress, Address: &callee}, 0, evm.PUSH1, 2},
+		{"slot", Breakpoint{Kind: BreakStorage, Slot: slot}, 4, evm.SSTORE, 2},
+		{"slot of caller", Breakpoint{Kind: BreakStorage, Slot: evm.Hash{}, Address: &caller}, popPC + 5, evm.SSTORE, 1},
+	}
+	for _, tt := range tests {
+		d, _, run := debugCall(t)
+		d.Start(run)
+		d.AddBreakpoint(tt.bp)
+		// A breakpoint never hit
+		d.AddBreakpoint(Breakpoint{Kind: BreakAddress, Address: &other})
+		pause := d.Continue()
+		if pause == nil {
+			t.Fatalf("%s: breakpoint not hit", tt.name)
+		}
+		if pause.PC != tt.pc || pause.Op != tt.op || pause.Depth != tt.depth || pause.Breakpoint == nil || pause.Breakpoint.ID != 1 {
+			t.Errorf("%s: pause mismatch: have pc %d %v depth %d, breakpoint %v", tt.name, pause.PC, pause.Op, pause.Depth, pause.Breakpoint)
+		}
+		// The pause keeps reporting its breakpoint once removed
+		if d.RemoveBreakpoint(1); pause.Breakpoint != nil && pause.Breakpoint.ID != 1 {
+			t.Errorf("%s: removed breakpoint mismatch: have %v", tt.name, pause.Breakpoint)
+		}
+		d.Stop()
+	}
+
+	d := New()
+	if _, err := d.AddBreakpoint(Breakpoint{Kind: BreakAddress}); err == nil {
+		t.Error("address breakpoint without address added")
+	}
+	id, _ := d.AddBreakpoint(Breakpoint{Kind: BreakOp, Op: evm.ADD})
+	if !d.RemoveBreakpoint(id) || d.RemoveBreakpoint(id) || len(d.Breakpoints()) != 0 {
+		t.Error("breakpoint removal failed")
+	}
+}
+
+func TestStop(t *testing.T) {
+	d, statedb, run := debugCall(t)
+	d.AddBreakpoint(Breakpoint{Kind: BreakOp, Op: evm.SSTORE})
+	checkPause(t, d.Start(run), 0, evm.PUSH1, 1)
+	d.Stop()
+	if d.Running() || d.Continue() != nil {
+		t.Fatal("execution not completed")
+	}
+	if have := statedb.GetState(caller, evm.Hash{}); have != evm.BytesToHash([]byte{1}) {
+		t.Errorf("caller storage mismatch: have %x", have)
+	}
+}
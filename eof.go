@@ -0,0 +1,295 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// EVM Object Format (EOF) v1 containers as specified by EIP-3540. Subcontainer
+// sections are not supported, EOF contracts are deployed by CREATE, CREATE2
+// and creation transactions whose initcode is itself an EOF container.
+// Without EXTCALL and EOFCREATE, EOF code keeps CALL, DELEGATECALL,
+// STATICCALL, CREATE and CREATE2, see newEOFInstructionSet.
+const (
+	eofFormatByte = 0xef
+	eof1Version   = 1
+
+	kindTypes     = 0x01
+	kindCode      = 0x02
+	kindContainer = 0x03
+	kindData      = 0xff
+
+	eofTypeSize           = 4    // Size of a types section entry
+	eofMaxCodeSections    = 1024 // Maximum number of code sections
+	eofMaxIOItems         = 127  // Maximum number of inputs or outputs of a function
+	eofNonReturning       = 0x80 // Outputs value of a function never returning
+	eofMaxStackHeight     = 1023 // Maximum stack height of a function
+	eofReturnStackLimit   = 1024 // Maximum depth of CALLF
+	eofMinContainerHeader = 15   // Header of a container with one code section
+)
+
+var eofMagic = []byte{eofFormatByte, 0x00}
+
+// ErrInvalidEOF is returned when EOF code fails to parse or validate, the
+// wrapped error holding the details.
+var ErrInvalidEOF = errors.New("invalid eof")
+
+var (
+	errInvalidMagic             = errors.New("invalid magic")
+	errInvalidVersion           = errors.New("invalid version")
+	errMissingTypeHeader        = errors.New("missing type header")
+	errInvalidTypeSize          = errors.New("invalid type section size")
+	errMissingCodeHeader        = errors.New("missing code header")
+	errInvalidCodeHeader        = errors.New("invalid code header")
+	errInvalidCodeSize          = errors.New("invalid code size")
+	errUnsupportedContainer     = errors.New("container sections not supported")
+	errMissingDataHeader        = errors.New("missing data header")
+	errMissingTerminator        = errors.New("missing header terminator")
+	errTooManyCodeSections      = errors.New("too many code sections")
+	errInvalidContainerSize     = errors.New("invalid container size")
+	errInvalidSection0Type      = errors.New("invalid section 0 type")
+	errTooManyInputs            = errors.New("invalid type content, too many inputs")
+	errTooManyOutputs           = errors.New("invalid type content, too many outputs")
+	errInvalidMaxStackHeight    = errors.New("invalid max stack height")
+	errUndefinedInstruction     = errors.New("undefined instruction")
+	errTruncatedImmediate       = errors.New("truncated immediate")
+	errInvalidJumpDest          = errors.New("invalid jump destination")
+	errInvalidSectionArgument   = errors.New("invalid section argument")
+	errInvalidCallArgument      = errors.New("callf to non-returning function")
+	errInvalidDataloadnArgument = errors.New("invalid dataloadn argument")
+	errInvalidNonReturning      = errors.New("invalid non-returning flag")
+	errUnreachableCode          = errors.New("unreachable code")
+	errUnreachableSection       = errors.New("unreachable code section")
+	errNoTerminalInstruction    = errors.New("code does not end with a terminating instruction")
+	errInvalidBackwardJump      = errors.New("invalid backward jump")
+	errInvalidOutputs           = errors.New("invalid number of outputs")
+	errStackUnderflow           = errors.New("stack underflow")
+	errStackOverflow            = errors.New("stack overflow")
+)
+
+// FunctionMetadata is the types section entry of a code section.
+type FunctionMetadata struct {
+	Inputs           uint8  // Stack items the function takes
+	Outputs          uint8  // Stack items the function returns, 0x80 if it never returns
+	MaxStackIncrease uint16 // Stack height the function reaches above its inputs
+}
+
+// Container is an EOF v1 container.
+type Container struct {
+	Types []FunctionMetadata
+	Code  [][]byte
+	Data  []byte
+}
+
+// hasEOFMagic reports whether code starts with the EOF magic.
+func hasEOFMagic(code []byte) bool {
+	return len(code) >= len(eofMagic) && bytes.Equal(eofMagic, code[:len(eofMagic)])
+}
+
+// MarshalBinary encodes the container in the EOF format.
+func (c *Container) MarshalBinary() ([]byte, error) {
+	if len(c.Types) != len(c.Code) {
+		return nil, errInvalidTypeSize
+	}
+	b := make([]byte, 0, eofMinContainerHeader+2*len(c.Code))
+	b = append(b, eofMagic...)
+	b = append(b, eof1Version)
+	b = append(b, kindTypes)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(c.Types)*eofTypeSize))
+	b = append(b, kindCode)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(c.Code)))
+	for _, code := range c.Code {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(code)))
+	}
+	b = append(b, kindData)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(c.Data)))
+	b = append(b, 0) // terminator
+
+	for _, typ := range c.Types {
+		b = append(b, typ.Inputs, typ.Outputs)
+		b = binary.BigEndian.AppendUint16(b, typ.MaxStackIncrease)
+	}
+	for _, code := range c.Code {
+		b = append(b, code...)
+	}
+	return append(b, c.Data...), nil
+}
+
+// UnmarshalBinary decodes an EOF container, checking its header and types
+// section. The code sections are not validated, see ValidateCode.
+func (c *Container) UnmarshalBinary(b []byte) error {
+	if !hasEOFMagic(b) {
+		return errInvalidMagic
+	}
+	if len(b) < eofMinContainerHeader {
+		return errInvalidContainerSize
+	}
+	if b[2] != eof1Version {
+		return fmt.Errorf("%w: have %d, want %d", errInvalidVersion, b[2], eof1Version)
+	}
+	// Parse the header
+	kind, typesSize, offset, err := parseSection(b, 3)
+	if err != nil {
+		return err
+	}
+	if kind != kindTypes {
+		return fmt.Errorf("%w: found section kind %#x instead", errMissingTypeHeader, kind)
+	}
+	if typesSize < eofTypeSize || typesSize%eofTypeSize != 0 {
+		return fmt.Errorf("%w: type section size must be divisible by %d, have %d", errInvalidTypeSize, eofTypeSize, typesSize)
+	}
+	kind, codeSizes, offset, err := parseSectionList(b, offset)
+	if err != nil {
+		return err
+	}
+	if kind != kindCode {
+		return fmt.Errorf("%w: found section kind %#x instead", errMissingCodeHeader, kind)
+	}
+	if len(codeSizes) == 0 {
+		return fmt.Errorf("%w: no code sections", errInvalidCodeHeader)
+	}
+	if len(codeSizes) > eofMaxCodeSections {
+		return fmt.Errorf("%w: have %d", errTooManyCodeSections, len(codeSizes))
+	}
+	if len(codeSizes) != typesSize/eofTypeSize {
+		return fmt.Errorf("%w: mismatch of code sections and types, have %d, want %d", errInvalidCodeSize, len(codeSizes), typesSize/eofTypeSize)
+	}
+	for i, size := range codeSizes {
+		if size == 0 {
+			return fmt.Errorf("%w: code section %d is empty", errInvalidCodeSize, i)
+		}
+	}
+	if offset < len(b) && b[offset] == kindContainer {
+		return errUnsupportedContainer
+	}
+	kind, dataSize, offset, err := parseSection(b, offset)
+	if err != nil {
+		return err
+	}
+	if kind != kindData {
+		return fmt.Errorf("%w: found section kind %#x instead", errMissingDataHeader, kind)
+	}
+	if offset >= len(b) || b[offset] != 0 {
+		return errMissingTerminator
+	}
+	offset++
+
+	// The body must match the sizes of the header exactly
+	size := offset + typesSize + dataSize
+	for _, codeSize := range codeSizes {
+		size += codeSize
+	}
+	if len(b) != size {
+		return fmt.Errorf("%w: have %d, want %d", errInvalidContainerSize, len(b), size)
+	}
+
+	// Parse the types section
+	types := make([]FunctionMetadata, typesSize/eofTypeSize)
+	for i := range types {
+		types[i] = FunctionMetadata{
+			Inputs:           b[offset+i*eofTypeSize],
+			Outputs:          b[offset+i*eofTypeSize+1],
+			MaxStackIncrease: binary.BigEndian.Uint16(b[offset+i*eofTypeSize+2:]),
+		}
+		if types[i].Inputs > eofMaxIOItems {
+			return fmt.Errorf("%w: section %d has %d", errTooManyInputs, i, types[i].Inputs)
+		}
+		if types[i].Outputs > eofMaxIOItems && types[i].Outputs != eofNonReturning {
+			return fmt.Errorf("%w: section %d has %d", errTooManyOutputs, i, types[i].Outputs)
+		}
+		if int(types[i].Inputs)+int(types[i].MaxStackIncrease) > eofMaxStackHeight {
+			return fmt.Errorf("%w: section %d reaches %d", errInvalidMaxStackHeight, i, int(types[i].Inputs)+int(types[i].MaxStackIncrease))
+		}
+	}
+	if types[0].Inputs != 0 || types[0].Outputs != eofNonReturning {
+		return fmt.Errorf("%w: have %d inputs and %d outputs", errInvalidSection0Type, types[0].Inputs, types[0].Outputs)
+	}
+	offset += typesSize
+
+	// Parse the code sections and the data section
+	code := make([][]byte, len(codeSizes))
+	for i, codeSize := range codeSizes {
+		code[i] = b[offset : offset+codeSize]
+		offset += codeSize
+	}
+	c.Types = types
+	c.Code = code
+	c.Data = b[offset:]
+	return nil
+}
+
+// ValidateCode validates the code sections of the container against the EOF
+// instruction set jt, see LookupEOFInstructionSet. Every section has to be
+// reachable from section 0 through CALLF.
+func (c *Container) ValidateCode(jt *JumpTable) error {
+	callees := make([][]int, len(c.Code))
+	for i, code := range c.Code {
+		var err error
+		if callees[i], err = validateCode(code, i, c, jt); err != nil {
+			return fmt.Errorf("section %d: %w", i, err)
+		}
+	}
+	// Walk the call graph from section 0
+	visited := make([]bool, len(c.Code))
+	visited[0] = true
+	worklist := []int{0}
+	for len(worklist) > 0 {
+		section := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		for _, callee := range callees[section] {
+			if !visited[callee] {
+				visited[callee] = true
+				worklist = append(worklist, callee)
+			}
+		}
+	}
+	for i, ok := range visited {
+		if !ok {
+			return fmt.Errorf("%w: section %d", errUnreachableSection, i)
+		}
+	}
+	return nil
+}
+
+// parseSection decodes the kind and the size of a section header.
+func parseSection(b []byte, offset int) (kind, size int, next int, err error) {
+	if offset+3 > len(b) {
+		return 0, 0, 0, errInvalidContainerSize
+	}
+	return int(b[offset]), int(binary.BigEndian.Uint16(b[offset+1:])), offset + 3, nil
+}
+
+// parseSectionList decodes the kind and the sizes of a multi-section header.
+func parseSectionList(b []byte, offset int) (kind int, sizes []int, next int, err error) {
+	if offset+3 > len(b) {
+		return 0, nil, 0, errInvalidContainerSize
+	}
+	kind, count := int(b[offset]), int(binary.BigEndian.Uint16(b[offset+1:]))
+	offset += 3
+	if offset+2*count > len(b) {
+		return 0, nil, 0, errInvalidContainerSize
+	}
+	sizes = make([]int, count)
+	for i := range sizes {
+		sizes[i] = int(binary.BigEndian.Uint16(b[offset+2*i:]))
+	}
+	return kind, sizes, offset + 2*count, nil
+}
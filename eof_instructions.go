@@ -0,0 +1,224 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"encoding/binary"
+
+	"github.com/holiman/uint256"
+)
+
+// EOF指令只在经过验证的EOF代码中执行,验证保证了立即数完整、跳转目标合法以及
+// 栈高度正确,因此这里无需再做检查
+
+// opRjump implements RJUMP (EIP-4200), a jump by the signed offset of the
+// immediate relative to the next instruction.
+func opRjump(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	code := scope.Contract.sectionCode()
+	offset := int16(binary.BigEndian.Uint16(code[*pc+1:]))
+	// 主循环会在指令执行后将pc加一
+	*pc = uint64(int64(*pc) + 2 + int64(offset))
+	return nil, nil
+}
+
+// opRjumpi implements RJUMPI (EIP-4200), RJUMP taken if the condition is not
+// zero.
+func opRjumpi(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	if cond := scope.Stack.pop(); cond.IsZero() {
+		*pc += 2
+		return nil, nil
+	}
+	return opRjump(pc, interpreter, scope)
+}
+
+// opRjumpv implements RJUMPV (EIP-4200), a jump through the table of offsets
+// of the immediate indexed by the stack, falling through if the index is out
+// of range.
+func opRjumpv(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	var (
+		code  = scope.Contract.sectionCode()
+		count = uint64(code[*pc+1]) + 1
+		index = scope.Stack.pop()
+	)
+	if !index.LtUint64(count) {
+		*pc += 1 + 2*count
+		return nil, nil
+	}
+	offset := int16(binary.BigEndian.Uint16(code[*pc+2+2*index.Uint64():]))
+	*pc = uint64(int64(*pc) + 1 + 2*int64(count) + int64(offset))
+	return nil, nil
+}
+
+// opCallf implements CALLF (EIP-4750), a call of the code section given by the
+// immediate.
+func opCallf(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	var (
+		contract = scope.Contract
+		section  = uint64(binary.BigEndian.Uint16(contract.sectionCode()[*pc+1:]))
+		typ      = contract.Container.Types[section]
+	)
+	if len(contract.returnStack) >= eofReturnStackLimit {
+		return nil, ErrReturnStackExceeded
+	}
+	if limit := eofMaxStackHeight + 1 - int(typ.MaxStackIncrease); scope.Stack.len() > limit {
+		return nil, &ErrStackOverflow{stackLen: scope.Stack.len(), limit: limit}
+	}
+	contract.returnStack = append(contract.returnStack, returnContext{section: contract.section, pc: *pc + 3})
+	contract.section = section
+	// 主循环加一后溢出回到被调用代码段的起始位置
+	*pc = ^uint64(0)
+	return nil, nil
+}
+
+// opRetf implements RETF (EIP-4750), the return to the caller of CALLF.
+func opRetf(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	contract := scope.Contract
+	ctx := contract.returnStack[len(contract.returnStack)-1]
+	contract.returnStack = contract.returnStack[:len(contract.returnStack)-1]
+	contract.section = ctx.section
+	*pc = ctx.pc - 1
+	return nil, nil
+}
+
+// opDataLoad implements DATALOAD (EIP-7480), reading a word of the data
+// section at the offset on the stack. Bytes past the data section are zero.
+func opDataLoad(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	offset := scope.Stack.peek()
+	offset64, overflow := offset.Uint64WithOverflow()
+	if overflow {
+		offset64 = 0xffffffffffffffff
+	}
+	offset.SetBytes(getData(scope.Contract.Container.Data, offset64, 32))
+	return nil, nil
+}
+
+// opDataLoadN implements DATALOADN (EIP-7480), reading a word of the data
+// section at the offset of the immediate.
+func opDataLoadN(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	code := scope.Contract.sectionCode()
+	offset := uint64(binary.BigEndian.Uint16(code[*pc+1:]))
+	scope.Stack.push(new(uint256.Int).SetBytes32(scope.Contract.Container.Data[offset : offset+32]))
+	*pc += 2
+	return nil, nil
+}
+
+// opDataSize implements DATASIZE (EIP-7480).
+func opDataSize(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	scope.Stack.push(new(uint256.Int).SetUint64(uint64(len(scope.Contract.Container.Data))))
+	return nil, nil
+}
+
+// opDataCopy implements DATACOPY (EIP-7480), copying the data section to memory
+// like CALLDATACOPY.
+func opDataCopy(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	var (
+		memOffset  = scope.Stack.pop()
+		dataOffset = scope.Stack.pop()
+		length     = scope.Stack.pop()
+	)
+	dataOffset64, overflow := dataOffset.Uint64WithOverflow()
+	if overflow {
+		dataOffset64 = 0xffffffffffffffff
+	}
+	length64 := length.Uint64()
+	scope.Memory.Set(memOffset.Uint64(), length64, getData(scope.Contract.Container.Data, dataOffset64, length64))
+	return nil, nil
+}
+
+// opDupN implements DUPN (EIP-663), duplicating the stack item at the depth
+// given by the immediate plus one.
+func opDupN(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	n := int(scope.Contract.sectionCode()[*pc+1])
+	scope.Stack.dup(n + 1)
+	*pc += 1
+	return nil, nil
+}
+
+// opSwapN implements SWAPN (EIP-663), swapping the top of the stack with the
+// item at the depth given by the immediate plus one.
+func opSwapN(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	n := int(scope.Contract.sectionCode()[*pc+1])
+	scope.Stack.swap(n + 2)
+	*pc += 1
+	return nil, nil
+}
+
+// opExchange implements EXCHANGE (EIP-663), swapping the items at the depths
+// n+1 and n+m+1 below the top of the stack, n and m being the nibbles of the
+// immediate plus one.
+func opExchange(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	var (
+		imm  = scope.Contract.sectionCode()[*pc+1]
+		n    = int(imm>>4) + 1
+		m    = int(imm&0x0f) + 1
+		data = scope.Stack.data
+		top  = len(data) - 1
+	)
+	data[top-n], data[top-n-m] = data[top-n-m], data[top-n]
+	*pc += 1
+	return nil, nil
+}
+
+// opExtCodeSizeEOF implements EXTCODESIZE of legacy code once EOF is active,
+// EOF accounts reporting the size of their magic only (EIP-3540).
+func opExtCodeSizeEOF(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	slot := scope.Stack.peek()
+	code := interpreter.evm.StateDB.GetCode(BytesToAddr(slot.Bytes()))
+	if hasEOFMagic(code) {
+		code = eofMagic
+	}
+	slot.SetUint64(uint64(len(code)))
+	return nil, nil
+}
+
+// opExtCodeCopyEOF implements EXTCODECOPY of legacy code once EOF is active,
+// EOF accounts exposing their magic only (EIP-3540).
+func opExtCodeCopyEOF(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	var (
+		a          = scope.Stack.pop()
+		memOffset  = scope.Stack.pop()
+		codeOffset = scope.Stack.pop()
+		length     = scope.Stack.pop()
+	)
+	uint64CodeOffset, overflow := codeOffset.Uint64WithOverflow()
+	if overflow {
+		uint64CodeOffset = 0xffffffffffffffff
+	}
+	code := interpreter.evm.StateDB.GetCode(BytesToAddr(a.Bytes()))
+	if hasEOFMagic(code) {
+		code = eofMagic
+	}
+	codeCopy := getData(code, uint64CodeOffset, length.Uint64())
+	scope.Memory.Set(memOffset.Uint64(), length.Uint64(), codeCopy)
+	return nil, nil
+}
+
+// opExtCodeHashEOF implements EXTCODEHASH of legacy code once EOF is active,
+// EOF accounts reporting the hash of their magic (EIP-3540).
+func opExtCodeHashEOF(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+	slot := scope.Stack.peek()
+	address := BytesToAddr(slot.Bytes())
+	switch {
+	case interpreter.evm.StateDB.Empty(address):
+		slot.Clear()
+	case hasEOFMagic(interpreter.evm.StateDB.GetCode(address)):
+		slot.SetBytes(HashToBytes(HashWith(interpreter.evm.hasher, eofMagic)))
+	default:
+		slot.SetBytes(HashToBytes(interpreter.evm.StateDB.GetCodeHash(address)))
+	}
+	return nil, nil
+}
@@ -0,0 +1,389 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/lyonnee/evm/params"
+)
+
+// eofDouble returns the container of a contract returning 2*3 computed by a
+// function doubling its input.
+func eofDouble() *Container {
+	return &Container{
+		Types: []FunctionMetadata{{Inputs: 0, Outputs: eofNonReturning, MaxStackIncrease: 2}, {Inputs: 1, Outputs: 1, MaxStackIncrease: 1}},
+		Code: [][]byte{
+			// PUSH1 3 CALLF 1 PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+			Hex2Bytes("6003e3000160005260206000f3"),
+			// DUP1 ADD RETF
+			Hex2Bytes("8001e4"),
+		},
+	}
+}
+
+func mustMarshalEOF(t *testing.T, c *Container) []byte {
+	t.Helper()
+	code, err := c.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+// newEOFEnv returns an EVM of the given fork with the contract code deployed
+// at stContract.
+func newEOFEnv(t *testing.T, fork string, code []byte) (*EVM, *StateDBImpl) {
+	t.Helper()
+	config, err := params.ForkConfig(fork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, db := newTransitionEnv(t, config, big.NewInt(0), 0)
+	db.SetCode(stContract, code)
+	return vm, db
+}
+
+func TestEOFMarshalRoundTrip(t *testing.T) {
+	c := eofDouble()
+	c.Data = []byte{0xbe, 0xef}
+	code := mustMarshalEOF(t, c)
+	want := Hex2Bytes("ef0001010008020002000d0003ff000200" + "00800002" + "01010001" + "6003e3000160005260206000f3" + "8001e4" + "beef")
+	if !bytes.Equal(code, want) {
+		t.Fatalf("encoding mismatch:\nhave %x\nwant %x", code, want)
+	}
+	var decoded Container
+	if err := decoded.UnmarshalBinary(code); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, c) {
+		t.Errorf("decoded container mismatch: have %+v, want %+v", decoded, c)
+	}
+}
+
+func TestEOFUnmarshalErrors(t *testing.T) {
+	valid := mustMarshalEOF(t, eofDouble())
+	patch := func(pos int, b byte) []byte {
+		code := append([]byte{}, valid...)
+		code[pos] = b
+		return code
+	}
+	invalidTypes := eofDouble()
+	invalidTypes.Types[0].Outputs = 0
+	tests := []struct {
+		code []byte
+		want error
+	}{
+		{Hex2Bytes("ef01"), errInvalidMagic},
+		{valid[:10], errInvalidContainerSize},
+		{patch(2, 2), errInvalidVersion},
+		{patch(3, kindCode), errMissingTypeHeader},
+		{patch(13, kindContainer), errUnsupportedContainer},
+		{patch(13, kindTypes), errMissingDataHeader},
+		{patch(16, 1), errMissingTerminator},
+		{append(append([]byte{}, valid...), 0), errInvalidContainerSize},
+		{mustMarshalEOF(t, invalidTypes), errInvalidSection0Type},
+	}
+	for i, tt := range tests {
+		var c Container
+		if err := c.UnmarshalBinary(tt.code); !errors.Is(err, tt.want) {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.want)
+		}
+	}
+}
+
+func TestEOFValidateCode(t *testing.T) {
+	main := func(maxStack uint16, code string) *Container {
+		return &Container{
+			Types: []FunctionMetadata{{Outputs: eofNonReturning, MaxStackIncrease: maxStack}},
+			Code:  [][]byte{Hex2Bytes(code)},
+		}
+	}
+	withFunction := func(c *Container, typ FunctionMetadata, code string) *Container {
+		c.Types = append(c.Types, typ)
+		c.Code = append(c.Code, Hex2Bytes(code))
+		return c
+	}
+	dataload := main(1, "d100005000")
+	dataload.Data = make([]byte, 31)
+
+	tests := []struct {
+		name      string
+		container *Container
+		want      error
+	}{
+		{"valid", eofDouble(), nil},
+		{"stop", main(0, "00"), nil},
+		{"jump", main(1, "600056"), errUndefinedInstruction},
+		{"truncated push", main(1, "6100"), errTruncatedImmediate},
+		{"jump into immediate", main(0, "e0ffff"), errInvalidJumpDest},
+		{"jump out of code", main(0, "e0000500"), errInvalidJumpDest},
+		{"no terminator", main(1, "6000"), errNoTerminalInstruction},
+		{"unreachable", main(0, "0000"), errUnreachableCode},
+		{"underflow", main(0, "0100"), errStackUnderflow},
+		{"max stack mismatch", main(0, "60005000"), errInvalidMaxStackHeight},
+		{"backward jump height", main(1, "6000e0fffb"), errInvalidBackwardJump},
+		{"unknown section", main(0, "e3000100"), errInvalidSectionArgument},
+		{"retf in section 0", main(0, "e4"), errInvalidNonReturning},
+		{"unreachable section", withFunction(main(0, "00"), FunctionMetadata{}, "e4"), errUnreachableSection},
+		{"unreachable recursive sections", withFunction(withFunction(main(0, "00"), FunctionMetadata{}, "e30002e4"), FunctionMetadata{}, "e30001e4"), errUnreachableSection},
+		{"call chain", withFunction(withFunction(main(0, "e3000100"), FunctionMetadata{}, "e30002e4"), FunctionMetadata{}, "e4"), nil},
+		{"invalid outputs", withFunction(main(1, "e300015000"), FunctionMetadata{Outputs: 1}, "e4"), errInvalidOutputs},
+		{"dataloadn out of data", dataload, errInvalidDataloadnArgument},
+		{"dupn underflow", main(2, "6000e60100"), errStackUnderflow},
+		{"exchange underflow", main(2, "60006000e80000"), errStackUnderflow},
+	}
+	for _, tt := range tests {
+		var c Container
+		err := c.UnmarshalBinary(mustMarshalEOF(t, tt.container))
+		if err == nil {
+			err = c.ValidateCode(&eofInstructionSet)
+		}
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestEOFExecution(t *testing.T) {
+	word := func(b ...byte) []byte { return LeftPadBytes(b, 32) }
+	tests := []struct {
+		name      string
+		container *Container
+		input     []byte
+		want      []byte
+	}{
+		{"callf", eofDouble(), nil, word(6)},
+		{
+			// PUSH1 0 CALLDATALOAD RJUMPV [5, 10] PUSH1 0xff RJUMP 7 PUSH1 0x0a
+			// RJUMP 2 PUSH1 0x0b PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+			name:      "rjumpv table",
+			container: &Container{Types: []FunctionMetadata{{Outputs: eofNonReturning, MaxStackIncrease: 2}}, Code: [][]byte{Hex2Bytes("600035e2010005000a60ffe00007600ae00002600b60005260206000f3")}},
+			input:     word(1),
+			want:      word(0x0b),
+		},
+		{
+			name:      "rjumpv fallthrough",
+			container: &Container{Types: []FunctionMetadata{{Outputs: eofNonReturning, MaxStackIncrease: 2}}, Code: [][]byte{Hex2Bytes("600035e2010005000a60ffe00007600ae00002600b60005260206000f3")}},
+			input:     word(5),
+			want:      word(0xff),
+		},
+		{
+			// Sums 3+2+1 in a loop: PUSH1 0 PUSH1 3 (loop:) DUP1 RJUMPI 4 POP
+			// RJUMP 11 SWAP1 DUP2 ADD SWAP1 PUSH1 1 SWAP1 SUB RJUMP -19 (end:)
+			// PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+			name:      "rjumpi loop",
+			container: &Container{Types: []FunctionMetadata{{Outputs: eofNonReturning, MaxStackIncrease: 3}}, Code: [][]byte{Hex2Bytes("6000600380e1000450e0000b9081019060019003e0ffed60005260206000f3")}},
+			want:      word(6),
+		},
+		{
+			// PUSH1 1 PUSH1 2 PUSH1 3 DUPN 2 SWAPN 0 EXCHANGE 0x00, then the
+			// four stack items are returned from the top
+			name:      "dupn swapn exchange",
+			container: &Container{Types: []FunctionMetadata{{Outputs: eofNonReturning, MaxStackIncrease: 5}}, Code: [][]byte{Hex2Bytes("600160026003e602e700e80060005260205260405260605260806000f3")}},
+			want:      append(append(append(word(3), word(2)...), word(1)...), word(1)...),
+		},
+		{
+			// DATALOADN 0, DATASIZE, DATALOAD 32 and DATACOPY of 2 bytes at 32
+			name: "data section",
+			container: &Container{
+				Types: []FunctionMetadata{{Outputs: eofNonReturning, MaxStackIncrease: 3}},
+				Code:  [][]byte{Hex2Bytes("d10000600052d26020526020d0604052600260206060d360626000f3")},
+				Data:  append(word(0x2a), 0xbe, 0xef),
+			},
+			want: append(append(append(word(0x2a), word(34)...), append([]byte{0xbe, 0xef}, make([]byte, 30)...)...), 0xbe, 0xef),
+		},
+	}
+	for _, tt := range tests {
+		vm, _ := newEOFEnv(t, "Osaka", mustMarshalEOF(t, tt.container))
+		ret, _, err := vm.Call(AccountRef(stSender), stContract, tt.input, 100000, new(big.Int))
+		if err != nil {
+			t.Errorf("%s: call failed: %v", tt.name, err)
+		} else if !bytes.Equal(ret, tt.want) {
+			t.Errorf("%s: output mismatch:\nhave %x\nwant %x", tt.name, ret, tt.want)
+		}
+	}
+}
+
+func TestEOFUnvalidatedCode(t *testing.T) {
+	// Code put in the state without the deployment checks is validated
+	// before its execution
+	header := "ef00010100040200010003ff000000008000" + "00"
+	tests := []struct {
+		name string
+		code string
+	}{
+		{"callf to unknown section", header + "e30005"},
+		{"dataloadn past data", header + "d10005"},
+	}
+	for _, tt := range tests {
+		vm, _ := newEOFEnv(t, "Osaka", Hex2Bytes(tt.code))
+		for i := 0; i < 2; i++ {
+			_, gas, err := vm.Call(AccountRef(stSender), stContract, nil, 100000, new(big.Int))
+			if !errors.Is(err, ErrInvalidEOF) || gas != 0 {
+				t.Errorf("%s: have %v with %d gas left, want %v", tt.name, err, gas, ErrInvalidEOF)
+			}
+		}
+	}
+}
+
+// callCode returns legacy code calling addr and returning 32 bytes of its
+// output.
+func callCode(addr Address) []byte {
+	// PUSH1 32 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSHn addr GAS CALL POP
+	code := append(Hex2Bytes("60206000600060006000"), byte(PUSH1)+byte(AddressLength-1))
+	code = append(code, addr[:]...)
+	// PUSH1 32 PUSH1 0 RETURN
+	return append(code, Hex2Bytes("5af15060206000f3")...)
+}
+
+func TestEOFInterop(t *testing.T) {
+	var (
+		eofAddr    = BytesToAddr([]byte("eof"))
+		legacyAddr = BytesToAddr([]byte("legacy"))
+	)
+	// Legacy code calling EOF code
+	vm, db := newEOFEnv(t, "Osaka", callCode(eofAddr))
+	db.SetCode(eofAddr, mustMarshalEOF(t, eofDouble()))
+	ret, _, err := vm.Call(AccountRef(stSender), stContract, nil, 100000, new(big.Int))
+	if err != nil || !bytes.Equal(ret, LeftPadBytes([]byte{6}, 32)) {
+		t.Errorf("legacy to eof call: have %x, %v", ret, err)
+	}
+
+	// EOF code calling legacy code returning 42, the output is copied with
+	// RETURNDATACOPY
+	code := append(Hex2Bytes("60006000600060006000"), byte(PUSH1)+byte(AddressLength-1))
+	code = append(code, legacyAddr[:]...)
+	// PUSH2 0xffff CALL, GAS isn't available to EOF code
+	code = append(code, Hex2Bytes("61fffff1506020600060003e60206000f3")...)
+	vm, db = newEOFEnv(t, "Osaka", mustMarshalEOF(t, &Container{
+		Types: []FunctionMetadata{{Outputs: eofNonReturning, MaxStackIncrease: 7}},
+		Code:  [][]byte{code},
+	}))
+	db.SetCode(legacyAddr, Hex2Bytes("602a60005260206000f3"))
+	ret, _, err = vm.Call(AccountRef(stSender), stContract, nil, 100000, new(big.Int))
+	if err != nil || !bytes.Equal(ret, LeftPadBytes([]byte{42}, 32)) {
+		t.Errorf("eof to legacy call: have %x, %v", ret, err)
+	}
+}
+
+func TestEOFIntrospection(t *testing.T) {
+	eofAddr := BytesToAddr([]byte("eof"))
+	push := append([]byte{byte(PUSH1) + byte(AddressLength-1)}, eofAddr[:]...)
+	// EXTCODESIZE, EXTCODEHASH and the first 4 bytes of EXTCODECOPY of the EOF
+	// account, stored in three words and returned
+	var code []byte
+	code = append(append(code, push...), Hex2Bytes("3b600052")...)
+	code = append(append(code, push...), Hex2Bytes("3f602052")...)
+	code = append(append(code, Hex2Bytes("600460006040")...), push...)
+	code = append(code, Hex2Bytes("3c60606000f3")...)
+	container := mustMarshalEOF(t, eofDouble())
+
+	tests := []struct {
+		fork string
+		size int
+		hash Hash
+		copy []byte
+	}{
+		// Legacy code only sees the magic of EOF accounts
+		{"Osaka", 2, Keccak256Hash(eofMagic), eofMagic},
+		{"Prague", len(container), Keccak256Hash(container), container[:4]},
+	}
+	for _, tt := range tests {
+		vm, db := newEOFEnv(t, tt.fork, code)
+		db.SetCode(eofAddr, container)
+		ret, _, err := vm.Call(AccountRef(stSender), stContract, nil, 100000, new(big.Int))
+		if err != nil {
+			t.Fatalf("%s: call failed: %v", tt.fork, err)
+		}
+		if have := new(big.Int).SetBytes(ret[:32]); have.Int64() != int64(tt.size) {
+			t.Errorf("%s: code size mismatch: have %v, want %d", tt.fork, have, tt.size)
+		}
+		if have := BytesToHash(ret[32:64]); have != tt.hash {
+			t.Errorf("%s: code hash mismatch: have %x, want %x", tt.fork, have, tt.hash)
+		}
+		if have := ret[64 : 64+len(tt.copy)]; !bytes.Equal(have, tt.copy) {
+			t.Errorf("%s: code copy mismatch: have %x, want %x", tt.fork, have, tt.copy)
+		}
+	}
+
+	// EOF code can't inspect gas or other accounts' code
+	for _, op := range []OpCode{GAS, EXTCODESIZE, EXTCODEHASH, EXTCODECOPY} {
+		vm, _ := newEOFEnv(t, "Osaka", mustMarshalEOF(t, &Container{
+			Types: []FunctionMetadata{{Outputs: eofNonReturning, MaxStackIncrease: 4}},
+			Code:  [][]byte{append(Hex2Bytes("6000600060006000"), byte(op), byte(STOP))},
+		}))
+		if _, _, err := vm.Call(AccountRef(stSender), stContract, nil, 100000, new(big.Int)); !errors.Is(err, errUndefinedInstruction) {
+			t.Errorf("%v in eof code: have %v, want %v", op, err, errUndefinedInstruction)
+		}
+	}
+}
+
+func TestEOFCreate(t *testing.T) {
+	// initcode returns its data section: DATASIZE PUSH1 0 PUSH1 0 DATACOPY
+	// DATASIZE PUSH1 0 RETURN
+	initcode := func(runtime []byte) []byte {
+		return mustMarshalEOF(t, &Container{
+			Types: []FunctionMetadata{{Outputs: eofNonReturning, MaxStackIncrease: 3}},
+			Code:  [][]byte{Hex2Bytes("d260006000d3d26000f3")},
+			Data:  runtime,
+		})
+	}
+	vm, db := newEOFEnv(t, "Osaka", nil)
+	runtime := mustMarshalEOF(t, eofDouble())
+	_, addr, _, err := vm.Create(AccountRef(stSender), initcode(runtime), 1000000, new(big.Int))
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if !bytes.Equal(db.GetCode(addr), runtime) {
+		t.Fatalf("deployed code mismatch: have %x, want %x", db.GetCode(addr), runtime)
+	}
+	ret, _, err := vm.Call(AccountRef(stSender), addr, nil, 100000, new(big.Int))
+	if err != nil || !bytes.Equal(ret, LeftPadBytes([]byte{6}, 32)) {
+		t.Errorf("call of created contract: have %x, %v", ret, err)
+	}
+
+	// EOF initcode must deploy valid EOF code
+	_, _, gas, err := vm.Create(AccountRef(stSender), initcode([]byte{0x60, 0x00}), 1000000, new(big.Int))
+	if !errors.Is(err, ErrInvalidEOF) || gas != 0 {
+		t.Errorf("legacy runtime: have %v with %d gas left", err, gas)
+	}
+	// Invalid EOF initcode is rejected before execution
+	invalid := mustMarshalEOF(t, &Container{Types: []FunctionMetadata{{Outputs: eofNonReturning}}, Code: [][]byte{{byte(JUMP)}}})
+	_, _, gas, err = vm.Create(AccountRef(stSender), invalid, 1000000, new(big.Int))
+	if !errors.Is(err, errUndefinedInstruction) || gas != 0 {
+		t.Errorf("invalid initcode: have %v with %d gas left", err, gas)
+	}
+	// Legacy initcode can't deploy code starting with 0xEF (EIP-3541)
+	_, _, _, err = vm.Create(AccountRef(stSender), Hex2Bytes("60ef60005360016000f3"), 1000000, new(big.Int))
+	if err != ErrInvalidCode {
+		t.Errorf("legacy initcode deploying 0xEF: have %v, want %v", err, ErrInvalidCode)
+	}
+}
+
+func TestEOFBeforeOsaka(t *testing.T) {
+	vm, _ := newEOFEnv(t, "Prague", mustMarshalEOF(t, eofDouble()))
+	_, _, err := vm.Call(AccountRef(stSender), stContract, nil, 100000, new(big.Int))
+	var invalid *ErrInvalidOpCode
+	if !errors.As(err, &invalid) || invalid.opcode != 0xef {
+		t.Errorf("eof code executed before Osaka: %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/lyonnee/evm/params"
+)
+
+// validateCode validates a code section of the container (EIP-3670, EIP-4200,
+// EIP-4750, EIP-663 and EIP-7480) and its stack usage (EIP-5450). It returns
+// the sections called from the code.
+func validateCode(code []byte, section int, container *Container, jt *JumpTable) ([]int, error) {
+	var (
+		typ       = container.Types[section]
+		immediate = make(bitvec, len(code)/8+1+4)
+		targets   []int
+		callees   []int
+		hasRetf   bool
+	)
+	for pos := 0; pos < len(code); {
+		op := OpCode(code[pos])
+		if op != INVALID && jt[op].undefined {
+			return nil, fmt.Errorf("%w: %v at pc %d", errUndefinedInstruction, op, pos)
+		}
+		size := eofImmediateSize(code, pos)
+		if pos+1+size > len(code) {
+			return nil, fmt.Errorf("%w: %v at pc %d", errTruncatedImmediate, op, pos)
+		}
+		next := pos + 1 + size
+		switch op {
+		case RJUMP, RJUMPI:
+			targets = append(targets, next+int(int16(binary.BigEndian.Uint16(code[pos+1:]))))
+		case RJUMPV:
+			for i := pos + 2; i < next; i += 2 {
+				targets = append(targets, next+int(int16(binary.BigEndian.Uint16(code[i:]))))
+			}
+		case CALLF:
+			callee := int(binary.BigEndian.Uint16(code[pos+1:]))
+			if callee >= len(container.Types) {
+				return nil, fmt.Errorf("%w: section %d at pc %d", errInvalidSectionArgument, callee, pos)
+			}
+			if container.Types[callee].Outputs == eofNonReturning {
+				return nil, fmt.Errorf("%w: section %d at pc %d", errInvalidCallArgument, callee, pos)
+			}
+			callees = append(callees, callee)
+		case RETF:
+			if typ.Outputs == eofNonReturning {
+				return nil, fmt.Errorf("%w: RETF at pc %d", errInvalidNonReturning, pos)
+			}
+			hasRetf = true
+		case DATALOADN:
+			if offset := int(binary.BigEndian.Uint16(code[pos+1:])); offset+32 > len(container.Data) {
+				return nil, fmt.Errorf("%w: offset %d exceeds data size %d", errInvalidDataloadnArgument, offset, len(container.Data))
+			}
+		}
+		for i := pos + 1; i < next; i++ {
+			immediate.set1(uint64(i))
+		}
+		pos = next
+	}
+	if typ.Outputs != eofNonReturning && !hasRetf {
+		return nil, fmt.Errorf("%w: returning function without RETF", errInvalidNonReturning)
+	}
+	// Relative jumps must land on an instruction of the section
+	for _, target := range targets {
+		if target < 0 || target >= len(code) || !immediate.codeSegment(uint64(target)) {
+			return nil, fmt.Errorf("%w: %d", errInvalidJumpDest, target)
+		}
+	}
+	return callees, validateStack(code, section, container, jt)
+}
+
+// eofImmediateSize returns the size of the immediate of the instruction at
+// pos.
+func eofImmediateSize(code []byte, pos int) int {
+	switch op := OpCode(code[pos]); {
+	case op >= PUSH1 && op <= PUSH32:
+		return int(op - PUSH1 + 1)
+	case op == RJUMP, op == RJUMPI, op == CALLF, op == DATALOADN:
+		return 2
+	case op == DUPN, op == SWAPN, op == EXCHANGE:
+		return 1
+	case op == RJUMPV:
+		if pos+1 >= len(code) {
+			return 1
+		}
+		return 1 + 2*(int(code[pos+1])+1)
+	}
+	return 0
+}
+
+// validateStack checks that every instruction of the code section is reached
+// with a known stack height range which neither underflows nor exceeds the
+// declared maximum, and that functions return the declared outputs.
+func validateStack(code []byte, section int, container *Container, jt *JumpTable) error {
+	var (
+		typ        = container.Types[section]
+		minHeights = make([]int, len(code))
+		maxHeights = make([]int, len(code))
+		maxHeight  = int(typ.Inputs)
+	)
+	for i := range minHeights {
+		minHeights[i] = -1
+	}
+	minHeights[0], maxHeights[0] = int(typ.Inputs), int(typ.Inputs)
+
+	for pos := 0; pos < len(code); {
+		op := OpCode(code[pos])
+		low, high := minHeights[pos], maxHeights[pos]
+		if low == -1 {
+			return fmt.Errorf("%w: pc %d", errUnreachableCode, pos)
+		}
+		next := pos + 1 + eofImmediateSize(code, pos)
+
+		// Stack items the instruction requires and its effect on the height
+		var required, change int
+		switch op {
+		case CALLF:
+			callee := container.Types[binary.BigEndian.Uint16(code[pos+1:])]
+			required, change = int(callee.Inputs), int(callee.Outputs)-int(callee.Inputs)
+			if high+int(callee.MaxStackIncrease) > int(params.StackLimit) {
+				return fmt.Errorf("%w: CALLF at pc %d", errStackOverflow, pos)
+			}
+		case RETF:
+			if low != high || low != int(typ.Outputs) {
+				return fmt.Errorf("%w: have %d-%d, want %d at pc %d", errInvalidOutputs, low, high, typ.Outputs, pos)
+			}
+		case DUPN:
+			required, change = int(code[pos+1])+1, 1
+		case SWAPN:
+			required = int(code[pos+1]) + 2
+		case EXCHANGE:
+			required = int(code[pos+1]>>4) + int(code[pos+1]&0x0f) + 3
+		default:
+			required = jt[op].minStack
+			change = int(params.StackLimit) - jt[op].maxStack
+		}
+		if low < required {
+			return fmt.Errorf("%w: %v requires %d items, have %d at pc %d", errStackUnderflow, op, required, low, pos)
+		}
+		low, high = low+change, high+change
+		if high > maxHeight {
+			maxHeight = high
+		}
+
+		// Propagate the height range to the successors
+		visit := func(target int) error {
+			if target >= len(code) {
+				return fmt.Errorf("%w: pc %d", errNoTerminalInstruction, pos)
+			}
+			switch {
+			case target > pos && minHeights[target] == -1:
+				minHeights[target], maxHeights[target] = low, high
+			case target > pos:
+				if low < minHeights[target] {
+					minHeights[target] = low
+				}
+				if high > maxHeights[target] {
+					maxHeights[target] = high
+				}
+			case minHeights[target] != low || maxHeights[target] != high:
+				return fmt.Errorf("%w: pc %d to %d", errInvalidBackwardJump, pos, target)
+			}
+			return nil
+		}
+		var successors []int
+		switch op {
+		case STOP, RETURN, REVERT, INVALID, RETF:
+		case RJUMP:
+			successors = append(successors, next+int(int16(binary.BigEndian.Uint16(code[pos+1:]))))
+		case RJUMPI:
+			successors = append(successors, next, next+int(int16(binary.BigEndian.Uint16(code[pos+1:]))))
+		case RJUMPV:
+			successors = append(successors, next)
+			for i := pos + 2; i < next; i += 2 {
+				successors = append(successors, next+int(int16(binary.BigEndian.Uint16(code[i:]))))
+			}
+		default:
+			successors = append(successors, next)
+		}
+		for _, target := range successors {
+			if err := visit(target); err != nil {
+				return err
+			}
+		}
+		pos = next
+	}
+	if maxHeight > eofMaxStackHeight {
+		return fmt.Errorf("%w: %d", errStackOverflow, maxHeight)
+	}
+	if have := maxHeight - int(typ.Inputs); have != int(typ.MaxStackIncrease) {
+		return fmt.Errorf("%w: have %d, want %d", errInvalidMaxStackHeight, typ.MaxStackIncrease, have)
+	}
+	return nil
+}
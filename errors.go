@@ -37,12 +37,111 @@ var (
 	ErrGasUintOverflow          = errors.New("gas uint64 overflow")
 	ErrInvalidCode              = errors.New("invalid code: must not begin with 0xef")
 	ErrNonceUintOverflow        = errors.New("nonce uint64 overflow")
+	ErrExecutionAborted         = errors.New("execution aborted")
+	ErrStepLimitReached         = errors.New("instruction step limit reached")
+	ErrReturnStackExceeded      = errors.New("return stack limit reached")
 
 	// errStopToken is an internal token indicating interpreter loop termination,
 	// never returned to outside callers.
 	errStopToken = errors.New("stop token")
 )
 
+// List of transaction-level errors returned by ApplyMessage. A message failing
+// with one of these errors is invalid and must not be included in a block.
+var (
+	// ErrNonceTooLow is returned if the nonce of a transaction is lower than the
+	// one present in the local chain.
+	ErrNonceTooLow = errors.New("nonce too low")
+
+	// ErrNonceTooHigh is returned if the nonce of a transaction is higher than the
+	// next one expected based on the local chain.
+	ErrNonceTooHigh = errors.New("nonce too high")
+
+	// ErrNonceMax is returned if the nonce of a transaction sender account has
+	// maximum allowed value and would become invalid if incremented.
+	ErrNonceMax = errors.New("nonce has max value")
+
+	// ErrGasLimitReached is returned by the gas pool if the amount of gas required
+	// by a transaction is higher than what's left in the block.
+	ErrGasLimitReached = errors.New("gas limit reached")
+
+	// ErrInsufficientFundsForTransfer is returned if the transaction sender doesn't
+	// have enough funds for transfer(topmost call only).
+	ErrInsufficientFundsForTransfer = errors.New("insufficient funds for transfer")
+
+	// ErrInsufficientFunds is returned if the total cost of executing a transaction
+	// is higher than the balance of the user's account.
+	ErrInsufficientFunds = errors.New("insufficient funds for gas * price + value")
+
+	// ErrIntrinsicGas is returned if the transaction is specified to use less gas
+	// than required to start the invocation.
+	ErrIntrinsicGas = errors.New("intrinsic gas too low")
+
+	// ErrTipAboveFeeCap is a sanity error to ensure no one is able to specify a
+	// transaction with a tip higher than the total fee cap.
+	ErrTipAboveFeeCap = errors.New("max priority fee per gas higher than max fee per gas")
+
+	// ErrTipVeryHigh is a sanity error to avoid extremely big numbers specified
+	// in the tip field.
+	ErrTipVeryHigh = errors.New("max priority fee per gas higher than 2^256-1")
+
+	// ErrFeeCapVeryHigh is a sanity error to avoid extremely big numbers specified
+	// in the fee cap field.
+	ErrFeeCapVeryHigh = errors.New("max fee per gas higher than 2^256-1")
+
+	// ErrFeeCapTooLow is returned if the transaction fee cap is less than the
+	// base fee of the block.
+	ErrFeeCapTooLow = errors.New("max fee per gas less than block base fee")
+
+	// ErrSenderNoEOA is returned if the sender of a transaction is a contract.
+	ErrSenderNoEOA = errors.New("sender not an eoa")
+
+	// ErrFloorDataGas is returned if the transaction is specified to use less gas
+	// than required for the data floor cost (EIP-7623).
+	ErrFloorDataGas = errors.New("insufficient gas for floor data gas cost")
+
+	// ErrTxTypeNotSupported is returned if a message uses a transaction feature
+	// the fork doesn't support yet.
+	ErrTxTypeNotSupported = errors.New("transaction type not supported")
+
+	// ErrSetCodeTxCreate is returned if a set code transaction creates a
+	// contract.
+	ErrSetCodeTxCreate = errors.New("set code transaction must not be a create transaction")
+
+	// ErrEmptyAuthList is returned if a set code transaction carries no
+	// authorization.
+	ErrEmptyAuthList = errors.New("set code transaction with empty auth list")
+
+	// ErrBlobTxCreate is returned if a blob transaction creates a contract.
+	ErrBlobTxCreate = errors.New("blob transaction of type create")
+
+	// ErrMissingBlobHashes is returned if a blob transaction carries no blob
+	// hash.
+	ErrMissingBlobHashes = errors.New("blob transaction missing blob hashes")
+
+	// ErrBlobHashVersion is returned if a blob hash doesn't have the version
+	// of the KZG commitments.
+	ErrBlobHashVersion = errors.New("blob hash version mismatch")
+
+	// ErrTooManyBlobs is returned if a blob transaction carries more blobs
+	// than a block can hold.
+	ErrTooManyBlobs = errors.New("blob transaction exceeds the blob gas limit of a block")
+
+	// ErrBlobFeeCapTooLow is returned if the blob gas fee cap of a transaction
+	// is less than the blob gas price of the block.
+	ErrBlobFeeCapTooLow = errors.New("max fee per blob gas less than block blob gas fee")
+)
+
+// List of the reasons an EIP-7702 authorization is skipped. They are only
+// informational, an invalid authorization doesn't invalidate the transaction.
+var (
+	ErrAuthorizationWrongChainID       = errors.New("EIP-7702 authorization chain ID mismatch")
+	ErrAuthorizationNonceOverflow      = errors.New("EIP-7702 authorization nonce > 64 bit")
+	ErrAuthorizationInvalidSignature   = errors.New("EIP-7702 authorization has invalid signature")
+	ErrAuthorizationDestinationHasCode = errors.New("EIP-7702 authorization destination has code")
+	ErrAuthorizationNonceMismatch      = errors.New("EIP-7702 authorization nonce does not match current account nonce")
+)
+
 // ErrStackUnderflow wraps an evm error when the items on the stack less
 // than the minimal requirement.
 type ErrStackUnderflow struct {
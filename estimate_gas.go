@@ -0,0 +1,147 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/lyonnee/evm/params"
+)
+
+// ErrGasEstimationExceeded is returned by EstimateGas if the message runs out
+// of gas even with the highest gas limit it may be given.
+var ErrGasEstimationExceeded = errors.New("gas required exceeds allowance")
+
+// EVMFactory returns an EVM, configured for the block the message is applied
+// in, which executes against the given state.
+type EVMFactory func(statedb StateDB) *EVM
+
+// EstimateGas returns the lowest gas limit the message executes with without
+// running out of gas, following the eth_estimateGas semantics. The gas limit
+// is binary searched, each attempt being applied to statedb and reverted
+// afterwards, so the state is left unchanged.
+//
+// The search is bounded by the gas limit of the message if it is set, by the
+// block gas limit otherwise, and by what the sender can afford at the fee cap
+// of the message. If the message fails for another reason than running out of
+// gas, the execution error is returned with the revert data, if any, a revert
+// being reported as a RevertError.
+func EstimateGas(newEVM EVMFactory, msg *Message, statedb StateDB) (uint64, []byte, error) {
+	evm := newEVM(statedb)
+	hi := evm.Context.GasLimit
+	if msg.GasLimit >= params.TxGas {
+		hi = msg.GasLimit
+	}
+	// Cap the limit to what the sender can pay for
+	feeCap := msg.GasFeeCap
+	if feeCap == nil {
+		feeCap = msg.GasPrice
+	}
+	if feeCap != nil && feeCap.Sign() > 0 {
+		balance := new(big.Int).Set(statedb.GetBalance(msg.From))
+		if msg.Value != nil {
+			if msg.Value.Cmp(balance) > 0 {
+				return 0, nil, fmt.Errorf("%w: address %v", ErrInsufficientFundsForTransfer, msg.From.Hex())
+			}
+			balance.Sub(balance, msg.Value)
+		}
+		allowance := balance.Div(balance, feeCap)
+		if allowance.IsUint64() && hi > allowance.Uint64() {
+			hi = allowance.Uint64()
+		}
+	}
+	// A plain transfer to an account without code costs exactly TxGas
+	if msg.To != nil && len(msg.Data) == 0 && len(msg.AccessList) == 0 && msg.SetCodeAuthorizations == nil &&
+		len(statedb.GetCode(*msg.To)) == 0 && hi >= params.TxGas {
+		if failed, _, err := executeEstimate(newEVM, msg, statedb, params.TxGas); err == nil && !failed {
+			return params.TxGas, nil, nil
+		}
+	}
+	// Make sure the message succeeds with the highest limit
+	failed, result, err := executeEstimate(newEVM, msg, statedb, hi)
+	if err != nil {
+		return 0, nil, err
+	}
+	if failed {
+		if result != nil && !errors.Is(result.Err, ErrOutOfGas) {
+			return 0, result.Revert(), WrapRevert(result.ReturnData, result.Err)
+		}
+		return 0, nil, fmt.Errorf("%w (%d)", ErrGasEstimationExceeded, hi)
+	}
+	// The gas used is a lower bound, the limit also has to cover the gas
+	// refunded and the 1/64th withheld from the calls (EIP-150), including
+	// the stipend of the value transfers. Try a limit covering these first,
+	// which most of the time is close to the answer.
+	lo := result.UsedGas - 1
+	optimistic := (result.UsedGas + result.RefundedGas + params.CallStipend) * 64 / 63
+	if optimistic < hi {
+		failed, _, err = executeEstimate(newEVM, msg, statedb, optimistic)
+		if err != nil {
+			return 0, nil, err
+		}
+		if failed {
+			lo = optimistic
+		} else {
+			hi = optimistic
+		}
+	}
+	for lo+1 < hi {
+		mid := (hi + lo) / 2
+		if mid > lo*2 {
+			// Most messages need little more than the gas they use, favour
+			// the lower half of the range
+			mid = lo * 2
+		}
+		failed, _, err = executeEstimate(newEVM, msg, statedb, mid)
+		if err != nil {
+			return 0, nil, err
+		}
+		if failed {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return hi, nil, nil
+}
+
+// executeEstimate applies the message with the given gas limit and reverts
+// the state afterwards. It reports whether the execution failed, a gas limit
+// below the intrinsic or floor data gas counting as a failure rather than an
+// error.
+func executeEstimate(newEVM EVMFactory, msg *Message, statedb StateDB, gas uint64) (bool, *ExecutionResult, error) {
+	cpy := *msg
+	cpy.GasLimit = gas
+	if cpy.GasPrice == nil {
+		cpy.GasPrice = new(big.Int)
+	}
+	snapshot := statedb.Snapshot()
+	defer statedb.RevertToSnapshot(snapshot)
+
+	evm := newEVM(statedb)
+	evm.Reset(NewTxContext(&cpy), statedb)
+	result, err := ApplyMessage(evm, &cpy, new(GasPool).AddGas(gas))
+	if err != nil {
+		if errors.Is(err, ErrIntrinsicGas) || errors.Is(err, ErrFloorDataGas) {
+			return true, nil, nil
+		}
+		return true, nil, err
+	}
+	return result.Failed(), result, nil
+}
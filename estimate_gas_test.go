@@ -0,0 +1,107 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/lyonnee/evm/params"
+)
+
+func TestEstimateGas(t *testing.T) {
+	var (
+		transfer = BytesToAddr([]byte("transfer"))
+		caller   = BytesToAddr([]byte("caller"))
+		reverter = BytesToAddr([]byte("reverter"))
+		looper   = BytesToAddr([]byte("looper"))
+	)
+	config, err := params.ForkConfig("Cancun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, db := newTransitionEnv(t, config, big.NewInt(1), 0)
+	// Call the contract with 1 wei and all the gas left, reverting if the
+	// call fails: PUSH1 0 DUP1 DUP1 DUP1 PUSH1 1 PUSHn contract GAS CALL
+	// ISZERO PUSH1 dest JUMPI STOP JUMPDEST PUSH1 0 DUP1 REVERT
+	code := append(Hex2Bytes("60008080806001"), byte(PUSH1)+byte(AddressLength-1))
+	code = append(append(code, stContract.Bytes()...), 0x5a, 0xf1, 0x15, 0x60, byte(15+AddressLength), 0x57, 0x00)
+	code = append(code, Hex2Bytes("5b600080fd")...)
+	db.SetCode(caller, code)
+	db.AddBalance(caller, big.NewInt(1))
+	// PUSH1 0xaa PUSH1 0 MSTORE8 PUSH1 1 PUSH1 0 REVERT
+	db.SetCode(reverter, Hex2Bytes("60aa60005360016000fd"))
+	// JUMPDEST PUSH1 0 JUMP
+	db.SetCode(looper, Hex2Bytes("5b600056"))
+	newEVM := func(statedb StateDB) *EVM {
+		vm.Reset(TxContext{}, statedb)
+		return vm
+	}
+
+	for _, tt := range []struct {
+		name string
+		to   Address
+		data []byte
+		want uint64
+	}{
+		{name: "transfer", to: transfer, want: params.TxGas},
+		{name: "refund", to: stContract},
+		{name: "call", to: caller},
+		{name: "data", to: transfer, data: []byte{1, 0}},
+	} {
+		to := tt.to
+		msg := &Message{From: stSender, To: &to, Value: big.NewInt(1), Data: tt.data, GasPrice: big.NewInt(1)}
+		gas, _, err := EstimateGas(newEVM, msg, db)
+		if err != nil {
+			t.Fatalf("%s: estimate failed: %v", tt.name, err)
+		}
+		if tt.want != 0 && gas != tt.want {
+			t.Errorf("%s: estimate mismatch: have %d, want %d", tt.name, gas, tt.want)
+		}
+		// The estimate is the lowest limit the message succeeds with
+		if failed, _, err := executeEstimate(newEVM, msg, db, gas); failed || err != nil {
+			t.Errorf("%s: message fails with the estimate %d: %v", tt.name, gas, err)
+		}
+		if failed, _, _ := executeEstimate(newEVM, msg, db, gas-1); !failed {
+			t.Errorf("%s: message succeeds below the estimate %d", tt.name, gas)
+		}
+	}
+	if nonce := db.GetNonce(stSender); nonce != 0 {
+		t.Errorf("state modified: sender nonce %d", nonce)
+	}
+
+	// Reverts are reported with their data
+	msg := &Message{From: stSender, To: &reverter, GasPrice: big.NewInt(1)}
+	if _, ret, err := EstimateGas(newEVM, msg, db); !errors.Is(err, ErrExecutionReverted) || len(ret) != 1 || ret[0] != 0xaa {
+		t.Errorf("revert mismatch: have %x, %v", ret, err)
+	}
+	// Running out of gas with the highest limit fails the estimation
+	msg = &Message{From: stSender, To: &looper, GasPrice: big.NewInt(1)}
+	if _, _, err := EstimateGas(newEVM, msg, db); !errors.Is(err, ErrGasEstimationExceeded) {
+		t.Errorf("error mismatch: have %v, want %v", err, ErrGasEstimationExceeded)
+	}
+	// The limit is capped by the balance of the sender
+	msg = &Message{From: stSender, To: &looper, GasPrice: big.NewInt(1e12)}
+	if _, _, err := EstimateGas(newEVM, msg, db); err == nil || err.Error() != "gas required exceeds allowance (1000000)" {
+		t.Errorf("error mismatch: have %v", err)
+	}
+	msg = &Message{From: stSender, To: &transfer, Value: big.NewInt(2e18), GasPrice: big.NewInt(1)}
+	if _, _, err := EstimateGas(newEVM, msg, db); !errors.Is(err, ErrInsufficientFundsForTransfer) {
+		t.Errorf("error mismatch: have %v, want %v", err, ErrInsufficientFundsForTransfer)
+	}
+}
@@ -17,7 +17,11 @@
 package evm
 
 import (
+	"bytes"
+	"context"
 	"math/big"
+	"sort"
+	"sync"
 	"sync/atomic"
 
 	"github.com/holiman/uint256"
@@ -51,6 +55,18 @@ type BlockContext struct {
 	ExcessBlobGas *uint64  // ExcessBlobGas field in the header, needed to compute the data
 }
 
+// CanTransfer checks whether there are enough funds in the address' account to make a transfer.
+// This does not take the necessary gas in to account to make the transfer valid.
+func CanTransfer(db StateDB, addr Address, amount *big.Int) bool {
+	return db.GetBalance(addr).Cmp(amount) >= 0
+}
+
+// Transfer subtracts amount from sender and adds amount to recipient using the given Db
+func Transfer(db StateDB, sender, recipient Address, amount *big.Int) {
+	db.SubBalance(sender, amount)
+	db.AddBalance(recipient, amount)
+}
+
 type TxContext struct {
 	// Message information
 	Origin     Address  // Provides information for ORIGIN
@@ -59,13 +75,14 @@ type TxContext struct {
 }
 
 type codeAndHash struct {
-	code []byte
-	hash Hash
+	code   []byte
+	hash   Hash
+	hasher Hasher
 }
 
 func (c *codeAndHash) Hash() Hash {
 	if c.hash == NilHash {
-		c.hash = Keccak256Hash(c.code)
+		c.hash = HashWith(c.hasher, c.code)
 	}
 	return c.hash
 }
@@ -86,8 +103,23 @@ type EVM struct {
 	// global (to this context) ethereum virtual machine
 	// used throughout the execution of the tx.
 	interpreter *EVMInterpreter
+	// precompiles is the set of precompiled contracts available to this EVM
+	precompiles map[Address]PrecompiledContract
+	// addressDeriver derives the addresses of the created contracts
+	addressDeriver AddressDeriver
+	// hasher is the hash function of the EVM, emptyCodeHash its hash of
+	// empty code and hashWordGas its cost per hashed word
+	hasher        Hasher
+	emptyCodeHash Hash
+	hashWordGas   uint64
 	// abort is used to abort the EVM calling operations
 	abort atomic.Bool
+	// interrupt is set once the context of CallContext/CreateContext is done,
+	// the interpreter then stops at the next instruction boundary
+	interrupt atomic.Bool
+	// steps counts the instructions executed by the current top-level call,
+	// checked against Config.MaxSteps
+	steps uint64
 	// callGasTemp holds the gas available for the current call. This is needed because the
 	// available gas is calculated in gasCall* according to the 63/64 rule and later
 	// applied in opCall*.
@@ -117,12 +149,20 @@ func (evm *EVM) Interpreter() *EVMInterpreter {
 	return evm.interpreter
 }
 
+// ChainRules returns the fork rules the EVM was configured with.
+func (evm *EVM) ChainRules() params.Rules {
+	return evm.chainRules
+}
+
 // SetBlockContext updates the block context of the EVM.
 func (evm *EVM) SetBlockContext(blockCtx BlockContext) {
 	evm.Context = blockCtx
 }
 
 // 调用其他合约
+//
+// A REVERT is reported as ErrExecutionReverted with the revert data as ret,
+// WrapRevert turns it into a RevertError carrying the decoded reason.
 func (evm *EVM) Call(caller ContractRef, addr Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, err error) {
 	// 检查调用深度,避免无限递归调用。
 	if evm.depth > int(params.CallCreateDepth) {
@@ -174,16 +214,16 @@ func (evm *EVM) Call(caller ContractRef, addr Address, input []byte, gas uint64,
 
 	if isPrecompile {
 		// 如果是预编译合约,直接运行获取返回值
-		ret, gas, err = RunPrecompiledContract(p, input, gas)
+		ret, gas, err = evm.runPrecompiledContract(p, CALL, caller.Address(), addr, addr, input, gas, value, evm.interpreter.readOnly)
 	} else {
 		// 否则,获取代码并创建合约实例,通过解释器Run执行
-		code := evm.StateDB.GetCode(addr)
+		code := evm.resolveCode(addr)
 		if len(code) == 0 {
 			ret, err = nil, nil
 		} else {
 			addrCopy := addr
 			contract := NewContract(caller, AccountRef(addrCopy), value, gas)
-			contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), code)
+			contract.SetCallCode(&addrCopy, evm.resolveCodeHash(addrCopy), code)
 			ret, err = evm.interpreter.Run(contract, input, false)
 			gas = contract.Gas
 		}
@@ -217,13 +257,13 @@ func (evm *EVM) CallCode(caller ContractRef, addr Address, input []byte, gas uin
 	}
 
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
-		ret, gas, err = RunPrecompiledContract(p, input, gas)
+		ret, gas, err = evm.runPrecompiledContract(p, CALLCODE, caller.Address(), addr, caller.Address(), input, gas, value, evm.interpreter.readOnly)
 	} else {
 		addrCopy := addr
 		// Initialise a new contract and set the code that is to be used by the EVM.
 		// The contract is a scoped environment for this execution context only.
 		contract := NewContract(caller, AccountRef(caller.Address()), value, gas)
-		contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), evm.StateDB.GetCode(addrCopy))
+		contract.SetCallCode(&addrCopy, evm.resolveCodeHash(addrCopy), evm.resolveCode(addrCopy))
 		ret, err = evm.interpreter.Run(contract, input, false)
 		gas = contract.Gas
 	}
@@ -256,13 +296,18 @@ func (evm *EVM) DelegateCall(caller ContractRef, addr Address, input []byte, gas
 
 	// It is allowed to call precompiles, even via delegatecall
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
-		ret, gas, err = RunPrecompiledContract(p, input, gas)
+		// DELEGATECALL 继承上层调用的caller和value
+		parentCaller, parentValue := caller.Address(), new(big.Int)
+		if parent, ok := caller.(*Contract); ok {
+			parentCaller, parentValue = parent.CallerAddress, parent.value
+		}
+		ret, gas, err = evm.runPrecompiledContract(p, DELEGATECALL, parentCaller, addr, caller.Address(), input, gas, parentValue, evm.interpreter.readOnly)
 	} else {
 		addrCopy := addr
 		// 这里的AsDelegate()为更新了合约的Caller信息
 		// caller为上层合约的caller
 		contract := NewContract(caller, AccountRef(caller.Address()), nil, gas).AsDelegate()
-		contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), evm.StateDB.GetCode(addrCopy))
+		contract.SetCallCode(&addrCopy, evm.resolveCodeHash(addrCopy), evm.resolveCode(addrCopy))
 		ret, err = evm.interpreter.Run(contract, input, false)
 		gas = contract.Gas
 	}
@@ -306,7 +351,7 @@ func (evm *EVM) StaticCall(caller ContractRef, addr Address, input []byte, gas u
 	}
 
 	if p, isPrecompile := evm.precompile(addr); isPrecompile {
-		ret, gas, err = RunPrecompiledContract(p, input, gas)
+		ret, gas, err = evm.runPrecompiledContract(p, STATICCALL, caller.Address(), addr, addr, input, gas, new(big.Int), true)
 	} else {
 		// At this point, we use a copy of define.Address. If we don't, the go compiler will
 		// leak the 'contract' to the outer scope, and make allocation for 'contract'
@@ -315,7 +360,7 @@ func (evm *EVM) StaticCall(caller ContractRef, addr Address, input []byte, gas u
 		// Initialise a new contract and set the code that is to be used by the EVM.
 		// The contract is a scoped environment for this execution context only.
 		contract := NewContract(caller, AccountRef(addrCopy), new(big.Int), gas)
-		contract.SetCallCode(&addrCopy, evm.StateDB.GetCodeHash(addrCopy), evm.StateDB.GetCode(addrCopy))
+		contract.SetCallCode(&addrCopy, evm.resolveCodeHash(addrCopy), evm.resolveCode(addrCopy))
 		// When an error was returned by the EVM or when setting the creation code
 		// above we revert to the snapshot and consume any gas remaining. Additionally
 		// when we're in Homestead this also counts for code storage gas errors.
@@ -331,14 +376,70 @@ func (evm *EVM) StaticCall(caller ContractRef, addr Address, input []byte, gas u
 	return ret, gas, err
 }
 
+// Create creates a new contract using code as deployment code, its address
+// being derived from the caller's nonce. As with Call, a REVERT of the
+// deployment code is reported as ErrExecutionReverted with the revert data as
+// ret, to be wrapped with WrapRevert.
 func (evm *EVM) Create(caller ContractRef, code []byte, gas uint64, value *big.Int) (ret []byte, contractAddr Address, leftOverGas uint64, err error) {
-	contractAddr = CreateAddress(caller.Address().Bytes(), evm.StateDB.GetNonce(caller.Address()))
-	return evm.create(caller, &codeAndHash{code: code}, gas, value, contractAddr, CREATE)
+	contractAddr = evm.addressDeriver.CreateAddress(caller.Address(), evm.StateDB.GetNonce(caller.Address()))
+	return evm.create(caller, &codeAndHash{code: code, hasher: evm.hasher}, gas, value, contractAddr, CREATE)
 }
 
+// Create2 creates a new contract using code as deployment code, its address
+// being derived from the salt and the hash of the code (EIP-1014).
 func (evm *EVM) Create2(caller ContractRef, code []byte, gas uint64, endowment *big.Int, salt *uint256.Int) (ret []byte, contractAddr Address, leftOverGas uint64, err error) {
-	contractAddr = CreateAddress2(caller.Address().Bytes(), salt.Bytes32(), nil)
-	return evm.create(caller, &codeAndHash{code: code}, gas, endowment, contractAddr, CREATE2)
+	codeAndHash := &codeAndHash{code: code, hasher: evm.hasher}
+	contractAddr = evm.addressDeriver.CreateAddress2(caller.Address(), salt.Bytes32(), codeAndHash.Hash())
+	return evm.create(caller, codeAndHash, gas, endowment, contractAddr, CREATE2)
+}
+
+// CallContext executes Call, stopping at the next instruction boundary with
+// ErrExecutionAborted once ctx is done.
+func (evm *EVM) CallContext(ctx context.Context, caller ContractRef, addr Address, input []byte, gas uint64, value *big.Int) (ret []byte, leftOverGas uint64, err error) {
+	if ctx.Err() != nil {
+		return nil, gas, ErrExecutionAborted
+	}
+	defer evm.watch(ctx)()
+	return evm.Call(caller, addr, input, gas, value)
+}
+
+// CreateContext executes Create, stopping at the next instruction boundary
+// with ErrExecutionAborted once ctx is done.
+func (evm *EVM) CreateContext(ctx context.Context, caller ContractRef, code []byte, gas uint64, value *big.Int) (ret []byte, contractAddr Address, leftOverGas uint64, err error) {
+	if ctx.Err() != nil {
+		return nil, NilAddr, gas, ErrExecutionAborted
+	}
+	defer evm.watch(ctx)()
+	return evm.Create(caller, code, gas, value)
+}
+
+// watch raises the interrupt flag when ctx is done. The returned function
+// stops watching and clears the flag, it has to be called once the execution
+// is over.
+func (evm *EVM) watch(ctx context.Context) func() {
+	evm.interrupt.Store(false)
+	if ctx.Done() == nil {
+		// 不可取消的context无需监听
+		return func() {}
+	}
+	var (
+		done = make(chan struct{})
+		wg   sync.WaitGroup
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		select {
+		case <-ctx.Done():
+			evm.interrupt.Store(true)
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+		evm.interrupt.Store(false)
+	}
 }
 
 func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64, value *big.Int, address Address, typ OpCode) ([]byte, Address, uint64, error) {
@@ -358,7 +459,7 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 	}
 
 	contractHash := evm.StateDB.GetCodeHash(address)
-	if evm.StateDB.GetNonce(address) != 0 || (contractHash != NilHash && contractHash != EmptyCodeHash) {
+	if evm.StateDB.GetNonce(address) != 0 || (contractHash != NilHash && contractHash != evm.emptyCodeHash) {
 		return nil, NilAddr, 0, ErrContractAddressCollision
 	}
 
@@ -382,16 +483,31 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 		}
 	}
 
-	ret, err := evm.interpreter.Run(contract, nil, false)
+	// Osaka - EOF: EOF格式的初始化代码在执行前验证,失败时消耗全部gas
+	var (
+		ret   []byte
+		err   error
+		isEOF = evm.chainRules.IsOsaka && hasEOFMagic(codeAndHash.code)
+	)
+	if isEOF {
+		contract.Container, err = evm.interpreter.validateEOF(codeAndHash.code)
+	}
+	if err == nil {
+		ret, err = evm.interpreter.Run(contract, nil, false)
+	}
 
 	if err == nil && evm.chainRules.IsEIP158 && uint64(len(ret)) > params.MaxCodeSize {
 		err = ErrMaxCodeSizeExceeded
 	}
 
 	// London - EIP-3541: 拒绝以 0xEF 字节开头的新地址
-	if err == nil && len(ret) >= 1 && ret[0] == 0xEF && evm.chainRules.IsLondon {
+	if err == nil && !isEOF && len(ret) >= 1 && ret[0] == 0xEF && evm.chainRules.IsLondon {
 		err = ErrInvalidCode
 	}
+	// Osaka - EOF: EOF初始化代码只能部署有效的EOF合约
+	if err == nil && isEOF {
+		_, err = evm.interpreter.validateEOF(ret)
+	}
 
 	// 如果合约创建成功并且没有返回错误，计算存储代码所需的gas。
 	//如果由于没有足够的气体而无法存储代码，则设置一个错误，并让下面的错误检查条件处理它。
@@ -423,24 +539,96 @@ func (evm *EVM) create(caller ContractRef, codeAndHash *codeAndHash, gas uint64,
 	return ret, address, contract.Gas, err
 }
 
+// resolveCode returns the code executed by a call to addr, following the
+// EIP-7702 delegation designator of the account after Prague.
+func (evm *EVM) resolveCode(addr Address) []byte {
+	code := evm.StateDB.GetCode(addr)
+	if !evm.chainRules.IsPrague {
+		return code
+	}
+	if target, ok := ParseDelegation(code); ok {
+		return evm.StateDB.GetCode(target)
+	}
+	return code
+}
+
+// resolveCodeHash returns the hash of the code resolveCode returns.
+func (evm *EVM) resolveCodeHash(addr Address) Hash {
+	if evm.chainRules.IsPrague {
+		if target, ok := ParseDelegation(evm.StateDB.GetCode(addr)); ok {
+			return evm.StateDB.GetCodeHash(target)
+		}
+	}
+	return evm.StateDB.GetCodeHash(addr)
+}
+
 func (evm *EVM) precompile(addr Address) (PrecompiledContract, bool) {
-	var precompiles map[Address]PrecompiledContract
-	switch {
-	case evm.chainRules.IsCancun:
-		precompiles = PrecompiledContractsCancun
-	case evm.chainRules.IsBerlin:
-		precompiles = PrecompiledContractsBerlin
-	case evm.chainRules.IsIstanbul:
-		precompiles = PrecompiledContractsIstanbul
-	case evm.chainRules.IsByzantium:
-		precompiles = PrecompiledContractsByzantium
-	default:
-		precompiles = PrecompiledContractsHomestead
-	}
-	p, ok := precompiles[addr]
+	p, ok := evm.precompiles[addr]
 	return p, ok
 }
 
+// ActivePrecompiles returns the addresses of the precompiled contracts
+// available to this EVM, in ascending order.
+func (evm *EVM) ActivePrecompiles() []Address {
+	addrs := make([]Address, 0, len(evm.precompiles))
+	for addr := range evm.precompiles {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i][:], addrs[j][:]) < 0
+	})
+	return addrs
+}
+
+// Hasher returns the hash function of this EVM.
+func (evm *EVM) Hasher() Hasher {
+	return evm.hasher
+}
+
+// AddressDeriver returns the scheme deriving the addresses of the contracts
+// created by this EVM.
+func (evm *EVM) AddressDeriver() AddressDeriver {
+	return evm.addressDeriver
+}
+
+// runPrecompiledContract runs the precompile, handing the call environment to
+// stateful ones. The call of type typ runs in the context of the storage
+// account, addr for CALL and STATICCALL.
+func (evm *EVM) runPrecompiledContract(p PrecompiledContract, typ OpCode, caller, addr, storage Address, input []byte, gas uint64, value *big.Int, readOnly bool) (ret []byte, remainingGas uint64, err error) {
+	sp, ok := p.(StatefulPrecompiledContract)
+	if !ok {
+		return RunPrecompiledContract(p, input, gas)
+	}
+	gasCost := sp.RequiredGas(input)
+	if gas < gasCost {
+		return nil, 0, ErrOutOfGas
+	}
+	ctx := &PrecompileContext{
+		EVM:            evm,
+		StateDB:        evm.StateDB,
+		Caller:         caller,
+		Address:        addr,
+		Value:          value,
+		StorageAddress: storage,
+		CallType:       typ,
+		ReadOnly:       readOnly,
+		Gas:            gas - gasCost,
+	}
+	ret, err = sp.RunStateful(ctx, input)
+	return ret, ctx.Gas, err
+}
+
+// NewValidatedEVM returns a new EVM like NewEVM, after checking the config
+// with Config.Validate.
+func NewValidatedEVM(blockCtx BlockContext, txCtx TxContext, statedb StateDB, chainConfig *params.ChainConfig, config Config) (*EVM, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return NewEVM(blockCtx, txCtx, statedb, chainConfig, config), nil
+}
+
+// NewEVM returns a new EVM. The config is assumed to be valid and isn't
+// checked, use NewValidatedEVM to check a custom jump table once.
 func NewEVM(blockCtx BlockContext, txCtx TxContext, statedb StateDB, chainConfig *params.ChainConfig, config Config) *EVM {
 	evm := &EVM{
 		Context:    blockCtx,
@@ -449,6 +637,23 @@ func NewEVM(blockCtx BlockContext, txCtx TxContext, statedb StateDB, chainConfig
 		Config:     config,
 		chainRules: chainConfig.Rules(blockCtx.BlockNumber, blockCtx.Random != nil, blockCtx.Time),
 	}
+	if config.Precompiles != nil {
+		evm.precompiles = config.Precompiles
+	} else {
+		evm.precompiles = precompiledContracts(evm.chainRules)
+	}
+	if config.Hasher != nil {
+		evm.hasher = config.Hasher
+	} else {
+		evm.hasher = KeccakHasher{}
+	}
+	evm.emptyCodeHash = HashWith(evm.hasher, nil)
+	_, evm.hashWordGas = evm.hasher.Gas()
+	if config.AddressDeriver != nil {
+		evm.addressDeriver = config.AddressDeriver
+	} else {
+		evm.addressDeriver = EthereumAddressDeriver{Hasher: config.Hasher}
+	}
 	evm.interpreter = NewEVMInterpreter(evm)
 	return evm
 }
@@ -21,6 +21,7 @@ import "github.com/holiman/uint256"
 const (
 	GasQuickStep   uint64 = 2  // 快速操作的gas价格等级,包括对256位值的算数、位操作、SHA3等
 	GasFastestStep uint64 = 3  // 最快操作的gas价格,包括访问stack的操作
+	GasFastishStep uint64 = 4  // 介于最快和快速之间的操作,包括EOF的条件跳转
 	GasFastStep    uint64 = 5  // 快速操作,主要是访问内存的操作
 	GasMidStep     uint64 = 8  // 中等速度操作,包括访问存储的操作
 	GasSlowStep    uint64 = 10 // 慢速操作,主要包括日志相关操作
@@ -64,7 +64,7 @@ func memoryGasCost(mem *Memory, newMemSize uint64) (uint64, error) {
 - EXTCODECOPY
 - RETURNDATACOPY
 */
-func memoryCopierGas(stackpos int) gasFunc {
+func memoryCopierGas(stackpos int) GasFunc {
 	return func(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
 		// 计算扩容内存所需的 gas
 		gas, err := memoryGasCost(mem, memorySize)
@@ -91,15 +91,17 @@ func memoryCopierGas(stackpos int) gasFunc {
 
 var (
 	// CALLDATACOPY (stack position 2)
-	gasCallDataCopy gasFunc = memoryCopierGas(2)
+	gasCallDataCopy GasFunc = memoryCopierGas(2)
 	// CODECOPY (stack position 2)
-	gasCodeCopy gasFunc = memoryCopierGas(2)
+	gasCodeCopy GasFunc = memoryCopierGas(2)
 	// MCOPY (stack position 2)
-	gasMcopy gasFunc = memoryCopierGas(2)
+	gasMcopy GasFunc = memoryCopierGas(2)
+	// DATACOPY (stack position 2)
+	gasDataCopy GasFunc = memoryCopierGas(2)
 	// EXTCODECOPY (stack position 3)
-	gasExtCodeCopy gasFunc = memoryCopierGas(3)
+	gasExtCodeCopy GasFunc = memoryCopierGas(3)
 	// RETURNDATACOPY (stack position 2)
-	gasReturnDataCopy gasFunc = memoryCopierGas(2)
+	gasReturnDataCopy GasFunc = memoryCopierGas(2)
 )
 
 // gasSStore 实现了以太坊虚拟机(EVM)在 Constantinople 版本中,SSTORE 操作的 gas 计费逻辑
@@ -240,7 +242,7 @@ func gasSStoreEIP2200(evm *EVM, contract *Contract, stack *Stack, mem *Memory, m
 
 // 计算日志（Log）操作的Gas消耗
 // n => 日志数量
-func makeGasLog(n uint64) gasFunc {
+func makeGasLog(n uint64) GasFunc {
 	return func(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
 		requestedSize, overflow := stack.Back(1).Uint64WithOverflow()
 		if overflow {
@@ -280,7 +282,7 @@ func gasKeccak256(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memor
 	if overflow {
 		return 0, ErrGasUintOverflow
 	}
-	if wordGas, overflow = math.SafeMul(toWordSize(wordGas), params.Keccak256WordGas); overflow {
+	if wordGas, overflow = math.SafeMul(toWordSize(wordGas), evm.hashWordGas); overflow {
 		return 0, ErrGasUintOverflow
 	}
 	if gas, overflow = math.SafeAdd(gas, wordGas); overflow {
@@ -312,7 +314,7 @@ func gasCreate2(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memoryS
 	if overflow {
 		return 0, ErrGasUintOverflow
 	}
-	if wordGas, overflow = math.SafeMul(toWordSize(wordGas), params.Keccak256WordGas); overflow {
+	if wordGas, overflow = math.SafeMul(toWordSize(wordGas), evm.hashWordGas); overflow {
 		return 0, ErrGasUintOverflow
 	}
 	if gas, overflow = math.SafeAdd(gas, wordGas); overflow {
@@ -348,7 +350,7 @@ func gasCreate2Eip3860(evm *EVM, contract *Contract, stack *Stack, mem *Memory,
 		return 0, ErrGasUintOverflow
 	}
 	// Since size <= MaxInitCodeSize, these multiplication cannot overflow
-	moreGas := (params.InitCodeWordGas + params.Keccak256WordGas) * ((size + 31) / 32)
+	moreGas := (params.InitCodeWordGas + evm.hashWordGas) * ((size + 31) / 32)
 	if gas, overflow = math.SafeAdd(gas, moreGas); overflow {
 		return 0, ErrGasUintOverflow
 	}
@@ -18,7 +18,10 @@ package evm
 
 import (
 	"math"
+	"math/big"
 	"testing"
+
+	"github.com/lyonnee/evm/params"
 )
 
 func TestMemoryGasCost(t *testing.T) {
@@ -69,3 +72,40 @@ var eip2200Tests = []struct {
 	{1, 2306, "0x6001600055", 2306, 0, ErrOutOfGas},                            // 1 -> 1 (2300 sentry + 2xPUSH)
 	{1, 2307, "0x6001600055", 806, 0, nil},                                     // 1 -> 1 (2301 sentry + 2xPUSH)
 }
+
+// TestCallColdAccessGas checks that the EIP-2929 cold account surcharge of a
+// CALL is charged against the callee, not the gas argument on top of the stack.
+func TestCallColdAccessGas(t *testing.T) {
+	config, err := params.ForkConfig("Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	callee := BytesToAddr([]byte("callee"))
+	// PUSH1 0 (x5) PUSHn callee PUSH1 0 CALL STOP
+	code := Hex2Bytes("60006000600060006000")
+	code = append(code, byte(PUSH1)+byte(AddressLength-1))
+	code = append(code, callee.Bytes()...)
+	code = append(code, byte(PUSH1), 0, byte(CALL), byte(STOP))
+
+	run := func(warm bool) uint64 {
+		vm, db := newTransitionEnv(t, config, nil, 0)
+		db.SetCode(stContract, code)
+		db.AddAddressToAccessList(stSender)
+		db.AddAddressToAccessList(stContract)
+		if warm {
+			db.AddAddressToAccessList(callee)
+		}
+		_, leftOver, err := vm.Call(AccountRef(stSender), stContract, nil, 100000, new(big.Int))
+		if err != nil {
+			t.Fatalf("call failed: %v", err)
+		}
+		if have := db.AddressInAccessList(callee); !have {
+			t.Errorf("callee missing from the access list")
+		}
+		return 100000 - leftOver
+	}
+	cold, warm := run(false), run(true)
+	if have, want := cold-warm, params.ColdAccountAccessCostEIP2929-params.WarmStorageReadCostEIP2929; have != want {
+		t.Errorf("cold surcharge mismatch: have %d, want %d", have, want)
+	}
+}
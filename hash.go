@@ -16,6 +16,11 @@
 
 package evm
 
+import (
+	"encoding/hex"
+	"fmt"
+)
+
 const HashLength int = 32
 
 type Hash [HashLength]byte
@@ -31,7 +36,10 @@ func (h *Hash) SetBytes(b []byte) {
 func (h Hash) Bytes() []byte { return h[:] }
 
 var (
-	NilHash       Hash = Hash{}
+	NilHash Hash = Hash{}
+	// EmptyCodeHash is the Keccak-256 hash of empty code. It only holds for
+	// the KeccakHasher, the hash of empty code under another Hasher is
+	// HashWith(hasher, nil).
 	EmptyCodeHash Hash = Keccak256Hash(nil)
 )
 
@@ -42,3 +50,22 @@ func BytesToHash(b []byte) Hash {
 }
 
 func HashToBytes(h Hash) []byte { return h[:] }
+
+// Hex returns the hex encoding of the hash without 0x prefix.
+func (h Hash) Hex() string { return hex.EncodeToString(h[:]) }
+
+// MarshalText encodes the hash as 0x prefixed hex.
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte("0x" + h.Hex()), nil
+}
+
+// UnmarshalText decodes a hex string of at most HashLength bytes, left padding
+// shorter values.
+func (h *Hash) UnmarshalText(input []byte) error {
+	b, err := decodeFixedHex(input, HashLength)
+	if err != nil {
+		return fmt.Errorf("invalid hash %q: %w", input, err)
+	}
+	*h = BytesToHash(b)
+	return nil
+}
@@ -232,9 +232,9 @@ func opKeccak256(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) (
 	// 从Memory中获取数据指针
 	data := scope.Memory.GetPtr(offset.ToBig().Int64(), size.ToBig().Int64())
 
-	// 如果解释器的hasher为空,则新建一个keccak256哈希状态机,否则重置现有的hasher
+	// 如果解释器的hasher为空,则由EVM的Hasher新建一个哈希状态机,否则重置现有的hasher
 	if interpreter.hasher == nil {
-		interpreter.hasher = NewKeccakState()
+		interpreter.hasher = interpreter.evm.hasher.NewState()
 	} else {
 		interpreter.hasher.Reset()
 	}
@@ -790,7 +790,7 @@ func opRevert(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]b
 }
 
 func opUndefined(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
-	return nil, &ErrInvalidOpCode{opcode: OpCode(scope.Contract.Code[*pc])}
+	return nil, &ErrInvalidOpCode{opcode: OpCode(scope.Contract.sectionCode()[*pc])}
 }
 
 func opStop(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
@@ -845,12 +845,13 @@ func opSelfdestruct6780(pc *uint64, interpreter *EVMInterpreter, scope *ScopeCon
 
 func opPush1(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
 	var (
-		codeLen = uint64(len(scope.Contract.Code))
+		code    = scope.Contract.sectionCode()
+		codeLen = uint64(len(code))
 		integer = new(uint256.Int)
 	)
 	*pc += 1
 	if *pc < codeLen {
-		scope.Stack.push(integer.SetUint64(uint64(scope.Contract.Code[*pc])))
+		scope.Stack.push(integer.SetUint64(uint64(code[*pc])))
 	} else {
 		scope.Stack.push(integer.Clear())
 	}
@@ -859,7 +860,7 @@ func opPush1(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]by
 
 // 生成指定数量日志指令
 // size: 日志数量
-func makeLog(size int) executionFunc {
+func makeLog(size int) ExecutionFunc {
 	return func(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
 		if interpreter.readOnly {
 			return nil, ErrWriteProtection
@@ -868,7 +869,7 @@ func makeLog(size int) executionFunc {
 		mStart, mSize := scope.Stack.pop(), scope.Stack.pop()
 		for i := 0; i < size; i++ {
 			addr := scope.Stack.pop()
-			topics[i] = Hash(addr.Bytes())
+			topics[i] = addr.Bytes32()
 		}
 
 		d := scope.Memory.GetCopy(int64(mStart.Uint64()), int64(mSize.Uint64()))
@@ -879,9 +880,10 @@ func makeLog(size int) executionFunc {
 }
 
 // 生成指定长度的Push指令
-func makePush(size uint64, pushByteSize int) executionFunc {
+func makePush(size uint64, pushByteSize int) ExecutionFunc {
 	return func(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
-		codeLen := len(scope.Contract.Code)
+		code := scope.Contract.sectionCode()
+		codeLen := len(code)
 
 		startMin := codeLen
 		if int(*pc+1) < startMin {
@@ -894,7 +896,7 @@ func makePush(size uint64, pushByteSize int) executionFunc {
 		}
 
 		scope.Stack.push(new(uint256.Int).SetBytes(rightPadBytes(
-			scope.Contract.Code[startMin:endMin], pushByteSize)))
+			code[startMin:endMin], pushByteSize)))
 
 		*pc += size
 		return nil, nil
@@ -902,7 +904,7 @@ func makePush(size uint64, pushByteSize int) executionFunc {
 }
 
 // 生成指定长度复制指令
-func makeDup(size int64) executionFunc {
+func makeDup(size int64) ExecutionFunc {
 	return func(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
 		scope.Stack.dup(int(size))
 		return nil, nil
@@ -910,7 +912,7 @@ func makeDup(size int64) executionFunc {
 }
 
 // 生成指定长度的交换指令
-func makeSwap(size int64) executionFunc {
+func makeSwap(size int64) ExecutionFunc {
 	// 交换n + 1，否则n就会和n交换
 	size++
 	return func(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
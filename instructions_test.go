@@ -47,7 +47,7 @@ type twoOperandParams struct {
 
 var alphabetSoup = "ABCDEF090807060504030201ffffffffffffffffffffffffffffffffffffffff"
 var commonParams []*twoOperandParams
-var twoOpMethods map[string]executionFunc
+var twoOpMethods map[string]ExecutionFunc
 
 type contractRef struct {
 	addr Address
@@ -77,7 +77,7 @@ func init() {
 			commonParams[i*len(params)+j] = &twoOperandParams{x, y}
 		}
 	}
-	twoOpMethods = map[string]executionFunc{
+	twoOpMethods = map[string]ExecutionFunc{
 		"add":     opAdd,
 		"sub":     opSub,
 		"mul":     opMul,
@@ -126,7 +126,7 @@ var testChainConfig = &params.ChainConfig{
 	VerkleTime:          nil,
 }
 
-func testTwoOperandOp(t *testing.T, tests []TwoOperandTestcase, opFn executionFunc, name string) {
+func testTwoOperandOp(t *testing.T, tests []TwoOperandTestcase, opFn ExecutionFunc, name string) {
 	var (
 		env            = NewEVM(BlockContext{}, TxContext{}, nil, testChainConfig, Config{})
 		stack          = newstack()
@@ -269,7 +269,7 @@ func TestWriteExpectedValues(t *testing.T) {
 	t.Skip("Enable this test to create json test cases.")
 
 	// getResult is a convenience function to generate the expected values
-	getResult := func(args []*twoOperandParams, opFn executionFunc) []TwoOperandTestcase {
+	getResult := func(args []*twoOperandParams, opFn ExecutionFunc) []TwoOperandTestcase {
 		var (
 			env         = NewEVM(BlockContext{}, TxContext{}, nil, testChainConfig, Config{})
 			stack       = newstack()
@@ -314,7 +314,7 @@ func TestJsonTestcases(t *testing.T) {
 	}
 }
 
-func opBenchmark(bench *testing.B, op executionFunc, args ...string) {
+func opBenchmark(bench *testing.B, op ExecutionFunc, args ...string) {
 	var (
 		env            = NewEVM(BlockContext{}, TxContext{}, nil, testChainConfig, Config{})
 		stack          = newstack()
@@ -953,3 +953,57 @@ func TestOpMCopy(t *testing.T) {
 		}
 	}
 }
+
+// logRecorder is a StateDB only collecting the logs added to it.
+type logRecorder struct {
+	StateDB
+	logs []Log
+}
+
+func (l *logRecorder) AddLog(log Log) {
+	l.logs = append(l.logs, log)
+}
+
+func TestOpLogShortTopics(t *testing.T) {
+	for size := 1; size <= 4; size++ {
+		var (
+			db             = new(logRecorder)
+			env            = NewEVM(BlockContext{BlockNumber: big.NewInt(1)}, TxContext{}, db, testChainConfig, Config{})
+			stack          = newstack()
+			mem            = NewMemory()
+			evmInterpreter = env.interpreter
+			contract       = NewContract(AccountRef(Address{}), AccountRef(Address{1}), new(big.Int), 0)
+			pc             = uint64(0)
+			want           = make([]Hash, size)
+		)
+		// Topics whose big-endian encodings are shorter than a word, as
+		// pushed by PUSH1 or PUSH2, the last one being zero.
+		for i := size - 1; i >= 0; i-- {
+			topic := uint64(0x0100 * i)
+			stack.push(uint256.NewInt(topic))
+			want[i] = BytesToHash(new(big.Int).SetUint64(topic).Bytes())
+		}
+		mem.Resize(32)
+		mem.Set(0, 2, []byte{0xab, 0xcd})
+		stack.push(uint256.NewInt(2))
+		stack.push(new(uint256.Int))
+		if _, err := makeLog(size)(&pc, evmInterpreter, &ScopeContext{mem, stack, contract}); err != nil {
+			t.Fatalf("LOG%d: unexpected error: %v", size, err)
+		}
+		if len(db.logs) != 1 {
+			t.Fatalf("LOG%d: log count mismatch: have %d, want 1", size, len(db.logs))
+		}
+		log := db.logs[0]
+		if len(log.Topics) != size {
+			t.Fatalf("LOG%d: topic count mismatch: have %d, want %d", size, len(log.Topics), size)
+		}
+		for i := range want {
+			if log.Topics[i] != want[i] {
+				t.Errorf("LOG%d: topic %d mismatch: have %x, want %x", size, i, log.Topics[i], want[i])
+			}
+		}
+		if !bytes.Equal(log.Data, []byte{0xab, 0xcd}) {
+			t.Errorf("LOG%d: data mismatch: have %x", size, log.Data)
+		}
+	}
+}
@@ -17,6 +17,8 @@
 package evm
 
 import (
+	"fmt"
+
 	"github.com/lyonnee/evm/math"
 )
 
@@ -26,6 +28,32 @@ type Config struct {
 	NoBaseFee               bool      // Forces the EIP-1559 baseFee to 0 (needed for 0 price calls)
 	EnablePreimageRecording bool      // Enables recording of SHA3/keccak preimages
 	ExtraEips               []int     // Additional EIPS that are to be enabled
+	MaxSteps                uint64    // Maximum number of instructions executed per top-level call, 0 means no limit
+
+	// Precompiles overrides the precompiled contracts of the fork when set.
+	// Use DefaultPrecompiles to extend the fork's set.
+	Precompiles map[Address]PrecompiledContract
+	// JumpTable overrides the instruction set of the fork when set. Use
+	// LookupInstructionSet to obtain a fork's table to customise, the table
+	// must pass JumpTable.Validate.
+	JumpTable *JumpTable
+	// AddressDeriver derives the addresses of created contracts, nil
+	// selects EthereumAddressDeriver using Hasher.
+	AddressDeriver AddressDeriver
+	// Hasher replaces Keccak-256 in the KECCAK256 instruction, code hashes
+	// and address derivation when set. The state trie keeps hashing its nodes
+	// and keys with Keccak-256.
+	Hasher Hasher
+}
+
+// Validate checks the config can be used by an EVM, which NewEVM assumes.
+func (c *Config) Validate() error {
+	if c.JumpTable != nil {
+		if err := c.JumpTable.Validate(); err != nil {
+			return fmt.Errorf("invalid jump table: %w", err)
+		}
+	}
+	return nil
 }
 
 // ScopeContext contains the things that are per-call, such as stack and memory,
@@ -38,14 +66,23 @@ type ScopeContext struct {
 
 // EVMInterpreter represents an EVM interpreter
 type EVMInterpreter struct {
-	evm   *EVM
-	table *JumpTable
+	evm      *EVM
+	table    *JumpTable
+	eofTable *JumpTable // Instructions of EOF code, nil before Osaka
 
-	hasher    KeccakState // Keccak256 hasher instance shared across opcodes
-	hasherBuf Hash        // Keccak256 hasher result array shared aross opcodes
+	hasher    KeccakState // Hasher state shared across opcodes
+	hasherBuf Hash        // Hasher result array shared across opcodes
 
 	readOnly   bool   // Whether to throw on stateful modifications
 	returnData []byte // Last CALL's return data for subsequent reuse
+
+	eofCache map[Hash]eofContainer // Validated EOF code by code hash
+}
+
+// eofContainer is the outcome of the validation of EOF code.
+type eofContainer struct {
+	container *Container
+	err       error
 }
 
 // NewEVMInterpreter returns a new instance of the Interpreter.
@@ -53,6 +90,10 @@ func NewEVMInterpreter(evm *EVM) *EVMInterpreter {
 	// If jump table was not initialised we set the default one.
 	var table *JumpTable
 	switch {
+	case evm.chainRules.IsOsaka:
+		table = &osakaInstructionSet
+	case evm.chainRules.IsPrague:
+		table = &pragueInstructionSet
 	case evm.chainRules.IsCancun:
 		table = &cancunInstructionSet
 	case evm.chainRules.IsShanghai:
@@ -78,6 +119,9 @@ func NewEVMInterpreter(evm *EVM) *EVMInterpreter {
 	default:
 		table = &frontierInstructionSet
 	}
+	if evm.Config.JumpTable != nil {
+		table = evm.Config.JumpTable
+	}
 	var extraEips []int
 	if len(evm.Config.ExtraEips) > 0 {
 		// Deep-copy jumptable to prevent modification of opcodes in other tables
@@ -92,7 +136,71 @@ func NewEVMInterpreter(evm *EVM) *EVMInterpreter {
 		}
 	}
 	evm.Config.ExtraEips = extraEips
-	return &EVMInterpreter{evm: evm, table: table}
+	// Charge the hash instruction as the hasher requires
+	if constant, _ := evm.hasher.Gas(); table[KECCAK256].constantGas != constant {
+		if len(extraEips) == 0 {
+			table = copyJumpTable(table)
+		}
+		table[KECCAK256].constantGas = constant
+	}
+	// EOF代码的指令集由legacy指令集派生,保留对其所做的定制
+	var eofTable *JumpTable
+	if evm.chainRules.IsOsaka {
+		eofTable = &eofInstructionSet
+		if table != &osakaInstructionSet {
+			derived := newEOFInstructionSet(*table)
+			eofTable = &derived
+		}
+	}
+	return &EVMInterpreter{evm: evm, table: table, eofTable: eofTable}
+}
+
+// validateEOF parses code as an EOF container and validates its code sections
+// against the EOF instruction set.
+func (in *EVMInterpreter) validateEOF(code []byte) (*Container, error) {
+	var c Container
+	if err := c.UnmarshalBinary(code); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEOF, err)
+	}
+	if err := c.ValidateCode(in.eofTable); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEOF, err)
+	}
+	return &c, nil
+}
+
+// loadEOF returns the validated container of the EOF code of contract. Code
+// can reach the state without the deployment checks, through a genesis alloc
+// or a host SetCode, so it is validated on first execution, the outcome being
+// cached by code hash.
+func (in *EVMInterpreter) loadEOF(contract *Contract) (*Container, error) {
+	if contract.CodeHash == (Hash{}) {
+		return in.validateEOF(contract.Code)
+	}
+	if cached, ok := in.eofCache[contract.CodeHash]; ok {
+		return cached.container, cached.err
+	}
+	container, err := in.validateEOF(contract.Code)
+	if in.eofCache == nil {
+		in.eofCache = make(map[Hash]eofContainer)
+	}
+	in.eofCache[contract.CodeHash] = eofContainer{container, err}
+	return container, err
+}
+
+// EVM returns the EVM the interpreter is running in.
+func (in *EVMInterpreter) EVM() *EVM {
+	return in.evm
+}
+
+// ReadOnly returns whether the interpreter executes within a static call, in
+// which case state modifications are not allowed.
+func (in *EVMInterpreter) ReadOnly() bool {
+	return in.readOnly
+}
+
+// ReturnData returns the return data of the last call.
+func (in *EVMInterpreter) ReturnData() []byte {
+	return in.returnData
 }
 
 func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (ret []byte, err error) {
@@ -100,6 +208,11 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 	in.evm.depth++
 	defer func() { in.evm.depth-- }()
 
+	// 指令计数按顶层调用重置
+	if in.evm.depth == 1 {
+		in.evm.steps = 0
+	}
+
 	if readOnly && !in.readOnly {
 		in.readOnly = true
 		defer func() { in.readOnly = false }()
@@ -110,6 +223,18 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 	if len(contract.Code) == 0 {
 		return nil, nil
 	}
+	// Osaka起EOF合约使用EOF指令集执行,未经部署验证的代码在执行前验证
+	table := in.table
+	if in.eofTable != nil && hasEOFMagic(contract.Code) {
+		if contract.Container == nil {
+			container, err := in.loadEOF(contract)
+			if err != nil {
+				return nil, err
+			}
+			contract.Container = container
+		}
+		table = in.eofTable
+	}
 
 	var (
 		op          OpCode           // 当前操作码
@@ -155,11 +280,22 @@ func (in *EVMInterpreter) Run(contract *Contract, input []byte, readOnly bool) (
 		if debug {
 			logged, pcCopy, gasCopy = false, pc, contract.Gas
 		}
-
-		op = contract.GetOp(pc)
-		operation := in.table[op]
+		op = contract.opAt(pc)
+		operation := table[op]
 		cost = operation.constantGas
 
+		// 在每条指令执行前检查是否被中断或超出指令数限制,
+		// 先取出操作码以便追踪器记录被中止的指令
+		if in.evm.interrupt.Load() {
+			return nil, ErrExecutionAborted
+		}
+		if in.evm.Config.MaxSteps != 0 {
+			if in.evm.steps >= in.evm.Config.MaxSteps {
+				return nil, ErrStepLimitReached
+			}
+			in.evm.steps++
+		}
+
 		if sLen := stack.len(); sLen < operation.minStack {
 			return nil, &ErrStackUnderflow{
 				stackLen: sLen,
@@ -17,6 +17,7 @@
 package evm
 
 import (
+	"context"
 	"math/big"
 	"testing"
 	"time"
@@ -217,3 +218,79 @@ func TestLoopInterrupt(t *testing.T) {
 		}
 	}
 }
+
+func TestCallContextDeadline(t *testing.T) {
+	address := BytesToAddr([]byte("contract"))
+	vmctx := BlockContext{
+		CanTransfer: CanTransfer,
+		Transfer:    Transfer,
+	}
+	for i, tt := range loopInterruptTests {
+		statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		statedbImpl := &StateDBImpl{db: statedb}
+		statedbImpl.CreateAccount(address)
+		statedbImpl.SetCode(address, Hex2Bytes(tt))
+		statedb.Finalise(true)
+
+		evm := NewEVM(vmctx, TxContext{}, statedbImpl, allEthashProtocolChanges, Config{})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, gas, err := evm.CallContext(ctx, AccountRef(NilAddr), address, nil, math.MaxUint64, new(big.Int))
+		cancel()
+		if err != ErrExecutionAborted {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, ErrExecutionAborted)
+		}
+		if gas != 0 {
+			t.Errorf("test %d: aborted call should consume all gas, left %d", i, gas)
+		}
+		// The interrupt must not leak into subsequent executions.
+		if _, _, err := evm.Call(AccountRef(NilAddr), NilAddr, nil, 100000, new(big.Int)); err != nil {
+			t.Errorf("test %d: follow-up call failed: %v", i, err)
+		}
+	}
+}
+
+func TestCallContextCancelled(t *testing.T) {
+	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	evm := NewEVM(BlockContext{CanTransfer: CanTransfer, Transfer: Transfer}, TxContext{}, &StateDBImpl{db: statedb}, allEthashProtocolChanges, Config{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := evm.CallContext(ctx, AccountRef(NilAddr), NilAddr, nil, 100000, new(big.Int)); err != ErrExecutionAborted {
+		t.Errorf("call error mismatch: have %v, want %v", err, ErrExecutionAborted)
+	}
+	if _, _, _, err := evm.CreateContext(ctx, AccountRef(NilAddr), nil, 100000, new(big.Int)); err != ErrExecutionAborted {
+		t.Errorf("create error mismatch: have %v, want %v", err, ErrExecutionAborted)
+	}
+}
+
+func TestMaxSteps(t *testing.T) {
+	address := BytesToAddr([]byte("contract"))
+	tests := []struct {
+		code     string
+		maxSteps uint64
+		want     error
+	}{
+		// push(2) jumpdest dup1 jump, looping forever
+		{"60025b8056", 1000, ErrStepLimitReached},
+		// push(1) push(1) add stop, four instructions
+		{"600160010100", 4, nil},
+		{"600160010100", 3, ErrStepLimitReached},
+	}
+	for i, tt := range tests {
+		statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+		statedbImpl := &StateDBImpl{db: statedb}
+		statedbImpl.CreateAccount(address)
+		statedbImpl.SetCode(address, Hex2Bytes(tt.code))
+		statedb.Finalise(true)
+
+		vmctx := BlockContext{CanTransfer: CanTransfer, Transfer: Transfer}
+		evm := NewEVM(vmctx, TxContext{}, statedbImpl, allEthashProtocolChanges, Config{MaxSteps: tt.maxSteps})
+		// Run twice to make sure the budget is per top-level call.
+		for j := 0; j < 2; j++ {
+			if _, _, err := evm.Call(AccountRef(NilAddr), address, nil, math.MaxUint64, new(big.Int)); err != tt.want {
+				t.Errorf("test %d.%d: error mismatch: have %v, want %v", i, j, err, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm_test
+
+import (
+	"context"
+	"math"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/logger"
+	"github.com/lyonnee/evm/memstate"
+	"github.com/lyonnee/evm/params"
+)
+
+var (
+	outerContract = evm.BytesToAddr([]byte("outer"))
+	innerContract = evm.BytesToAddr([]byte("inner"))
+)
+
+// callCode returns code calling addr with all the available gas, storing the
+// success flag in slot 0 and stopping. It executes 8 instructions up to the
+// CALL included, and 3 after it.
+func callCode(addr evm.Address) []byte {
+	// PUSH1 0 (x5) PUSH<addr> addr GAS CALL PUSH1 0 SSTORE STOP
+	code := []byte{0x60, 0x00, 0x60, 0x00, 0x60, 0x00, 0x60, 0x00, 0x60, 0x00}
+	code = append(code, byte(evm.PUSH1)+byte(evm.AddressLength)-1)
+	code = append(code, addr.Bytes()...)
+	return append(code, byte(evm.GAS), byte(evm.CALL), 0x60, 0x00, byte(evm.SSTORE), byte(evm.STOP))
+}
+
+// newInterruptEVM returns an EVM over a memstate holding the outer contract,
+// calling the inner one, and the given inner code.
+func newInterruptEVM(inner []byte, config evm.Config) (*evm.EVM, *memstate.StateDB) {
+	statedb := memstate.New()
+	statedb.SetCode(outerContract, callCode(innerContract))
+	statedb.SetCode(innerContract, inner)
+	statedb.Finalise(true)
+
+	blockCtx := evm.BlockContext{
+		CanTransfer: evm.CanTransfer,
+		Transfer:    evm.Transfer,
+		BlockNumber: big.NewInt(1),
+		Difficulty:  new(big.Int),
+	}
+	chainConfig, _ := params.ForkConfig("Istanbul")
+	return evm.NewEVM(blockCtx, evm.TxContext{}, statedb, chainConfig, config), statedb
+}
+
+func TestMaxStepsStraightLine(t *testing.T) {
+	// PUSH1 1 (x9) STOP, ten instructions
+	var code []byte
+	for i := 0; i < 9; i++ {
+		code = append(code, 0x60, 0x01)
+	}
+	code = append(code, byte(evm.STOP))
+
+	for n := uint64(1); n <= 10; n++ {
+		vm, _ := newInterruptEVM(code, evm.Config{MaxSteps: n})
+
+		_, gas, err := vm.Call(evm.AccountRef(evm.NilAddr), innerContract, nil, 100000, new(big.Int))
+		switch {
+		case n < 10 && err != evm.ErrStepLimitReached:
+			t.Errorf("limit %d: error mismatch: have %v, want %v", n, err, evm.ErrStepLimitReached)
+		case n < 10 && gas != 0:
+			t.Errorf("limit %d: interrupted call should consume all gas, left %d", n, gas)
+		case n == 10 && err != nil:
+			t.Errorf("limit %d: execution failed: %v", n, err)
+		case n == 10 && gas != 100000-9*3:
+			t.Errorf("limit %d: gas left mismatch: have %d, want %d", n, gas, 100000-9*3)
+		}
+	}
+}
+
+func TestMaxStepsNested(t *testing.T) {
+	// PUSH1 1 PUSH1 1 SSTORE STOP, four instructions run from the outer
+	// contract's CALL, making fifteen in total
+	inner := evm.Hex2Bytes("6001600155" + "00")
+
+	tests := []struct {
+		maxSteps uint64
+		want     error
+	}{
+		{15, nil},
+		// Interrupted at the STOP of the outer contract
+		{14, evm.ErrStepLimitReached},
+		// Interrupted at the SSTORE of the inner contract, the error of the
+		// inner call interrupts the outer contract too
+		{10, evm.ErrStepLimitReached},
+		// Interrupted at the first instruction of the inner contract
+		{8, evm.ErrStepLimitReached},
+	}
+	for _, tt := range tests {
+		vm, statedb := newInterruptEVM(inner, evm.Config{MaxSteps: tt.maxSteps})
+
+		_, gas, err := vm.Call(evm.AccountRef(evm.NilAddr), outerContract, nil, 1000000, new(big.Int))
+		if err != tt.want {
+			t.Errorf("limit %d: error mismatch: have %v, want %v", tt.maxSteps, err, tt.want)
+			continue
+		}
+		if err == nil {
+			if have := statedb.GetState(outerContract, evm.Hash{}); have != evm.BytesToHash([]byte{1}) {
+				t.Errorf("limit %d: inner call reported as failed", tt.maxSteps)
+			}
+			if have := statedb.GetState(innerContract, evm.BytesToHash([]byte{1})); have != evm.BytesToHash([]byte{1}) {
+				t.Errorf("limit %d: inner call didn't complete", tt.maxSteps)
+			}
+			continue
+		}
+		if gas != 0 {
+			t.Errorf("limit %d: interrupted call should consume all gas, left %d", tt.maxSteps, gas)
+		}
+		if have := statedb.GetState(innerContract, evm.BytesToHash([]byte{1})); have != (evm.Hash{}) {
+			t.Errorf("limit %d: inner storage written: %x", tt.maxSteps, have)
+		}
+	}
+}
+
+func TestMaxStepsTrace(t *testing.T) {
+	// PUSH1 1 PUSH1 1 ADD STOP, interrupted at the ADD
+	tracer := logger.NewStructLogger(nil)
+	vm, _ := newInterruptEVM(evm.Hex2Bytes("6001600101"+"00"), evm.Config{MaxSteps: 2, Tracer: tracer})
+
+	if _, _, err := vm.Call(evm.AccountRef(evm.NilAddr), innerContract, nil, 100000, new(big.Int)); err != evm.ErrStepLimitReached {
+		t.Fatalf("error mismatch: have %v, want %v", err, evm.ErrStepLimitReached)
+	}
+	logs := tracer.StructLogs()
+	if len(logs) != 3 {
+		t.Fatalf("step count mismatch: have %d, want 3", len(logs))
+	}
+	if last := logs[2]; last.Pc != 4 || last.Op != evm.ADD || last.Err != evm.ErrStepLimitReached {
+		t.Errorf("interrupted step mismatch: pc %d, op %v, err %v", last.Pc, last.Op, last.Err)
+	}
+}
+
+func TestAbortNested(t *testing.T) {
+	// JUMPDEST PUSH1 0 JUMP, looping in the inner contract until aborted
+	vm, _ := newInterruptEVM(evm.Hex2Bytes("5b600056"), evm.Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, gas, err := vm.CallContext(ctx, evm.AccountRef(evm.NilAddr), outerContract, nil, math.MaxUint64, new(big.Int))
+	if err != evm.ErrExecutionAborted {
+		t.Errorf("error mismatch: have %v, want %v", err, evm.ErrExecutionAborted)
+	}
+	if gas != 0 {
+		t.Errorf("aborted call should consume all gas, left %d", gas)
+	}
+}
@@ -16,32 +16,31 @@
 
 package evm
 
-import (
-	"fmt"
-
-	"github.com/lyonnee/evm/params"
-)
+import "github.com/lyonnee/evm/params"
 
 type (
-	executionFunc func(pc *uint64, interpreter *EVMInterpreter, callContext *ScopeContext) ([]byte, error)
-	gasFunc       func(*EVM, *Contract, *Stack, *Memory, uint64) (uint64, error) // last parameter is the requested memory size as a uint64
-	// memorySizeFunc returns the required size, and whether the operation overflowed a uint64
-	memorySizeFunc func(*Stack) (size uint64, overflow bool)
+	// ExecutionFunc executes an instruction, pc points at the instruction being executed
+	ExecutionFunc func(pc *uint64, interpreter *EVMInterpreter, callContext *ScopeContext) ([]byte, error)
+	GasFunc       func(*EVM, *Contract, *Stack, *Memory, uint64) (uint64, error) // last parameter is the requested memory size as a uint64
+	// MemorySizeFunc returns the required size, and whether the operation overflowed a uint64
+	MemorySizeFunc func(*Stack) (size uint64, overflow bool)
 )
 
 type operation struct {
 	// execute is the operation function
-	execute     executionFunc
+	execute     ExecutionFunc
 	constantGas uint64
-	dynamicGas  gasFunc
+	dynamicGas  GasFunc
 	// minStack tells how many stack items are required
 	minStack int
 	// maxStack specifies the max length the stack can have for this operation
 	// to not overflow the stack.
 	maxStack int
+	// undefined is set for the opcodes the instruction set does not define
+	undefined bool
 
 	// memorySize returns the memory size required for the operation
-	memorySize memorySizeFunc
+	memorySize MemorySizeFunc
 }
 
 var (
@@ -57,24 +56,16 @@ var (
 	mergeInstructionSet            = newMergeInstructionSet()
 	shanghaiInstructionSet         = newShanghaiInstructionSet()
 	cancunInstructionSet           = newCancunInstructionSet()
+	pragueInstructionSet           = newPragueInstructionSet()
+	osakaInstructionSet            = newOsakaInstructionSet()
+	eofInstructionSet              = newEOFInstructionSet(osakaInstructionSet)
 )
 
 type JumpTable [256]*operation
 
 func validate(jt JumpTable) JumpTable {
-	for i, op := range jt {
-		if op == nil {
-			panic(fmt.Sprintf("op %#x is not set", i))
-		}
-		// The interpreter has an assumption that if the memorySize function is
-		// set, then the dynamicGas function is also set. This is a somewhat
-		// arbitrary assumption, and can be removed if we need to -- but it
-		// allows us to avoid a condition check. As long as we have that assumption
-		// in there, this little sanity check prevents us from merging in a
-		// change which violates it.
-		if op.memorySize != nil && op.dynamicGas == nil {
-			panic(fmt.Sprintf("op %v has dynamic memory but not dynamic gas", OpCode(i).String()))
-		}
+	if err := jt.Validate(); err != nil {
+		panic(err)
 	}
 	return jt
 }
@@ -90,6 +81,47 @@ func copyJumpTable(source *JumpTable) *JumpTable {
 	return &dest
 }
 
+// newOsakaInstructionSet returns the instructions of legacy code in Osaka, those
+// of Prague with the code of EOF accounts hidden from EXTCODE*. The EOF
+// instructions are in newEOFInstructionSet.
+func newOsakaInstructionSet() JumpTable {
+	instructionSet := newPragueInstructionSet()
+	enable3540(&instructionSet) // EOF accounts seen by legacy code
+	return validate(instructionSet)
+}
+
+// newEOFInstructionSet returns the instructions of EOF code derived from the
+// legacy instruction set base. The instructions inspecting or jumping within
+// the code, inspecting gas or other accounts' code and SELFDESTRUCT are
+// removed, the EOF instructions are added.
+//
+// EXTCALL, EXTDELEGATECALL, EXTSTATICCALL (EIP-7069) and EOFCREATE (EIP-7620)
+// aren't implemented, so EOF code deviates from the EOF rules by keeping
+// CALL, DELEGATECALL and STATICCALL to call other contracts, and CREATE and
+// CREATE2 to deploy them.
+func newEOFInstructionSet(base JumpTable) JumpTable {
+	instructionSet := *copyJumpTable(&base)
+	for _, op := range []OpCode{
+		CALLCODE, SELFDESTRUCT, JUMP, JUMPI, PC, CODESIZE, CODECOPY,
+		GAS, EXTCODESIZE, EXTCODECOPY, EXTCODEHASH,
+	} {
+		instructionSet.Disable(op)
+	}
+	enable4200(&instructionSet) // Static relative jumps
+	enable4750(&instructionSet) // Functions
+	enable663(&instructionSet)  // DUPN, SWAPN and EXCHANGE
+	enable7480(&instructionSet) // Data section access
+	return validate(instructionSet)
+}
+
+// newPragueInstructionSet returns the instructions of Prague. The fork brings
+// no new opcodes, the calls are charged for following EIP-7702 delegations.
+func newPragueInstructionSet() JumpTable {
+	instructionSet := newCancunInstructionSet()
+	enable7702(&instructionSet) // EIP-7702 Set code transactions
+	return validate(instructionSet)
+}
+
 func newCancunInstructionSet() JumpTable {
 	instructionSet := newShanghaiInstructionSet()
 	enable4844(&instructionSet) // EIP-4844 (DATAHASH opcode)
@@ -1066,7 +1098,7 @@ func newFrontierInstructionSet() JumpTable {
 	// Fill all unassigned slots with opUndefined.
 	for i, entry := range tbl {
 		if entry == nil {
-			tbl[i] = &operation{execute: opUndefined, maxStack: maxStack(0, 0)}
+			tbl[i] = &operation{execute: opUndefined, maxStack: maxStack(0, 0), undefined: true}
 		}
 	}
 
@@ -18,6 +18,7 @@ package evm
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/lyonnee/evm/params"
 )
@@ -27,9 +28,11 @@ import (
 func LookupInstructionSet(rules params.Rules) (JumpTable, error) {
 	switch {
 	case rules.IsVerkle:
-		return newCancunInstructionSet(), errors.New("verkle-fork not defined yet")
+		return newOsakaInstructionSet(), errors.New("verkle-fork not defined yet")
+	case rules.IsOsaka:
+		return newOsakaInstructionSet(), nil
 	case rules.IsPrague:
-		return newCancunInstructionSet(), errors.New("prague-fork not defined yet")
+		return newPragueInstructionSet(), nil
 	case rules.IsCancun:
 		return newCancunInstructionSet(), nil
 	case rules.IsShanghai:
@@ -56,6 +59,15 @@ func LookupInstructionSet(rules params.Rules) (JumpTable, error) {
 	return newFrontierInstructionSet(), nil
 }
 
+// LookupEOFInstructionSet returns the instruction set of EOF code for the fork
+// configured by the rules. EOF is activated by Osaka.
+func LookupEOFInstructionSet(rules params.Rules) (JumpTable, error) {
+	if !rules.IsOsaka {
+		return JumpTable{}, errors.New("eof not activated")
+	}
+	return newEOFInstructionSet(newOsakaInstructionSet()), nil
+}
+
 // Stack returns the mininum and maximum stack requirements.
 func (op *operation) Stack() (int, int) {
 	return op.minStack, op.maxStack
@@ -74,3 +86,86 @@ func (op *operation) HasCost() bool {
 	// filter out
 	return op.dynamicGas != nil || op.constantGas != 0
 }
+
+// Operation describes an instruction to be installed into a JumpTable.
+type Operation struct {
+	// Execute is the operation function, it is mandatory.
+	Execute ExecutionFunc
+	// ConstantGas is charged before the instruction is executed.
+	ConstantGas uint64
+	// DynamicGas computes the gas charged on top of ConstantGas, it is
+	// mandatory when MemorySize is set.
+	DynamicGas GasFunc
+	// MinStack and MaxStack are the stack bounds the instruction can be
+	// executed within, see StackBounds.
+	MinStack int
+	MaxStack int
+	// MemorySize returns the memory size required by the instruction, the
+	// memory is expanded before Execute is called.
+	MemorySize MemorySizeFunc
+}
+
+// StackBounds returns the MinStack and MaxStack values for an instruction
+// popping pops items from the stack and pushing pushes items onto it.
+func StackBounds(pops, pushes int) (int, int) {
+	return minStack(pops, pushes), maxStack(pops, pushes)
+}
+
+// Register installs op as the instruction for the given opcode, replacing the
+// current one. The table is left unchanged if the result is invalid. The
+// name of a new opcode is registered with RegisterOpCode.
+func (jt *JumpTable) Register(code OpCode, op Operation) error {
+	if op.Execute == nil {
+		return fmt.Errorf("op %v has no execute function", code)
+	}
+	// Validate a copy, leaving the table untouched if the result is invalid
+	updated := *jt
+	updated[code] = &operation{
+		execute:     op.Execute,
+		constantGas: op.ConstantGas,
+		dynamicGas:  op.DynamicGas,
+		minStack:    op.MinStack,
+		maxStack:    op.MaxStack,
+		memorySize:  op.MemorySize,
+	}
+	if err := updated.Validate(); err != nil {
+		return err
+	}
+	jt[code] = updated[code]
+	return nil
+}
+
+// Disable turns the given opcode into an undefined instruction.
+func (jt *JumpTable) Disable(code OpCode) {
+	jt[code] = &operation{execute: opUndefined, maxStack: maxStack(0, 0), undefined: true}
+}
+
+// Copy returns a deep copy of the jump table.
+func (jt *JumpTable) Copy() *JumpTable {
+	return copyJumpTable(jt)
+}
+
+// Validate checks that every opcode of the table is set and consistent.
+func (jt *JumpTable) Validate() error {
+	for i, op := range jt {
+		if op == nil {
+			return fmt.Errorf("op %#x is not set", i)
+		}
+		if op.execute == nil {
+			return fmt.Errorf("op %v has no execute function", OpCode(i))
+		}
+		// The interpreter has an assumption that if the memorySize function is
+		// set, then the dynamicGas function is also set. This is a somewhat
+		// arbitrary assumption, and can be removed if we need to -- but it
+		// allows us to avoid a condition check. As long as we have that assumption
+		// in there, this little sanity check prevents us from merging in a
+		// change which violates it.
+		if op.memorySize != nil && op.dynamicGas == nil {
+			return fmt.Errorf("op %v has dynamic memory but not dynamic gas", OpCode(i).String())
+		}
+		if op.minStack < 0 || op.minStack > op.maxStack {
+			return fmt.Errorf("op %v has invalid stack bounds (%d, %d)", OpCode(i), op.minStack, op.maxStack)
+		}
+	}
+	return nil
+}
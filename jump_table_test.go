@@ -17,8 +17,16 @@
 package evm
 
 import (
+	"fmt"
+	"math/big"
+	"sync"
 	"testing"
 
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/holiman/uint256"
+	"github.com/lyonnee/evm/params"
 	"github.com/stretchr/testify/require"
 )
 
@@ -33,3 +41,171 @@ func TestJumpTableCopy(t *testing.T) {
 	require.Equal(t, uint64(100), deepCopy[SLOAD].constantGas)
 	require.Equal(t, uint64(0), tbl[SLOAD].constantGas)
 }
+
+func TestCustomInstructionSet(t *testing.T) {
+	const L1BLOCKNUMBER OpCode = 0xc0
+	// The opcode names are global, unregister it for the next runs
+	t.Cleanup(func() { customOpCodes.remove(L1BLOCKNUMBER) })
+	require.NoError(t, RegisterOpCode(L1BLOCKNUMBER, "L1BLOCKNUMBER"))
+	require.Equal(t, "L1BLOCKNUMBER", L1BLOCKNUMBER.String())
+	require.Equal(t, L1BLOCKNUMBER, StringToOp("L1BLOCKNUMBER"))
+	require.Error(t, RegisterOpCode(ADD, "PLUS"))
+	require.Error(t, RegisterOpCode(0xc1, "ADD"))
+	require.Error(t, RegisterOpCode(L1BLOCKNUMBER, "L1BLOCK"))
+	require.Error(t, RegisterOpCode(0xc1, "L1BLOCKNUMBER"))
+
+	rules := allEthashProtocolChanges.Rules(new(big.Int), false, 0)
+	jt, err := LookupInstructionSet(rules)
+	require.NoError(t, err)
+
+	minStack, maxStack := StackBounds(0, 1)
+	require.NoError(t, jt.Register(L1BLOCKNUMBER, Operation{
+		Execute: func(pc *uint64, interpreter *EVMInterpreter, scope *ScopeContext) ([]byte, error) {
+			scope.Stack.Push(uint256.NewInt(interpreter.EVM().Context.BlockNumber.Uint64() + 1000))
+			return nil, nil
+		},
+		ConstantGas: GasQuickStep,
+		MinStack:    minStack,
+		MaxStack:    maxStack,
+	}))
+	jt.Disable(ADD)
+
+	// Invalid definitions are rejected, leaving the table untouched.
+	undefined := jt[0xc1]
+	require.Error(t, jt.Register(0xc1, Operation{}))
+	require.Error(t, jt.Register(0xc1, Operation{
+		Execute:    opStop,
+		MemorySize: memoryReturn,
+	}))
+	require.Same(t, undefined, jt[0xc1])
+	require.NoError(t, jt.Validate())
+
+	address := BytesToAddr([]byte("contract"))
+	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	statedbImpl := &StateDBImpl{db: statedb}
+	// L1BLOCKNUMBER PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+	statedbImpl.SetCode(address, Hex2Bytes("c060005260206000f3"))
+	// PUSH1 1 PUSH1 1 ADD
+	adder := BytesToAddr([]byte("adder"))
+	statedbImpl.SetCode(adder, Hex2Bytes("6001600101"))
+
+	vmctx := BlockContext{CanTransfer: CanTransfer, Transfer: Transfer, BlockNumber: big.NewInt(7)}
+	evm := NewEVM(vmctx, TxContext{}, statedbImpl, allEthashProtocolChanges, Config{JumpTable: &jt})
+
+	ret, gas, err := evm.Call(AccountRef(NilAddr), address, nil, 100000, new(big.Int))
+	require.NoError(t, err)
+	require.Equal(t, uint64(1007), new(uint256.Int).SetBytes(ret).Uint64())
+	// L1BLOCKNUMBER, three pushes, MSTORE with one word of memory expansion
+	require.Equal(t, uint64(100000-GasQuickStep-3*GasFastestStep-GasFastestStep-3), gas)
+
+	_, _, err = evm.Call(AccountRef(NilAddr), adder, nil, 100000, new(big.Int))
+	require.Equal(t, &ErrInvalidOpCode{opcode: ADD}, err)
+
+	// The fork's shared table is left untouched.
+	require.Equal(t, ADD.String(), "ADD")
+	fresh := NewEVM(vmctx, TxContext{}, statedbImpl, allEthashProtocolChanges, Config{})
+	_, _, err = fresh.Call(AccountRef(NilAddr), adder, nil, 100000, new(big.Int))
+	require.NoError(t, err)
+}
+
+func TestInvalidJumpTable(t *testing.T) {
+	vmctx := BlockContext{CanTransfer: CanTransfer, Transfer: Transfer, BlockNumber: big.NewInt(7)}
+	// A table missing instructions, such as one built from scratch
+	var jt JumpTable
+	require.Error(t, jt.Register(0xc0, Operation{Execute: opStop}))
+	config := Config{JumpTable: &jt}
+	require.Error(t, config.Validate())
+
+	_, err := NewValidatedEVM(vmctx, TxContext{}, nil, allEthashProtocolChanges, config)
+	require.Error(t, err)
+
+	rules := allEthashProtocolChanges.Rules(new(big.Int), false, 0)
+	jt, err = LookupInstructionSet(rules)
+	require.NoError(t, err)
+	evm, err := NewValidatedEVM(vmctx, TxContext{}, nil, allEthashProtocolChanges, config)
+	require.NoError(t, err)
+	require.NotNil(t, evm)
+}
+
+func TestRegisterOpCodeConcurrent(t *testing.T) {
+	ops := []OpCode{0xc2, 0xc3, 0xc4, 0xc5}
+	t.Cleanup(func() {
+		for _, op := range ops {
+			customOpCodes.remove(op)
+		}
+	})
+	var (
+		wg   sync.WaitGroup
+		errs = make(chan error, len(ops))
+	)
+	for _, op := range ops {
+		wg.Add(2)
+		go func(op OpCode) {
+			defer wg.Done()
+			errs <- RegisterOpCode(op, fmt.Sprintf("CUSTOM%X", int(op)))
+		}(op)
+		go func(op OpCode) {
+			defer wg.Done()
+			_ = op.String()
+			_ = StringToOp(fmt.Sprintf("CUSTOM%X", int(op)))
+		}(op)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	for _, op := range ops {
+		require.Equal(t, fmt.Sprintf("CUSTOM%X", int(op)), op.String())
+		require.Equal(t, op, StringToOp(op.String()))
+	}
+}
+
+func TestLookupInstructionSetPrague(t *testing.T) {
+	config, err := params.ForkConfig("Prague")
+	require.NoError(t, err)
+	jt, err := LookupInstructionSet(config.Rules(new(big.Int), true, 0))
+	require.NoError(t, err)
+	require.NoError(t, jt.Validate())
+	require.Equal(t, newCancunInstructionSet()[MCOPY].constantGas, jt[MCOPY].constantGas)
+}
+
+func TestLookupEOFInstructionSet(t *testing.T) {
+	prague, err := params.ForkConfig("Prague")
+	require.NoError(t, err)
+	_, err = LookupEOFInstructionSet(prague.Rules(new(big.Int), true, 0))
+	require.Error(t, err)
+
+	osaka, err := params.ForkConfig("Osaka")
+	require.NoError(t, err)
+	jt, err := LookupEOFInstructionSet(osaka.Rules(new(big.Int), true, 0))
+	require.NoError(t, err)
+	removed := []OpCode{
+		CALLCODE, SELFDESTRUCT, JUMP, JUMPI, PC, CODESIZE, CODECOPY,
+		GAS, EXTCODESIZE, EXTCODECOPY, EXTCODEHASH,
+	}
+	added := []OpCode{RJUMP, RJUMPI, RJUMPV, CALLF, RETF, DUPN, SWAPN, EXCHANGE, DATALOAD, DATALOADN, DATASIZE, DATACOPY}
+	// The calls and creations are kept without EXTCALL and EOFCREATE
+	kept := []OpCode{CALL, DELEGATECALL, STATICCALL, CREATE, CREATE2}
+	for _, op := range removed {
+		require.True(t, jt[op].undefined, "%v defined in EOF", op)
+	}
+	for _, op := range append(added, kept...) {
+		require.False(t, jt[op].undefined, "%v undefined in EOF", op)
+	}
+	// Exactly these instructions differ from legacy code
+	changed := make(map[OpCode]bool)
+	for _, op := range append(removed, added...) {
+		changed[op] = true
+	}
+	for i := range jt {
+		if op := OpCode(i); !changed[op] {
+			require.Equal(t, osakaInstructionSet[op].undefined, jt[op].undefined, "%v", op)
+		}
+	}
+	// Legacy code keeps the instructions of Prague
+	legacy, err := LookupInstructionSet(osaka.Rules(new(big.Int), true, 0))
+	require.NoError(t, err)
+	require.False(t, legacy[JUMP].undefined)
+	require.True(t, legacy[RJUMP].undefined)
+}
@@ -26,7 +26,7 @@ type Log struct {
 	Data []byte `json:"data"`
 
 	// Derived fields. These fields are filled in by the node
-	// but not secured by consensus.
+	// but not secured by consensus, see ReceiptBuilder.
 	// block in which the transaction was included
 	BlockNumber uint64 `json:"blockNumber"`
 	// hash of the transaction
@@ -0,0 +1,257 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/lyonnee/evm"
+)
+
+// accessList is an accumulator for the set of accounts and storage slots an
+// EVM contract execution touches.
+type accessList map[evm.Address]accessListSlots
+
+// accessListSlots is an accumulator for the set of storage slots within a
+// single contract that an EVM contract execution touches.
+type accessListSlots map[evm.Hash]struct{}
+
+// newAccessList creates a new accessList.
+func newAccessList() accessList {
+	return make(map[evm.Address]accessListSlots)
+}
+
+// addAddress adds an address to the accesslist.
+func (al accessList) addAddress(address evm.Address) {
+	// Set address if not previously present
+	if _, present := al[address]; !present {
+		al[address] = make(map[evm.Hash]struct{})
+	}
+}
+
+// addSlot adds a storage slot to the accesslist.
+func (al accessList) addSlot(address evm.Address, slot evm.Hash) {
+	// Set address if not previously present
+	al.addAddress(address)
+
+	// Set the slot on the surely existent storage set
+	al[address][slot] = struct{}{}
+}
+
+// equal checks if the content of the current access list is the same as the
+// content of the other one.
+func (al accessList) equal(other accessList) bool {
+	if len(al) != len(other) {
+		return false
+	}
+	for addr, slots := range al {
+		otherSlots, ok := other[addr]
+		if !ok || len(slots) != len(otherSlots) {
+			return false
+		}
+		for slot := range slots {
+			if _, ok := otherSlots[slot]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// accessList converts the accesslist to an evm.AccessList, sorted by address
+// and storage slot.
+func (al accessList) accessList() evm.AccessList {
+	acl := make(evm.AccessList, 0, len(al))
+	for addr, slots := range al {
+		tuple := evm.AccessTuple{Address: addr, StorageKeys: []evm.Hash{}}
+		for slot := range slots {
+			tuple.StorageKeys = append(tuple.StorageKeys, slot)
+		}
+		sort.Slice(tuple.StorageKeys, func(i, j int) bool {
+			return bytes.Compare(tuple.StorageKeys[i][:], tuple.StorageKeys[j][:]) < 0
+		})
+		acl = append(acl, tuple)
+	}
+	sort.Slice(acl, func(i, j int) bool {
+		return bytes.Compare(acl[i].Address[:], acl[j].Address[:]) < 0
+	})
+	return acl
+}
+
+// AccessListTracer is a tracer that accumulates touched accounts and storage
+// slots into an internal set.
+type AccessListTracer struct {
+	excl map[evm.Address]struct{} // Set of account to exclude from the list
+	list accessList               // Set of accounts and storage slots touched
+}
+
+var _ evm.EVMLogger = (*AccessListTracer)(nil)
+
+// NewAccessListTracer creates a new tracer that can generate AccessLists.
+// An optional AccessList can be specified to occupy slots and addresses in
+// the resulting accesslist. The sender, the recipient and the precompiles,
+// which are warm anyway, are left out of the list.
+func NewAccessListTracer(acl evm.AccessList, from, to evm.Address, precompiles []evm.Address) *AccessListTracer {
+	excl := map[evm.Address]struct{}{
+		from: {}, to: {},
+	}
+	for _, addr := range precompiles {
+		excl[addr] = struct{}{}
+	}
+	list := newAccessList()
+	for _, al := range acl {
+		if _, ok := excl[al.Address]; !ok {
+			list.addAddress(al.Address)
+		}
+		for _, slot := range al.StorageKeys {
+			list.addSlot(al.Address, slot)
+		}
+	}
+	return &AccessListTracer{
+		excl: excl,
+		list: list,
+	}
+}
+
+func (a *AccessListTracer) CaptureTxStart(gasLimit uint64) {}
+
+func (a *AccessListTracer) CaptureTxEnd(restGas uint64) {}
+
+func (a *AccessListTracer) CaptureStart(env *evm.EVM, from evm.Address, to evm.Address, create bool, input []byte, gas uint64, value *big.Int) {
+}
+
+func (a *AccessListTracer) CaptureEnd(output []byte, gasUsed uint64, err error) {}
+
+func (a *AccessListTracer) CaptureEnter(typ evm.OpCode, from evm.Address, to evm.Address, input []byte, gas uint64, value *big.Int) {
+}
+
+func (a *AccessListTracer) CaptureExit(output []byte, gasUsed uint64, err error) {}
+
+// CaptureState captures all opcodes that touch storage or addresses and adds
+// them to the accesslist.
+func (a *AccessListTracer) CaptureState(pc uint64, op evm.OpCode, gas, cost uint64, scope *evm.ScopeContext, rData []byte, depth int, err error) {
+	stackData := scope.Stack.Data()
+	stackLen := len(stackData)
+	if (op == evm.SLOAD || op == evm.SSTORE) && stackLen >= 1 {
+		slot := evm.Hash(stackData[stackLen-1].Bytes32())
+		a.list.addSlot(scope.Contract.Address(), slot)
+	}
+	if (op == evm.EXTCODECOPY || op == evm.EXTCODEHASH || op == evm.EXTCODESIZE || op == evm.BALANCE || op == evm.SELFDESTRUCT) && stackLen >= 1 {
+		addr := evm.BytesToAddr(stackData[stackLen-1].Bytes())
+		if _, ok := a.excl[addr]; !ok {
+			a.list.addAddress(addr)
+		}
+	}
+	if (op == evm.DELEGATECALL || op == evm.CALL || op == evm.STATICCALL || op == evm.CALLCODE) && stackLen >= 5 {
+		addr := evm.BytesToAddr(stackData[stackLen-2].Bytes())
+		if _, ok := a.excl[addr]; !ok {
+			a.list.addAddress(addr)
+		}
+	}
+}
+
+func (a *AccessListTracer) CaptureFault(pc uint64, op evm.OpCode, gas, cost uint64, scope *evm.ScopeContext, depth int, err error) {
+}
+
+// AccessList returns the current accesslist maintained by the tracer.
+func (a *AccessListTracer) AccessList() evm.AccessList {
+	return a.list.accessList()
+}
+
+// Equal returns if the content of two access list traces are equal.
+func (a *AccessListTracer) Equal(other *AccessListTracer) bool {
+	return a.list.equal(other.list)
+}
+
+// AccessListResult is the outcome of CreateAccessList.
+type AccessListResult struct {
+	AccessList evm.AccessList `json:"accessList"`
+	// GasUsed is the gas used by the message with the access list.
+	GasUsed uint64 `json:"gasUsed"`
+	// GasUsedWithout is the gas used by the message without an access list.
+	GasUsedWithout uint64 `json:"gasUsedWithout"`
+	// Err is the execution error of the message with the access list.
+	Err error `json:"-"`
+}
+
+// CreateAccessList returns the access list of the accounts and storage slots
+// the message touches, as eth_createAccessList does. The message is executed
+// with the access list found by the previous execution until it touches no
+// new entry, as the accesses may depend on the gas available. The access list
+// of the message, if any, is the starting point. Every execution is reverted,
+// so the state is left unchanged.
+func CreateAccessList(newEVM evm.EVMFactory, msg *evm.Message, statedb evm.StateDB) (*AccessListResult, error) {
+	var (
+		env         = newEVM(statedb)
+		precompiles = env.ActivePrecompiles()
+		to          evm.Address
+	)
+	if msg.To != nil {
+		to = *msg.To
+	} else {
+		to = env.AddressDeriver().CreateAddress(msg.From, msg.Nonce)
+	}
+	cpy := *msg
+	if cpy.GasLimit == 0 {
+		cpy.GasLimit = env.Context.GasLimit
+	}
+	if cpy.GasPrice == nil {
+		cpy.GasPrice = new(big.Int)
+	}
+	// Measure the message without access list for comparison
+	cpy.AccessList = nil
+	without, err := applyAccessListMessage(newEVM, &cpy, statedb, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply transaction: %w", err)
+	}
+	prevTracer := NewAccessListTracer(msg.AccessList, msg.From, to, precompiles)
+	for {
+		cpy.AccessList = prevTracer.AccessList()
+		tracer := NewAccessListTracer(cpy.AccessList, msg.From, to, precompiles)
+		result, err := applyAccessListMessage(newEVM, &cpy, statedb, tracer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply transaction: %w", err)
+		}
+		if tracer.Equal(prevTracer) {
+			return &AccessListResult{
+				AccessList:     cpy.AccessList,
+				GasUsed:        result.UsedGas,
+				GasUsedWithout: without.UsedGas,
+				Err:            result.Err,
+			}, nil
+		}
+		prevTracer = tracer
+	}
+}
+
+// applyAccessListMessage applies the message with the given tracer and
+// reverts the state afterwards.
+func applyAccessListMessage(newEVM evm.EVMFactory, msg *evm.Message, statedb evm.StateDB, tracer evm.EVMLogger) (*evm.ExecutionResult, error) {
+	snapshot := statedb.Snapshot()
+	defer statedb.RevertToSnapshot(snapshot)
+
+	env := newEVM(statedb)
+	env.Reset(evm.NewTxContext(msg), statedb)
+	prevTracer := env.Config.Tracer
+	env.Config.Tracer = tracer
+	defer func() { env.Config.Tracer = prevTracer }()
+
+	return evm.ApplyMessage(env, msg, new(evm.GasPool).AddGas(msg.GasLimit))
+}
@@ -0,0 +1,101 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package logger
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/memstate"
+	"github.com/lyonnee/evm/params"
+)
+
+func TestCreateAccessList(t *testing.T) {
+	var (
+		other  = evm.BytesToAddr([]byte("other"))
+		callee = evm.BytesToAddr([]byte("callee"))
+		push   = func(addr evm.Address) []byte {
+			return append([]byte{byte(evm.PUSH1) + byte(evm.AddressLength-1)}, addr.Bytes()...)
+		}
+	)
+	// PUSH1 1 SLOAD POP, PUSHn other BALANCE POP, STATICCALL the ecrecover
+	// precompile and then the callee: PUSH1 0 DUP1 DUP1 DUP1 PUSHn addr GAS
+	// STATICCALL POP
+	code := evm.Hex2Bytes("60015450")
+	code = append(append(code, push(other)...), 0x31, 0x50)
+	code = append(code, evm.Hex2Bytes("600080808060015afa50")...)
+	code = append(append(code, evm.Hex2Bytes("6000808080")...), push(callee)...)
+	code = append(code, 0x5a, 0xfa, 0x50, 0x00)
+
+	config, err := params.ForkConfig("Cancun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb := memstate.New()
+	statedb.SetCode(contract, code)
+	// PUSH1 2 SLOAD STOP
+	statedb.SetCode(callee, evm.Hex2Bytes("60025400"))
+	statedb.Finalise(true)
+	blockCtx := evm.BlockContext{
+		CanTransfer: evm.CanTransfer,
+		Transfer:    evm.Transfer,
+		GasLimit:    30_000_000,
+		BlockNumber: big.NewInt(1),
+		Difficulty:  new(big.Int),
+		BaseFee:     new(big.Int),
+	}
+	newEVM := func(statedb evm.StateDB) *evm.EVM {
+		return evm.NewEVM(blockCtx, evm.TxContext{}, statedb, config, evm.Config{})
+	}
+	msg := &evm.Message{From: caller, To: &contract, GasLimit: 100000}
+	result, err := CreateAccessList(newEVM, msg, statedb)
+	if err != nil {
+		t.Fatalf("access list creation failed: %v", err)
+	}
+	if result.Err != nil {
+		t.Fatalf("execution failed: %v", result.Err)
+	}
+	want := map[evm.Address][]evm.Hash{
+		contract: {evm.BytesToHash([]byte{1})},
+		other:    {},
+		callee:   {evm.BytesToHash([]byte{2})},
+	}
+	have := make(map[evm.Address][]evm.Hash)
+	for _, tuple := range result.AccessList {
+		have[tuple.Address] = tuple.StorageKeys
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("access list mismatch: have %v, want %v", have, want)
+	}
+	// Listing other saves 100 gas and callee with its slot 200, while the
+	// recipient is warm anyway and its tuple costs 2300 more than it saves
+	if result.GasUsed != result.GasUsedWithout+2000 {
+		t.Errorf("gas mismatch: have %d with and %d without", result.GasUsed, result.GasUsedWithout)
+	}
+	if nonce := statedb.GetNonce(caller); nonce != 0 {
+		t.Errorf("state modified: caller nonce %d", nonce)
+	}
+
+	// The list applied to the message is stable
+	tracer := NewAccessListTracer(result.AccessList, caller, contract, newEVM(statedb).ActivePrecompiles())
+	again := NewAccessListTracer(tracer.AccessList(), caller, contract, nil)
+	if !tracer.Equal(again) {
+		t.Error("access list not stable")
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/memstate"
+	"github.com/lyonnee/evm/params"
+)
+
+var (
+	caller   = evm.BytesToAddr([]byte("caller"))
+	contract = evm.BytesToAddr([]byte("contract"))
+)
+
+var testChainConfig = &params.ChainConfig{
+	ChainID:             big.NewInt(1),
+	HomesteadBlock:      big.NewInt(0),
+	EIP150Block:         big.NewInt(0),
+	EIP155Block:         big.NewInt(0),
+	EIP158Block:         big.NewInt(0),
+	ByzantiumBlock:      big.NewInt(0),
+	ConstantinopleBlock: big.NewInt(0),
+	PetersburgBlock:     big.NewInt(0),
+	IstanbulBlock:       big.NewInt(0),
+}
+
+// runTraced executes the given code at the test contract address with the
+// tracer attached.
+func runTraced(t *testing.T, code []byte, tracer evm.EVMLogger) {
+	t.Helper()
+
+	statedb := memstate.New()
+	statedb.SetCode(contract, code)
+	blockCtx := evm.BlockContext{
+		CanTransfer: evm.CanTransfer,
+		Transfer:    evm.Transfer,
+		BlockNumber: big.NewInt(1),
+		Difficulty:  new(big.Int),
+	}
+	vm := evm.NewEVM(blockCtx, evm.TxContext{}, statedb, testChainConfig, evm.Config{Tracer: tracer})
+	if _, _, err := vm.Call(evm.AccountRef(caller), contract, nil, 100000, new(big.Int)); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+}
+
+// PUSH1 0x2a PUSH1 0x01 SSTORE PUSH1 0x01 SLOAD STOP
+var storageCode = evm.Hex2Bytes("602a60015560015400")
+
+func TestStructLogger(t *testing.T) {
+	tracer := NewStructLogger(&Config{EnableMemory: true, EnableReturnData: true})
+	runTraced(t, storageCode, tracer)
+
+	logs := tracer.StructLogs()
+	if len(logs) != 6 {
+		t.Fatalf("log count mismatch: have %d, want 6", len(logs))
+	}
+	wantOps := []evm.OpCode{evm.PUSH1, evm.PUSH1, evm.SSTORE, evm.PUSH1, evm.SLOAD, evm.STOP}
+	for i, log := range logs {
+		if log.Op != wantOps[i] {
+			t.Errorf("log %d: op mismatch: have %v, want %v", i, log.Op, wantOps[i])
+		}
+		if log.Depth != 1 {
+			t.Errorf("log %d: depth mismatch: have %d, want 1", i, log.Depth)
+		}
+	}
+	if logs[1].Gas-logs[2].Gas != logs[1].GasCost {
+		t.Errorf("gas accounting mismatch: %d - %d != %d", logs[1].Gas, logs[2].Gas, logs[1].GasCost)
+	}
+	if have := len(logs[2].Stack); have != 2 {
+		t.Errorf("stack size mismatch: have %d, want 2", have)
+	}
+	slot, value := evm.BytesToHash([]byte{1}), evm.BytesToHash([]byte{0x2a})
+	for _, i := range []int{2, 4} {
+		if len(logs[i].Storage) != 1 || logs[i].Storage[slot] != value {
+			t.Errorf("log %d: storage mismatch: have %x", i, logs[i].Storage)
+		}
+	}
+	if logs[0].Storage != nil {
+		t.Errorf("unexpected storage on non-storage op: %x", logs[0].Storage)
+	}
+}
+
+func TestStructLoggerConfig(t *testing.T) {
+	tracer := NewStructLogger(&Config{DisableStack: true, DisableStorage: true, Limit: 3})
+	runTraced(t, storageCode, tracer)
+
+	logs := tracer.StructLogs()
+	if len(logs) != 3 {
+		t.Fatalf("log count mismatch: have %d, want 3", len(logs))
+	}
+	for i, log := range logs {
+		if log.Stack != nil || log.Storage != nil || log.Memory != nil {
+			t.Errorf("log %d: captured disabled fields", i)
+		}
+	}
+}
+
+func TestStructLoggerFault(t *testing.T) {
+	// PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH<addr> contract GAS STATICCALL STOP
+	static := append([]byte{0x60, 0x00, 0x60, 0x00, 0x60, 0x00, 0x60, 0x00, byte(evm.PUSH1) + byte(evm.AddressLength) - 1}, contract.Bytes()...)
+	static = append(static, byte(evm.GAS), byte(evm.STATICCALL), byte(evm.STOP))
+	outer := evm.BytesToAddr([]byte("outer"))
+
+	for _, tt := range []struct {
+		code   string
+		static bool
+		op     evm.OpCode
+		depth  int
+		err    error
+	}{
+		// PUSH1 0 PUSH1 0 REVERT
+		{code: "60006000fd", op: evm.REVERT, depth: 1, err: evm.ErrExecutionReverted},
+		// PUSH1 5 JUMP
+		{code: "600556", op: evm.JUMP, depth: 1, err: evm.ErrInvalidJump},
+		// PUSH1 1 PUSH1 0 SSTORE STOP, reached through a STATICCALL
+		{code: "600160005500", static: true, op: evm.SSTORE, depth: 2, err: evm.ErrWriteProtection},
+	} {
+		tracer := NewStructLogger(&Config{})
+		statedb := memstate.New()
+		statedb.SetCode(contract, evm.Hex2Bytes(tt.code))
+		statedb.SetCode(outer, static)
+		blockCtx := evm.BlockContext{
+			CanTransfer: evm.CanTransfer,
+			Transfer:    evm.Transfer,
+			BlockNumber: big.NewInt(1),
+			Difficulty:  new(big.Int),
+		}
+		vm := evm.NewEVM(blockCtx, evm.TxContext{}, statedb, testChainConfig, evm.Config{Tracer: tracer})
+		target := contract
+		if tt.static {
+			target = outer
+		}
+		vm.Call(evm.AccountRef(caller), target, nil, 100000, new(big.Int))
+
+		var faults int
+		for _, log := range tracer.StructLogs() {
+			if log.Err == nil {
+				continue
+			}
+			faults++
+			if log.Op != tt.op || log.Depth != tt.depth || log.Err != tt.err {
+				t.Errorf("%s: fault mismatch: have %v at depth %d (%v), want %v at depth %d (%v)",
+					tt.code, log.Op, log.Depth, log.Err, tt.op, tt.depth, tt.err)
+			}
+		}
+		if faults != 1 {
+			t.Errorf("%s: fault count mismatch: have %d, want 1", tt.code, faults)
+		}
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	runTraced(t, storageCode, NewJSONLogger(nil, &buf))
+
+	var lines []map[string]interface{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var line map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("invalid json line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 7 {
+		t.Fatalf("line count mismatch: have %d, want 7", len(lines))
+	}
+	first := lines[0]
+	for _, field := range []string{"pc", "op", "gas", "gasCost", "memSize", "stack", "depth", "refund", "opName"} {
+		if _, ok := first[field]; !ok {
+			t.Errorf("missing field %q", field)
+		}
+	}
+	if first["opName"] != "PUSH1" || first["gas"] != "0x186a0" || first["gasCost"] != "0x3" {
+		t.Errorf("unexpected first step: %v", first)
+	}
+	if stack := lines[2]["stack"].([]interface{}); len(stack) != 2 || stack[1] != "0x1" {
+		t.Errorf("unexpected stack: %v", stack)
+	}
+	if _, ok := lines[6]["gasUsed"]; !ok {
+		t.Errorf("missing summary line: %v", lines[6])
+	}
+}
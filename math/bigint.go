@@ -49,3 +49,11 @@ func BigMax(first, second *big.Int) *big.Int {
 func BigGreaterThan(first, second *big.Int) bool {
 	return first.Cmp(second) > 0
 }
+
+func BigMin(first, second *big.Int) *big.Int {
+	if BigGreaterThan(first, second) {
+		return new(big.Int).Set(second)
+	} else {
+		return new(big.Int).Set(first)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package memstate
+
+import (
+	"math/big"
+
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/trie"
+)
+
+// Storage is a set of storage slots of a single account.
+type Storage map[evm.Hash]evm.Hash
+
+// Copy returns an independent copy of the storage.
+func (s Storage) Copy() Storage {
+	cpy := make(Storage, len(s))
+	for key, value := range s {
+		cpy[key] = value
+	}
+	return cpy
+}
+
+// account is the in-memory representation of a single account.
+//
+// originStorage holds the values as they were at the start of the current
+// transaction (the committed view used by GetCommittedState), dirtyStorage
+// holds the writes performed by the running transaction. The two are merged
+// by StateDB.Finalise. pendingStorage collects the finalised writes not yet
+// applied to the storage trie.
+type account struct {
+	address   evm.Address
+	nonce     uint64
+	balance   *big.Int
+	code      []byte
+	codeHash  evm.Hash
+	dirtyCode bool // true if the code was updated since the last commit
+
+	originStorage  Storage
+	dirtyStorage   Storage
+	pendingStorage Storage
+
+	// trie is the storage trie, created on the first root computation.
+	trie *trie.SecureTrie
+
+	// selfDestructed is set by SELFDESTRUCT. The account stays accessible
+	// until the end of the transaction, at which point it is removed.
+	selfDestructed bool
+	// created is set when the account was created within the current
+	// transaction, needed by the EIP-6780 SELFDESTRUCT semantics.
+	created bool
+}
+
+func newAccount(address evm.Address, emptyCodeHash evm.Hash) *account {
+	return &account{
+		address:        address,
+		balance:        new(big.Int),
+		codeHash:       emptyCodeHash,
+		originStorage:  make(Storage),
+		dirtyStorage:   make(Storage),
+		pendingStorage: make(Storage),
+	}
+}
+
+// empty returns whether the account is considered empty as defined by EIP-161.
+func (a *account) empty() bool {
+	return a.nonce == 0 && a.balance.Sign() == 0 && len(a.code) == 0
+}
+
+func (a *account) getState(key evm.Hash) evm.Hash {
+	if value, dirty := a.dirtyStorage[key]; dirty {
+		return value
+	}
+	return a.originStorage[key]
+}
+
+// finalise moves the dirty storage into the committed view.
+func (a *account) finalise() {
+	for key, value := range a.dirtyStorage {
+		a.pendingStorage[key] = value
+		if value == evm.NilHash {
+			delete(a.originStorage, key)
+		} else {
+			a.originStorage[key] = value
+		}
+	}
+	if len(a.dirtyStorage) > 0 {
+		a.dirtyStorage = make(Storage)
+	}
+	a.created = false
+}
+
+// updateTrie applies the pending storage writes to the storage trie and
+// returns its root.
+func (a *account) updateTrie(db trie.KeyValueStore) (evm.Hash, error) {
+	if a.trie == nil {
+		a.trie = trie.NewEmptySecure(db)
+	}
+	for key, value := range a.pendingStorage {
+		if err := a.trie.UpdateStorage(key, value); err != nil {
+			return evm.Hash{}, err
+		}
+	}
+	if len(a.pendingStorage) > 0 {
+		a.pendingStorage = make(Storage)
+	}
+	return a.trie.Hash(), nil
+}
+
+// stateAccount returns the consensus representation of the account.
+func (a *account) stateAccount(root evm.Hash) *trie.StateAccount {
+	return &trie.StateAccount{
+		Nonce:    a.nonce,
+		Balance:  a.balance,
+		Root:     root,
+		CodeHash: a.codeHash.Bytes(),
+	}
+}
+
+func (a *account) deepCopy() *account {
+	cpy := &account{
+		address:        a.address,
+		nonce:          a.nonce,
+		balance:        new(big.Int).Set(a.balance),
+		code:           a.code,
+		codeHash:       a.codeHash,
+		dirtyCode:      a.dirtyCode,
+		originStorage:  a.originStorage.Copy(),
+		dirtyStorage:   a.dirtyStorage.Copy(),
+		pendingStorage: a.pendingStorage.Copy(),
+		selfDestructed: a.selfDestructed,
+		created:        a.created,
+	}
+	if a.trie != nil {
+		cpy.trie = a.trie.Copy()
+	}
+	return cpy
+}
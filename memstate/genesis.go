@@ -0,0 +1,116 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package memstate
+
+import (
+	"encoding/json"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/lyonnee/evm"
+)
+
+// GenesisAlloc specifies the initial state of a set of accounts, in the
+// format of the genesis alloc and the test fixture pre-states.
+type GenesisAlloc map[evm.Address]GenesisAccount
+
+// GenesisAccount is an account in the state of the genesis block.
+type GenesisAccount struct {
+	Code    []byte
+	Storage map[evm.Hash]evm.Hash
+	Balance *big.Int
+	Nonce   uint64
+}
+
+type genesisAccountJSON struct {
+	Code    hexutil.Bytes         `json:"code,omitempty"`
+	Storage map[evm.Hash]evm.Hash `json:"storage,omitempty"`
+	Balance *math.HexOrDecimal256 `json:"balance"`
+	Nonce   math.HexOrDecimal64   `json:"nonce,omitempty"`
+}
+
+// MarshalJSON encodes the account with hex quantities.
+func (a GenesisAccount) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&genesisAccountJSON{
+		Code:    a.Code,
+		Storage: a.Storage,
+		Balance: (*math.HexOrDecimal256)(a.Balance),
+		Nonce:   math.HexOrDecimal64(a.Nonce),
+	})
+}
+
+// UnmarshalJSON decodes the account, quantities may be hex or decimal.
+func (a *GenesisAccount) UnmarshalJSON(input []byte) error {
+	var dec genesisAccountJSON
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return err
+	}
+	a.Code = dec.Code
+	a.Storage = dec.Storage
+	a.Balance = new(big.Int)
+	if dec.Balance != nil {
+		a.Balance = (*big.Int)(dec.Balance)
+	}
+	a.Nonce = uint64(dec.Nonce)
+	return nil
+}
+
+// NewFromAlloc creates a state holding the given accounts. The accounts are
+// finalised, so their storage is reported as committed state.
+func NewFromAlloc(alloc GenesisAlloc) *StateDB {
+	s := New()
+	for addr, account := range alloc {
+		s.CreateAccount(addr)
+		if account.Balance != nil {
+			s.SetBalance(addr, account.Balance)
+		}
+		s.SetNonce(addr, account.Nonce)
+		s.SetCode(addr, account.Code)
+		for key, value := range account.Storage {
+			s.SetState(addr, key, value)
+		}
+	}
+	s.Finalise(false)
+	return s
+}
+
+// Dump returns the accounts held by the state in the genesis alloc format.
+// Storage slots holding zero are omitted.
+func (s *StateDB) Dump() GenesisAlloc {
+	alloc := make(GenesisAlloc, len(s.accounts))
+	for addr, obj := range s.accounts {
+		storage := make(map[evm.Hash]evm.Hash)
+		for key := range obj.originStorage {
+			if value := obj.getState(key); value != evm.NilHash {
+				storage[key] = value
+			}
+		}
+		for key, value := range obj.dirtyStorage {
+			if value != evm.NilHash {
+				storage[key] = value
+			}
+		}
+		alloc[addr] = GenesisAccount{
+			Code:    obj.code,
+			Storage: storage,
+			Balance: new(big.Int).Set(obj.balance),
+			Nonce:   obj.nonce,
+		}
+	}
+	return alloc
+}
@@ -0,0 +1,266 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package memstate
+
+import (
+	"math/big"
+
+	"github.com/lyonnee/evm"
+)
+
+// journalEntry is a modification entry in the state change journal that can be
+// reverted on demand.
+type journalEntry interface {
+	// revert undoes the changes introduced by this journal entry.
+	revert(*StateDB)
+
+	// dirtied returns the address modified by this journal entry.
+	dirtied() *evm.Address
+}
+
+// journal contains the list of state modifications applied since the last
+// Finalise. These are tracked to be able to be reverted in the case of an
+// execution exception or request for reversal.
+type journal struct {
+	entries []journalEntry      // Current changes tracked by the journal
+	dirties map[evm.Address]int // Dirty accounts and the number of changes
+}
+
+func newJournal() *journal {
+	return &journal{
+		dirties: make(map[evm.Address]int),
+	}
+}
+
+// append inserts a new modification entry to the end of the change journal.
+func (j *journal) append(entry journalEntry) {
+	j.entries = append(j.entries, entry)
+	if addr := entry.dirtied(); addr != nil {
+		j.dirties[*addr]++
+	}
+}
+
+// revert undoes a batch of journalled modifications along with any reverted
+// dirty handling too.
+func (j *journal) revert(s *StateDB, snapshot int) {
+	for i := len(j.entries) - 1; i >= snapshot; i-- {
+		j.entries[i].revert(s)
+
+		if addr := j.entries[i].dirtied(); addr != nil {
+			if j.dirties[*addr]--; j.dirties[*addr] == 0 {
+				delete(j.dirties, *addr)
+			}
+		}
+	}
+	j.entries = j.entries[:snapshot]
+}
+
+func (j *journal) length() int {
+	return len(j.entries)
+}
+
+type (
+	// Changes to the account trie.
+	createObjectChange struct {
+		account *evm.Address
+	}
+	resetObjectChange struct {
+		account *evm.Address
+		prev    *account
+	}
+	selfDestructChange struct {
+		account     *evm.Address
+		prev        bool // whether account had already self-destructed
+		prevbalance *big.Int
+	}
+
+	// Changes to individual accounts.
+	balanceChange struct {
+		account *evm.Address
+		prev    *big.Int
+	}
+	nonceChange struct {
+		account *evm.Address
+		prev    uint64
+	}
+	storageChange struct {
+		account   *evm.Address
+		key       evm.Hash
+		prevalue  evm.Hash
+		prevdirty bool
+	}
+	codeChange struct {
+		account  *evm.Address
+		prevcode []byte
+		prevhash evm.Hash
+	}
+	// touchChange marks an account as touched by a zero-value transfer, which
+	// matters for the EIP-158 empty account deletion.
+	touchChange struct {
+		account *evm.Address
+	}
+
+	// Changes to other state values.
+	refundChange struct {
+		prev uint64
+	}
+	addLogChange      struct{}
+	addPreimageChange struct {
+		hash evm.Hash
+	}
+	accessListAddAccountChange struct {
+		address *evm.Address
+	}
+	accessListAddSlotChange struct {
+		address *evm.Address
+		slot    *evm.Hash
+	}
+	transientStorageChange struct {
+		account       *evm.Address
+		key, prevalue evm.Hash
+	}
+)
+
+func (ch createObjectChange) revert(s *StateDB) {
+	delete(s.accounts, *ch.account)
+}
+
+func (ch createObjectChange) dirtied() *evm.Address {
+	return ch.account
+}
+
+func (ch resetObjectChange) revert(s *StateDB) {
+	s.accounts[ch.prev.address] = ch.prev
+}
+
+func (ch resetObjectChange) dirtied() *evm.Address {
+	return ch.account
+}
+
+func (ch selfDestructChange) revert(s *StateDB) {
+	if obj := s.getAccount(*ch.account); obj != nil {
+		obj.selfDestructed = ch.prev
+		obj.balance = ch.prevbalance
+	}
+}
+
+func (ch selfDestructChange) dirtied() *evm.Address {
+	return ch.account
+}
+
+func (ch balanceChange) revert(s *StateDB) {
+	s.getAccount(*ch.account).balance = ch.prev
+}
+
+func (ch balanceChange) dirtied() *evm.Address {
+	return ch.account
+}
+
+func (ch nonceChange) revert(s *StateDB) {
+	s.getAccount(*ch.account).nonce = ch.prev
+}
+
+func (ch nonceChange) dirtied() *evm.Address {
+	return ch.account
+}
+
+func (ch storageChange) revert(s *StateDB) {
+	obj := s.getAccount(*ch.account)
+	if ch.prevdirty {
+		obj.dirtyStorage[ch.key] = ch.prevalue
+	} else {
+		delete(obj.dirtyStorage, ch.key)
+	}
+}
+
+func (ch storageChange) dirtied() *evm.Address {
+	return ch.account
+}
+
+func (ch codeChange) revert(s *StateDB) {
+	obj := s.getAccount(*ch.account)
+	obj.code = ch.prevcode
+	obj.codeHash = ch.prevhash
+}
+
+func (ch codeChange) dirtied() *evm.Address {
+	return ch.account
+}
+
+func (ch touchChange) revert(s *StateDB) {
+}
+
+func (ch touchChange) dirtied() *evm.Address {
+	return ch.account
+}
+
+func (ch refundChange) revert(s *StateDB) {
+	s.refund = ch.prev
+}
+
+func (ch refundChange) dirtied() *evm.Address {
+	return nil
+}
+
+func (ch addLogChange) revert(s *StateDB) {
+	s.logs = s.logs[:len(s.logs)-1]
+}
+
+func (ch addLogChange) dirtied() *evm.Address {
+	return nil
+}
+
+func (ch addPreimageChange) revert(s *StateDB) {
+	delete(s.preimages, ch.hash)
+}
+
+func (ch addPreimageChange) dirtied() *evm.Address {
+	return nil
+}
+
+func (ch accessListAddAccountChange) revert(s *StateDB) {
+	/*
+		One important invariant here, is that whenever a (addr, slot) is added, if the
+		addr is not already present, the add causes two journal entries:
+		- one for the address,
+		- one for the (address,slot)
+		Therefore, when unrolling the change, we can always blindly delete the
+		(addr) at this point, since no storage adds can remain when come upon
+		a single (addr) change.
+	*/
+	s.accessList.DeleteAddress(*ch.address)
+}
+
+func (ch accessListAddAccountChange) dirtied() *evm.Address {
+	return nil
+}
+
+func (ch accessListAddSlotChange) revert(s *StateDB) {
+	s.accessList.DeleteSlot(*ch.address, *ch.slot)
+}
+
+func (ch accessListAddSlotChange) dirtied() *evm.Address {
+	return nil
+}
+
+func (ch transientStorageChange) revert(s *StateDB) {
+	s.setTransientState(*ch.account, ch.key, ch.prevalue)
+}
+
+func (ch transientStorageChange) dirtied() *evm.Address {
+	return nil
+}
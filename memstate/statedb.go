@@ -0,0 +1,587 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package memstate provides an in-memory, journaled implementation of the
+// evm.StateDB interface. It is meant to be used as the default backend for
+// tests and tools that do not need a persistent state.
+package memstate
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/trie"
+)
+
+type revision struct {
+	id           int
+	journalIndex int
+}
+
+// StateDB is an in-memory evm.StateDB.
+//
+// All modifications are journaled, so they can be rolled back with
+// RevertToSnapshot. Finalise has to be called at the end of every transaction
+// to fold the transaction's writes into the committed view and to reset the
+// transaction scoped data (refund, access list, transient storage).
+//
+// The state root is computed by IntermediateRoot, Commit additionally writes
+// the trie nodes and contract codes to the backing key-value store.
+type StateDB struct {
+	db       trie.KeyValueStore
+	trie     *trie.SecureTrie
+	accounts map[evm.Address]*account
+
+	// hasher hashes the codes, emptyCodeHash is its hash of empty code
+	hasher        evm.Hasher
+	emptyCodeHash evm.Hash
+
+	// accountsPending holds the accounts finalised since the last root
+	// computation, the deleted ones being absent from accounts.
+	accountsPending map[evm.Address]struct{}
+
+	// dbErr memoizes the first error of the trie or the store, it is
+	// reported by Commit.
+	dbErr error
+
+	refund    uint64
+	logs      []evm.Log
+	preimages map[evm.Hash][]byte
+
+	accessList       *accessList
+	transientStorage transientStorage
+
+	journal        *journal
+	validRevisions []revision
+	nextRevisionId int
+}
+
+var _ evm.StateDB = (*StateDB)(nil)
+
+// New creates a new empty state backed by an in-memory store.
+func New() *StateDB {
+	return NewWithStore(trie.NewMemoryStore())
+}
+
+// NewWithStore creates a new empty state committing to the given store.
+func NewWithStore(db trie.KeyValueStore) *StateDB {
+	return NewWithHasher(db, evm.KeccakHasher{})
+}
+
+// NewWithHasher creates a new empty state committing to the given store and
+// hashing the codes with hasher, which must be the Hasher of the EVM.
+func NewWithHasher(db trie.KeyValueStore, hasher evm.Hasher) *StateDB {
+	return &StateDB{
+		db:               db,
+		hasher:           hasher,
+		emptyCodeHash:    evm.HashWith(hasher, nil),
+		trie:             trie.NewEmptySecure(db),
+		accounts:         make(map[evm.Address]*account),
+		accountsPending:  make(map[evm.Address]struct{}),
+		preimages:        make(map[evm.Hash][]byte),
+		accessList:       newAccessList(),
+		transientStorage: newTransientStorage(),
+		journal:          newJournal(),
+	}
+}
+
+func (s *StateDB) getAccount(addr evm.Address) *account {
+	return s.accounts[addr]
+}
+
+func (s *StateDB) getOrNewAccount(addr evm.Address) *account {
+	if obj := s.getAccount(addr); obj != nil {
+		return obj
+	}
+	return s.createAccount(addr)
+}
+
+// createAccount creates a new account. If there is an existing account with
+// the given address, it is overwritten and returned as the second return value.
+func (s *StateDB) createAccount(addr evm.Address) *account {
+	prev := s.getAccount(addr)
+	obj := newAccount(addr, s.emptyCodeHash)
+	obj.created = true
+	if prev == nil {
+		s.journal.append(createObjectChange{account: &addr})
+	} else {
+		s.journal.append(resetObjectChange{account: &addr, prev: prev})
+	}
+	s.accounts[addr] = obj
+	return obj
+}
+
+// CreateAccount explicitly creates an account. If an account with the same
+// address already exists, its balance is carried over into the new one.
+//
+// CreateAccount is called during the EVM CREATE operation. The situation might
+// arise that a contract does the following:
+//
+//  1. sends funds to sha(account ++ (nonce + 1))
+//  2. tx_create(sha(account ++ nonce)) (note that this gets the address of 1)
+//
+// Carrying over the balance ensures that Ether doesn't disappear.
+func (s *StateDB) CreateAccount(addr evm.Address) {
+	prev := s.getAccount(addr)
+	obj := s.createAccount(addr)
+	if prev != nil {
+		obj.balance = new(big.Int).Set(prev.balance)
+	}
+}
+
+// SubBalance subtracts amount from the account associated with addr.
+func (s *StateDB) SubBalance(addr evm.Address, amount *big.Int) {
+	if amount.Sign() == 0 {
+		s.touch(addr)
+		return
+	}
+	obj := s.getOrNewAccount(addr)
+	s.setBalance(obj, new(big.Int).Sub(obj.balance, amount))
+}
+
+// AddBalance adds amount to the account associated with addr.
+func (s *StateDB) AddBalance(addr evm.Address, amount *big.Int) {
+	// EIP161: We must check emptiness for the objects such that the account
+	// clearing (0,0,0 objects) can take effect.
+	if amount.Sign() == 0 {
+		s.touch(addr)
+		return
+	}
+	obj := s.getOrNewAccount(addr)
+	s.setBalance(obj, new(big.Int).Add(obj.balance, amount))
+}
+
+// SetBalance sets the balance of the account associated with addr. It is
+// mostly useful to seed a state before execution.
+func (s *StateDB) SetBalance(addr evm.Address, amount *big.Int) {
+	s.setBalance(s.getOrNewAccount(addr), new(big.Int).Set(amount))
+}
+
+func (s *StateDB) setBalance(obj *account, amount *big.Int) {
+	s.journal.append(balanceChange{
+		account: &obj.address,
+		prev:    obj.balance,
+	})
+	obj.balance = amount
+}
+
+// touch marks the account as modified without changing it, so an empty
+// account gets removed by Finalise.
+func (s *StateDB) touch(addr evm.Address) {
+	obj := s.getOrNewAccount(addr)
+	s.journal.append(touchChange{account: &obj.address})
+}
+
+// GetBalance retrieves the balance from the given address or 0 if object not found.
+func (s *StateDB) GetBalance(addr evm.Address) *big.Int {
+	if obj := s.getAccount(addr); obj != nil {
+		return new(big.Int).Set(obj.balance)
+	}
+	return new(big.Int)
+}
+
+// GetNonce retrieves the nonce from the given address or 0 if object not found.
+func (s *StateDB) GetNonce(addr evm.Address) uint64 {
+	if obj := s.getAccount(addr); obj != nil {
+		return obj.nonce
+	}
+	return 0
+}
+
+// SetNonce sets the nonce of the account associated with addr.
+func (s *StateDB) SetNonce(addr evm.Address, nonce uint64) {
+	obj := s.getOrNewAccount(addr)
+	s.journal.append(nonceChange{
+		account: &obj.address,
+		prev:    obj.nonce,
+	})
+	obj.nonce = nonce
+}
+
+// GetCodeHash returns the code hash of the account, or the zero hash if the
+// account does not exist.
+func (s *StateDB) GetCodeHash(addr evm.Address) evm.Hash {
+	if obj := s.getAccount(addr); obj != nil {
+		return obj.codeHash
+	}
+	return evm.NilHash
+}
+
+// GetCode returns the code of the account, or nil if the account does not exist.
+func (s *StateDB) GetCode(addr evm.Address) []byte {
+	if obj := s.getAccount(addr); obj != nil {
+		return obj.code
+	}
+	return nil
+}
+
+// SetCode sets the code of the account associated with addr.
+func (s *StateDB) SetCode(addr evm.Address, code []byte) {
+	obj := s.getOrNewAccount(addr)
+	s.journal.append(codeChange{
+		account:  &obj.address,
+		prevcode: obj.code,
+		prevhash: obj.codeHash,
+	})
+	obj.code = code
+	obj.codeHash = evm.HashWith(s.hasher, code)
+	obj.dirtyCode = true
+}
+
+// GetCodeSize returns the size of the account's code.
+func (s *StateDB) GetCodeSize(addr evm.Address) int {
+	return len(s.GetCode(addr))
+}
+
+// AddRefund adds gas to the refund counter
+func (s *StateDB) AddRefund(gas uint64) {
+	s.journal.append(refundChange{prev: s.refund})
+	s.refund += gas
+}
+
+// SubRefund removes gas from the refund counter.
+// This method will panic if the refund counter goes below zero
+func (s *StateDB) SubRefund(gas uint64) {
+	s.journal.append(refundChange{prev: s.refund})
+	if gas > s.refund {
+		panic(fmt.Sprintf("refund counter below zero (gas: %d > refund: %d)", gas, s.refund))
+	}
+	s.refund -= gas
+}
+
+// GetRefund returns the current value of the refund counter.
+func (s *StateDB) GetRefund() uint64 {
+	return s.refund
+}
+
+// GetCommittedState retrieves a value from the given account's committed
+// storage, i.e. the value at the start of the current transaction.
+func (s *StateDB) GetCommittedState(addr evm.Address, key evm.Hash) evm.Hash {
+	if obj := s.getAccount(addr); obj != nil {
+		return obj.originStorage[key]
+	}
+	return evm.NilHash
+}
+
+// GetState retrieves the current value of a storage slot, including the
+// uncommitted writes of the running transaction.
+func (s *StateDB) GetState(addr evm.Address, key evm.Hash) evm.Hash {
+	if obj := s.getAccount(addr); obj != nil {
+		return obj.getState(key)
+	}
+	return evm.NilHash
+}
+
+// SetState updates a value in the account's storage.
+func (s *StateDB) SetState(addr evm.Address, key, value evm.Hash) {
+	obj := s.getOrNewAccount(addr)
+	prev, prevdirty := obj.dirtyStorage[key]
+	if !prevdirty {
+		prev = obj.originStorage[key]
+	}
+	if prev == value {
+		return
+	}
+	s.journal.append(storageChange{
+		account:   &obj.address,
+		key:       key,
+		prevalue:  prev,
+		prevdirty: prevdirty,
+	})
+	obj.dirtyStorage[key] = value
+}
+
+// GetTransientState gets transient storage for a given account.
+func (s *StateDB) GetTransientState(addr evm.Address, key evm.Hash) evm.Hash {
+	return s.transientStorage.Get(addr, key)
+}
+
+// SetTransientState sets transient storage for a given account. It
+// adds the change to the journal so that it can be rolled back
+// to its previous value if there is a revert.
+func (s *StateDB) SetTransientState(addr evm.Address, key, value evm.Hash) {
+	prev := s.GetTransientState(addr, key)
+	if prev == value {
+		return
+	}
+	s.journal.append(transientStorageChange{
+		account:  &addr,
+		key:      key,
+		prevalue: prev,
+	})
+	s.setTransientState(addr, key, value)
+}
+
+// setTransientState is a lower level setter for transient storage. It
+// is called during a revert to prevent modifications to the journal.
+func (s *StateDB) setTransientState(addr evm.Address, key, value evm.Hash) {
+	s.transientStorage.Set(addr, key, value)
+}
+
+// SelfDestruct marks the given account as self-destructed.
+// This clears the account balance.
+//
+// The account's state object is still available until the state is finalised,
+// getAccount will return a non-nil account after SelfDestruct.
+func (s *StateDB) SelfDestruct(addr evm.Address) {
+	obj := s.getAccount(addr)
+	if obj == nil {
+		return
+	}
+	s.journal.append(selfDestructChange{
+		account:     &addr,
+		prev:        obj.selfDestructed,
+		prevbalance: obj.balance,
+	})
+	obj.selfDestructed = true
+	obj.balance = new(big.Int)
+}
+
+// HasSelfDestructed returns whether the account was self-destructed in the
+// current transaction.
+func (s *StateDB) HasSelfDestructed(addr evm.Address) bool {
+	if obj := s.getAccount(addr); obj != nil {
+		return obj.selfDestructed
+	}
+	return false
+}
+
+// Selfdestruct6780 self-destructs the account only if it was created within
+// the current transaction, as specified by EIP-6780.
+func (s *StateDB) Selfdestruct6780(addr evm.Address) {
+	obj := s.getAccount(addr)
+	if obj == nil {
+		return
+	}
+	if obj.created {
+		s.SelfDestruct(addr)
+	}
+}
+
+// Exist reports whether the given account address exists in the state.
+// Notably this also returns true for self-destructed accounts.
+func (s *StateDB) Exist(addr evm.Address) bool {
+	return s.getAccount(addr) != nil
+}
+
+// Empty returns whether the state object is either non-existent
+// or empty according to the EIP161 specification (balance = nonce = code = 0)
+func (s *StateDB) Empty(addr evm.Address) bool {
+	obj := s.getAccount(addr)
+	return obj == nil || obj.empty()
+}
+
+// AddressInAccessList returns true if the given address is in the access list.
+func (s *StateDB) AddressInAccessList(addr evm.Address) bool {
+	return s.accessList.ContainsAddress(addr)
+}
+
+// SlotInAccessList returns true if the given (address, slot)-tuple is in the access list.
+func (s *StateDB) SlotInAccessList(addr evm.Address, slot evm.Hash) (addressPresent bool, slotPresent bool) {
+	return s.accessList.Contains(addr, slot)
+}
+
+// AddAddressToAccessList adds the given address to the access list
+func (s *StateDB) AddAddressToAccessList(addr evm.Address) {
+	if s.accessList.AddAddress(addr) {
+		s.journal.append(accessListAddAccountChange{&addr})
+	}
+}
+
+// AddSlotToAccessList adds the given (address, slot)-tuple to the access list
+func (s *StateDB) AddSlotToAccessList(addr evm.Address, slot evm.Hash) {
+	addrMod, slotMod := s.accessList.AddSlot(addr, slot)
+	if addrMod {
+		// In practice, this should not happen, since there is no way to enter the
+		// scope of 'address' without having the 'address' become already added
+		// to the access list (via call-variant, create, etc).
+		// Better safe than sorry, though
+		s.journal.append(accessListAddAccountChange{&addr})
+	}
+	if slotMod {
+		s.journal.append(accessListAddSlotChange{
+			address: &addr,
+			slot:    &slot,
+		})
+	}
+}
+
+// Snapshot returns an identifier for the current revision of the state.
+func (s *StateDB) Snapshot() int {
+	id := s.nextRevisionId
+	s.nextRevisionId++
+	s.validRevisions = append(s.validRevisions, revision{id, s.journal.length()})
+	return id
+}
+
+// RevertToSnapshot reverts all state changes made since the given revision.
+func (s *StateDB) RevertToSnapshot(revid int) {
+	// Find the snapshot in the stack of valid snapshots.
+	idx := sort.Search(len(s.validRevisions), func(i int) bool {
+		return s.validRevisions[i].id >= revid
+	})
+	if idx == len(s.validRevisions) || s.validRevisions[idx].id != revid {
+		panic(fmt.Errorf("revision id %v cannot be reverted", revid))
+	}
+	snapshot := s.validRevisions[idx].journalIndex
+
+	// Replay the journal to undo changes and remove invalidated snapshots
+	s.journal.revert(s, snapshot)
+	s.validRevisions = s.validRevisions[:idx]
+}
+
+// AddLog records a log emitted by the execution.
+func (s *StateDB) AddLog(log evm.Log) {
+	s.journal.append(addLogChange{})
+	s.logs = append(s.logs, log)
+}
+
+// Logs returns all logs recorded so far.
+func (s *StateDB) Logs() []evm.Log {
+	return s.logs
+}
+
+// AddPreimage records a SHA3 preimage seen by the VM.
+func (s *StateDB) AddPreimage(hash evm.Hash, preimage []byte) {
+	if _, ok := s.preimages[hash]; !ok {
+		s.journal.append(addPreimageChange{hash: hash})
+		pi := make([]byte, len(preimage))
+		copy(pi, preimage)
+		s.preimages[hash] = pi
+	}
+}
+
+// Preimages returns a list of SHA3 preimages that have been submitted.
+func (s *StateDB) Preimages() map[evm.Hash][]byte {
+	return s.preimages
+}
+
+// Finalise finalises the state at the end of a transaction: self-destructed
+// accounts are removed, as well as touched empty accounts if deleteEmptyObjects
+// is set (EIP-158), the dirty storage becomes the committed storage, and the
+// journal, refund counter, access list and transient storage are reset.
+func (s *StateDB) Finalise(deleteEmptyObjects bool) {
+	for addr := range s.journal.dirties {
+		obj, exist := s.accounts[addr]
+		if !exist {
+			continue
+		}
+		s.accountsPending[addr] = struct{}{}
+		if obj.selfDestructed || (deleteEmptyObjects && obj.empty()) {
+			delete(s.accounts, addr)
+		} else {
+			obj.finalise()
+		}
+	}
+	s.journal = newJournal()
+	s.validRevisions = s.validRevisions[:0]
+	s.refund = 0
+	s.accessList = newAccessList()
+	s.transientStorage = newTransientStorage()
+}
+
+func (s *StateDB) setError(err error) {
+	if s.dbErr == nil {
+		s.dbErr = err
+	}
+}
+
+// Error returns the first error encountered by the trie or the store.
+func (s *StateDB) Error() error {
+	return s.dbErr
+}
+
+// IntermediateRoot finalises the state and computes its current root hash.
+// It is called in between transactions to get the root hash that goes into
+// the transaction receipts.
+func (s *StateDB) IntermediateRoot(deleteEmptyObjects bool) evm.Hash {
+	s.Finalise(deleteEmptyObjects)
+
+	for addr := range s.accountsPending {
+		obj, exist := s.accounts[addr]
+		if !exist {
+			if err := s.trie.DeleteAccount(addr); err != nil {
+				s.setError(fmt.Errorf("deleteAccount (%x) error: %v", addr, err))
+			}
+			continue
+		}
+		root, err := obj.updateTrie(s.db)
+		if err != nil {
+			s.setError(fmt.Errorf("updateStorage (%x) error: %v", addr, err))
+			continue
+		}
+		if err := s.trie.UpdateAccount(addr, obj.stateAccount(root)); err != nil {
+			s.setError(fmt.Errorf("updateAccount (%x) error: %v", addr, err))
+		}
+	}
+	if len(s.accountsPending) > 0 {
+		s.accountsPending = make(map[evm.Address]struct{})
+	}
+	return s.trie.Hash()
+}
+
+// Commit finalises the state, writes the modified storage tries, the account
+// trie and the updated contract codes to the store and returns the root hash.
+func (s *StateDB) Commit(deleteEmptyObjects bool) (evm.Hash, error) {
+	if s.dbErr != nil {
+		return evm.Hash{}, fmt.Errorf("commit aborted due to earlier error: %v", s.dbErr)
+	}
+	s.IntermediateRoot(deleteEmptyObjects)
+	if s.dbErr != nil {
+		return evm.Hash{}, s.dbErr
+	}
+	for _, obj := range s.accounts {
+		if obj.dirtyCode && len(obj.code) > 0 {
+			if err := trie.WriteCode(s.db, obj.codeHash, obj.code); err != nil {
+				return evm.Hash{}, err
+			}
+		}
+		obj.dirtyCode = false
+		if obj.trie != nil {
+			if _, err := obj.trie.Commit(); err != nil {
+				return evm.Hash{}, err
+			}
+		}
+	}
+	return s.trie.Commit()
+}
+
+// Copy creates a deep, independent copy of the state. Snapshots of the copied
+// state cannot be applied to the copy.
+func (s *StateDB) Copy() *StateDB {
+	cpy := NewWithHasher(s.db, s.hasher)
+	cpy.trie = s.trie.Copy()
+	cpy.dbErr = s.dbErr
+	for addr, obj := range s.accounts {
+		cpy.accounts[addr] = obj.deepCopy()
+	}
+	for addr := range s.accountsPending {
+		cpy.accountsPending[addr] = struct{}{}
+	}
+	for addr := range s.journal.dirties {
+		cpy.journal.dirties[addr]++
+	}
+	cpy.refund = s.refund
+	cpy.logs = append([]evm.Log(nil), s.logs...)
+	for hash, preimage := range s.preimages {
+		cpy.preimages[hash] = preimage
+	}
+	cpy.accessList = s.accessList.Copy()
+	cpy.transientStorage = s.transientStorage.Copy()
+	return cpy
+}
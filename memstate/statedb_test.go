@@ -0,0 +1,382 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package memstate
+
+import (
+	"crypto/sha256"
+	"math/big"
+	"testing"
+
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/params"
+	"github.com/lyonnee/evm/trie"
+)
+
+var (
+	addr1 = evm.BytesToAddr([]byte{0x01, 0x01})
+	addr2 = evm.BytesToAddr([]byte{0x02, 0x02})
+	key1  = evm.BytesToHash([]byte{0x01})
+	val1  = evm.BytesToHash([]byte{0xaa})
+	val2  = evm.BytesToHash([]byte{0xbb})
+)
+
+func TestSnapshotRevert(t *testing.T) {
+	s := New()
+	s.AddBalance(addr1, big.NewInt(42))
+	s.SetNonce(addr1, 1)
+	s.SetState(addr1, key1, val1)
+
+	snap := s.Snapshot()
+	s.AddBalance(addr1, big.NewInt(8))
+	s.SetNonce(addr1, 2)
+	s.SetState(addr1, key1, val2)
+	s.SetCode(addr1, []byte{0x60, 0x00})
+	s.AddRefund(100)
+	s.SetTransientState(addr1, key1, val1)
+	s.AddAddressToAccessList(addr2)
+	s.AddSlotToAccessList(addr2, key1)
+	s.AddLog(evm.Log{Address: addr1})
+	s.CreateAccount(addr2)
+
+	s.RevertToSnapshot(snap)
+
+	if have := s.GetBalance(addr1); have.Cmp(big.NewInt(42)) != 0 {
+		t.Errorf("balance mismatch: have %v, want 42", have)
+	}
+	if have := s.GetNonce(addr1); have != 1 {
+		t.Errorf("nonce mismatch: have %d, want 1", have)
+	}
+	if have := s.GetState(addr1, key1); have != val1 {
+		t.Errorf("storage mismatch: have %x, want %x", have, val1)
+	}
+	if have, want := s.GetCodeHash(addr1), evm.HashWith(evm.KeccakHasher{}, nil); have != want {
+		t.Errorf("code hash mismatch: have %x, want %x", have, want)
+	}
+	if have := s.GetRefund(); have != 0 {
+		t.Errorf("refund mismatch: have %d, want 0", have)
+	}
+	if have := s.GetTransientState(addr1, key1); have != evm.NilHash {
+		t.Errorf("transient storage not reverted: %x", have)
+	}
+	if s.AddressInAccessList(addr2) {
+		t.Error("access list not reverted")
+	}
+	if len(s.Logs()) != 0 {
+		t.Errorf("logs not reverted: %d", len(s.Logs()))
+	}
+	if s.Exist(addr2) {
+		t.Error("account creation not reverted")
+	}
+}
+
+func TestNestedSnapshots(t *testing.T) {
+	s := New()
+	outer := s.Snapshot()
+	s.SetState(addr1, key1, val1)
+	inner := s.Snapshot()
+	s.SetState(addr1, key1, val2)
+
+	s.RevertToSnapshot(inner)
+	if have := s.GetState(addr1, key1); have != val1 {
+		t.Fatalf("inner revert: have %x, want %x", have, val1)
+	}
+	s.RevertToSnapshot(outer)
+	if have := s.GetState(addr1, key1); have != evm.NilHash {
+		t.Fatalf("outer revert: have %x, want empty", have)
+	}
+	if s.Exist(addr1) {
+		t.Fatal("implicitly created account should be reverted")
+	}
+}
+
+func TestCommittedState(t *testing.T) {
+	s := New()
+	s.SetNonce(addr1, 1)
+	s.SetState(addr1, key1, val1)
+	if have := s.GetCommittedState(addr1, key1); have != evm.NilHash {
+		t.Fatalf("committed state should not see dirty writes, have %x", have)
+	}
+	s.Finalise(true)
+	if have := s.GetCommittedState(addr1, key1); have != val1 {
+		t.Fatalf("committed state mismatch after finalise: have %x, want %x", have, val1)
+	}
+	s.SetState(addr1, key1, val2)
+	if have := s.GetCommittedState(addr1, key1); have != val1 {
+		t.Fatalf("committed state mismatch: have %x, want %x", have, val1)
+	}
+	if have := s.GetState(addr1, key1); have != val2 {
+		t.Fatalf("dirty state mismatch: have %x, want %x", have, val2)
+	}
+}
+
+func TestFinaliseDeletesEmptyAndDestructed(t *testing.T) {
+	s := New()
+	s.SetBalance(addr1, big.NewInt(1))
+	s.SetNonce(addr2, 1)
+	s.Finalise(true)
+
+	// Touch an empty account and self-destruct a non-empty one.
+	empty := evm.BytesToAddr([]byte{0x03})
+	s.AddBalance(empty, new(big.Int))
+	s.SelfDestruct(addr1)
+	if !s.HasSelfDestructed(addr1) || !s.Exist(addr1) {
+		t.Fatal("self-destructed account must stay accessible until finalise")
+	}
+	if s.GetBalance(addr1).Sign() != 0 {
+		t.Fatal("self-destruct must clear the balance")
+	}
+	s.Finalise(true)
+
+	if s.Exist(addr1) {
+		t.Error("self-destructed account not removed")
+	}
+	if s.Exist(empty) {
+		t.Error("touched empty account not removed")
+	}
+	if !s.Exist(addr2) {
+		t.Error("non-empty account removed")
+	}
+}
+
+func TestSelfdestruct6780(t *testing.T) {
+	s := New()
+	s.SetBalance(addr1, big.NewInt(1))
+	s.Finalise(true)
+
+	// Accounts created in an earlier transaction survive.
+	s.Selfdestruct6780(addr1)
+	if s.HasSelfDestructed(addr1) {
+		t.Fatal("pre-existing account must not self-destruct under EIP-6780")
+	}
+	// Accounts created in the same transaction are destroyed.
+	s.CreateAccount(addr2)
+	s.Selfdestruct6780(addr2)
+	if !s.HasSelfDestructed(addr2) {
+		t.Fatal("newly created account must self-destruct under EIP-6780")
+	}
+}
+
+func TestCreateAccountKeepsBalance(t *testing.T) {
+	s := New()
+	s.SetBalance(addr1, big.NewInt(7))
+	s.SetState(addr1, key1, val1)
+	s.CreateAccount(addr1)
+
+	if have := s.GetBalance(addr1); have.Cmp(big.NewInt(7)) != 0 {
+		t.Errorf("balance not carried over: have %v", have)
+	}
+	if have := s.GetState(addr1, key1); have != evm.NilHash {
+		t.Errorf("storage should be reset: have %x", have)
+	}
+}
+
+func TestCreateAccountFinalisesExisting(t *testing.T) {
+	s := New()
+	s.SetBalance(addr1, big.NewInt(1))
+	s.SetState(addr1, key1, val1)
+	s.Finalise(true)
+
+	// Recreating the account is its only change in the transaction.
+	s.CreateAccount(addr1)
+	s.Finalise(true)
+
+	if have := s.GetCommittedState(addr1, key1); have != evm.NilHash {
+		t.Errorf("storage of the recreated account not finalised: have %x", have)
+	}
+	// The account was created in an earlier transaction.
+	s.Selfdestruct6780(addr1)
+	if s.HasSelfDestructed(addr1) {
+		t.Error("account recreated in an earlier transaction self-destructed under EIP-6780")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	s := New()
+	s.SetBalance(addr1, big.NewInt(1))
+	s.SetState(addr1, key1, val1)
+
+	cpy := s.Copy()
+	cpy.SetBalance(addr1, big.NewInt(2))
+	cpy.SetState(addr1, key1, val2)
+
+	if have := s.GetBalance(addr1); have.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("copy modified original balance: %v", have)
+	}
+	if have := s.GetState(addr1, key1); have != val1 {
+		t.Errorf("copy modified original storage: %x", have)
+	}
+}
+
+func TestExecution(t *testing.T) {
+	var (
+		s        = New()
+		contract = evm.BytesToAddr([]byte("contract"))
+		caller   = evm.BytesToAddr([]byte("caller"))
+	)
+	// PUSH1 0x2a PUSH1 0x01 SSTORE PUSH1 0x01 PUSH1 0x00 LOG0 STOP
+	s.SetCode(contract, evm.Hex2Bytes("602a600155600160"+"00a000"))
+	s.SetBalance(caller, big.NewInt(1000))
+	s.Finalise(true)
+
+	blockCtx := evm.BlockContext{
+		CanTransfer: func(db evm.StateDB, addr evm.Address, amount *big.Int) bool {
+			return db.GetBalance(addr).Cmp(amount) >= 0
+		},
+		Transfer: func(db evm.StateDB, sender, recipient evm.Address, amount *big.Int) {
+			db.SubBalance(sender, amount)
+			db.AddBalance(recipient, amount)
+		},
+		BlockNumber: big.NewInt(1),
+		Difficulty:  new(big.Int),
+	}
+	config := &params.ChainConfig{
+		ChainID:        big.NewInt(1),
+		HomesteadBlock: big.NewInt(0),
+		EIP150Block:    big.NewInt(0),
+		EIP155Block:    big.NewInt(0),
+		EIP158Block:    big.NewInt(0),
+		ByzantiumBlock: big.NewInt(0),
+	}
+	vm := evm.NewEVM(blockCtx, evm.TxContext{}, s, config, evm.Config{})
+	_, _, err := vm.Call(evm.AccountRef(caller), contract, nil, 100000, big.NewInt(10))
+	if err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	if have := s.GetState(contract, evm.BytesToHash([]byte{1})); have != evm.BytesToHash([]byte{0x2a}) {
+		t.Errorf("storage mismatch: have %x", have)
+	}
+	if have := s.GetBalance(contract); have.Cmp(big.NewInt(10)) != 0 {
+		t.Errorf("balance mismatch: have %v", have)
+	}
+	if len(s.Logs()) != 1 {
+		t.Errorf("log count mismatch: have %d, want 1", len(s.Logs()))
+	}
+}
+
+func TestIntermediateRoot(t *testing.T) {
+	s := New()
+	if root := s.IntermediateRoot(false); root != trie.EmptyRootHash {
+		t.Fatalf("empty root mismatch: have %x, want %x", root, trie.EmptyRootHash)
+	}
+	s.AddBalance(addr1, big.NewInt(42))
+	s.SetState(addr1, key1, val1)
+	root1 := s.IntermediateRoot(false)
+
+	// Changes in later transactions are applied incrementally
+	s.AddBalance(addr2, big.NewInt(1))
+	s.SetState(addr1, key1, val2)
+	root2 := s.IntermediateRoot(false)
+	if root2 == root1 {
+		t.Fatal("root not updated")
+	}
+	fresh := NewFromAlloc(s.Dump())
+	if root := fresh.IntermediateRoot(false); root != root2 {
+		t.Errorf("root mismatch with fresh state: have %x, want %x", root, root2)
+	}
+
+	// Reverting the changes restores the root
+	s.SubBalance(addr2, big.NewInt(1))
+	s.SetState(addr1, key1, val1)
+	if root := s.IntermediateRoot(true); root != root1 {
+		t.Errorf("root mismatch after revert: have %x, want %x", root, root1)
+	}
+}
+
+func TestCommit(t *testing.T) {
+	db := trie.NewMemoryStore()
+	s := NewWithStore(db)
+	code := []byte{0x60, 0x00}
+	s.SetNonce(addr1, 3)
+	s.SetCode(addr1, code)
+	s.SetState(addr1, key1, val1)
+
+	root, err := s.Commit(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != s.IntermediateRoot(false) {
+		t.Errorf("commit root mismatch: have %x, want %x", root, s.IntermediateRoot(false))
+	}
+	accounts, err := trie.NewSecure(root, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := accounts.GetAccount(addr1)
+	if err != nil || account == nil {
+		t.Fatalf("account not committed: %v", err)
+	}
+	if account.Nonce != 3 {
+		t.Errorf("nonce mismatch: have %d, want 3", account.Nonce)
+	}
+	if have, _ := trie.ReadCode(db, evm.KeccakHasher{}, evm.BytesToHash(account.CodeHash)); string(have) != string(code) {
+		t.Errorf("code mismatch: have %x, want %x", have, code)
+	}
+	storage, err := trie.NewSecure(account.Root, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, _ := storage.GetStorage(key1); have != val1 {
+		t.Errorf("storage mismatch: have %x, want %x", have, val1)
+	}
+}
+
+func TestHasher(t *testing.T) {
+	var (
+		hasher = evm.NewHasher(sha256.New, 30, 6)
+		s      = NewWithHasher(trie.NewMemoryStore(), hasher)
+		caller = evm.BytesToAddr([]byte("caller"))
+	)
+	s.SetBalance(caller, big.NewInt(1e18))
+	s.Finalise(true)
+
+	config := &params.ChainConfig{
+		ChainID:        big.NewInt(1),
+		HomesteadBlock: big.NewInt(0),
+		EIP150Block:    big.NewInt(0),
+		EIP155Block:    big.NewInt(0),
+		EIP158Block:    big.NewInt(0),
+		ByzantiumBlock: big.NewInt(0),
+	}
+	blockCtx := evm.BlockContext{
+		CanTransfer: evm.CanTransfer,
+		Transfer:    evm.Transfer,
+		GasLimit:    1000000,
+		BlockNumber: big.NewInt(1),
+		Difficulty:  new(big.Int),
+	}
+	msg := &evm.Message{
+		From:     caller,
+		GasLimit: 100000,
+		GasPrice: big.NewInt(1),
+		Value:    new(big.Int),
+		// Deploys 0xdeadbeef
+		Data: evm.Hex2Bytes("63deadbeef6000526004601cf3"),
+	}
+	vm := evm.NewEVM(blockCtx, evm.NewTxContext(msg), s, config, evm.Config{Hasher: hasher})
+	result, err := evm.ApplyMessage(vm, msg, new(evm.GasPool).AddGas(blockCtx.GasLimit))
+	if err != nil {
+		t.Fatalf("sender rejected: %v", err)
+	}
+	if result.Err != nil {
+		t.Fatalf("deployment failed: %v", result.Err)
+	}
+	contract := vm.AddressDeriver().CreateAddress(caller, 0)
+	want := sha256.Sum256(evm.Hex2Bytes("deadbeef"))
+	if have := s.GetCodeHash(contract); have != evm.Hash(want) {
+		t.Errorf("code hash mismatch: have %x, want %x", have, want)
+	}
+}
@@ -17,7 +17,9 @@
 package evm
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 )
 
 type OpCode byte
@@ -203,6 +205,26 @@ const (
 	LOG4
 )
 
+// 0xd0 EOF数据段操作
+const (
+	DATALOAD  OpCode = 0xd0
+	DATALOADN OpCode = 0xd1
+	DATASIZE  OpCode = 0xd2
+	DATACOPY  OpCode = 0xd3
+)
+
+// 0xe0 EOF控制流和Stack操作
+const (
+	RJUMP    OpCode = 0xe0
+	RJUMPI   OpCode = 0xe1
+	RJUMPV   OpCode = 0xe2
+	CALLF    OpCode = 0xe3
+	RETF     OpCode = 0xe4
+	DUPN     OpCode = 0xe6
+	SWAPN    OpCode = 0xe7
+	EXCHANGE OpCode = 0xe8
+)
+
 // 0xf0 关闭
 const (
 	CREATE       OpCode = 0xf0
@@ -378,6 +400,22 @@ var opCodeToString = map[OpCode]string{
 	LOG3: "LOG3",
 	LOG4: "LOG4",
 
+	// 0xd0 range - EOF data section ops.
+	DATALOAD:  "DATALOAD",
+	DATALOADN: "DATALOADN",
+	DATASIZE:  "DATASIZE",
+	DATACOPY:  "DATACOPY",
+
+	// 0xe0 range - EOF control flow and stack ops.
+	RJUMP:    "RJUMP",
+	RJUMPI:   "RJUMPI",
+	RJUMPV:   "RJUMPV",
+	CALLF:    "CALLF",
+	RETF:     "RETF",
+	DUPN:     "DUPN",
+	SWAPN:    "SWAPN",
+	EXCHANGE: "EXCHANGE",
+
 	// 0xf0 range - closures.
 	CREATE:       "CREATE",
 	CALL:         "CALL",
@@ -393,6 +431,9 @@ var opCodeToString = map[OpCode]string{
 
 func (op OpCode) String() string {
 	str := opCodeToString[op]
+	if len(str) == 0 {
+		str = customOpCodes.name(op)
+	}
 	if len(str) == 0 {
 		return fmt.Sprintf("opcode %#x not defined", int(op))
 	}
@@ -541,6 +582,18 @@ var stringToOp = map[string]OpCode{
 	"LOG2":           LOG2,
 	"LOG3":           LOG3,
 	"LOG4":           LOG4,
+	"DATALOAD":       DATALOAD,
+	"DATALOADN":      DATALOADN,
+	"DATASIZE":       DATASIZE,
+	"DATACOPY":       DATACOPY,
+	"RJUMP":          RJUMP,
+	"RJUMPI":         RJUMPI,
+	"RJUMPV":         RJUMPV,
+	"CALLF":          CALLF,
+	"RETF":           RETF,
+	"DUPN":           DUPN,
+	"SWAPN":          SWAPN,
+	"EXCHANGE":       EXCHANGE,
 	"CREATE":         CREATE,
 	"CREATE2":        CREATE2,
 	"CALL":           CALL,
@@ -553,5 +606,76 @@ var stringToOp = map[string]OpCode{
 
 // StringToOp finds the opcode whose name is stored in `str`.
 func StringToOp(str string) OpCode {
-	return stringToOp[str]
+	if op, ok := stringToOp[str]; ok {
+		return op
+	}
+	op, _ := customOpCodes.op(str)
+	return op
+}
+
+// customOpCodes holds the names of the opcodes registered with
+// RegisterOpCode. The builtin names are never modified, so that they can be
+// read without locking.
+var customOpCodes = opCodeNames{
+	names: make(map[OpCode]string),
+	ops:   make(map[string]OpCode),
+}
+
+// opCodeNames is a concurrency safe mapping of opcodes to names.
+type opCodeNames struct {
+	lock  sync.RWMutex
+	names map[OpCode]string
+	ops   map[string]OpCode
+}
+
+func (n *opCodeNames) name(op OpCode) string {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	return n.names[op]
+}
+
+func (n *opCodeNames) op(name string) (OpCode, bool) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	op, ok := n.ops[name]
+	return op, ok
+}
+
+// remove unregisters the name of a custom opcode.
+func (n *opCodeNames) remove(op OpCode) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	delete(n.ops, n.names[op])
+	delete(n.names, op)
+}
+
+// RegisterOpCode names a custom opcode, so that it is known to OpCode.String
+// and StringToOp. It fails if the opcode or the name are already taken. It is
+// safe for concurrent use, also with OpCode.String and StringToOp, though
+// the opcodes are usually registered during initialisation.
+func RegisterOpCode(op OpCode, name string) error {
+	if name == "" {
+		return errors.New("empty opcode name")
+	}
+	if prev, ok := opCodeToString[op]; ok {
+		return fmt.Errorf("opcode %#x already registered as %s", int(op), prev)
+	}
+	if prev, ok := stringToOp[name]; ok {
+		return fmt.Errorf("opcode name %s already registered for %#x", name, int(prev))
+	}
+	customOpCodes.lock.Lock()
+	defer customOpCodes.lock.Unlock()
+
+	if prev, ok := customOpCodes.names[op]; ok {
+		return fmt.Errorf("opcode %#x already registered as %s", int(op), prev)
+	}
+	if prev, ok := customOpCodes.ops[name]; ok {
+		return fmt.Errorf("opcode name %s already registered for %#x", name, int(prev))
+	}
+	customOpCodes.names[op] = name
+	customOpCodes.ops[name] = op
+	return nil
 }
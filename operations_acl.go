@@ -23,7 +23,7 @@ import (
 	"github.com/lyonnee/evm/params"
 )
 
-func makeGasSStoreFunc(clearingRefund uint64) gasFunc {
+func makeGasSStoreFunc(clearingRefund uint64) GasFunc {
 	return func(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
 		if contract.Gas <= params.SstoreSentryGasEIP2200 {
 			return 0, errors.New("not enough gas for reentrancy sentry")
@@ -123,9 +123,9 @@ func gasEip2929AccountCheck(evm *EVM, contract *Contract, stack *Stack, mem *Mem
 	return 0, nil
 }
 
-func makeCallVariantGasCallEIP2929(oldCalculator gasFunc) gasFunc {
+func makeCallVariantGasCallEIP2929(oldCalculator GasFunc) GasFunc {
 	return func(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
-		addr := BytesToAddr(stack.peek().Bytes())
+		addr := BytesToAddr(stack.Back(1).Bytes())
 		// Check slot presence in the access list
 		warmAccess := evm.StateDB.AddressInAccessList(addr)
 		// The WarmStorageReadCostEIP2929 (100) is already deducted in the form of a constant cost, so
@@ -157,6 +157,66 @@ func makeCallVariantGasCallEIP2929(oldCalculator gasFunc) gasFunc {
 	}
 }
 
+// makeCallVariantGasCallEIP7702 extends the EIP-2929 call gas with the access
+// of the delegate when the callee's code is an EIP-7702 delegation designator,
+// charged warm or cold as the delegate is in the access list or not.
+func makeCallVariantGasCallEIP7702(oldCalculator GasFunc) GasFunc {
+	return func(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
+		var (
+			total uint64 // dynamic gas charged before the old calculator
+			addr  = BytesToAddr(stack.Back(1).Bytes())
+		)
+		// The WarmStorageReadCostEIP2929 (100) is already deducted in the form of a constant cost, so
+		// the cost to charge for cold access, if any, is Cold - Warm
+		if !evm.StateDB.AddressInAccessList(addr) {
+			evm.StateDB.AddAddressToAccessList(addr)
+			coldCost := params.ColdAccountAccessCostEIP2929 - params.WarmStorageReadCostEIP2929
+			// Charge the remaining difference here already, to correctly calculate available
+			// gas for call
+			if !contract.UseGas(coldCost) {
+				return 0, ErrOutOfGas
+			}
+			total += coldCost
+		}
+		// Resolving the delegation is an extra account access
+		if target, ok := ParseDelegation(evm.StateDB.GetCode(addr)); ok {
+			cost := params.WarmStorageReadCostEIP2929
+			if !evm.StateDB.AddressInAccessList(target) {
+				evm.StateDB.AddAddressToAccessList(target)
+				cost = params.ColdAccountAccessCostEIP2929
+			}
+			if !contract.UseGas(cost) {
+				return 0, ErrOutOfGas
+			}
+			total += cost
+		}
+		// Now call the old calculator, which takes into account
+		// - create new account
+		// - transfer value
+		// - memory expansion
+		// - 63/64ths rule
+		gas, err := oldCalculator(evm, contract, stack, mem, memorySize)
+		if err != nil {
+			return gas, err
+		}
+		// The charges are temporarily given back and returned as part of the
+		// dynamic gas, so that tracers report them.
+		contract.Gas += total
+		var overflow bool
+		if gas, overflow = math.SafeAdd(gas, total); overflow {
+			return 0, ErrGasUintOverflow
+		}
+		return gas, nil
+	}
+}
+
+var (
+	gasCallEIP7702         = makeCallVariantGasCallEIP7702(gasCall)
+	gasDelegateCallEIP7702 = makeCallVariantGasCallEIP7702(gasDelegateCall)
+	gasStaticCallEIP7702   = makeCallVariantGasCallEIP7702(gasStaticCall)
+	gasCallCodeEIP7702     = makeCallVariantGasCallEIP7702(gasCallCode)
+)
+
 var (
 	gasCallEIP2929         = makeCallVariantGasCallEIP2929(gasCall)
 	gasDelegateCallEIP2929 = makeCallVariantGasCallEIP2929(gasDelegateCall)
@@ -185,7 +245,7 @@ var (
 	gasSStoreEIP3529 = makeGasSStoreFunc(params.SstoreClearsScheduleRefundEIP3529)
 )
 
-func makeSelfdestructGasFn(refundsEnabled bool) gasFunc {
+func makeSelfdestructGasFn(refundsEnabled bool) GasFunc {
 	gasFunc := func(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error) {
 		var (
 			gas     uint64
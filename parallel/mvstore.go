@@ -0,0 +1,331 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package parallel
+
+import (
+	"bytes"
+	"math/big"
+	"sync"
+
+	"github.com/lyonnee/evm"
+)
+
+// keyKind is the kind of state item a stateKey designates.
+type keyKind uint8
+
+const (
+	existsKey  keyKind = iota // whether the account exists
+	balanceKey                // balance of the account
+	nonceKey                  // nonce of the account
+	codeKey                   // code and code hash of the account
+	storageKey                // storage slot of the account
+	wipeKey                   // storage reset, by the creation or deletion of the account
+)
+
+// stateKey is an item of the state read and written by the transactions.
+type stateKey struct {
+	kind keyKind
+	addr evm.Address
+	slot evm.Hash // storage slot, storageKey only
+}
+
+// codeValue is the value of a codeKey.
+type codeValue struct {
+	code []byte
+	hash evm.Hash
+}
+
+// baseVersion is the version of the values read from the state the block is
+// executed on, before any transaction.
+const baseVersion = -1
+
+// version is a value written by the transaction of index tx.
+type version struct {
+	tx    int
+	value interface{}
+}
+
+// mvStore is the multi-version store of a block: every committed
+// transaction adds a version of the items it changed, so that a transaction
+// reads the state as left by the transactions before it in the block, and
+// falls through to the base state for the items they didn't change.
+//
+// The versions are only added in between the execution rounds, the store is
+// safe for concurrent reads while the transactions are executed.
+type mvStore struct {
+	base          evm.StateDB
+	hasher        evm.Hasher
+	emptyCodeHash evm.Hash
+
+	mu    sync.Mutex // protects base and cache
+	cache map[stateKey]interface{}
+
+	versions map[stateKey][]version // versions of each item, in transaction order
+}
+
+func newMVStore(base evm.StateDB, hasher evm.Hasher) *mvStore {
+	return &mvStore{
+		base:          base,
+		hasher:        hasher,
+		emptyCodeHash: evm.HashWith(hasher, nil),
+		cache:         make(map[stateKey]interface{}),
+		versions:      make(map[stateKey][]version),
+	}
+}
+
+// load returns the value of key in the base state.
+func (s *mvStore) load(key stateKey) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if value, ok := s.cache[key]; ok {
+		return value
+	}
+	var value interface{}
+	switch key.kind {
+	case existsKey:
+		value = s.base.Exist(key.addr)
+	case balanceKey:
+		value = s.base.GetBalance(key.addr)
+	case nonceKey:
+		value = s.base.GetNonce(key.addr)
+	case codeKey:
+		value = codeValue{code: s.base.GetCode(key.addr), hash: s.base.GetCodeHash(key.addr)}
+	case storageKey:
+		value = s.base.GetState(key.addr, key.slot)
+	}
+	s.cache[key] = value
+	return value
+}
+
+// latest returns the last version of key written before the transaction of
+// index tx, or nil and baseVersion if there is none.
+func (s *mvStore) latest(key stateKey, tx int) (interface{}, int) {
+	versions := s.versions[key]
+	for i := len(versions) - 1; i >= 0; i-- {
+		if versions[i].tx < tx {
+			return versions[i].value, versions[i].tx
+		}
+	}
+	return nil, baseVersion
+}
+
+// read returns the value of key as seen by the transaction of index tx, along
+// with the index of the transaction which wrote it.
+func (s *mvStore) read(key stateKey, tx int) (interface{}, int) {
+	if key.kind == storageKey {
+		// A slot written after the last reset of the storage keeps its
+		// value, the reset clears the others
+		_, wiped := s.latest(stateKey{kind: wipeKey, addr: key.addr}, tx)
+		value, written := s.latest(key, tx)
+		if written != baseVersion && written >= wiped {
+			return value, written
+		}
+		if wiped != baseVersion {
+			return evm.Hash{}, wiped
+		}
+		return s.load(key), baseVersion
+	}
+	if value, written := s.latest(key, tx); written != baseVersion {
+		return value, written
+	}
+	if key.kind == wipeKey {
+		return nil, baseVersion
+	}
+	return s.load(key), baseVersion
+}
+
+// exists, balance, nonce, code and storage return the items of an account
+// as seen by the transaction of index tx.
+
+func (s *mvStore) exists(addr evm.Address, tx int) bool {
+	value, _ := s.read(stateKey{kind: existsKey, addr: addr}, tx)
+	return value.(bool)
+}
+
+func (s *mvStore) balance(addr evm.Address, tx int) *big.Int {
+	value, _ := s.read(stateKey{kind: balanceKey, addr: addr}, tx)
+	return value.(*big.Int)
+}
+
+func (s *mvStore) nonce(addr evm.Address, tx int) uint64 {
+	value, _ := s.read(stateKey{kind: nonceKey, addr: addr}, tx)
+	return value.(uint64)
+}
+
+func (s *mvStore) code(addr evm.Address, tx int) codeValue {
+	value, _ := s.read(stateKey{kind: codeKey, addr: addr}, tx)
+	return value.(codeValue)
+}
+
+func (s *mvStore) storage(addr evm.Address, slot evm.Hash, tx int) evm.Hash {
+	value, _ := s.read(stateKey{kind: storageKey, addr: addr, slot: slot}, tx)
+	return value.(evm.Hash)
+}
+
+// validate reports whether the items read by the transaction of st still
+// have the version it observed. If so, executing the transaction again
+// would read the same values and make the same changes.
+func (s *mvStore) validate(st *txState) bool {
+	for key, observed := range st.reads {
+		if _, written := s.read(key, st.index); written != observed {
+			return false
+		}
+	}
+	return true
+}
+
+// set adds a version of key written by the transaction of index tx.
+func (s *mvStore) set(key stateKey, tx int, value interface{}) {
+	s.versions[key] = append(s.versions[key], version{tx: tx, value: value})
+}
+
+// update adds a version of key written by the transaction of index tx if
+// the value changed, so that the transactions which read the previous value
+// aren't invalidated for nothing.
+func (s *mvStore) update(key stateKey, tx int, value interface{}) {
+	prev, _ := s.read(key, tx)
+	var equal bool
+	switch key.kind {
+	case balanceKey:
+		equal = prev.(*big.Int).Cmp(value.(*big.Int)) == 0
+	case codeKey:
+		equal = prev.(codeValue).hash == value.(codeValue).hash && bytes.Equal(prev.(codeValue).code, value.(codeValue).code)
+	default:
+		equal = prev == value
+	}
+	if !equal {
+		s.set(key, tx, value)
+	}
+}
+
+// commit adds the changes of the transaction of st to the store, finalising
+// its accounts the way a StateDB does at the end of a transaction: the
+// self-destructed accounts are deleted, as well as the touched empty ones if
+// deleteEmptyObjects is set.
+func (s *mvStore) commit(st *txState, deleteEmptyObjects bool) {
+	tx := st.index
+	for addr, acc := range st.accounts {
+		if st.dirties[addr] == 0 {
+			continue
+		}
+		// The items the transaction didn't read are resolved against the
+		// state it is committed on
+		balance := acc.balance
+		if balance == nil {
+			balance = s.balance(addr, tx)
+			if acc.balanceDelta != nil {
+				balance = new(big.Int).Add(balance, acc.balanceDelta)
+			}
+		}
+		nonce := acc.nonce
+		if !acc.nonceKnown {
+			nonce = s.nonce(addr, tx)
+		}
+		code := acc.code
+		if !acc.codeKnown {
+			code = s.code(addr, tx)
+		}
+		if code.hash == (evm.Hash{}) {
+			code.hash = s.emptyCodeHash
+		}
+		if acc.selfDestructed || (deleteEmptyObjects && nonce == 0 && balance.Sign() == 0 && len(code.code) == 0) {
+			s.update(stateKey{kind: existsKey, addr: addr}, tx, false)
+			s.update(stateKey{kind: balanceKey, addr: addr}, tx, new(big.Int))
+			s.update(stateKey{kind: nonceKey, addr: addr}, tx, uint64(0))
+			s.update(stateKey{kind: codeKey, addr: addr}, tx, codeValue{})
+			s.set(stateKey{kind: wipeKey, addr: addr}, tx, nil)
+			continue
+		}
+		s.update(stateKey{kind: existsKey, addr: addr}, tx, true)
+		s.update(stateKey{kind: balanceKey, addr: addr}, tx, balance)
+		s.update(stateKey{kind: nonceKey, addr: addr}, tx, nonce)
+		s.update(stateKey{kind: codeKey, addr: addr}, tx, code)
+		if acc.wiped {
+			s.set(stateKey{kind: wipeKey, addr: addr}, tx, nil)
+		}
+		for slot, value := range acc.storage {
+			key := stateKey{kind: storageKey, addr: addr, slot: slot}
+			if acc.wiped {
+				// The slots are compared to their value before the reset
+				if value != (evm.Hash{}) {
+					s.set(key, tx, value)
+				}
+				continue
+			}
+			s.update(key, tx, value)
+		}
+	}
+}
+
+// flush writes the state left by the first n transactions of the block to
+// the base state, which has to be finalised afterwards.
+func (s *mvStore) flush(n int) {
+	type changes struct {
+		wiped bool
+		nonce bool
+		code  bool
+		slots []evm.Hash
+	}
+	accounts := make(map[evm.Address]*changes)
+	for key := range s.versions {
+		c := accounts[key.addr]
+		if c == nil {
+			c = new(changes)
+			accounts[key.addr] = c
+		}
+		switch key.kind {
+		case wipeKey:
+			c.wiped = true
+		case nonceKey:
+			c.nonce = true
+		case codeKey:
+			c.code = true
+		case storageKey:
+			c.slots = append(c.slots, key.slot)
+		}
+	}
+	for addr, c := range accounts {
+		if !s.exists(addr, n) {
+			if s.base.Exist(addr) {
+				s.base.SelfDestruct(addr)
+			}
+			continue
+		}
+		// Recreating the account resets its storage, the balance being
+		// carried over
+		recreated := c.wiped || !s.base.Exist(addr)
+		if recreated {
+			s.base.CreateAccount(addr)
+		}
+		diff := new(big.Int).Sub(s.balance(addr, n), s.base.GetBalance(addr))
+		if diff.Sign() >= 0 {
+			s.base.AddBalance(addr, diff)
+		} else {
+			s.base.SubBalance(addr, diff.Neg(diff))
+		}
+		if c.nonce || recreated {
+			s.base.SetNonce(addr, s.nonce(addr, n))
+		}
+		if c.code || recreated {
+			s.base.SetCode(addr, s.code(addr, n).code)
+		}
+		for _, slot := range c.slots {
+			s.base.SetState(addr, slot, s.storage(addr, slot, n))
+		}
+	}
+}
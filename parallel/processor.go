@@ -0,0 +1,181 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package parallel implements an optimistic parallel executor of the
+// transactions of a block, in the spirit of Block-STM.
+//
+// The transactions are executed concurrently, each against a view of the
+// state recording the items it reads and writes. They are then committed in
+// block order, a transaction whose reads were changed by the transactions
+// committed before it being executed again. The resulting state, receipts
+// and logs are identical to a sequential execution.
+package parallel
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/params"
+)
+
+// txResult is the outcome of an execution of a transaction.
+type txResult struct {
+	state  *txState
+	result *evm.ExecutionResult
+	err    error
+}
+
+// Processor executes the transactions of blocks in parallel, each worker
+// using its own EVM. It is a drop-in replacement of evm.Processor.
+type Processor struct {
+	config   *params.ChainConfig
+	chain    evm.ChainContext
+	vmConfig evm.Config
+	workers  int
+
+	// Reward credits the block rewards, such as the ethash miner and uncle
+	// rewards, once the transactions are applied. Nil credits nothing.
+	Reward func(header *evm.Header, statedb evm.StateDB)
+}
+
+// NewProcessor returns a Processor of the blocks of the given chain, which
+// executes the transactions with the given number of workers, GOMAXPROCS if
+// zero. The chain is accessed concurrently by the workers.
+//
+// Tracers aren't safe for concurrent use and would see the executions
+// discarded on conflicts, the blocks are executed sequentially when vmConfig
+// has one, so that it traces each transaction once and in order.
+func NewProcessor(config *params.ChainConfig, chain evm.ChainContext, vmConfig evm.Config, workers int) *Processor {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	return &Processor{
+		config:   config,
+		chain:    chain,
+		vmConfig: vmConfig,
+		workers:  workers,
+	}
+}
+
+// Process executes the block with the given header, transactions and
+// withdrawals against statedb, with the semantics of evm.Processor.Process.
+//
+// The receipts of the blocks before Byzantium carry the intermediate state
+// roots, such blocks are executed sequentially, as are the traced ones.
+func (p *Processor) Process(header *evm.Header, txs []*evm.Transaction, withdrawals []*evm.Withdrawal, statedb evm.BlockStateDB) (*evm.ProcessResult, error) {
+	var (
+		vm    = evm.NewEVM(evm.NewBlockContext(header, p.chain), evm.TxContext{}, statedb, p.config, p.vmConfig)
+		rules = vm.ChainRules()
+	)
+	if !rules.IsByzantium || p.vmConfig.Tracer != nil {
+		sequential := evm.NewProcessor(p.config, p.chain, p.vmConfig)
+		sequential.Reward = p.Reward
+		return sequential.Process(header, txs, withdrawals, statedb)
+	}
+	if withdrawals != nil && !rules.IsShanghai {
+		return nil, errors.New("withdrawals before shanghai")
+	}
+	for i, tx := range txs {
+		if tx.Message.GasPrice == nil {
+			return nil, fmt.Errorf("could not apply tx %d [%x]: missing gas price", i, tx.Hash)
+		}
+	}
+	if err := evm.ProcessParentBlockHash(header.ParentHash, vm); err != nil {
+		return nil, fmt.Errorf("could not process parent block hash: %w", err)
+	}
+	statedb.Finalise(rules.IsEIP158)
+
+	var (
+		store   = newMVStore(statedb, vm.Hasher())
+		results = make([]*txResult, len(txs))
+		gp      = new(evm.GasPool).AddGas(header.GasLimit)
+		builder = evm.NewReceiptBuilder(header.Number, vm.AddressDeriver())
+	)
+	for next := 0; next < len(txs); {
+		p.execute(header, txs, results, next, store)
+
+		// Commit the transactions in order up to the first one which read
+		// items changed since it was executed. The first one read the state
+		// left by the committed transactions, so there is always progress.
+		for ; next < len(txs); next++ {
+			res, tx := results[next], txs[next]
+			if !store.validate(res.state) {
+				results[next] = nil
+				break
+			}
+			if res.err != nil {
+				return nil, fmt.Errorf("could not apply tx %d [%x]: %w", next, tx.Hash, res.err)
+			}
+			// Each execution had a gas pool of its own
+			if gp.Gas() < tx.Message.GasLimit {
+				return nil, fmt.Errorf("could not apply tx %d [%x]: %w", next, tx.Hash, evm.ErrGasLimitReached)
+			}
+			gp.SubGas(res.result.UsedGas)
+
+			store.commit(res.state, true)
+			for _, log := range res.state.logs {
+				statedb.AddLog(log)
+			}
+			for hash, preimage := range res.state.preimages {
+				statedb.AddPreimage(hash, preimage)
+			}
+			builder.Add(tx.Hash, tx.Type, tx.Message, res.result, res.state.logs)
+		}
+	}
+	store.flush(len(txs))
+	statedb.Finalise(true)
+
+	return evm.FinalizeBlock(header, withdrawals, p.Reward, statedb, rules, builder), nil
+}
+
+// execute executes concurrently the transactions from next on which have no
+// result, against the state left by the committed transactions.
+func (p *Processor) execute(header *evm.Header, txs []*evm.Transaction, results []*txResult, next int, store *mvStore) {
+	jobs := make(chan int)
+	go func() {
+		for i := next; i < len(txs); i++ {
+			if results[i] == nil {
+				jobs <- i
+			}
+		}
+		close(jobs)
+	}()
+	var wg sync.WaitGroup
+	for w := 0; w < p.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// The EVM isn't safe for concurrent use, nor is the cache of the
+			// block hashes of the block context
+			var vm *evm.EVM
+			for i := range jobs {
+				state := newTxState(store, i)
+				if vm == nil {
+					vm = evm.NewEVM(evm.NewBlockContext(header, p.chain), evm.TxContext{}, state, p.config, p.vmConfig)
+				}
+				msg := txs[i].Message
+				vm.Reset(evm.NewTxContext(msg), state)
+				result, err := evm.ApplyMessage(vm, msg, new(evm.GasPool).AddGas(header.GasLimit))
+				results[i] = &txResult{state: state, result: result, err: err}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,275 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package parallel
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/memstate"
+	"github.com/lyonnee/evm/params"
+)
+
+type blockProcessor interface {
+	Process(header *evm.Header, txs []*evm.Transaction, withdrawals []*evm.Withdrawal, statedb evm.BlockStateDB) (*evm.ProcessResult, error)
+}
+
+var (
+	senders = []evm.Address{
+		evm.BytesToAddr([]byte("sender0")),
+		evm.BytesToAddr([]byte("sender1")),
+		evm.BytesToAddr([]byte("sender2")),
+		evm.BytesToAddr([]byte("sender3")),
+		evm.BytesToAddr([]byte("sender4")),
+	}
+	recipient = evm.BytesToAddr([]byte("recipient"))
+	fresh     = evm.BytesToAddr([]byte("fresh"))
+	coinbase  = evm.BytesToAddr([]byte("coinbase"))
+
+	// PUSH1 0 SLOAD PUSH1 1 ADD PUSH1 0 SSTORE, PUSH1 0 PUSH1 0 LOG0 STOP
+	counter = evm.BytesToAddr([]byte("counter"))
+	// COINBASE BALANCE PUSH1 0 SSTORE STOP
+	coinbaseReader = evm.BytesToAddr([]byte("coinbaseReader"))
+	// CALLER SELFDESTRUCT
+	destructor = evm.BytesToAddr([]byte("destructor"))
+	// PUSH1 1 PUSH1 0 SSTORE, PUSH1 0 PUSH1 0 SSTORE STOP
+	refunder = evm.BytesToAddr([]byte("refunder"))
+	// PUSH1 1 PUSH1 0 TSTORE, PUSH1 0 TLOAD PUSH1 0 SSTORE STOP
+	transient = evm.BytesToAddr([]byte("transient"))
+	// PUSH1 0 PUSH1 0 REVERT
+	reverter = evm.BytesToAddr([]byte("reverter"))
+)
+
+func newTestState() *memstate.StateDB {
+	alloc := memstate.GenesisAlloc{
+		counter:        {Code: evm.Hex2Bytes("60005460010160005560006000a000"), Balance: new(big.Int)},
+		coinbaseReader: {Code: evm.Hex2Bytes("413160005500"), Balance: new(big.Int)},
+		destructor:     {Code: evm.Hex2Bytes("33ff"), Balance: big.NewInt(1000)},
+		refunder:       {Code: evm.Hex2Bytes("600160005560006000550000"), Balance: new(big.Int)},
+		transient:      {Code: evm.Hex2Bytes("600160005d60005c60005500"), Balance: new(big.Int)},
+		reverter:       {Code: evm.Hex2Bytes("60006000fd"), Balance: new(big.Int)},
+	}
+	for _, sender := range senders {
+		alloc[sender] = memstate.GenesisAccount{Balance: big.NewInt(1e18)}
+	}
+	return memstate.NewFromAlloc(alloc)
+}
+
+func newTestTx(from int, nonce uint64, to *evm.Address, value int64, data []byte) *evm.Transaction {
+	return &evm.Transaction{
+		Hash: evm.Keccak256Hash([]byte{byte(from), byte(nonce)}),
+		Type: evm.DynamicFeeTxType,
+		Message: &evm.Message{
+			From:      senders[from],
+			To:        to,
+			Nonce:     nonce,
+			Value:     big.NewInt(value),
+			Data:      data,
+			GasLimit:  200_000,
+			GasPrice:  big.NewInt(10),
+			GasFeeCap: big.NewInt(10),
+			GasTipCap: big.NewInt(3),
+		},
+	}
+}
+
+// newTestBlock returns a block mixing independent transactions with ones
+// depending on each other through nonces, balances, storage slots and the
+// coinbase.
+func newTestBlock() []*evm.Transaction {
+	var (
+		// SSTORE 42 at slot 0 and deploy the code 00
+		storer = evm.Hex2Bytes("602a60005560016000f3")
+		// CALLER SELFDESTRUCT within the creation
+		suicider = evm.Hex2Bytes("33ff")
+		sender1  = senders[1]
+	)
+	return []*evm.Transaction{
+		newTestTx(0, 0, &recipient, 1, nil),
+		newTestTx(1, 0, &counter, 0, nil),
+		newTestTx(2, 0, &counter, 0, nil),
+		newTestTx(0, 1, &recipient, 2, nil),
+		newTestTx(3, 0, nil, 0, storer),
+		newTestTx(2, 1, nil, 5, suicider),
+		newTestTx(3, 1, &fresh, 0, nil),
+		newTestTx(1, 1, &destructor, 0, nil),
+		newTestTx(2, 2, &reverter, 0, nil),
+		newTestTx(3, 2, &coinbaseReader, 0, nil),
+		newTestTx(0, 2, &refunder, 0, nil),
+		newTestTx(1, 2, &transient, 0, nil),
+		newTestTx(0, 3, &sender1, 1e17, nil),
+		newTestTx(1, 3, &counter, 0, nil),
+		newTestTx(3, 3, &coinbaseReader, 0, nil),
+	}
+}
+
+var testHeader = &evm.Header{
+	Coinbase:   coinbase,
+	Number:     big.NewInt(1),
+	GasLimit:   30_000_000,
+	Time:       1,
+	Difficulty: new(big.Int),
+	BaseFee:    big.NewInt(7),
+}
+
+func process(t *testing.T, p blockProcessor, txs []*evm.Transaction) (*evm.ProcessResult, evm.Hash) {
+	t.Helper()
+	statedb := newTestState()
+	result, err := p.Process(testHeader, txs, []*evm.Withdrawal{{Address: recipient, Amount: 1}}, statedb)
+	if err != nil {
+		t.Fatalf("block processing failed: %v", err)
+	}
+	return result, statedb.IntermediateRoot(true)
+}
+
+func TestProcessor(t *testing.T) {
+	config, err := params.ForkConfig("Cancun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs := newTestBlock()
+	want, wantRoot := process(t, evm.NewProcessor(config, nil, evm.Config{}), txs)
+
+	for _, workers := range []int{1, 2, 4, 16} {
+		// Several runs to shake out scheduling dependent outcomes
+		for run := 0; run < 5; run++ {
+			have, root := process(t, NewProcessor(config, nil, evm.Config{}, workers), txs)
+			if root != wantRoot {
+				t.Fatalf("workers %d: state root mismatch: have %x, want %x", workers, root, wantRoot)
+			}
+			if have.GasUsed != want.GasUsed || have.Bloom != want.Bloom {
+				t.Errorf("workers %d: gas used or bloom mismatch: have %d, want %d", workers, have.GasUsed, want.GasUsed)
+			}
+			if !reflect.DeepEqual(have.Logs, want.Logs) {
+				t.Errorf("workers %d: logs mismatch: have %+v, want %+v", workers, have.Logs, want.Logs)
+			}
+			for i := range want.Receipts {
+				haveEnc, _ := have.Receipts[i].MarshalBinary()
+				wantEnc, _ := want.Receipts[i].MarshalBinary()
+				if !bytes.Equal(haveEnc, wantEnc) || have.Receipts[i].ContractAddress != want.Receipts[i].ContractAddress {
+					t.Errorf("workers %d: receipt %d mismatch", workers, i)
+				}
+			}
+		}
+	}
+}
+
+// txTracer records the transactions it sees, it isn't safe for concurrent
+// use.
+type txTracer struct {
+	starts, ends int
+	senders      []evm.Address
+}
+
+func (t *txTracer) CaptureTxStart(gasLimit uint64) { t.starts++ }
+func (t *txTracer) CaptureTxEnd(restGas uint64)    { t.ends++ }
+func (t *txTracer) CaptureStart(env *evm.EVM, from evm.Address, to evm.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.senders = append(t.senders, from)
+}
+func (t *txTracer) CaptureEnd(output []byte, gasUsed uint64, err error) {}
+func (t *txTracer) CaptureEnter(typ evm.OpCode, from evm.Address, to evm.Address, input []byte, gas uint64, value *big.Int) {
+}
+func (t *txTracer) CaptureExit(output []byte, gasUsed uint64, err error) {}
+func (t *txTracer) CaptureState(pc uint64, op evm.OpCode, gas, cost uint64, scope *evm.ScopeContext, rData []byte, depth int, err error) {
+}
+func (t *txTracer) CaptureFault(pc uint64, op evm.OpCode, gas, cost uint64, scope *evm.ScopeContext, depth int, err error) {
+}
+
+func TestProcessorTracer(t *testing.T) {
+	config, _ := params.ForkConfig("Cancun")
+	txs := newTestBlock()
+	_, wantRoot := process(t, evm.NewProcessor(config, nil, evm.Config{}), txs)
+
+	// The traced blocks are executed sequentially, the tracer sees every
+	// transaction once and in order
+	tracer := new(txTracer)
+	_, root := process(t, NewProcessor(config, nil, evm.Config{Tracer: tracer}, 4), txs)
+	if root != wantRoot {
+		t.Fatalf("state root mismatch: have %x, want %x", root, wantRoot)
+	}
+	if tracer.starts != len(txs) || tracer.ends != len(txs) {
+		t.Errorf("traced transactions mismatch: have %d started and %d ended, want %d", tracer.starts, tracer.ends, len(txs))
+	}
+	if len(tracer.senders) != len(txs) {
+		t.Fatalf("traced calls mismatch: have %d, want %d", len(tracer.senders), len(txs))
+	}
+	for i, tx := range txs {
+		if tracer.senders[i] != tx.Message.From {
+			t.Errorf("tx %d: traced sender mismatch: have %x, want %x", i, tracer.senders[i], tx.Message.From)
+		}
+	}
+}
+
+func TestProcessorConflicts(t *testing.T) {
+	config, _ := params.ForkConfig("Cancun")
+	txs := []*evm.Transaction{
+		newTestTx(0, 0, &recipient, 1, nil),
+		newTestTx(1, 0, &counter, 0, nil),
+		newTestTx(2, 0, &counter, 0, nil),
+		newTestTx(3, 0, &fresh, 0, nil),
+		newTestTx(0, 1, &recipient, 1, nil),
+		newTestTx(4, 0, &coinbaseReader, 0, nil),
+	}
+	vm := evm.NewEVM(evm.NewBlockContext(testHeader, nil), evm.TxContext{}, nil, config, evm.Config{})
+	store := newMVStore(newTestState(), vm.Hasher())
+
+	// Execute the block at once against the initial state, as the first
+	// round does, and validate in order
+	results := make([]*txResult, len(txs))
+	NewProcessor(config, nil, evm.Config{}, 4).execute(testHeader, txs, results, 0, store)
+	var conflicts []int
+	for i, res := range results {
+		if !store.validate(res.state) {
+			conflicts = append(conflicts, i)
+			continue
+		}
+		store.commit(res.state, true)
+	}
+	// The same counter, the same sender and the coinbase, credited by the
+	// transactions without reading its balance
+	if want := []int{2, 4, 5}; !reflect.DeepEqual(conflicts, want) {
+		t.Errorf("conflicts mismatch: have %v, want %v", conflicts, want)
+	}
+}
+
+func TestProcessorErrors(t *testing.T) {
+	config, _ := params.ForkConfig("Cancun")
+	p := NewProcessor(config, nil, evm.Config{}, 4)
+
+	// A transaction invalid once the ones before it are applied aborts the
+	// block, even if it was valid when executed speculatively
+	txs := newTestBlock()
+	txs = append(txs, newTestTx(0, 3, &recipient, 0, nil))
+	if _, err := p.Process(testHeader, txs, nil, newTestState()); !errors.Is(err, evm.ErrNonceTooLow) {
+		t.Errorf("error mismatch: have %v, want %v", err, evm.ErrNonceTooLow)
+	}
+	// The block gas limit holds across the transactions
+	header := *testHeader
+	header.GasLimit = 300_000
+	if _, err := p.Process(&header, newTestBlock(), nil, newTestState()); !errors.Is(err, evm.ErrGasLimitReached) {
+		t.Errorf("error mismatch: have %v, want %v", err, evm.ErrGasLimitReached)
+	}
+	// Withdrawals are rejected before Shanghai
+	config, _ = params.ForkConfig("London")
+	if _, err := NewProcessor(config, nil, evm.Config{}, 4).Process(testHeader, nil, []*evm.Withdrawal{{}}, newTestState()); err == nil {
+		t.Error("withdrawals processed before Shanghai")
+	}
+}
@@ -0,0 +1,501 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package parallel
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/lyonnee/evm"
+)
+
+// txAccount is an account as seen by a transaction. Its items are read from
+// the store on first access, except the balance credited before being read,
+// which is recorded as a delta so that transactions paying the same account,
+// such as the coinbase, don't conflict.
+type txAccount struct {
+	existsKnown    bool
+	exists         bool
+	ensured        bool // the account was made to exist before its existence was read
+	created        bool // created within the transaction
+	wiped          bool // storage reset by CreateAccount
+	selfDestructed bool
+
+	balance      *big.Int // nil if not known yet
+	balanceDelta *big.Int // credited to the unknown balance
+	nonceKnown   bool
+	nonce        uint64
+	codeKnown    bool
+	code         codeValue
+
+	storage map[evm.Hash]evm.Hash // slots written by the transaction
+	origin  map[evm.Hash]evm.Hash // slots read from the store
+}
+
+// journalEntry undoes a change of the transaction.
+type journalEntry struct {
+	undo    func()
+	dirtied *evm.Address // account modified by the change, if any
+}
+
+// txState is the StateDB a transaction is executed against. It reads the
+// state left by the transactions before it in the block from the store,
+// recording the version of every item read, and keeps its writes to itself
+// until it is committed.
+type txState struct {
+	store *mvStore
+	index int // index of the transaction in the block
+
+	reads    map[stateKey]int // version of the items read
+	accounts map[evm.Address]*txAccount
+
+	journal []journalEntry
+	dirties map[evm.Address]int // accounts modified and the number of changes
+
+	refund     uint64
+	logs       []evm.Log
+	preimages  map[evm.Hash][]byte
+	accessList map[evm.Address]map[evm.Hash]struct{}
+	transient  map[evm.Address]map[evm.Hash]evm.Hash
+}
+
+func newTxState(store *mvStore, index int) *txState {
+	return &txState{
+		store:      store,
+		index:      index,
+		reads:      make(map[stateKey]int),
+		accounts:   make(map[evm.Address]*txAccount),
+		dirties:    make(map[evm.Address]int),
+		preimages:  make(map[evm.Hash][]byte),
+		accessList: make(map[evm.Address]map[evm.Hash]struct{}),
+		transient:  make(map[evm.Address]map[evm.Hash]evm.Hash),
+	}
+}
+
+// read returns the value of key in the store, recording its version.
+func (s *txState) read(key stateKey) interface{} {
+	value, written := s.store.read(key, s.index)
+	s.reads[key] = written
+	return value
+}
+
+func (s *txState) append(entry journalEntry) {
+	s.journal = append(s.journal, entry)
+	if entry.dirtied != nil {
+		s.dirties[*entry.dirtied]++
+	}
+}
+
+// change journals a change of the account at addr, which is undone by
+// restoring the account as it was before.
+func (s *txState) change(addr evm.Address, acc *txAccount, prev txAccount) {
+	s.append(journalEntry{
+		undo:    func() { *acc = prev },
+		dirtied: &addr,
+	})
+}
+
+func (s *txState) account(addr evm.Address) *txAccount {
+	acc := s.accounts[addr]
+	if acc == nil {
+		acc = &txAccount{storage: make(map[evm.Hash]evm.Hash), origin: make(map[evm.Hash]evm.Hash)}
+		s.accounts[addr] = acc
+	}
+	return acc
+}
+
+// resolveExists reads whether the account exists, if not known yet.
+func (s *txState) resolveExists(addr evm.Address, acc *txAccount) {
+	if acc.existsKnown {
+		return
+	}
+	exists := s.read(stateKey{kind: existsKey, addr: addr}).(bool)
+	acc.existsKnown, acc.exists = true, exists || acc.ensured
+	if acc.ensured && !exists {
+		s.initCreated(acc)
+	}
+}
+
+// initCreated marks the account as just created, with the items of an
+// empty account not set by the transaction yet.
+func (s *txState) initCreated(acc *txAccount) {
+	acc.created = true
+	if !acc.nonceKnown {
+		acc.nonceKnown, acc.nonce = true, 0
+	}
+	if !acc.codeKnown {
+		acc.codeKnown, acc.code = true, codeValue{hash: s.store.emptyCodeHash}
+	}
+}
+
+// ensure makes the account exist, without reading whether it did if not
+// known yet. The change is journaled by the caller.
+func (s *txState) ensure(acc *txAccount) {
+	switch {
+	case !acc.existsKnown:
+		acc.ensured = true
+	case !acc.exists:
+		acc.exists = true
+		s.initCreated(acc)
+	}
+}
+
+func (s *txState) getBalance(addr evm.Address, acc *txAccount) *big.Int {
+	if acc.balance == nil {
+		acc.balance = s.read(stateKey{kind: balanceKey, addr: addr}).(*big.Int)
+		if acc.balanceDelta != nil {
+			acc.balance = new(big.Int).Add(acc.balance, acc.balanceDelta)
+			acc.balanceDelta = nil
+		}
+	}
+	return acc.balance
+}
+
+func (s *txState) getCode(addr evm.Address, acc *txAccount) codeValue {
+	if !acc.codeKnown {
+		acc.codeKnown, acc.code = true, s.read(stateKey{kind: codeKey, addr: addr}).(codeValue)
+	}
+	return acc.code
+}
+
+func (s *txState) getOrigin(addr evm.Address, acc *txAccount, key evm.Hash) evm.Hash {
+	if acc.wiped {
+		return evm.Hash{}
+	}
+	value, ok := acc.origin[key]
+	if !ok {
+		value = s.read(stateKey{kind: storageKey, addr: addr, slot: key}).(evm.Hash)
+		acc.origin[key] = value
+	}
+	return value
+}
+
+// touch makes the account exist and marks it as modified, so an empty
+// account gets removed on commit.
+func (s *txState) touch(addr evm.Address) {
+	acc := s.account(addr)
+	prev := *acc
+	s.ensure(acc)
+	s.change(addr, acc, prev)
+}
+
+// CreateAccount creates the account, carrying over the balance of an
+// existing one.
+func (s *txState) CreateAccount(addr evm.Address) {
+	acc := s.account(addr)
+	s.resolveExists(addr, acc)
+	prev := *acc
+	acc.exists, acc.created, acc.wiped, acc.selfDestructed = true, true, true, false
+	acc.nonceKnown, acc.nonce = true, 0
+	acc.codeKnown, acc.code = true, codeValue{hash: s.store.emptyCodeHash}
+	acc.storage = make(map[evm.Hash]evm.Hash)
+	s.change(addr, acc, prev)
+}
+
+// SubBalance subtracts amount from the account associated with addr.
+func (s *txState) SubBalance(addr evm.Address, amount *big.Int) {
+	if amount.Sign() == 0 {
+		s.touch(addr)
+		return
+	}
+	acc := s.account(addr)
+	balance := s.getBalance(addr, acc)
+	prev := *acc
+	s.ensure(acc)
+	acc.balance = new(big.Int).Sub(balance, amount)
+	s.change(addr, acc, prev)
+}
+
+// AddBalance adds amount to the account associated with addr. The balance
+// isn't read if not known yet.
+func (s *txState) AddBalance(addr evm.Address, amount *big.Int) {
+	if amount.Sign() == 0 {
+		s.touch(addr)
+		return
+	}
+	acc := s.account(addr)
+	prev := *acc
+	s.ensure(acc)
+	switch {
+	case acc.balance != nil:
+		acc.balance = new(big.Int).Add(acc.balance, amount)
+	case acc.balanceDelta != nil:
+		acc.balanceDelta = new(big.Int).Add(acc.balanceDelta, amount)
+	default:
+		acc.balanceDelta = new(big.Int).Set(amount)
+	}
+	s.change(addr, acc, prev)
+}
+
+// GetBalance retrieves the balance of the account, 0 if it doesn't exist.
+func (s *txState) GetBalance(addr evm.Address) *big.Int {
+	return new(big.Int).Set(s.getBalance(addr, s.account(addr)))
+}
+
+// GetNonce retrieves the nonce of the account, 0 if it doesn't exist.
+func (s *txState) GetNonce(addr evm.Address) uint64 {
+	acc := s.account(addr)
+	if !acc.nonceKnown {
+		acc.nonceKnown, acc.nonce = true, s.read(stateKey{kind: nonceKey, addr: addr}).(uint64)
+	}
+	return acc.nonce
+}
+
+// SetNonce sets the nonce of the account associated with addr.
+func (s *txState) SetNonce(addr evm.Address, nonce uint64) {
+	acc := s.account(addr)
+	prev := *acc
+	s.ensure(acc)
+	acc.nonceKnown, acc.nonce = true, nonce
+	s.change(addr, acc, prev)
+}
+
+// GetCodeHash returns the code hash of the account, or the zero hash if the
+// account does not exist.
+func (s *txState) GetCodeHash(addr evm.Address) evm.Hash {
+	acc := s.account(addr)
+	if s.resolveExists(addr, acc); !acc.exists {
+		return evm.NilHash
+	}
+	return s.getCode(addr, acc).hash
+}
+
+// GetCode returns the code of the account, or nil if the account does not exist.
+func (s *txState) GetCode(addr evm.Address) []byte {
+	return s.getCode(addr, s.account(addr)).code
+}
+
+// SetCode sets the code of the account associated with addr.
+func (s *txState) SetCode(addr evm.Address, code []byte) {
+	acc := s.account(addr)
+	prev := *acc
+	s.ensure(acc)
+	acc.codeKnown, acc.code = true, codeValue{code: code, hash: evm.HashWith(s.store.hasher, code)}
+	s.change(addr, acc, prev)
+}
+
+// GetCodeSize returns the size of the account's code.
+func (s *txState) GetCodeSize(addr evm.Address) int {
+	return len(s.GetCode(addr))
+}
+
+// AddRefund adds gas to the refund counter.
+func (s *txState) AddRefund(gas uint64) {
+	prev := s.refund
+	s.append(journalEntry{undo: func() { s.refund = prev }})
+	s.refund += gas
+}
+
+// SubRefund removes gas from the refund counter.
+// This method will panic if the refund counter goes below zero
+func (s *txState) SubRefund(gas uint64) {
+	prev := s.refund
+	s.append(journalEntry{undo: func() { s.refund = prev }})
+	if gas > s.refund {
+		panic(fmt.Sprintf("refund counter below zero (gas: %d > refund: %d)", gas, s.refund))
+	}
+	s.refund -= gas
+}
+
+// GetRefund returns the current value of the refund counter.
+func (s *txState) GetRefund() uint64 {
+	return s.refund
+}
+
+// GetCommittedState retrieves the value of a storage slot at the start of
+// the transaction.
+func (s *txState) GetCommittedState(addr evm.Address, key evm.Hash) evm.Hash {
+	return s.getOrigin(addr, s.account(addr), key)
+}
+
+// GetState retrieves the current value of a storage slot, including the
+// writes of the transaction.
+func (s *txState) GetState(addr evm.Address, key evm.Hash) evm.Hash {
+	acc := s.account(addr)
+	if value, dirty := acc.storage[key]; dirty {
+		return value
+	}
+	return s.getOrigin(addr, acc, key)
+}
+
+// SetState updates a value in the account's storage.
+func (s *txState) SetState(addr evm.Address, key, value evm.Hash) {
+	acc := s.account(addr)
+	if s.resolveExists(addr, acc); !acc.exists {
+		s.touch(addr)
+	}
+	prev := s.GetState(addr, key)
+	if prev == value {
+		return
+	}
+	storage := acc.storage
+	prevValue, prevDirty := storage[key]
+	s.append(journalEntry{
+		undo: func() {
+			if prevDirty {
+				storage[key] = prevValue
+			} else {
+				delete(storage, key)
+			}
+		},
+		dirtied: &addr,
+	})
+	storage[key] = value
+}
+
+// GetTransientState gets transient storage for a given account.
+func (s *txState) GetTransientState(addr evm.Address, key evm.Hash) evm.Hash {
+	return s.transient[addr][key]
+}
+
+// SetTransientState sets transient storage for a given account.
+func (s *txState) SetTransientState(addr evm.Address, key, value evm.Hash) {
+	prev := s.GetTransientState(addr, key)
+	if prev == value {
+		return
+	}
+	s.append(journalEntry{undo: func() { s.setTransientState(addr, key, prev) }})
+	s.setTransientState(addr, key, value)
+}
+
+func (s *txState) setTransientState(addr evm.Address, key, value evm.Hash) {
+	storage := s.transient[addr]
+	if storage == nil {
+		storage = make(map[evm.Hash]evm.Hash)
+		s.transient[addr] = storage
+	}
+	storage[key] = value
+}
+
+// SelfDestruct marks the given account as self-destructed and clears its
+// balance. The account is deleted when the transaction is committed.
+func (s *txState) SelfDestruct(addr evm.Address) {
+	acc := s.account(addr)
+	if s.resolveExists(addr, acc); !acc.exists {
+		return
+	}
+	prev := *acc
+	acc.selfDestructed = true
+	acc.balance, acc.balanceDelta = new(big.Int), nil
+	s.change(addr, acc, prev)
+}
+
+// HasSelfDestructed returns whether the account was self-destructed in the
+// transaction.
+func (s *txState) HasSelfDestructed(addr evm.Address) bool {
+	return s.account(addr).selfDestructed
+}
+
+// Selfdestruct6780 self-destructs the account only if it was created within
+// the transaction, as specified by EIP-6780.
+func (s *txState) Selfdestruct6780(addr evm.Address) {
+	acc := s.account(addr)
+	if s.resolveExists(addr, acc); acc.exists && acc.created {
+		s.SelfDestruct(addr)
+	}
+}
+
+// Exist reports whether the given account address exists in the state.
+// Notably this also returns true for self-destructed accounts.
+func (s *txState) Exist(addr evm.Address) bool {
+	acc := s.account(addr)
+	s.resolveExists(addr, acc)
+	return acc.exists
+}
+
+// Empty returns whether the account is either non-existent or empty
+// according to the EIP161 specification (balance = nonce = code = 0).
+func (s *txState) Empty(addr evm.Address) bool {
+	acc := s.account(addr)
+	if s.resolveExists(addr, acc); !acc.exists {
+		return true
+	}
+	return s.GetNonce(addr) == 0 && s.getBalance(addr, acc).Sign() == 0 && len(s.getCode(addr, acc).code) == 0
+}
+
+// AddressInAccessList returns true if the given address is in the access list.
+func (s *txState) AddressInAccessList(addr evm.Address) bool {
+	_, ok := s.accessList[addr]
+	return ok
+}
+
+// SlotInAccessList returns true if the given (address, slot)-tuple is in the access list.
+func (s *txState) SlotInAccessList(addr evm.Address, slot evm.Hash) (addressPresent bool, slotPresent bool) {
+	slots, ok := s.accessList[addr]
+	if !ok {
+		return false, false
+	}
+	_, slotPresent = slots[slot]
+	return true, slotPresent
+}
+
+// AddAddressToAccessList adds the given address to the access list.
+func (s *txState) AddAddressToAccessList(addr evm.Address) {
+	if _, ok := s.accessList[addr]; ok {
+		return
+	}
+	s.accessList[addr] = make(map[evm.Hash]struct{})
+	s.append(journalEntry{undo: func() { delete(s.accessList, addr) }})
+}
+
+// AddSlotToAccessList adds the given (address, slot)-tuple to the access list.
+func (s *txState) AddSlotToAccessList(addr evm.Address, slot evm.Hash) {
+	s.AddAddressToAccessList(addr)
+	slots := s.accessList[addr]
+	if _, ok := slots[slot]; ok {
+		return
+	}
+	slots[slot] = struct{}{}
+	s.append(journalEntry{undo: func() { delete(slots, slot) }})
+}
+
+// Snapshot returns an identifier for the current revision of the state.
+func (s *txState) Snapshot() int {
+	return len(s.journal)
+}
+
+// RevertToSnapshot reverts all state changes made since the given revision.
+func (s *txState) RevertToSnapshot(revid int) {
+	if revid < 0 || revid > len(s.journal) {
+		panic(fmt.Errorf("revision id %v cannot be reverted", revid))
+	}
+	for i := len(s.journal) - 1; i >= revid; i-- {
+		entry := s.journal[i]
+		entry.undo()
+		if addr := entry.dirtied; addr != nil {
+			if s.dirties[*addr]--; s.dirties[*addr] == 0 {
+				delete(s.dirties, *addr)
+			}
+		}
+	}
+	s.journal = s.journal[:revid]
+}
+
+// AddLog records a log emitted by the execution.
+func (s *txState) AddLog(log evm.Log) {
+	s.append(journalEntry{undo: func() { s.logs = s.logs[:len(s.logs)-1] }})
+	s.logs = append(s.logs, log)
+}
+
+// AddPreimage records a SHA3 preimage seen by the VM.
+func (s *txState) AddPreimage(hash evm.Hash, preimage []byte) {
+	if _, ok := s.preimages[hash]; ok {
+		return
+	}
+	s.preimages[hash] = evm.CopyBytes(preimage)
+	s.append(journalEntry{undo: func() { delete(s.preimages, hash) }})
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package params
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// forkActivations lists the mainnet forks in order, each entry enabling its
+// fork from genesis on. The names follow the ones used by the Ethereum tests.
+var forkActivations = []struct {
+	name   string
+	enable func(c *ChainConfig)
+}{
+	{"Frontier", func(c *ChainConfig) {}},
+	{"Homestead", func(c *ChainConfig) { c.HomesteadBlock = big.NewInt(0) }},
+	{"EIP150", func(c *ChainConfig) { c.EIP150Block = big.NewInt(0) }},
+	{"EIP158", func(c *ChainConfig) { c.EIP155Block, c.EIP158Block = big.NewInt(0), big.NewInt(0) }},
+	{"Byzantium", func(c *ChainConfig) { c.ByzantiumBlock = big.NewInt(0) }},
+	{"Constantinople", func(c *ChainConfig) {
+		c.ConstantinopleBlock = big.NewInt(0)
+		// Constantinople without the EIP-1283 fix, never active on mainnet
+		c.PetersburgBlock = big.NewInt(10000000)
+	}},
+	{"ConstantinopleFix", func(c *ChainConfig) { c.PetersburgBlock = big.NewInt(0) }},
+	{"Istanbul", func(c *ChainConfig) { c.IstanbulBlock = big.NewInt(0) }},
+	{"MuirGlacier", func(c *ChainConfig) { c.MuirGlacierBlock = big.NewInt(0) }},
+	{"Berlin", func(c *ChainConfig) { c.BerlinBlock = big.NewInt(0) }},
+	{"London", func(c *ChainConfig) { c.LondonBlock = big.NewInt(0) }},
+	{"ArrowGlacier", func(c *ChainConfig) { c.ArrowGlacierBlock = big.NewInt(0) }},
+	{"GrayGlacier", func(c *ChainConfig) { c.GrayGlacierBlock = big.NewInt(0) }},
+	{"Merge", func(c *ChainConfig) { c.TerminalTotalDifficulty = big.NewInt(0) }},
+	{"Shanghai", func(c *ChainConfig) { c.ShanghaiTime = new(uint64) }},
+	{"Cancun", func(c *ChainConfig) { c.CancunTime = new(uint64) }},
+	{"Prague", func(c *ChainConfig) { c.PragueTime = new(uint64) }},
+	{"Osaka", func(c *ChainConfig) { c.OsakaTime = new(uint64) }},
+}
+
+// ForkConfig returns a mainnet chain configuration with all forks up to and
+// including the named one active from genesis on.
+func ForkConfig(name string) (*ChainConfig, error) {
+	config := &ChainConfig{ChainID: big.NewInt(1)}
+	for _, fork := range forkActivations {
+		fork.enable(config)
+		if fork.name == name {
+			return config, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported fork %q", name)
+}
+
+// AvailableForks returns the names accepted by ForkConfig, oldest first.
+func AvailableForks() []string {
+	names := make([]string, len(forkActivations))
+	for i, fork := range forkActivations {
+		names[i] = fork.name
+	}
+	return names
+}
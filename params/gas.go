@@ -75,6 +75,11 @@ const (
 	TierStepGas           uint64 = 0     // Once per operation, for a selection of them.
 	SelfdestructRefundGas uint64 = 24000 // Refunded following a selfdestruct operation.
 
+	// The Refund Quotient is the cap on how much of the used gas can be refunded. Prior to
+	// EIP-3529, refunds were capped to gasUsed / 2. After EIP-3529, refunds are capped to gasUsed / 5.
+	RefundQuotient        uint64 = 2
+	RefundQuotientEIP3529 uint64 = 5
+
 	// These have been changed during the course of the chain
 	CallGasFrontier      uint64 = 40    // Once per CALL operation & message call transaction.
 	CallValueTransferGas uint64 = 9000  // Paid for CALL when the value transfer is non-zero.
@@ -84,16 +89,26 @@ const (
 	TxGas                     uint64 = 21000 // Per transaction not creating a contract. NOTE: Not payable on data of calls between transactions.
 	TxGasContractCreation     uint64 = 53000 // Per transaction that creates a contract. NOTE: Not payable on data of calls between transactions.
 	TxDataZeroGas             uint64 = 4     // Per byte of data attached to a transaction that equals zero. NOTE: Not payable on data of calls between transactions.
+	TxDataNonZeroGasFrontier  uint64 = 68    // Per byte of data attached to a transaction that is not equal to zero. NOTE: Not payable on data of calls between transactions.
+	TxDataNonZeroGasEIP2028   uint64 = 16    // Per byte of non zero data attached to a transaction after EIP 2028 (part in Istanbul)
+	TxAccessListAddressGas    uint64 = 2400  // Per address specified in EIP 2930 access list
 	TxAccessListStorageKeyGas uint64 = 1900  // Per storage key specified in EIP 2930 access list
+	TxAuthTupleGas            uint64 = 12500 // Per auth tuple of an EIP 7702 transaction, refunded from CallNewAccountGas for existing accounts
 	BalanceGasFrontier        uint64 = 20    // The cost of a BALANCE operation
+	TxCostFloorPerToken       uint64 = 10    // Per token of calldata in the EIP-7623 floor cost
+	TxTokenPerNonZeroByte     uint64 = 4     // Tokens a non-zero calldata byte counts for under EIP-7623, a zero byte counts for one
 
 	BlobTxBytesPerFieldElement         = 32      // Size in bytes of a field element
 	BlobTxFieldElementsPerBlob         = 4096    // Number of field elements stored in a single data blob
 	BlobTxHashVersion                  = 0x01    // Version byte of the commitment hash
-	MaxBlobGasPerBlock                 = 1 << 19 // Maximum consumable blob gas for data blobs per block
-	BlobTxTargetBlobGasPerBlock        = 1 << 18 // Target consumable blob gas for data blobs per block (for 1559-like pricing)
+	MaxBlobGasPerBlock                 = 786432  // Maximum consumable blob gas for data blobs per block
+	BlobTxTargetBlobGasPerBlock        = 393216  // Target consumable blob gas for data blobs per block (for 1559-like pricing)
 	BlobTxBlobGasPerBlob               = 1 << 17 // Gas consumption of a single data blob (== blob byte size)
 	BlobTxMinBlobGasprice              = 1       // Minimum gas price for data blobs
-	BlobTxBlobGaspriceUpdateFraction   = 2225652 // Controls the maximum rate of change for blob gas price
+	BlobTxBlobGaspriceUpdateFraction   = 3338477 // Controls the maximum rate of change for blob gas price
 	BlobTxPointEvaluationPrecompileGas = 50000   // Gas price for the point evaluation precompile.
+
+	MaxBlobGasPerBlockPrague               = 1179648 // Maximum consumable blob gas per block from Prague (EIP-7691)
+	BlobTxTargetBlobGasPerBlockPrague      = 786432  // Target consumable blob gas per block from Prague (EIP-7691)
+	BlobTxBlobGaspriceUpdateFractionPrague = 5007716 // Blob gas price update fraction from Prague (EIP-7691)
 )
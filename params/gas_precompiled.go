@@ -27,4 +27,14 @@ const (
 	Bls12381PairingPerPairGas uint64 = 23000  // Per-point pair gas price for BLS12-381 elliptic curve pairing check
 	Bls12381MapG1Gas          uint64 = 5500   // Gas price for BLS12-381 mapping field element to G1 operation
 	Bls12381MapG2Gas          uint64 = 110000 // Gas price for BLS12-381 mapping field element to G2 operation
+
+	// EIP-2537 as activated in Prague
+	Bls12381G1AddGasPrague          uint64 = 375   // Price for BLS12-381 elliptic curve G1 point addition
+	Bls12381G1MulGasPrague          uint64 = 12000 // Price for BLS12-381 elliptic curve G1 point scalar multiplication, the base of the MSM cost
+	Bls12381G2AddGasPrague          uint64 = 600   // Price for BLS12-381 elliptic curve G2 point addition
+	Bls12381G2MulGasPrague          uint64 = 22500 // Price for BLS12-381 elliptic curve G2 point scalar multiplication, the base of the MSM cost
+	Bls12381PairingBaseGasPrague    uint64 = 37700 // Base gas price for BLS12-381 elliptic curve pairing check
+	Bls12381PairingPerPairGasPrague uint64 = 32600 // Per-point pair gas price for BLS12-381 elliptic curve pairing check
+	Bls12381MapG1GasPrague          uint64 = 5500  // Gas price for BLS12-381 mapping field element to G1 operation
+	Bls12381MapG2GasPrague          uint64 = 23800 // Gas price for BLS12-381 mapping field element to G2 operation
 )
@@ -23,7 +23,6 @@ import (
 	"fmt"
 	"math/big"
 
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/crypto/blake2b"
 	"github.com/ethereum/go-ethereum/crypto/bls12381"
 	bn256 "github.com/ethereum/go-ethereum/crypto/bn256/google"
@@ -48,6 +47,48 @@ type PrecompiledContract interface {
 	Run(input []byte) ([]byte, error) // Run runs the precompiled contract
 }
 
+// StatefulPrecompiledContract is a precompiled contract with access to the
+// execution environment. When a contract implements it, RunStateful is invoked
+// instead of Run, after RequiredGas has been charged.
+type StatefulPrecompiledContract interface {
+	PrecompiledContract
+	// RunStateful runs the precompiled contract. State changes are reverted by
+	// the EVM if an error is returned.
+	RunStateful(ctx *PrecompileContext, input []byte) ([]byte, error)
+}
+
+// PrecompileContext is the environment a stateful precompiled contract is
+// executed in.
+type PrecompileContext struct {
+	EVM     *EVM
+	StateDB StateDB
+
+	Caller  Address  // Caller of the precompile, the parent's caller for DELEGATECALL
+	Address Address  // Address the precompile is registered at
+	Value   *big.Int // Value sent along the call, the parent's value for DELEGATECALL
+	// StorageAddress is the account the call runs in the context of, whose
+	// storage the precompile reads and writes: Address for CALL and
+	// STATICCALL, the calling contract for CALLCODE and DELEGATECALL.
+	StorageAddress Address
+	// CallType is the kind of call, CALL, CALLCODE, DELEGATECALL or
+	// STATICCALL.
+	CallType OpCode
+	// ReadOnly is set within a STATICCALL context, state modifications must
+	// then fail with ErrWriteProtection.
+	ReadOnly bool
+	// Gas is the gas left after RequiredGas was charged.
+	Gas uint64
+}
+
+// UseGas attempts the use gas and subtracts it and returns true on success
+func (ctx *PrecompileContext) UseGas(gas uint64) (ok bool) {
+	if ctx.Gas < gas {
+		return false
+	}
+	ctx.Gas -= gas
+	return true
+}
+
 // PrecompiledContractsHomestead contains the default set of pre-compiled Ethereum
 // contracts used in the Frontier and Homestead releases.
 var PrecompiledContractsHomestead = map[Address]PrecompiledContract{
@@ -113,6 +154,29 @@ var PrecompiledContractsCancun = map[Address]PrecompiledContract{
 	BytesToAddr([]byte{0x0a}): &kzgPointEvaluation{},
 }
 
+// PrecompiledContractsPrague contains the default set of pre-compiled Ethereum
+// contracts used in the Prague release, which adds the EIP-2537 BLS12-381
+// operations.
+var PrecompiledContractsPrague = map[Address]PrecompiledContract{
+	BytesToAddr([]byte{1}):    &ecrecover{},
+	BytesToAddr([]byte{2}):    &sha256hash{},
+	BytesToAddr([]byte{3}):    &ripemd160hash{},
+	BytesToAddr([]byte{4}):    &dataCopy{},
+	BytesToAddr([]byte{5}):    &bigModExp{eip2565: true},
+	BytesToAddr([]byte{6}):    &bn256AddIstanbul{},
+	BytesToAddr([]byte{7}):    &bn256ScalarMulIstanbul{},
+	BytesToAddr([]byte{8}):    &bn256PairingIstanbul{},
+	BytesToAddr([]byte{9}):    &blake2F{},
+	BytesToAddr([]byte{0x0a}): &kzgPointEvaluation{},
+	BytesToAddr([]byte{0x0b}): &bls12381G1Add{prague: true},
+	BytesToAddr([]byte{0x0c}): &bls12381G1MultiExp{prague: true},
+	BytesToAddr([]byte{0x0d}): &bls12381G2Add{prague: true},
+	BytesToAddr([]byte{0x0e}): &bls12381G2MultiExp{prague: true},
+	BytesToAddr([]byte{0x0f}): &bls12381Pairing{prague: true},
+	BytesToAddr([]byte{0x10}): &bls12381MapG1{prague: true},
+	BytesToAddr([]byte{0x11}): &bls12381MapG2{prague: true},
+}
+
 // PrecompiledContractsBLS contains the set of pre-compiled Ethereum
 // contracts specified in EIP-2537. These are exported for testing purposes.
 var PrecompiledContractsBLS = map[Address]PrecompiledContract{
@@ -139,6 +203,13 @@ var allPrecompiles = map[Address]PrecompiledContract{
 	BytesToAddr([]byte{8}):    &bn256PairingIstanbul{},
 	BytesToAddr([]byte{9}):    &blake2F{},
 	BytesToAddr([]byte{0x0a}): &kzgPointEvaluation{},
+	BytesToAddr([]byte{0x0b}): &bls12381G1Add{prague: true},
+	BytesToAddr([]byte{0x0c}): &bls12381G1MultiExp{prague: true},
+	BytesToAddr([]byte{0x0d}): &bls12381G2Add{prague: true},
+	BytesToAddr([]byte{0x0e}): &bls12381G2MultiExp{prague: true},
+	BytesToAddr([]byte{0x0f}): &bls12381Pairing{prague: true},
+	BytesToAddr([]byte{0x10}): &bls12381MapG1{prague: true},
+	BytesToAddr([]byte{0x11}): &bls12381MapG2{prague: true},
 
 	BytesToAddr([]byte{0x0f, 0x0a}): &bls12381G1Add{},
 	BytesToAddr([]byte{0x0f, 0x0b}): &bls12381G1Mul{},
@@ -152,6 +223,7 @@ var allPrecompiles = map[Address]PrecompiledContract{
 }
 
 var (
+	PrecompiledAddressesPrague    []Address
 	PrecompiledAddressesCancun    []Address
 	PrecompiledAddressesBerlin    []Address
 	PrecompiledAddressesIstanbul  []Address
@@ -175,11 +247,46 @@ func init() {
 	for k := range PrecompiledContractsCancun {
 		PrecompiledAddressesCancun = append(PrecompiledAddressesCancun, k)
 	}
+	for k := range PrecompiledContractsPrague {
+		PrecompiledAddressesPrague = append(PrecompiledAddressesPrague, k)
+	}
+}
+
+// precompiledContracts returns the package level precompile set of the fork.
+// The returned map must not be modified.
+func precompiledContracts(rules params.Rules) map[Address]PrecompiledContract {
+	switch {
+	case rules.IsPrague:
+		return PrecompiledContractsPrague
+	case rules.IsCancun:
+		return PrecompiledContractsCancun
+	case rules.IsBerlin:
+		return PrecompiledContractsBerlin
+	case rules.IsIstanbul:
+		return PrecompiledContractsIstanbul
+	case rules.IsByzantium:
+		return PrecompiledContractsByzantium
+	default:
+		return PrecompiledContractsHomestead
+	}
+}
+
+// DefaultPrecompiles returns a copy of the precompile set of the fork, meant to
+// be extended and passed as Config.Precompiles.
+func DefaultPrecompiles(rules params.Rules) map[Address]PrecompiledContract {
+	precompiles := precompiledContracts(rules)
+	cpy := make(map[Address]PrecompiledContract, len(precompiles))
+	for addr, p := range precompiles {
+		cpy[addr] = p
+	}
+	return cpy
 }
 
 // ActivePrecompiles returns the precompiles enabled with the current configuration.
 func ActivePrecompiles(rules params.Rules) []Address {
 	switch {
+	case rules.IsPrague:
+		return PrecompiledAddressesPrague
 	case rules.IsCancun:
 		return PrecompiledAddressesCancun
 	case rules.IsBerlin:
@@ -426,10 +533,15 @@ var (
 )
 
 // bls12381G1Add implements EIP-2537 G1Add precompile.
-type bls12381G1Add struct{}
+type bls12381G1Add struct {
+	prague bool // Prices the call as activated in Prague
+}
 
 // RequiredGas returns the gas required to execute the pre-compiled contract.
 func (c *bls12381G1Add) RequiredGas(input []byte) uint64 {
+	if c.prague {
+		return params.Bls12381G1AddGasPrague
+	}
 	return params.Bls12381G1AddGas
 }
 
@@ -499,26 +611,37 @@ func (c *bls12381G1Mul) Run(input []byte) ([]byte, error) {
 	return g.EncodePoint(r), nil
 }
 
-// bls12381G1MultiExp implements EIP-2537 G1MultiExp precompile.
-type bls12381G1MultiExp struct{}
-
-// RequiredGas returns the gas required to execute the pre-compiled contract.
-func (c *bls12381G1MultiExp) RequiredGas(input []byte) uint64 {
-	// Calculate G1 point, scalar value pair length
-	k := len(input) / 160
+// bls12381MultiExpGas returns the price of a multi-scalar multiplication of k
+// pairs, discounted by the given table.
+func bls12381MultiExpGas(k int, mulGas uint64, discounts []uint64) uint64 {
 	if k == 0 {
 		// Return 0 gas for small input length
 		return 0
 	}
-	// Lookup discount value for G1 point, scalar value pair length
+	// Lookup discount value for point, scalar value pair length
 	var discount uint64
-	if dLen := len(params.Bls12381MultiExpDiscountTable); k < dLen {
-		discount = params.Bls12381MultiExpDiscountTable[k-1]
+	if dLen := len(discounts); k < dLen {
+		discount = discounts[k-1]
 	} else {
-		discount = params.Bls12381MultiExpDiscountTable[dLen-1]
+		discount = discounts[dLen-1]
 	}
 	// Calculate gas and return the result
-	return (uint64(k) * params.Bls12381G1MulGas * discount) / 1000
+	return (uint64(k) * mulGas * discount) / 1000
+}
+
+// bls12381G1MultiExp implements EIP-2537 G1MultiExp precompile.
+type bls12381G1MultiExp struct {
+	prague bool // Prices the call and checks the subgroup of the points as activated in Prague
+}
+
+// RequiredGas returns the gas required to execute the pre-compiled contract.
+func (c *bls12381G1MultiExp) RequiredGas(input []byte) uint64 {
+	// Calculate G1 point, scalar value pair length
+	k := len(input) / 160
+	if c.prague {
+		return bls12381MultiExpGas(k, params.Bls12381G1MulGasPrague, params.Bls12381G1MultiExpDiscountTablePrague[:])
+	}
+	return bls12381MultiExpGas(k, params.Bls12381G1MulGas, params.Bls12381MultiExpDiscountTable[:])
 }
 
 func (c *bls12381G1MultiExp) Run(input []byte) ([]byte, error) {
@@ -544,6 +667,9 @@ func (c *bls12381G1MultiExp) Run(input []byte) ([]byte, error) {
 		if points[i], err = g.DecodePoint(input[t0:t1]); err != nil {
 			return nil, err
 		}
+		if c.prague && !g.InCorrectSubgroup(points[i]) {
+			return nil, errBLS12381G1PointSubgroup
+		}
 		// Decode scalar value
 		scalars[i] = new(big.Int).SetBytes(input[t1:t2])
 	}
@@ -557,10 +683,15 @@ func (c *bls12381G1MultiExp) Run(input []byte) ([]byte, error) {
 }
 
 // bls12381G2Add implements EIP-2537 G2Add precompile.
-type bls12381G2Add struct{}
+type bls12381G2Add struct {
+	prague bool // Prices the call as activated in Prague
+}
 
 // RequiredGas returns the gas required to execute the pre-compiled contract.
 func (c *bls12381G2Add) RequiredGas(input []byte) uint64 {
+	if c.prague {
+		return params.Bls12381G2AddGasPrague
+	}
 	return params.Bls12381G2AddGas
 }
 
@@ -631,25 +762,18 @@ func (c *bls12381G2Mul) Run(input []byte) ([]byte, error) {
 }
 
 // bls12381G2MultiExp implements EIP-2537 G2MultiExp precompile.
-type bls12381G2MultiExp struct{}
+type bls12381G2MultiExp struct {
+	prague bool // Prices the call and checks the subgroup of the points as activated in Prague
+}
 
 // RequiredGas returns the gas required to execute the pre-compiled contract.
 func (c *bls12381G2MultiExp) RequiredGas(input []byte) uint64 {
 	// Calculate G2 point, scalar value pair length
 	k := len(input) / 288
-	if k == 0 {
-		// Return 0 gas for small input length
-		return 0
-	}
-	// Lookup discount value for G2 point, scalar value pair length
-	var discount uint64
-	if dLen := len(params.Bls12381MultiExpDiscountTable); k < dLen {
-		discount = params.Bls12381MultiExpDiscountTable[k-1]
-	} else {
-		discount = params.Bls12381MultiExpDiscountTable[dLen-1]
+	if c.prague {
+		return bls12381MultiExpGas(k, params.Bls12381G2MulGasPrague, params.Bls12381G2MultiExpDiscountTablePrague[:])
 	}
-	// Calculate gas and return the result
-	return (uint64(k) * params.Bls12381G2MulGas * discount) / 1000
+	return bls12381MultiExpGas(k, params.Bls12381G2MulGas, params.Bls12381MultiExpDiscountTable[:])
 }
 
 func (c *bls12381G2MultiExp) Run(input []byte) ([]byte, error) {
@@ -671,10 +795,13 @@ func (c *bls12381G2MultiExp) Run(input []byte) ([]byte, error) {
 	for i := 0; i < k; i++ {
 		off := 288 * i
 		t0, t1, t2 := off, off+256, off+288
-		// Decode G1 point
+		// Decode G2 point
 		if points[i], err = g.DecodePoint(input[t0:t1]); err != nil {
 			return nil, err
 		}
+		if c.prague && !g.InCorrectSubgroup(points[i]) {
+			return nil, errBLS12381G2PointSubgroup
+		}
 		// Decode scalar value
 		scalars[i] = new(big.Int).SetBytes(input[t1:t2])
 	}
@@ -688,10 +815,15 @@ func (c *bls12381G2MultiExp) Run(input []byte) ([]byte, error) {
 }
 
 // bls12381Pairing implements EIP-2537 Pairing precompile.
-type bls12381Pairing struct{}
+type bls12381Pairing struct {
+	prague bool // Prices the call as activated in Prague
+}
 
 // RequiredGas returns the gas required to execute the pre-compiled contract.
 func (c *bls12381Pairing) RequiredGas(input []byte) uint64 {
+	if c.prague {
+		return params.Bls12381PairingBaseGasPrague + uint64(len(input)/384)*params.Bls12381PairingPerPairGasPrague
+	}
 	return params.Bls12381PairingBaseGas + uint64(len(input)/384)*params.Bls12381PairingPerPairGas
 }
 
@@ -767,10 +899,15 @@ func decodeBLS12381FieldElement(in []byte) ([]byte, error) {
 }
 
 // bls12381MapG1 implements EIP-2537 MapG1 precompile.
-type bls12381MapG1 struct{}
+type bls12381MapG1 struct {
+	prague bool // Prices the call as activated in Prague
+}
 
 // RequiredGas returns the gas required to execute the pre-compiled contract.
 func (c *bls12381MapG1) RequiredGas(input []byte) uint64 {
+	if c.prague {
+		return params.Bls12381MapG1GasPrague
+	}
 	return params.Bls12381MapG1Gas
 }
 
@@ -802,10 +939,15 @@ func (c *bls12381MapG1) Run(input []byte) ([]byte, error) {
 }
 
 // bls12381MapG2 implements EIP-2537 MapG2 precompile.
-type bls12381MapG2 struct{}
+type bls12381MapG2 struct {
+	prague bool // Prices the call as activated in Prague
+}
 
 // RequiredGas returns the gas required to execute the pre-compiled contract.
 func (c *bls12381MapG2) RequiredGas(input []byte) uint64 {
+	if c.prague {
+		return params.Bls12381MapG2GasPrague
+	}
 	return params.Bls12381MapG2Gas
 }
 
@@ -1054,23 +1196,15 @@ func (c *ecrecover) Run(input []byte) ([]byte, error) {
 	v := input[63] - 27
 
 	// tighter sig s values input homestead only apply to tx sigs
-	if !allZero(input[32:63]) || !crypto.ValidateSignatureValues(v, r, s, false) {
+	if !allZero(input[32:63]) {
 		return nil, nil
 	}
-	// We must make sure not to modify the 'input', so placing the 'v' along with
-	// the signature needs to be done on a new allocation
-	sig := make([]byte, 65)
-	copy(sig, input[64:128])
-	sig[64] = v
-	// v needs to be at the end for libsecp256k1
-	pubKey, err := crypto.Ecrecover(input[:32], sig)
+	addr, err := ecrecoverAddress(input[:32], v, r, s, false)
 	// make sure the public key is a valid one
 	if err != nil {
 		return nil, nil
 	}
-
-	// the first byte of pubkey is bitcoin heritage
-	return LeftPadBytes(crypto.Keccak256(pubKey[1:])[12:], 32), nil
+	return LeftPadBytes(addr.Bytes(), 32), nil
 }
 
 //===============================================================================================//
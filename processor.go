@@ -0,0 +1,242 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/lyonnee/evm/params"
+)
+
+// Header holds the fields of a block header the block is executed with.
+type Header struct {
+	ParentHash    Hash
+	Coinbase      Address
+	Number        *big.Int
+	GasLimit      uint64
+	Time          uint64
+	Difficulty    *big.Int
+	MixDigest     Hash     // PREVRANDAO once the difficulty is zero after the merge
+	BaseFee       *big.Int // nil before London
+	ExcessBlobGas *uint64  // nil before Cancun
+}
+
+// Transaction is a transaction of a block, applied as its message.
+type Transaction struct {
+	Hash    Hash
+	Type    uint8
+	Message *Message
+}
+
+// Withdrawal is an EIP-4895 validator withdrawal, processed at the end of
+// the block.
+type Withdrawal struct {
+	Index     uint64  `json:"index"`
+	Validator uint64  `json:"validatorIndex"`
+	Address   Address `json:"address"`
+	Amount    uint64  `json:"amount"` // Amount in Gwei
+}
+
+// gwei is the number of wei in a Gwei, the unit of the withdrawal amounts.
+var gwei = big.NewInt(1e9)
+
+// ChainContext gives access to the ancestors of the executed blocks.
+type ChainContext interface {
+	// GetHeader returns the header of the block with the given hash and
+	// number, nil if it is unknown.
+	GetHeader(hash Hash, number uint64) *Header
+}
+
+// BlockStateDB is the StateDB blocks are executed against, which can be
+// finalised between transactions, such as memstate.StateDB.
+type BlockStateDB interface {
+	StateDB
+
+	// Finalise ends the current transaction, resetting the transaction
+	// scoped data.
+	Finalise(deleteEmptyObjects bool)
+	// IntermediateRoot finalises the state and returns its root, the
+	// post-state of the receipts before Byzantium.
+	IntermediateRoot(deleteEmptyObjects bool) Hash
+	// Logs returns the logs added since the StateDB was created.
+	Logs() []Log
+}
+
+// NewBlockContext returns the BlockContext of the block with the given
+// header, the hashes of the ancestors being looked up in chain.
+func NewBlockContext(header *Header, chain ChainContext) BlockContext {
+	var random *Hash
+	if header.Difficulty == nil || header.Difficulty.Sign() == 0 {
+		random = &header.MixDigest
+	}
+	difficulty := header.Difficulty
+	if difficulty == nil {
+		difficulty = new(big.Int)
+	}
+	return BlockContext{
+		CanTransfer:   CanTransfer,
+		Transfer:      Transfer,
+		GetHash:       GetHashFn(header, chain),
+		Coinbase:      header.Coinbase,
+		GasLimit:      header.GasLimit,
+		BlockNumber:   new(big.Int).Set(header.Number),
+		Time:          header.Time,
+		Difficulty:    new(big.Int).Set(difficulty),
+		BaseFee:       header.BaseFee,
+		Random:        random,
+		ExcessBlobGas: header.ExcessBlobGas,
+	}
+}
+
+// GetHashFn returns a GetHashFunc which retrieves the hashes of the ancestors
+// of the block with the given header, walking back its parents in chain.
+func GetHashFn(ref *Header, chain ChainContext) GetHashFunc {
+	// cache holds the hashes of the ancestors, from the parent backwards
+	var cache []Hash
+
+	return func(n uint64) Hash {
+		number := ref.Number.Uint64()
+		if number <= n {
+			// Only ancestors are served
+			return Hash{}
+		}
+		if len(cache) == 0 {
+			cache = append(cache, ref.ParentHash)
+		}
+		if idx := number - n - 1; idx < uint64(len(cache)) {
+			return cache[idx]
+		}
+		// Walk back from the oldest known ancestor
+		lastKnownHash := cache[len(cache)-1]
+		lastKnownNumber := number - uint64(len(cache))
+		for chain != nil {
+			header := chain.GetHeader(lastKnownHash, lastKnownNumber)
+			if header == nil || lastKnownNumber == 0 {
+				break
+			}
+			cache = append(cache, header.ParentHash)
+			lastKnownHash = header.ParentHash
+			lastKnownNumber--
+			if n == lastKnownNumber {
+				return lastKnownHash
+			}
+		}
+		return Hash{}
+	}
+}
+
+// ProcessResult is the outcome of the execution of a block.
+type ProcessResult struct {
+	Receipts Receipts
+	Logs     []Log // Logs of all the receipts, numbered across the block
+	GasUsed  uint64
+	Bloom    Bloom
+}
+
+// Processor executes the transactions of blocks, reusing one EVM for the
+// transactions of a block.
+type Processor struct {
+	config   *params.ChainConfig
+	chain    ChainContext
+	vmConfig Config
+
+	// Reward credits the block rewards, such as the ethash miner and uncle
+	// rewards, once the transactions are applied. Nil credits nothing.
+	Reward func(header *Header, statedb StateDB)
+}
+
+// NewProcessor returns a Processor of the blocks of the given chain, the
+// EVMs being created with vmConfig.
+func NewProcessor(config *params.ChainConfig, chain ChainContext, vmConfig Config) *Processor {
+	return &Processor{
+		config:   config,
+		chain:    chain,
+		vmConfig: vmConfig,
+	}
+}
+
+// Process executes the block with the given header, transactions and
+// withdrawals against statedb. The transactions are applied in order with a
+// gas pool of the block gas limit, the first invalid one aborting the block,
+// then the block rewards are credited and the withdrawals processed.
+//
+// The state is finalised but not committed, its root being the state root of
+// the block.
+func (p *Processor) Process(header *Header, txs []*Transaction, withdrawals []*Withdrawal, statedb BlockStateDB) (*ProcessResult, error) {
+	var (
+		vm      = NewEVM(NewBlockContext(header, p.chain), TxContext{}, statedb, p.config, p.vmConfig)
+		rules   = vm.ChainRules()
+		gp      = new(GasPool).AddGas(header.GasLimit)
+		builder = NewReceiptBuilder(header.Number, vm.AddressDeriver())
+	)
+	if withdrawals != nil && !rules.IsShanghai {
+		return nil, errors.New("withdrawals before shanghai")
+	}
+	if err := ProcessParentBlockHash(header.ParentHash, vm); err != nil {
+		return nil, fmt.Errorf("could not process parent block hash: %w", err)
+	}
+	statedb.Finalise(rules.IsEIP158)
+
+	for i, tx := range txs {
+		msg := tx.Message
+		if msg.GasPrice == nil {
+			return nil, fmt.Errorf("could not apply tx %d [%x]: missing gas price", i, tx.Hash)
+		}
+		vm.Reset(NewTxContext(msg), statedb)
+		logs := len(statedb.Logs())
+		result, err := ApplyMessage(vm, msg, gp)
+		if err != nil {
+			return nil, fmt.Errorf("could not apply tx %d [%x]: %w", i, tx.Hash, err)
+		}
+		var root []byte
+		if rules.IsByzantium {
+			statedb.Finalise(true)
+		} else {
+			root = statedb.IntermediateRoot(rules.IsEIP158).Bytes()
+		}
+		receipt := builder.Add(tx.Hash, tx.Type, msg, result, statedb.Logs()[logs:])
+		receipt.PostState = root
+	}
+	return FinalizeBlock(header, withdrawals, p.Reward, statedb, rules, builder), nil
+}
+
+// FinalizeBlock ends the processing of a block once its transactions are
+// applied: the block rewards are credited with reward, if not nil, the
+// withdrawals are processed and the state is finalised. It returns the
+// result of the block, made of the receipts of builder.
+func FinalizeBlock(header *Header, withdrawals []*Withdrawal, reward func(header *Header, statedb StateDB), statedb BlockStateDB, rules params.Rules, builder *ReceiptBuilder) *ProcessResult {
+	if reward != nil {
+		reward(header, statedb)
+	}
+	for _, w := range withdrawals {
+		amount := new(big.Int).SetUint64(w.Amount)
+		statedb.AddBalance(w.Address, amount.Mul(amount, gwei))
+	}
+	statedb.Finalise(rules.IsEIP158)
+
+	result := &ProcessResult{
+		Receipts: builder.Receipts(),
+		GasUsed:  builder.GasUsed(),
+		Bloom:    builder.Bloom(),
+	}
+	for _, receipt := range result.Receipts {
+		result.Logs = append(result.Logs, receipt.Logs...)
+	}
+	return result
+}
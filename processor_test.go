@@ -0,0 +1,203 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm_test
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/memstate"
+	"github.com/lyonnee/evm/params"
+)
+
+// testChain serves headers by hash.
+type testChain map[evm.Hash]*evm.Header
+
+func (c testChain) GetHeader(hash evm.Hash, number uint64) *evm.Header {
+	if header, ok := c[hash]; ok && header.Number.Uint64() == number {
+		return header
+	}
+	return nil
+}
+
+// newTestChain returns a chain of n blocks and their hashes.
+func newTestChain(n int) (testChain, []evm.Hash) {
+	var (
+		chain  = make(testChain)
+		hashes []evm.Hash
+		parent evm.Hash
+	)
+	for i := 0; i < n; i++ {
+		hash := evm.Keccak256Hash([]byte{byte(i)})
+		chain[hash] = &evm.Header{ParentHash: parent, Number: big.NewInt(int64(i))}
+		hashes = append(hashes, hash)
+		parent = hash
+	}
+	return chain, hashes
+}
+
+func TestGetHashFn(t *testing.T) {
+	chain, hashes := newTestChain(5)
+	header := &evm.Header{ParentHash: hashes[4], Number: big.NewInt(5)}
+	getHash := evm.GetHashFn(header, chain)
+	// Out of order lookups, served from the walk or the cache
+	for _, n := range []uint64{2, 4, 0, 3, 1, 0} {
+		if have := getHash(n); have != hashes[n] {
+			t.Errorf("block %d hash mismatch: have %x, want %x", n, have, hashes[n])
+		}
+	}
+	if have := getHash(5); have != (evm.Hash{}) {
+		t.Errorf("hash of the block itself served: %x", have)
+	}
+	// Unknown ancestors resolve to the zero hash
+	header = &evm.Header{ParentHash: evm.Hash{1}, Number: big.NewInt(5)}
+	if have := evm.GetHashFn(header, chain)(3); have != (evm.Hash{}) {
+		t.Errorf("unknown ancestor hash served: %x", have)
+	}
+}
+
+func TestProcessor(t *testing.T) {
+	var (
+		sender    = evm.BytesToAddr([]byte("sender"))
+		recipient = evm.BytesToAddr([]byte("recipient"))
+		logger    = evm.BytesToAddr([]byte("logger"))
+		reverter  = evm.BytesToAddr([]byte("reverter"))
+		coinbase  = evm.BytesToAddr([]byte("coinbase"))
+		validator = evm.BytesToAddr([]byte("validator"))
+	)
+	config, err := params.ForkConfig("Cancun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newState := func() *memstate.StateDB {
+		return memstate.NewFromAlloc(memstate.GenesisAlloc{
+			sender: {Balance: big.NewInt(1e18)},
+			// PUSH1 0x2a PUSH1 0 MSTORE, PUSH1 7 PUSH1 0x20 PUSH1 0 LOG1,
+			// PUSH1 1 BLOCKHASH PUSH1 0 SSTORE STOP
+			logger: {Code: evm.Hex2Bytes("602a600052600760206000a160014060005500"), Balance: new(big.Int)},
+			// PUSH1 0 PUSH1 0 REVERT
+			reverter: {Code: evm.Hex2Bytes("60006000fd"), Balance: new(big.Int)},
+		})
+	}
+	chain, hashes := newTestChain(3)
+	header := &evm.Header{
+		ParentHash: hashes[2],
+		Coinbase:   coinbase,
+		Number:     big.NewInt(3),
+		GasLimit:   30_000_000,
+		Time:       1,
+		Difficulty: new(big.Int),
+		BaseFee:    big.NewInt(7),
+	}
+	newTx := func(nonce uint64, to *evm.Address, value int64) *evm.Transaction {
+		return &evm.Transaction{
+			Hash: evm.Keccak256Hash([]byte{byte(nonce)}),
+			Type: evm.DynamicFeeTxType,
+			Message: &evm.Message{
+				From:      sender,
+				To:        to,
+				Nonce:     nonce,
+				Value:     big.NewInt(value),
+				GasLimit:  100_000,
+				GasPrice:  big.NewInt(10),
+				GasFeeCap: big.NewInt(10),
+				GasTipCap: big.NewInt(3),
+			},
+		}
+	}
+	txs := []*evm.Transaction{
+		newTx(0, &recipient, 1),
+		newTx(1, &logger, 0),
+		newTx(2, nil, 0),
+		newTx(3, &reverter, 0),
+	}
+	withdrawals := []*evm.Withdrawal{{Index: 0, Validator: 1, Address: validator, Amount: 5}}
+
+	statedb := newState()
+	processor := evm.NewProcessor(config, chain, evm.Config{})
+	processor.Reward = func(header *evm.Header, statedb evm.StateDB) {
+		statedb.AddBalance(header.Coinbase, big.NewInt(2e18))
+	}
+	result, err := processor.Process(header, txs, withdrawals, statedb)
+	if err != nil {
+		t.Fatalf("block processing failed: %v", err)
+	}
+
+	if len(result.Receipts) != len(txs) {
+		t.Fatalf("receipt count mismatch: have %d, want %d", len(result.Receipts), len(txs))
+	}
+	var gasUsed uint64
+	for i, receipt := range result.Receipts {
+		gasUsed += receipt.GasUsed
+		if receipt.CumulativeGasUsed != gasUsed || receipt.TxHash != txs[i].Hash {
+			t.Errorf("receipt %d mismatch: cumulative gas %d, hash %x", i, receipt.CumulativeGasUsed, receipt.TxHash)
+		}
+		want := evm.ReceiptStatusSuccessful
+		if i == 3 {
+			want = evm.ReceiptStatusFailed
+		}
+		if receipt.Status != want {
+			t.Errorf("receipt %d status mismatch: have %d, want %d", i, receipt.Status, want)
+		}
+	}
+	if result.GasUsed != gasUsed || result.Receipts[0].GasUsed != params.TxGas {
+		t.Errorf("gas used mismatch: have %d, want %d", result.GasUsed, gasUsed)
+	}
+	if want := evm.CreateAddress(sender.Bytes(), 2); result.Receipts[2].ContractAddress != want {
+		t.Errorf("contract address mismatch: have %x, want %x", result.Receipts[2].ContractAddress, want)
+	}
+	if len(result.Logs) != 1 || result.Logs[0].Address != logger || result.Logs[0].TxIndex != 1 || result.Logs[0].BlockNumber != 3 {
+		t.Fatalf("logs mismatch: %+v", result.Logs)
+	}
+	if topics := result.Logs[0].Topics; len(topics) != 1 || topics[0] != evm.BytesToHash([]byte{7}) {
+		t.Errorf("log topics mismatch: %x", topics)
+	}
+	if !result.Bloom.Test(logger.Bytes()) {
+		t.Error("bloom misses the log address")
+	}
+
+	// State effects of the transactions, the reward and the withdrawal
+	if have := statedb.GetState(logger, evm.Hash{}); have != hashes[1] {
+		t.Errorf("BLOCKHASH mismatch: have %x, want %x", have, hashes[1])
+	}
+	if have := statedb.GetNonce(sender); have != 4 {
+		t.Errorf("sender nonce mismatch: have %d, want 4", have)
+	}
+	if have := statedb.GetBalance(recipient); have.Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("recipient balance mismatch: have %v, want 1", have)
+	}
+	if have, want := statedb.GetBalance(validator), big.NewInt(5e9); have.Cmp(want) != 0 {
+		t.Errorf("withdrawal balance mismatch: have %v, want %v", have, want)
+	}
+	tips := new(big.Int).SetUint64(3 * gasUsed)
+	if have, want := statedb.GetBalance(coinbase), tips.Add(tips, big.NewInt(2e18)); have.Cmp(want) != 0 {
+		t.Errorf("coinbase balance mismatch: have %v, want %v", have, want)
+	}
+
+	// An invalid transaction aborts the block
+	txs[3].Message.Nonce = 1
+	if _, err := processor.Process(header, txs, nil, newState()); !errors.Is(err, evm.ErrNonceTooLow) {
+		t.Errorf("error mismatch: have %v, want %v", err, evm.ErrNonceTooLow)
+	}
+	// Withdrawals are rejected before Shanghai
+	config, _ = params.ForkConfig("London")
+	if _, err := evm.NewProcessor(config, chain, evm.Config{}).Process(header, nil, withdrawals, newState()); err == nil {
+		t.Error("withdrawals processed before Shanghai")
+	}
+}
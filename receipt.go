@@ -0,0 +1,252 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// Transaction types, the type of a receipt being the one of its transaction.
+const (
+	LegacyTxType     = 0x00
+	AccessListTxType = 0x01
+	DynamicFeeTxType = 0x02
+	BlobTxType       = 0x03
+	SetCodeTxType    = 0x04
+)
+
+const (
+	// ReceiptStatusFailed is the status code of a transaction if execution failed.
+	ReceiptStatusFailed = uint64(0)
+	// ReceiptStatusSuccessful is the status code of a transaction if execution succeeded.
+	ReceiptStatusSuccessful = uint64(1)
+)
+
+var (
+	receiptStatusFailedRLP     = []byte{}
+	receiptStatusSuccessfulRLP = []byte{0x01}
+)
+
+var errShortTypedReceipt = errors.New("typed receipt too short")
+
+// Receipt represents the result of a transaction.
+type Receipt struct {
+	// Consensus fields
+	Type              uint8  `json:"type"`
+	PostState         []byte `json:"root"` // State root of the transaction before Byzantium, replacing Status
+	Status            uint64 `json:"status"`
+	CumulativeGasUsed uint64 `json:"cumulativeGasUsed"`
+	Bloom             Bloom  `json:"logsBloom"`
+	Logs              []Log  `json:"logs"`
+
+	// Implementation fields, derived from the transaction and its execution
+	TxHash            Hash     `json:"transactionHash"`
+	ContractAddress   Address  `json:"contractAddress"`
+	GasUsed           uint64   `json:"gasUsed"`
+	EffectiveGasPrice *big.Int `json:"effectiveGasPrice"`
+
+	// Inclusion information
+	BlockHash        Hash     `json:"blockHash"`
+	BlockNumber      *big.Int `json:"blockNumber"`
+	TransactionIndex uint     `json:"transactionIndex"`
+}
+
+// receiptRLP is the consensus encoding of a receipt.
+type receiptRLP struct {
+	PostStateOrStatus []byte
+	CumulativeGasUsed uint64
+	Bloom             Bloom
+	Logs              []logRLP
+}
+
+// logRLP is the consensus encoding of a log.
+type logRLP struct {
+	Address Address
+	Topics  []Hash
+	Data    []byte
+}
+
+func (r *Receipt) statusEncoding() []byte {
+	if len(r.PostState) > 0 {
+		return r.PostState
+	}
+	if r.Status == ReceiptStatusFailed {
+		return receiptStatusFailedRLP
+	}
+	return receiptStatusSuccessfulRLP
+}
+
+func (r *Receipt) consensusRLP() *receiptRLP {
+	logs := make([]logRLP, len(r.Logs))
+	for i, log := range r.Logs {
+		logs[i] = logRLP{Address: log.Address, Topics: log.Topics, Data: log.Data}
+	}
+	return &receiptRLP{r.statusEncoding(), r.CumulativeGasUsed, r.Bloom, logs}
+}
+
+// MarshalBinary returns the consensus encoding of the receipt, the RLP list
+// of a legacy receipt or the type byte followed by it for typed receipts.
+func (r *Receipt) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if r.Type != LegacyTxType {
+		buf.WriteByte(r.Type)
+	}
+	if err := rlp.Encode(&buf, r.consensusRLP()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes the consensus encoding of a receipt, filling its
+// consensus fields.
+func (r *Receipt) UnmarshalBinary(b []byte) error {
+	typ := uint8(LegacyTxType)
+	if len(b) > 0 && b[0] <= 0x7f {
+		if len(b) == 1 {
+			return errShortTypedReceipt
+		}
+		typ, b = b[0], b[1:]
+	}
+	var dec receiptRLP
+	if err := rlp.DecodeBytes(b, &dec); err != nil {
+		return err
+	}
+	r.Type = typ
+	r.CumulativeGasUsed = dec.CumulativeGasUsed
+	r.Bloom = dec.Bloom
+	r.Logs = make([]Log, len(dec.Logs))
+	for i, log := range dec.Logs {
+		r.Logs[i] = Log{Address: log.Address, Topics: log.Topics, Data: log.Data}
+	}
+	switch status := dec.PostStateOrStatus; {
+	case bytes.Equal(status, receiptStatusFailedRLP):
+		r.Status = ReceiptStatusFailed
+	case bytes.Equal(status, receiptStatusSuccessfulRLP):
+		r.Status = ReceiptStatusSuccessful
+	case len(status) == HashLength:
+		r.PostState = CopyBytes(status)
+	default:
+		return fmt.Errorf("invalid receipt status %x", status)
+	}
+	return nil
+}
+
+// Receipts is the list of receipts of a block.
+type Receipts []*Receipt
+
+// Len returns the number of receipts.
+func (rs Receipts) Len() int { return len(rs) }
+
+// EncodeIndex writes the consensus encoding of the i'th receipt to w, as
+// hashed into the receipts root.
+func (rs Receipts) EncodeIndex(i int, w *bytes.Buffer) {
+	r := rs[i]
+	if r.Type != LegacyTxType {
+		w.WriteByte(r.Type)
+	}
+	rlp.Encode(w, r.consensusRLP())
+}
+
+// SetBlockHash sets the hash of the block on the receipts and their logs. It
+// is only known once the block, which commits to the receipts, is sealed.
+func (rs Receipts) SetBlockHash(hash Hash) {
+	for _, r := range rs {
+		r.BlockHash = hash
+		for i := range r.Logs {
+			r.Logs[i].BlockHash = hash
+		}
+	}
+}
+
+// ReceiptBuilder builds the receipts of the transactions of a block as they
+// are executed, accumulating the gas used and numbering the logs across the
+// block.
+type ReceiptBuilder struct {
+	blockNumber *big.Int
+	deriver     AddressDeriver
+	receipts    Receipts
+	gasUsed     uint64
+	logIndex    uint
+}
+
+// NewReceiptBuilder returns a builder of the receipts of the given block. The
+// addresses of created contracts are derived with deriver, nil selecting
+// EthereumAddressDeriver.
+func NewReceiptBuilder(blockNumber *big.Int, deriver AddressDeriver) *ReceiptBuilder {
+	if deriver == nil {
+		deriver = EthereumAddressDeriver{}
+	}
+	return &ReceiptBuilder{blockNumber: new(big.Int).Set(blockNumber), deriver: deriver}
+}
+
+// Add builds the receipt of the next transaction of the block from the
+// message it was applied as, its execution result and the logs it emitted,
+// i.e. the logs recorded by the StateDB while applying it.
+func (b *ReceiptBuilder) Add(txHash Hash, txType uint8, msg *Message, result *ExecutionResult, logs []Log) *Receipt {
+	b.gasUsed += result.UsedGas
+	receipt := &Receipt{
+		Type:              txType,
+		Status:            ReceiptStatusSuccessful,
+		CumulativeGasUsed: b.gasUsed,
+		TxHash:            txHash,
+		GasUsed:           result.UsedGas,
+		EffectiveGasPrice: new(big.Int),
+		BlockNumber:       new(big.Int).Set(b.blockNumber),
+		TransactionIndex:  uint(len(b.receipts)),
+		Logs:              make([]Log, len(logs)),
+	}
+	if result.Failed() {
+		receipt.Status = ReceiptStatusFailed
+	}
+	if msg.GasPrice != nil {
+		receipt.EffectiveGasPrice.Set(msg.GasPrice)
+	}
+	if msg.To == nil {
+		receipt.ContractAddress = b.deriver.CreateAddress(msg.From, msg.Nonce)
+	}
+	for i, log := range logs {
+		log.BlockNumber = b.blockNumber.Uint64()
+		log.TxHash = txHash
+		log.TxIndex = receipt.TransactionIndex
+		log.Index = b.logIndex
+		receipt.Logs[i] = log
+		b.logIndex++
+	}
+	receipt.Bloom = LogsBloom(receipt.Logs)
+	b.receipts = append(b.receipts, receipt)
+	return receipt
+}
+
+// Receipts returns the receipts built so far.
+func (b *ReceiptBuilder) Receipts() Receipts {
+	return b.receipts
+}
+
+// GasUsed returns the gas used by the transactions added so far.
+func (b *ReceiptBuilder) GasUsed() uint64 {
+	return b.gasUsed
+}
+
+// Bloom returns the logs bloom of the block.
+func (b *ReceiptBuilder) Bloom() Bloom {
+	return CreateBloom(b.receipts)
+}
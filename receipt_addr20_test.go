@@ -0,0 +1,70 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+//go:build addr20
+
+package evm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func TestReceiptMatchesGeth(t *testing.T) {
+	var (
+		to   = BytesToAddr([]byte("to"))
+		logs = []Log{
+			{Address: to, Topics: []Hash{BytesToHash([]byte("a")), BytesToHash([]byte("b"))}, Data: []byte("data")},
+			{Address: stContract},
+		}
+		b = NewReceiptBuilder(big.NewInt(1), nil)
+	)
+	b.Add(BytesToHash([]byte{1}), LegacyTxType, &Message{From: stSender, To: &to}, &ExecutionResult{UsedGas: 21000}, logs[:1])
+	b.Add(BytesToHash([]byte{2}), DynamicFeeTxType, &Message{From: stSender, To: &to}, &ExecutionResult{UsedGas: 30000, Err: ErrOutOfGas}, nil)
+	b.Add(BytesToHash([]byte{3}), SetCodeTxType, &Message{From: stSender, To: &to}, &ExecutionResult{UsedGas: 40000}, logs[1:])
+
+	var gethReceipts types.Receipts
+	for _, r := range b.Receipts() {
+		gr := &types.Receipt{Type: r.Type, Status: r.Status, CumulativeGasUsed: r.CumulativeGasUsed}
+		for _, log := range r.Logs {
+			gl := &types.Log{Address: common.Address(log.Address), Data: log.Data}
+			for _, topic := range log.Topics {
+				gl.Topics = append(gl.Topics, common.Hash(topic))
+			}
+			gr.Logs = append(gr.Logs, gl)
+		}
+		gr.Bloom = types.CreateBloom(types.Receipts{gr})
+		if !bytes.Equal(r.Bloom.Bytes(), gr.Bloom.Bytes()) {
+			t.Errorf("receipt %d: bloom mismatch", r.TransactionIndex)
+		}
+		have, _ := r.MarshalBinary()
+		want, err := gr.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(have, want) {
+			t.Errorf("receipt %d: encoding mismatch: have %x, want %x", r.TransactionIndex, have, want)
+		}
+		gethReceipts = append(gethReceipts, gr)
+	}
+	if have, want := b.Bloom(), types.CreateBloom(gethReceipts); !bytes.Equal(have.Bytes(), want.Bytes()) {
+		t.Error("block bloom mismatch")
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestReceiptBuilder(t *testing.T) {
+	var (
+		to     = BytesToAddr([]byte("to"))
+		topic  = BytesToHash([]byte("topic"))
+		logsA  = []Log{{Address: to, Topics: []Hash{topic}}, {Address: to, Data: []byte{1}}}
+		logsB  = []Log{{Address: stContract}}
+		number = big.NewInt(7)
+	)
+	b := NewReceiptBuilder(number, nil)
+	b.Add(BytesToHash([]byte{1}), DynamicFeeTxType, &Message{From: stSender, To: &to, GasPrice: big.NewInt(3)}, &ExecutionResult{UsedGas: 30000}, logsA)
+	b.Add(BytesToHash([]byte{2}), LegacyTxType, &Message{From: stSender, Nonce: 1}, &ExecutionResult{UsedGas: 50000, Err: ErrExecutionReverted}, nil)
+	b.Add(BytesToHash([]byte{3}), LegacyTxType, &Message{From: stSender, To: &to}, &ExecutionResult{UsedGas: 21000}, logsB)
+
+	receipts := b.Receipts()
+	if len(receipts) != 3 {
+		t.Fatalf("receipt count mismatch: have %d, want 3", len(receipts))
+	}
+	if b.GasUsed() != 101000 {
+		t.Errorf("gas used mismatch: have %d, want 101000", b.GasUsed())
+	}
+	for i, want := range []struct {
+		status, cumulative uint64
+		logs               int
+	}{
+		{ReceiptStatusSuccessful, 30000, 2},
+		{ReceiptStatusFailed, 80000, 0},
+		{ReceiptStatusSuccessful, 101000, 1},
+	} {
+		r := receipts[i]
+		if r.Status != want.status || r.CumulativeGasUsed != want.cumulative || len(r.Logs) != want.logs {
+			t.Errorf("receipt %d mismatch: have status %d, gas %d, %d logs", i, r.Status, r.CumulativeGasUsed, len(r.Logs))
+		}
+		if r.TransactionIndex != uint(i) || r.BlockNumber.Cmp(number) != 0 {
+			t.Errorf("receipt %d inclusion mismatch: have index %d, block %v", i, r.TransactionIndex, r.BlockNumber)
+		}
+	}
+	// Logs are numbered across the block
+	for i, log := range append(receipts[0].Logs, receipts[2].Logs...) {
+		if log.Index != uint(i) || log.BlockNumber != 7 {
+			t.Errorf("log %d mismatch: have index %d, block %d", i, log.Index, log.BlockNumber)
+		}
+	}
+	if log := receipts[2].Logs[0]; log.TxIndex != 2 || log.TxHash != BytesToHash([]byte{3}) {
+		t.Errorf("log transaction mismatch: have index %d, hash %x", log.TxIndex, log.TxHash)
+	}
+	// The input logs are left untouched
+	if logsA[1].Index != 0 || logsA[1].TxHash != (Hash{}) {
+		t.Error("input logs modified")
+	}
+	if want := CreateAddress(stSender.Bytes(), 1); receipts[1].ContractAddress != want {
+		t.Errorf("contract address mismatch: have %x, want %x", receipts[1].ContractAddress, want)
+	}
+	if receipts[0].ContractAddress != (Address{}) {
+		t.Errorf("unexpected contract address %x", receipts[0].ContractAddress)
+	}
+	if receipts[0].EffectiveGasPrice.Cmp(big.NewInt(3)) != 0 {
+		t.Errorf("effective gas price mismatch: have %v, want 3", receipts[0].EffectiveGasPrice)
+	}
+
+	// The bloom of the block matches the logs of every receipt
+	bloom := b.Bloom()
+	for _, probe := range [][]byte{to.Bytes(), topic.Bytes(), stContract.Bytes()} {
+		if !bloom.Test(probe) {
+			t.Errorf("bloom misses %x", probe)
+		}
+	}
+	if receipts[0].Bloom.Test(stContract.Bytes()) {
+		t.Error("receipt bloom holds the logs of another receipt")
+	}
+	if receipts[1].Bloom != (Bloom{}) {
+		t.Error("bloom of a receipt without logs not empty")
+	}
+
+	hash := BytesToHash([]byte("block"))
+	receipts.SetBlockHash(hash)
+	if receipts[2].BlockHash != hash || receipts[2].Logs[0].BlockHash != hash {
+		t.Error("block hash not set")
+	}
+}
+
+func TestReceiptEncoding(t *testing.T) {
+	for _, r := range []*Receipt{
+		{Type: LegacyTxType, Status: ReceiptStatusSuccessful, CumulativeGasUsed: 21000},
+		{Type: DynamicFeeTxType, Status: ReceiptStatusFailed, CumulativeGasUsed: 1},
+		{Type: SetCodeTxType, PostState: BytesToHash([]byte{1}).Bytes(), CumulativeGasUsed: 5, Logs: []Log{
+			{Address: stContract, Topics: []Hash{BytesToHash([]byte{2})}, Data: []byte{3}},
+		}},
+	} {
+		r.Bloom = LogsBloom(r.Logs)
+		enc, err := r.MarshalBinary()
+		if err != nil {
+			t.Fatalf("type %d: encode failed: %v", r.Type, err)
+		}
+		if (r.Type == LegacyTxType) != (enc[0] >= 0xc0) {
+			t.Errorf("type %d: wrong envelope %x", r.Type, enc[0])
+		}
+		var buf bytes.Buffer
+		Receipts{r}.EncodeIndex(0, &buf)
+		if !bytes.Equal(buf.Bytes(), enc) {
+			t.Errorf("type %d: EncodeIndex mismatch: have %x, want %x", r.Type, buf.Bytes(), enc)
+		}
+		var dec Receipt
+		if err := dec.UnmarshalBinary(enc); err != nil {
+			t.Fatalf("type %d: decode failed: %v", r.Type, err)
+		}
+		if dec.Type != r.Type || dec.Status != r.Status || !bytes.Equal(dec.PostState, r.PostState) ||
+			dec.CumulativeGasUsed != r.CumulativeGasUsed || dec.Bloom != r.Bloom || len(dec.Logs) != len(r.Logs) {
+			t.Errorf("type %d: round trip mismatch: have %+v, want %+v", r.Type, dec, r)
+		}
+	}
+	var dec Receipt
+	if err := dec.UnmarshalBinary([]byte{DynamicFeeTxType}); !errors.Is(err, errShortTypedReceipt) {
+		t.Errorf("short receipt error mismatch: have %v, want %v", err, errShortTypedReceipt)
+	}
+}
+
+func TestBloom(t *testing.T) {
+	var b Bloom
+	for _, v := range []string{"testtest", "test", "hallo", "other"} {
+		b.Add([]byte(v))
+	}
+	for _, v := range []string{"testtest", "test", "hallo", "other"} {
+		if !b.Test([]byte(v)) {
+			t.Errorf("bloom misses %q", v)
+		}
+	}
+	if b.Test([]byte("missing")) {
+		t.Error("bloom matches a value never added")
+	}
+	enc, err := b.MarshalText()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dec Bloom
+	if err := dec.UnmarshalText(enc); err != nil || dec != b {
+		t.Errorf("text round trip mismatch: %v", err)
+	}
+}
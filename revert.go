@@ -0,0 +1,146 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	// revertSelector is the selector of the solidity Error(string) revert.
+	revertSelector = Keccak256([]byte("Error(string)"))[:4]
+	// panicSelector is the selector of the solidity Panic(uint256) revert.
+	panicSelector = Keccak256([]byte("Panic(uint256)"))[:4]
+
+	errInvalidRevertData = errors.New("invalid revert data")
+)
+
+// PanicReasons are the reasons of the solidity Panic(uint256) codes.
+var PanicReasons = map[uint64]string{
+	0x00: "generic panic",
+	0x01: "assert(false)",
+	0x11: "arithmetic underflow or overflow",
+	0x12: "division or modulo by zero",
+	0x21: "enum overflow",
+	0x22: "invalid encoded storage byte array accessed",
+	0x31: "out-of-bounds array access; popping on an empty array",
+	0x32: "out-of-bounds access of an array or bytesN",
+	0x41: "out of memory",
+	0x51: "uninitialized function",
+}
+
+// UnpackRevert resolves the reason of a solidity Error(string) or
+// Panic(uint256) revert.
+func UnpackRevert(data []byte) (string, error) {
+	if len(data) < 4 {
+		return "", errInvalidRevertData
+	}
+	switch {
+	case bytes.Equal(data[:4], revertSelector):
+		return unpackString(data[4:])
+	case bytes.Equal(data[:4], panicSelector):
+		if len(data) != 4+32 {
+			return "", errInvalidRevertData
+		}
+		code := new(big.Int).SetBytes(data[4:])
+		if reason, ok := PanicReasons[code.Uint64()]; code.IsUint64() && ok {
+			return reason, nil
+		}
+		return fmt.Sprintf("unknown panic code: %#x", code), nil
+	}
+	return "", errInvalidRevertData
+}
+
+// unpackString decodes the ABI encoded string argument of a revert.
+func unpackString(args []byte) (string, error) {
+	if len(args) < 32 {
+		return "", errInvalidRevertData
+	}
+	offset := new(big.Int).SetBytes(args[:32])
+	if !offset.IsUint64() || offset.Uint64() > uint64(len(args))-32 {
+		return "", errors.New("invalid revert offset")
+	}
+	start := offset.Uint64()
+	size := new(big.Int).SetBytes(args[start : start+32])
+	if !size.IsUint64() || size.Uint64() > uint64(len(args))-start-32 {
+		return "", errors.New("invalid revert length")
+	}
+	return string(args[start+32 : start+32+size.Uint64()]), nil
+}
+
+// ErrorDecoder decodes the custom errors of a contract from revert data. It
+// is implemented by the *abi.ABI of the contract.
+type ErrorDecoder interface {
+	// DecodeError returns a readable form of the custom error encoded in
+	// data, false if the error is unknown.
+	DecodeError(data []byte) (string, bool)
+}
+
+// RevertError is an ErrExecutionReverted carrying the revert data and the
+// reason decoded from it, if any.
+type RevertError struct {
+	Data   []byte // Data returned by the REVERT
+	Reason string // Decoded reason, empty if the data could not be decoded
+}
+
+// NewRevertError returns the RevertError of the given revert data. Solidity
+// Error(string) and Panic(uint256) reverts are decoded, as well as the custom
+// errors known to the decoders.
+func NewRevertError(data []byte, decoders ...ErrorDecoder) *RevertError {
+	err := &RevertError{Data: CopyBytes(data)}
+	if reason, unpackErr := UnpackRevert(data); unpackErr == nil {
+		err.Reason = reason
+		return err
+	}
+	for _, decoder := range decoders {
+		if reason, ok := decoder.DecodeError(data); ok {
+			err.Reason = reason
+			break
+		}
+	}
+	return err
+}
+
+// WrapRevert returns err as a RevertError decoding ret if it is
+// ErrExecutionReverted, err unchanged otherwise. It is meant for the results
+// of EVM.Call and EVM.Create, which report a revert as ErrExecutionReverted
+// with the revert data as return value.
+func WrapRevert(ret []byte, err error, decoders ...ErrorDecoder) error {
+	if err != ErrExecutionReverted {
+		return err
+	}
+	return NewRevertError(ret, decoders...)
+}
+
+func (e *RevertError) Error() string {
+	if e.Reason == "" {
+		return ErrExecutionReverted.Error()
+	}
+	return ErrExecutionReverted.Error() + ": " + e.Reason
+}
+
+// Unwrap returns ErrExecutionReverted.
+func (e *RevertError) Unwrap() error { return ErrExecutionReverted }
+
+// ErrorCode returns the JSON-RPC error code of a revert.
+func (e *RevertError) ErrorCode() int { return 3 }
+
+// ErrorData returns the hex encoded revert data, as JSON-RPC error data.
+func (e *RevertError) ErrorData() interface{} { return "0x" + Bytes2Hex(e.Data) }
@@ -0,0 +1,127 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// abiWord returns the 32 bytes ABI encoding of an unsigned integer.
+func abiWord(v int64) []byte {
+	return LeftPadBytes(big.NewInt(v).Bytes(), 32)
+}
+
+// abiString returns the ABI encoding of a string, its length followed by the
+// padded content.
+func abiString(s string) []byte {
+	return append(abiWord(int64(len(s))), RightPadBytes([]byte(s), (len(s)+31)/32*32)...)
+}
+
+func TestUnpackRevert(t *testing.T) {
+	for _, tt := range []struct {
+		data string
+		want string
+		err  bool
+	}{
+		{data: "", err: true},
+		{data: "08c379a0", err: true},
+		{data: "08c379a0" + Bytes2Hex(abiWord(32)) + Bytes2Hex(abiString("ERC20: insufficient allowance")), want: "ERC20: insufficient allowance"},
+		{data: "08c379a0" + Bytes2Hex(abiWord(64)) + Bytes2Hex(abiString("x")), err: true},
+		{data: "08c379a0" + Bytes2Hex(abiWord(32)) + Bytes2Hex(abiWord(33)), err: true},
+		{data: "4e487b71" + Bytes2Hex(abiWord(0x11)), want: "arithmetic underflow or overflow"},
+		{data: "4e487b71" + Bytes2Hex(abiWord(0x01)), want: "assert(false)"},
+		{data: "4e487b71" + Bytes2Hex(abiWord(0x99)), want: "unknown panic code: 0x99"},
+		{data: "4e487b71" + Bytes2Hex(abiWord(0x11)) + "00", err: true},
+		{data: "deadbeef", err: true},
+	} {
+		have, err := UnpackRevert(Hex2Bytes(tt.data))
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error mismatch: have %v, want error %t", tt.data, err, tt.err)
+			continue
+		}
+		if have != tt.want {
+			t.Errorf("%s: reason mismatch: have %q, want %q", tt.data, have, tt.want)
+		}
+	}
+}
+
+// selectorDecoder is an ErrorDecoder knowing custom errors by selector.
+type selectorDecoder map[[4]byte]string
+
+func (d selectorDecoder) DecodeError(data []byte) (string, bool) {
+	if len(data) < 4 {
+		return "", false
+	}
+	name, ok := d[[4]byte(data[:4])]
+	return name, ok
+}
+
+func TestRevertError(t *testing.T) {
+	unauthorized := Keccak256([]byte("Unauthorized(address)"))[:4]
+	errs := selectorDecoder{[4]byte(unauthorized): "Unauthorized"}
+	for _, tt := range []struct {
+		data []byte
+		want string
+	}{
+		{
+			data: append(unauthorized, abiWord(0xaa)...),
+			want: "execution reverted: Unauthorized",
+		},
+		{
+			data: append(Hex2Bytes("08c379a0"), append(abiWord(32), abiString("plain")...)...),
+			want: "execution reverted: plain",
+		},
+		{
+			data: Hex2Bytes("deadbeef"),
+			want: "execution reverted",
+		},
+	} {
+		err := NewRevertError(tt.data, errs)
+		if err.Error() != tt.want {
+			t.Errorf("message mismatch: have %q, want %q", err.Error(), tt.want)
+		}
+		if !errors.Is(err, ErrExecutionReverted) {
+			t.Errorf("%v is not ErrExecutionReverted", err)
+		}
+		if data := err.ErrorData(); data != "0x"+Bytes2Hex(tt.data) {
+			t.Errorf("data mismatch: have %v", data)
+		}
+	}
+
+	ret := append(Hex2Bytes("08c379a0"), append(abiWord(32), abiString("no")...)...)
+	var revertErr *RevertError
+	if err := WrapRevert(ret, ErrExecutionReverted); !errors.As(err, &revertErr) || revertErr.Reason != "no" {
+		t.Errorf("revert not wrapped: %v", err)
+	}
+	if err := WrapRevert(ret, ErrOutOfGas); err != ErrOutOfGas {
+		t.Errorf("error mismatch: have %v, want %v", err, ErrOutOfGas)
+	}
+	if err := WrapRevert(ret, nil); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	result := &ExecutionResult{Err: ErrExecutionReverted, ReturnData: ret}
+	if revertErr := result.RevertError(); revertErr == nil || revertErr.Reason != "no" {
+		t.Errorf("revert error mismatch: have %v", revertErr)
+	}
+	result = &ExecutionResult{Err: ErrOutOfGas, ReturnData: ret}
+	if revertErr := result.RevertError(); revertErr != nil {
+		t.Errorf("unexpected revert error %v", revertErr)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/holiman/uint256"
+)
+
+// DelegationPrefix is the prefix of the code an EIP-7702 authorization writes
+// into the authority's account, followed by the address of the delegate.
+var DelegationPrefix = []byte{0xef, 0x01, 0x00}
+
+// setCodeMagic is the signature domain of EIP-7702 authorizations.
+const setCodeMagic = 0x05
+
+// ParseDelegation returns the delegate of code if it's a delegation
+// designator.
+func ParseDelegation(code []byte) (Address, bool) {
+	if len(code) != len(DelegationPrefix)+AddressLength || !bytes.HasPrefix(code, DelegationPrefix) {
+		return Address{}, false
+	}
+	return BytesToAddr(code[len(DelegationPrefix):]), true
+}
+
+// AddressToDelegation returns the delegation designator of addr.
+func AddressToDelegation(addr Address) []byte {
+	return append(CopyBytes(DelegationPrefix), addr.Bytes()...)
+}
+
+// SetCodeAuthorization is an EIP-7702 authorization, allowing the signer (the
+// authority) to delegate the execution of its account to the code at Address.
+// A zero ChainID makes it valid on every chain, a zero Address clears the
+// delegation.
+type SetCodeAuthorization struct {
+	ChainID uint256.Int `json:"chainId"`
+	Address Address     `json:"address"`
+	Nonce   uint64      `json:"nonce"`
+	V       uint8       `json:"yParity"`
+	R       uint256.Int `json:"r"`
+	S       uint256.Int `json:"s"`
+}
+
+// SigHash returns the hash signed by the authority,
+// keccak256(0x05 || rlp([chain_id, address, nonce])).
+func (a *SetCodeAuthorization) SigHash() Hash {
+	data, _ := rlp.EncodeToBytes([]interface{}{&a.ChainID, a.Address, a.Nonce})
+	return Keccak256Hash([]byte{setCodeMagic}, data)
+}
+
+// Authority recovers the signer of the authorization.
+func (a *SetCodeAuthorization) Authority() (Address, error) {
+	sighash := a.SigHash()
+	return ecrecoverAddress(sighash[:], a.V, a.R.ToBig(), a.S.ToBig(), true)
+}
+
+// SignSetCode signs the authorization with the private key of the authority.
+func SignSetCode(key *ecdsa.PrivateKey, auth SetCodeAuthorization) (SetCodeAuthorization, error) {
+	sighash := auth.SigHash()
+	sig, err := crypto.Sign(sighash[:], key)
+	if err != nil {
+		return SetCodeAuthorization{}, err
+	}
+	auth.R.SetBytes(sig[:32])
+	auth.S.SetBytes(sig[32:64])
+	auth.V = sig[64]
+	return auth, nil
+}
@@ -0,0 +1,224 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+	"github.com/lyonnee/evm/params"
+)
+
+var (
+	setCodeKey, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+	setCodeTarget = BytesToAddr([]byte("delegate"))
+)
+
+// keyAddress returns the address of the account of key, as ecrecover derives it.
+func keyAddress(key *ecdsa.PrivateKey) Address {
+	return BytesToAddr(crypto.PubkeyToAddress(key.PublicKey).Bytes())
+}
+
+func signSetCode(t *testing.T, auth SetCodeAuthorization) SetCodeAuthorization {
+	t.Helper()
+	signed, err := SignSetCode(setCodeKey, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestDelegation(t *testing.T) {
+	code := AddressToDelegation(setCodeTarget)
+	if len(code) != 3+AddressLength || !bytes.HasPrefix(code, DelegationPrefix) {
+		t.Fatalf("invalid designator %x", code)
+	}
+	if addr, ok := ParseDelegation(code); !ok || addr != setCodeTarget {
+		t.Errorf("designator parsed as %x, %v", addr, ok)
+	}
+	for _, code := range [][]byte{nil, DelegationPrefix, code[:len(code)-1], append(CopyBytes(code), 0), Hex2Bytes("ef0000")} {
+		if _, ok := ParseDelegation(code); ok {
+			t.Errorf("%x parsed as designator", code)
+		}
+	}
+}
+
+func TestSetCodeAuthority(t *testing.T) {
+	auth := signSetCode(t, SetCodeAuthorization{ChainID: *uint256.NewInt(1), Address: setCodeTarget, Nonce: 7})
+	authority, err := auth.Authority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := keyAddress(setCodeKey); authority != want {
+		t.Errorf("authority mismatch: have %x, want %x", authority, want)
+	}
+	// The signature covers the nonce
+	auth.Nonce++
+	if authority, _ := auth.Authority(); authority == keyAddress(setCodeKey) {
+		t.Error("authority recovered from a modified authorization")
+	}
+	// High s values are rejected as for transactions
+	auth.Nonce--
+	auth.S.Sub(uint256.MustFromBig(crypto.S256().Params().N), &auth.S)
+	auth.V ^= 1
+	if _, err := auth.Authority(); err == nil {
+		t.Error("authorization with high s accepted")
+	}
+}
+
+func TestApplyMessageSetCode(t *testing.T) {
+	config, err := params.ForkConfig("Prague")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authority := keyAddress(setCodeKey)
+	// The delegate stores 42 at slot 0 of the account it runs for
+	delegateCode := Hex2Bytes("602a60005500")
+
+	tests := []struct {
+		name      string
+		auth      SetCodeAuthorization
+		delegated bool
+		want      error
+	}{
+		{"valid", SetCodeAuthorization{ChainID: *uint256.NewInt(1), Address: setCodeTarget}, true, nil},
+		{"any chain", SetCodeAuthorization{Address: setCodeTarget}, true, nil},
+		{"wrong chain", SetCodeAuthorization{ChainID: *uint256.NewInt(2), Address: setCodeTarget}, false, ErrAuthorizationWrongChainID},
+		{"wrong nonce", SetCodeAuthorization{Address: setCodeTarget, Nonce: 1}, false, ErrAuthorizationNonceMismatch},
+	}
+	for _, tt := range tests {
+		vm, db := newTransitionEnv(t, config, big.NewInt(0), 0)
+		db.SetCode(setCodeTarget, delegateCode)
+		msg := &Message{
+			From:                  stSender,
+			To:                    &authority,
+			GasLimit:              100000,
+			GasPrice:              new(big.Int),
+			SetCodeAuthorizations: []SetCodeAuthorization{signSetCode(t, tt.auth)},
+		}
+		if _, err := newStateTransition(vm, msg, nil).validateAuthorization(&msg.SetCodeAuthorizations[0]); !errors.Is(err, tt.want) {
+			t.Errorf("%s: validation error mismatch: have %v, want %v", tt.name, err, tt.want)
+		}
+		vm.Reset(NewTxContext(msg), db)
+		res, err := ApplyMessage(vm, msg, new(GasPool).AddGas(vm.Context.GasLimit))
+		if err != nil || res.Err != nil {
+			t.Fatalf("%s: apply failed: %v, %v", tt.name, err, res)
+		}
+		code := db.GetCode(authority)
+		if !tt.delegated {
+			if len(code) != 0 {
+				t.Errorf("%s: invalid authorization applied: %x", tt.name, code)
+			}
+			continue
+		}
+		if !bytes.Equal(code, AddressToDelegation(setCodeTarget)) {
+			t.Errorf("%s: code mismatch: have %x", tt.name, code)
+		}
+		if nonce := db.GetNonce(authority); nonce != 1 {
+			t.Errorf("%s: authority nonce mismatch: have %d, want 1", tt.name, nonce)
+		}
+		if have := db.GetState(authority, Hash{}); have != BytesToHash([]byte{42}) {
+			t.Errorf("%s: delegate didn't run for the authority: slot 0 is %x", tt.name, have)
+		}
+	}
+}
+
+func TestApplyMessageSetCodeErrors(t *testing.T) {
+	authority := keyAddress(setCodeKey)
+	auths := []SetCodeAuthorization{signSetCode(t, SetCodeAuthorization{Address: setCodeTarget})}
+	tests := []struct {
+		fork  string
+		to    *Address
+		auths []SetCodeAuthorization
+		want  error
+	}{
+		{"Cancun", &authority, auths, ErrTxTypeNotSupported},
+		{"Prague", nil, auths, ErrSetCodeTxCreate},
+		{"Prague", &authority, []SetCodeAuthorization{}, ErrEmptyAuthList},
+	}
+	for _, tt := range tests {
+		config, err := params.ForkConfig(tt.fork)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vm, db := newTransitionEnv(t, config, big.NewInt(0), 0)
+		msg := &Message{From: stSender, To: tt.to, GasLimit: 100000, GasPrice: new(big.Int), SetCodeAuthorizations: tt.auths}
+		vm.Reset(NewTxContext(msg), db)
+		if _, err := ApplyMessage(vm, msg, new(GasPool).AddGas(vm.Context.GasLimit)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.fork, err, tt.want)
+		}
+	}
+
+	// A delegated sender is still an EOA
+	config, _ := params.ForkConfig("Prague")
+	vm, db := newTransitionEnv(t, config, big.NewInt(0), 0)
+	db.SetCode(stSender, AddressToDelegation(setCodeTarget))
+	msg := &Message{From: stSender, To: &authority, GasLimit: 100000, GasPrice: new(big.Int)}
+	vm.Reset(NewTxContext(msg), db)
+	if _, err := ApplyMessage(vm, msg, new(GasPool).AddGas(vm.Context.GasLimit)); err != nil {
+		t.Errorf("delegated sender rejected: %v", err)
+	}
+}
+
+func TestDelegatedCall(t *testing.T) {
+	config, err := params.ForkConfig("Prague")
+	if err != nil {
+		t.Fatal(err)
+	}
+	authority := BytesToAddr([]byte("authority"))
+	push := func(addr Address) []byte {
+		return append([]byte{byte(PUSH1) + byte(AddressLength-1)}, addr[:]...)
+	}
+	// PUSH1 0 (x5) PUSHn authority GAS CALL POP, then EXTCODESIZE of the
+	// authority is returned
+	caller := append(Hex2Bytes("60006000600060006000"), push(authority)...)
+	caller = append(caller, Hex2Bytes("5af150")...)
+	caller = append(append(caller, push(authority)...), Hex2Bytes("3b60005260206000f3")...)
+
+	run := func(warmDelegate bool) (uint64, []byte) {
+		vm, db := newTransitionEnv(t, config, big.NewInt(0), 0)
+		db.SetCode(stContract, caller)
+		db.SetCode(authority, AddressToDelegation(setCodeTarget))
+		db.SetCode(setCodeTarget, Hex2Bytes("602a60005500"))
+		db.AddAddressToAccessList(stContract)
+		if warmDelegate {
+			db.AddAddressToAccessList(setCodeTarget)
+		}
+		ret, left, err := vm.Call(AccountRef(stSender), stContract, nil, 100000, new(big.Int))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if have := db.GetState(authority, Hash{}); have != BytesToHash([]byte{42}) {
+			t.Errorf("delegate didn't run for the authority: slot 0 is %x", have)
+		}
+		return 100000 - left, ret
+	}
+	cold, ret := run(false)
+	warm, _ := run(true)
+	// EXTCODESIZE reports the designator
+	if have := new(big.Int).SetBytes(ret); have.Int64() != int64(3+AddressLength) {
+		t.Errorf("extcodesize mismatch: have %v, want %d", have, 3+AddressLength)
+	}
+	if cold-warm != params.ColdAccountAccessCostEIP2929-params.WarmStorageReadCostEIP2929 {
+		t.Errorf("delegate access gas mismatch: cold %d, warm %d", cold, warm)
+	}
+}
@@ -87,3 +87,23 @@ func (s *Stack) peek() *uint256.Int {
 func (s *Stack) Back(n int) *uint256.Int {
 	return &s.data[s.len()-n-1]
 }
+
+// Push pushes a value onto the stack.
+func (s *Stack) Push(d *uint256.Int) {
+	s.push(d)
+}
+
+// Pop removes and returns the value on top of the stack.
+func (s *Stack) Pop() uint256.Int {
+	return s.pop()
+}
+
+// Peek returns the value on top of the stack without removing it.
+func (s *Stack) Peek() *uint256.Int {
+	return s.peek()
+}
+
+// Len returns the number of items on the stack.
+func (s *Stack) Len() int {
+	return s.len()
+}
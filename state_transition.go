@@ -0,0 +1,636 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/lyonnee/evm/math"
+	"github.com/lyonnee/evm/params"
+)
+
+// ExecutionResult includes all output after executing given evm
+// message no matter the execution itself is successful or not.
+type ExecutionResult struct {
+	UsedGas     uint64 // Total used gas, with the refund already deducted
+	RefundedGas uint64 // Gas returned to the sender by the refund counter
+	Err         error  // Any error encountered during the execution(listed in errors.go)
+	ReturnData  []byte // Returned data from evm(function result or data supplied with revert opcode)
+}
+
+// Unwrap returns the internal evm error which allows us for further
+// analysis outside.
+func (result *ExecutionResult) Unwrap() error {
+	return result.Err
+}
+
+// Failed returns the indicator whether the execution is successful or not
+func (result *ExecutionResult) Failed() bool { return result.Err != nil }
+
+// Return is a helper function to help caller distinguish between revert reason
+// and function return. Return returns the data after execution if no error occurs.
+func (result *ExecutionResult) Return() []byte {
+	if result.Err != nil {
+		return nil
+	}
+	return CopyBytes(result.ReturnData)
+}
+
+// Revert returns the concrete revert reason if the execution is aborted by `REVERT`
+// opcode. Note the reason can be nil if no data supplied with revert opcode.
+func (result *ExecutionResult) Revert() []byte {
+	if result.Err != ErrExecutionReverted {
+		return nil
+	}
+	return CopyBytes(result.ReturnData)
+}
+
+// RevertError returns the RevertError decoding the revert data, nil if the
+// execution was not aborted by the `REVERT` opcode. The decoders resolve the
+// custom errors of the called contract.
+func (result *ExecutionResult) RevertError(decoders ...ErrorDecoder) *RevertError {
+	if result.Err != ErrExecutionReverted {
+		return nil
+	}
+	return NewRevertError(result.ReturnData, decoders...)
+}
+
+// IntrinsicGas computes the 'intrinsic gas' for a message with the given data.
+func IntrinsicGas(data []byte, accessList AccessList, authList []SetCodeAuthorization, isContractCreation bool, isHomestead, isEIP2028, isEIP3860 bool) (uint64, error) {
+	// Set the starting gas for the raw transaction
+	var gas uint64
+	if isContractCreation && isHomestead {
+		gas = params.TxGasContractCreation
+	} else {
+		gas = params.TxGas
+	}
+	dataLen := uint64(len(data))
+	// Bump the required gas by the amount of transactional data
+	if dataLen > 0 {
+		// Zero and non-zero bytes are priced differently
+		var nz uint64
+		for _, byt := range data {
+			if byt != 0 {
+				nz++
+			}
+		}
+		// Make sure we don't exceed uint64 for all data combinations
+		nonZeroGas := params.TxDataNonZeroGasFrontier
+		if isEIP2028 {
+			nonZeroGas = params.TxDataNonZeroGasEIP2028
+		}
+		if (math.MaxUint64-gas)/nonZeroGas < nz {
+			return 0, ErrGasUintOverflow
+		}
+		gas += nz * nonZeroGas
+
+		z := dataLen - nz
+		if (math.MaxUint64-gas)/params.TxDataZeroGas < z {
+			return 0, ErrGasUintOverflow
+		}
+		gas += z * params.TxDataZeroGas
+
+		if isContractCreation && isEIP3860 {
+			lenWords := toWordSize(dataLen)
+			if (math.MaxUint64-gas)/params.InitCodeWordGas < lenWords {
+				return 0, ErrGasUintOverflow
+			}
+			gas += lenWords * params.InitCodeWordGas
+		}
+	}
+	if accessList != nil {
+		gas += uint64(len(accessList)) * params.TxAccessListAddressGas
+		gas += uint64(accessList.StorageKeys()) * params.TxAccessListStorageKeyGas
+	}
+	if authList != nil {
+		gas += uint64(len(authList)) * params.CallNewAccountGas
+	}
+	return gas, nil
+}
+
+// FloorDataGas computes the minimum gas a transaction carrying the given data
+// is charged, as introduced by EIP-7623.
+func FloorDataGas(data []byte) (uint64, error) {
+	var (
+		z      = uint64(bytes.Count(data, []byte{0}))
+		nz     = uint64(len(data)) - z
+		tokens = nz*params.TxTokenPerNonZeroByte + z
+	)
+	// Make sure we don't exceed uint64 for all data combinations
+	if (math.MaxUint64-params.TxGas)/params.TxCostFloorPerToken < tokens {
+		return 0, ErrGasUintOverflow
+	}
+	return params.TxGas + tokens*params.TxCostFloorPerToken, nil
+}
+
+// Message represents a transaction, already stripped of its signature, that
+// is ready to be applied to the state.
+//
+// GasPrice is the effective price paid per unit of gas. After London the
+// caller is expected to compute it as min(GasTipCap+BaseFee, GasFeeCap); for
+// legacy transactions GasFeeCap and GasTipCap may be left nil, in which case
+// they default to GasPrice.
+type Message struct {
+	To         *Address // nil means contract creation
+	From       Address
+	Nonce      uint64
+	Value      *big.Int
+	GasLimit   uint64
+	GasPrice   *big.Int
+	GasFeeCap  *big.Int
+	GasTipCap  *big.Int
+	Data       []byte
+	AccessList AccessList
+	// BlobHashes are the versioned hashes of the blobs of an EIP-4844 blob
+	// transaction, whose blob gas is paid at most BlobGasFeeCap per unit.
+	BlobHashes    []Hash
+	BlobGasFeeCap *big.Int
+	// SetCodeAuthorizations are the EIP-7702 authorizations of a set code
+	// transaction, applied before the call.
+	SetCodeAuthorizations []SetCodeAuthorization
+
+	// When SkipAccountChecks is true, the message nonce is not checked against the
+	// account nonce in state. It also disables checking that the sender is an EOA.
+	// This field will be set to true for operations like RPC eth_call.
+	SkipAccountChecks bool
+}
+
+// NewTxContext creates a new transaction context for a single message.
+func NewTxContext(msg *Message) TxContext {
+	return TxContext{
+		Origin:     msg.From,
+		GasPrice:   new(big.Int).Set(msg.GasPrice),
+		BlobHashes: msg.BlobHashes,
+	}
+}
+
+// ApplyMessage computes the new state by applying the given message
+// against the old state within the environment.
+//
+// ApplyMessage returns the bytes returned by any EVM execution (if it took place),
+// the gas used (which includes gas refunds) and an error if it failed. An error always
+// indicates a core error meaning that the message would always fail for that particular
+// state and would never be accepted within a block.
+//
+// The transaction context of the EVM is expected to be set up for the message
+// (see NewTxContext). Transaction scoped state such as the transient storage is
+// not reset here, the StateDB implementation has to do that between messages.
+func ApplyMessage(evm *EVM, msg *Message, gp *GasPool) (*ExecutionResult, error) {
+	return newStateTransition(evm, msg, gp).transitionDb()
+}
+
+// stateTransition represents a state transition.
+//
+// == The State Transitioning Model
+//
+// A state transition is a change made when a transaction is applied to the current world
+// state. The state transitioning model does all the necessary work to work out a valid new
+// state root.
+//
+//  1. Nonce handling
+//  2. Pre pay gas
+//  3. Create a new state object if the recipient is nil
+//  4. Value transfer
+//
+// == If contract creation ==
+//
+//	4a. Attempt to run transaction data
+//	4b. If valid, use result as code for the new state object
+//
+// == end ==
+//
+//  5. Run Script section
+//  6. Derive new state root
+type stateTransition struct {
+	gp           *GasPool
+	msg          *Message
+	gasRemaining uint64
+	initialGas   uint64
+	state        StateDB
+	evm          *EVM
+}
+
+// newStateTransition initialises and returns a new state transition object.
+// The message is shallow copied so that its unset fee fields can be defaulted
+// without touching the caller's value.
+func newStateTransition(evm *EVM, msg *Message, gp *GasPool) *stateTransition {
+	cpy := *msg
+	if cpy.Value == nil {
+		cpy.Value = new(big.Int)
+	}
+	if cpy.GasPrice == nil {
+		cpy.GasPrice = new(big.Int)
+	}
+	if cpy.GasFeeCap == nil {
+		cpy.GasFeeCap = cpy.GasPrice
+	}
+	if cpy.GasTipCap == nil {
+		cpy.GasTipCap = cpy.GasPrice
+	}
+	return &stateTransition{
+		gp:    gp,
+		evm:   evm,
+		msg:   &cpy,
+		state: evm.StateDB,
+	}
+}
+
+// to returns the recipient of the message.
+func (st *stateTransition) to() Address {
+	if st.msg == nil || st.msg.To == nil /* contract creation */ {
+		return NilAddr
+	}
+	return *st.msg.To
+}
+
+func (st *stateTransition) buyGas() error {
+	mgval := new(big.Int).SetUint64(st.msg.GasLimit)
+	mgval = mgval.Mul(mgval, st.msg.GasPrice)
+	balanceCheck := new(big.Int).SetUint64(st.msg.GasLimit)
+	balanceCheck = balanceCheck.Mul(balanceCheck, st.msg.GasFeeCap)
+	balanceCheck.Add(balanceCheck, st.msg.Value)
+
+	// The blob gas is paid at the blob gas price of the block, the balance
+	// must cover the fee cap
+	if blobGas := st.blobGasUsed(); blobGas > 0 {
+		blobFee := new(big.Int).SetUint64(blobGas)
+		mgval.Add(mgval, blobFee.Mul(blobFee, st.blobBaseFee()))
+		blobBalanceCheck := new(big.Int).SetUint64(blobGas)
+		balanceCheck.Add(balanceCheck, blobBalanceCheck.Mul(blobBalanceCheck, st.msg.BlobGasFeeCap))
+	}
+	if have, want := st.state.GetBalance(st.msg.From), balanceCheck; have.Cmp(want) < 0 {
+		return fmt.Errorf("%w: address %v have %v want %v", ErrInsufficientFunds, st.msg.From.Hex(), have, want)
+	}
+	if err := st.gp.SubGas(st.msg.GasLimit); err != nil {
+		return err
+	}
+	st.gasRemaining += st.msg.GasLimit
+
+	st.initialGas = st.msg.GasLimit
+	st.state.SubBalance(st.msg.From, mgval)
+	return nil
+}
+
+func (st *stateTransition) preCheck() error {
+	// Only check transactions that are not fake
+	msg := st.msg
+	if !msg.SkipAccountChecks {
+		// Make sure this transaction's nonce is correct.
+		stNonce := st.state.GetNonce(msg.From)
+		if msgNonce := msg.Nonce; stNonce < msgNonce {
+			return fmt.Errorf("%w: address %v, tx: %d state: %d", ErrNonceTooHigh,
+				msg.From.Hex(), msgNonce, stNonce)
+		} else if stNonce > msgNonce {
+			return fmt.Errorf("%w: address %v, tx: %d state: %d", ErrNonceTooLow,
+				msg.From.Hex(), msgNonce, stNonce)
+		} else if stNonce+1 < stNonce {
+			return fmt.Errorf("%w: address %v, nonce: %d", ErrNonceMax,
+				msg.From.Hex(), stNonce)
+		}
+		// Make sure the sender is an EOA, which may delegate to code (EIP-7702)
+		codeHash := st.state.GetCodeHash(msg.From)
+		_, delegated := ParseDelegation(st.state.GetCode(msg.From))
+		if codeHash != NilHash && codeHash != st.evm.emptyCodeHash && !delegated {
+			return fmt.Errorf("%w: address %v, codehash: %x", ErrSenderNoEOA,
+				msg.From.Hex(), codeHash)
+		}
+	}
+
+	// Make sure that transaction gasFeeCap is greater than the baseFee (post london)
+	if st.evm.chainRules.IsLondon {
+		// Skip the checks if gas fields are zero and baseFee was explicitly disabled (eth_call)
+		if !st.evm.Config.NoBaseFee || msg.GasFeeCap.BitLen() > 0 || msg.GasTipCap.BitLen() > 0 {
+			if l := msg.GasFeeCap.BitLen(); l > 256 {
+				return fmt.Errorf("%w: address %v, maxFeePerGas bit length: %d", ErrFeeCapVeryHigh,
+					msg.From.Hex(), l)
+			}
+			if l := msg.GasTipCap.BitLen(); l > 256 {
+				return fmt.Errorf("%w: address %v, maxPriorityFeePerGas bit length: %d", ErrTipVeryHigh,
+					msg.From.Hex(), l)
+			}
+			if msg.GasFeeCap.Cmp(msg.GasTipCap) < 0 {
+				return fmt.Errorf("%w: address %v, maxPriorityFeePerGas: %s, maxFeePerGas: %s", ErrTipAboveFeeCap,
+					msg.From.Hex(), msg.GasTipCap, msg.GasFeeCap)
+			}
+			if msg.GasFeeCap.Cmp(st.baseFee()) < 0 {
+				return fmt.Errorf("%w: address %v, maxFeePerGas: %s baseFee: %s", ErrFeeCapTooLow,
+					msg.From.Hex(), msg.GasFeeCap, st.baseFee())
+			}
+		}
+	}
+	// Check the shape of EIP-4844 blob transactions
+	if msg.BlobHashes != nil {
+		if !st.evm.chainRules.IsCancun {
+			return fmt.Errorf("%w: blob transaction before Cancun", ErrTxTypeNotSupported)
+		}
+		if msg.To == nil {
+			return fmt.Errorf("%w: address %v", ErrBlobTxCreate, msg.From.Hex())
+		}
+		if len(msg.BlobHashes) == 0 {
+			return fmt.Errorf("%w: address %v", ErrMissingBlobHashes, msg.From.Hex())
+		}
+		for i, hash := range msg.BlobHashes {
+			if hash[0] != params.BlobTxHashVersion {
+				return fmt.Errorf("%w: blob %d has version %d, supported %d", ErrBlobHashVersion,
+					i, hash[0], params.BlobTxHashVersion)
+			}
+		}
+		if have, limit := st.blobGasUsed(), MaxBlobGasPerBlock(st.evm.chainRules); have > limit {
+			return fmt.Errorf("%w: address %v, blob gas %d, limit %d", ErrTooManyBlobs,
+				msg.From.Hex(), have, limit)
+		}
+		if msg.BlobGasFeeCap == nil || msg.BlobGasFeeCap.Cmp(st.blobBaseFee()) < 0 {
+			return fmt.Errorf("%w: address %v, maxFeePerBlobGas: %v, blobBaseFee: %v", ErrBlobFeeCapTooLow,
+				msg.From.Hex(), msg.BlobGasFeeCap, st.blobBaseFee())
+		}
+	}
+	// Check the shape of EIP-7702 set code transactions
+	if msg.SetCodeAuthorizations != nil {
+		if !st.evm.chainRules.IsPrague {
+			return fmt.Errorf("%w: set code transaction before Prague", ErrTxTypeNotSupported)
+		}
+		if msg.To == nil {
+			return fmt.Errorf("%w: address %v", ErrSetCodeTxCreate, msg.From.Hex())
+		}
+		if len(msg.SetCodeAuthorizations) == 0 {
+			return fmt.Errorf("%w: address %v", ErrEmptyAuthList, msg.From.Hex())
+		}
+	}
+	return st.buyGas()
+}
+
+// transitionDb will transition the state by applying the current message and
+// returning the evm execution result with following fields.
+//
+//   - used gas: total gas used (including gas being refunded)
+//   - returndata: the returned data from evm
+//   - concrete execution error: various EVM errors which abort the execution, e.g.
+//     ErrOutOfGas, ErrExecutionReverted
+//
+// However if any consensus issue encountered, return the error directly with
+// nil evm execution result.
+func (st *stateTransition) transitionDb() (*ExecutionResult, error) {
+	// First check this message satisfies all consensus rules before
+	// applying the message. The rules include these clauses
+	//
+	// 1. the nonce of the message caller is correct
+	// 2. caller has enough balance to cover transaction fee(gaslimit * gasprice)
+	// 3. the amount of gas required is available in the block
+	// 4. the purchased gas is enough to cover intrinsic usage
+	// 5. there is no overflow when calculating intrinsic gas
+	// 6. caller has enough balance to cover asset transfer for **topmost** call
+
+	// Check clauses 1-3, buy gas if everything is correct
+	if err := st.preCheck(); err != nil {
+		return nil, err
+	}
+
+	if tracer := st.evm.Config.Tracer; tracer != nil {
+		tracer.CaptureTxStart(st.initialGas)
+		defer func() {
+			tracer.CaptureTxEnd(st.gasRemaining)
+		}()
+	}
+
+	var (
+		msg              = st.msg
+		sender           = AccountRef(msg.From)
+		rules            = st.evm.chainRules
+		contractCreation = msg.To == nil
+	)
+
+	// Check clauses 4-5, subtract intrinsic gas if everything is correct
+	gas, err := IntrinsicGas(msg.Data, msg.AccessList, msg.SetCodeAuthorizations, contractCreation, rules.IsHomestead, rules.IsIstanbul, rules.IsShanghai)
+	if err != nil {
+		return nil, err
+	}
+	if st.gasRemaining < gas {
+		return nil, fmt.Errorf("%w: have %d, want %d", ErrIntrinsicGas, st.gasRemaining, gas)
+	}
+	st.gasRemaining -= gas
+
+	// After EIP-7623 the gas limit must also cover the data floor cost
+	var floorDataGas uint64
+	if rules.IsPrague {
+		floorDataGas, err = FloorDataGas(msg.Data)
+		if err != nil {
+			return nil, err
+		}
+		if st.initialGas < floorDataGas {
+			return nil, fmt.Errorf("%w: have %d, want %d", ErrFloorDataGas, st.initialGas, floorDataGas)
+		}
+	}
+
+	// Check clause 6
+	if msg.Value.Sign() > 0 && !st.evm.Context.CanTransfer(st.state, msg.From, msg.Value) {
+		return nil, fmt.Errorf("%w: address %v", ErrInsufficientFundsForTransfer, msg.From.Hex())
+	}
+
+	// Check whether the init code size has been exceeded.
+	if rules.IsShanghai && contractCreation && uint64(len(msg.Data)) > params.MaxInitCodeSize {
+		return nil, fmt.Errorf("%w: code size %v limit %v", ErrMaxInitCodeSizeExceeded, len(msg.Data), params.MaxInitCodeSize)
+	}
+
+	// Prepare the access list (post-berlin)
+	st.prepareAccessList(rules)
+
+	var (
+		ret   []byte
+		vmerr error // vm errors do not effect consensus and are therefore not assigned to err
+	)
+	if contractCreation {
+		ret, _, st.gasRemaining, vmerr = st.evm.Create(sender, msg.Data, st.gasRemaining, msg.Value)
+	} else {
+		// Increment the nonce for the next transaction
+		st.state.SetNonce(msg.From, st.state.GetNonce(sender.Address())+1)
+		// Apply the EIP-7702 authorizations, the invalid ones are skipped
+		for i := range msg.SetCodeAuthorizations {
+			st.applyAuthorization(&msg.SetCodeAuthorizations[i])
+		}
+		// Warm the delegate of the recipient as a convenience
+		if rules.IsPrague {
+			if addr, ok := ParseDelegation(st.state.GetCode(st.to())); ok {
+				st.state.AddAddressToAccessList(addr)
+			}
+		}
+		ret, st.gasRemaining, vmerr = st.evm.Call(sender, st.to(), msg.Data, st.gasRemaining, msg.Value)
+	}
+
+	var refund uint64
+	if !rules.IsLondon {
+		// Before EIP-3529: refunds were capped to gasUsed / 2
+		refund = st.refundGas(params.RefundQuotient)
+	} else {
+		// After EIP-3529: refunds are capped to gasUsed / 5
+		refund = st.refundGas(params.RefundQuotientEIP3529)
+	}
+	if rules.IsPrague && st.gasUsed() < floorDataGas {
+		// After EIP-7623: the transaction pays at least the data floor cost
+		st.gasRemaining = st.initialGas - floorDataGas
+	}
+	st.returnGas()
+
+	effectiveTip := msg.GasPrice
+	if rules.IsLondon {
+		effectiveTip = math.BigMin(msg.GasTipCap, new(big.Int).Sub(msg.GasFeeCap, st.baseFee()))
+	}
+
+	if st.evm.Config.NoBaseFee && msg.GasFeeCap.Sign() == 0 && msg.GasTipCap.Sign() == 0 {
+		// Skip fee payment when NoBaseFee is set and the fee fields
+		// are 0. This avoids a negative effectiveTip being applied to
+		// the coinbase when simulating calls.
+	} else {
+		fee := new(big.Int).SetUint64(st.gasUsed())
+		fee.Mul(fee, effectiveTip)
+		st.state.AddBalance(st.evm.Context.Coinbase, fee)
+	}
+
+	return &ExecutionResult{
+		UsedGas:     st.gasUsed(),
+		RefundedGas: refund,
+		Err:         vmerr,
+		ReturnData:  ret,
+	}, nil
+}
+
+// prepareAccessList warms up the sender, the recipient, the precompiles and the
+// entries of the optional access list as required by EIP-2929 and EIP-2930,
+// as well as the coinbase (EIP-3651) once Shanghai is active.
+func (st *stateTransition) prepareAccessList(rules params.Rules) {
+	if !rules.IsBerlin {
+		return
+	}
+	st.state.AddAddressToAccessList(st.msg.From)
+	if st.msg.To != nil {
+		st.state.AddAddressToAccessList(*st.msg.To)
+	}
+	for _, addr := range st.evm.ActivePrecompiles() {
+		st.state.AddAddressToAccessList(addr)
+	}
+	for _, el := range st.msg.AccessList {
+		st.state.AddAddressToAccessList(el.Address)
+		for _, key := range el.StorageKeys {
+			st.state.AddSlotToAccessList(el.Address, key)
+		}
+	}
+	if rules.IsShanghai {
+		st.state.AddAddressToAccessList(st.evm.Context.Coinbase)
+	}
+}
+
+// validateAuthorization checks an EIP-7702 authorization against the chain
+// and the state of the authority, returning the authority.
+func (st *stateTransition) validateAuthorization(auth *SetCodeAuthorization) (Address, error) {
+	// The chain ID must be zero or the chain's
+	if !auth.ChainID.IsZero() && auth.ChainID.ToBig().Cmp(st.evm.chainRules.ChainID) != 0 {
+		return Address{}, ErrAuthorizationWrongChainID
+	}
+	// The nonce is limited to 2^64-1 by EIP-2681
+	if auth.Nonce+1 < auth.Nonce {
+		return Address{}, ErrAuthorizationNonceOverflow
+	}
+	authority, err := auth.Authority()
+	if err != nil {
+		return Address{}, fmt.Errorf("%w: %v", ErrAuthorizationInvalidSignature, err)
+	}
+	// The authority is warmed even if the authorization turns out invalid.
+	// Its code must be empty or a delegation, and its nonce must match.
+	st.state.AddAddressToAccessList(authority)
+	code := st.state.GetCode(authority)
+	if _, ok := ParseDelegation(code); len(code) != 0 && !ok {
+		return authority, ErrAuthorizationDestinationHasCode
+	}
+	if have := st.state.GetNonce(authority); have != auth.Nonce {
+		return authority, ErrAuthorizationNonceMismatch
+	}
+	return authority, nil
+}
+
+// applyAuthorization writes the delegation designator of a valid EIP-7702
+// authorization into the authority's account.
+func (st *stateTransition) applyAuthorization(auth *SetCodeAuthorization) error {
+	authority, err := st.validateAuthorization(auth)
+	if err != nil {
+		return err
+	}
+	// The intrinsic gas charged for a new account is partly refunded if the
+	// authority exists already
+	if st.state.Exist(authority) {
+		st.state.AddRefund(params.CallNewAccountGas - params.TxAuthTupleGas)
+	}
+	st.state.SetNonce(authority, auth.Nonce+1)
+	if auth.Address == (Address{}) {
+		// Delegating to the zero address clears the delegation
+		st.state.SetCode(authority, nil)
+		return nil
+	}
+	st.state.SetCode(authority, AddressToDelegation(auth.Address))
+	return nil
+}
+
+// refundGas applies the refund counter, capped to the refund quotient, and
+// reports the amount of gas refunded.
+func (st *stateTransition) refundGas(refundQuotient uint64) uint64 {
+	// Apply refund counter, capped to a refund quotient
+	refund := st.gasUsed() / refundQuotient
+	if refund > st.state.GetRefund() {
+		refund = st.state.GetRefund()
+	}
+	st.gasRemaining += refund
+	return refund
+}
+
+// returnGas returns the remaining gas to the sender and the block gas pool.
+func (st *stateTransition) returnGas() {
+	// Return ETH for remaining gas, exchanged at the original rate.
+	remaining := new(big.Int).Mul(new(big.Int).SetUint64(st.gasRemaining), st.msg.GasPrice)
+	st.state.AddBalance(st.msg.From, remaining)
+
+	// Also return remaining gas to the block gas counter so it is
+	// available for the next transaction.
+	st.gp.AddGas(st.gasRemaining)
+}
+
+// gasUsed returns the amount of gas used up by the state transition.
+func (st *stateTransition) gasUsed() uint64 {
+	return st.initialGas - st.gasRemaining
+}
+
+// baseFee returns the block base fee, treating a missing one as zero.
+func (st *stateTransition) baseFee() *big.Int {
+	if st.evm.Context.BaseFee == nil {
+		return new(big.Int)
+	}
+	return st.evm.Context.BaseFee
+}
+
+// blobGasUsed returns the amount of blob gas used by the message.
+func (st *stateTransition) blobGasUsed() uint64 {
+	return uint64(len(st.msg.BlobHashes)) * params.BlobTxBlobGasPerBlob
+}
+
+// blobBaseFee returns the blob gas price of the block, treating a missing
+// excess blob gas as zero.
+func (st *stateTransition) blobBaseFee() *big.Int {
+	var excess uint64
+	if st.evm.Context.ExcessBlobGas != nil {
+		excess = *st.evm.Context.ExcessBlobGas
+	}
+	return CalcBlobFee(st.evm.chainRules, excess)
+}
@@ -0,0 +1,371 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/lyonnee/evm/params"
+)
+
+var (
+	stSender   = BytesToAddr([]byte("sender"))
+	stCoinbase = BytesToAddr([]byte("coinbase"))
+	stContract = BytesToAddr([]byte("contract"))
+)
+
+var istanbulChainConfig = &params.ChainConfig{
+	ChainID:             big.NewInt(1),
+	HomesteadBlock:      big.NewInt(0),
+	EIP150Block:         big.NewInt(0),
+	EIP155Block:         big.NewInt(0),
+	EIP158Block:         big.NewInt(0),
+	ByzantiumBlock:      big.NewInt(0),
+	ConstantinopleBlock: big.NewInt(0),
+	PetersburgBlock:     big.NewInt(0),
+	IstanbulBlock:       big.NewInt(0),
+}
+
+// newTransitionEnv sets up a state with a funded sender and a contract
+// clearing two pre-populated storage slots.
+func newTransitionEnv(t *testing.T, config *params.ChainConfig, baseFee *big.Int, time uint64) (*EVM, *StateDBImpl) {
+	t.Helper()
+
+	statedb, _ := state.New(types.EmptyRootHash, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	db := &StateDBImpl{db: statedb}
+	db.AddBalance(stSender, big.NewInt(1e18))
+	// PUSH1 0 PUSH1 0 SSTORE PUSH1 0 PUSH1 1 SSTORE STOP
+	db.SetCode(stContract, Hex2Bytes("6000600055600060015500"))
+	db.SetNonce(stContract, 1)
+	db.SetState(stContract, BytesToHash([]byte{0}), BytesToHash([]byte{1}))
+	db.SetState(stContract, BytesToHash([]byte{1}), BytesToHash([]byte{1}))
+	statedb.Finalise(true)
+
+	blockCtx := BlockContext{
+		CanTransfer: CanTransfer,
+		Transfer:    Transfer,
+		Coinbase:    stCoinbase,
+		GasLimit:    30_000_000,
+		BlockNumber: big.NewInt(1),
+		Time:        time,
+		Difficulty:  new(big.Int),
+		BaseFee:     baseFee,
+	}
+	return NewEVM(blockCtx, TxContext{}, db, config, Config{}), db
+}
+
+func TestApplyMessageTransfer(t *testing.T) {
+	vm, db := newTransitionEnv(t, allEthashProtocolChanges, big.NewInt(10), 0)
+	to := BytesToAddr([]byte("recipient"))
+	msg := &Message{
+		From:      stSender,
+		To:        &to,
+		Value:     big.NewInt(1000),
+		GasLimit:  50000,
+		GasPrice:  big.NewInt(12),
+		GasFeeCap: big.NewInt(20),
+		GasTipCap: big.NewInt(2),
+	}
+	vm.Reset(NewTxContext(msg), db)
+
+	gp := new(GasPool).AddGas(vm.Context.GasLimit)
+	res, err := ApplyMessage(vm, msg, gp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Failed() {
+		t.Fatalf("execution failed: %v", res.Err)
+	}
+	if res.UsedGas != params.TxGas {
+		t.Errorf("used gas mismatch: have %d, want %d", res.UsedGas, params.TxGas)
+	}
+	if have := gp.Gas(); have != vm.Context.GasLimit-params.TxGas {
+		t.Errorf("gas pool mismatch: have %d, want %d", have, vm.Context.GasLimit-params.TxGas)
+	}
+	if have := db.GetNonce(stSender); have != 1 {
+		t.Errorf("nonce mismatch: have %d, want 1", have)
+	}
+	if have := db.GetBalance(to); have.Cmp(big.NewInt(1000)) != 0 {
+		t.Errorf("recipient balance mismatch: have %v", have)
+	}
+	// The sender pays the effective gas price, the coinbase only receives the tip.
+	wantSender := new(big.Int).Sub(big.NewInt(1e18), big.NewInt(1000+21000*12))
+	if have := db.GetBalance(stSender); have.Cmp(wantSender) != 0 {
+		t.Errorf("sender balance mismatch: have %v, want %v", have, wantSender)
+	}
+	if have := db.GetBalance(stCoinbase); have.Cmp(big.NewInt(21000*2)) != 0 {
+		t.Errorf("coinbase balance mismatch: have %v, want %v", have, 21000*2)
+	}
+}
+
+func TestApplyMessageRefundCap(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     *params.ChainConfig
+		baseFee    *big.Int
+		wantUsed   uint64
+		wantRefund uint64
+	}{
+		// 21000 + 4*3 + 2*5000 = 31012 gas spent, refund capped to 31012/2
+		{"istanbul", istanbulChainConfig, nil, 31012 - 15506, 15506},
+		// 21000 + 4*3 + 2*(2100+2900) = 31012 gas spent, refund capped to 31012/5
+		{"london", allEthashProtocolChanges, big.NewInt(1), 31012 - 6202, 6202},
+	}
+	for _, tt := range tests {
+		vm, db := newTransitionEnv(t, tt.config, tt.baseFee, 0)
+		msg := &Message{
+			From:     stSender,
+			To:       &stContract,
+			GasLimit: 100000,
+			GasPrice: big.NewInt(1),
+		}
+		vm.Reset(NewTxContext(msg), db)
+		res, err := ApplyMessage(vm, msg, new(GasPool).AddGas(vm.Context.GasLimit))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if res.UsedGas != tt.wantUsed || res.RefundedGas != tt.wantRefund {
+			t.Errorf("%s: gas mismatch: have used %d refund %d, want used %d refund %d",
+				tt.name, res.UsedGas, res.RefundedGas, tt.wantUsed, tt.wantRefund)
+		}
+	}
+}
+
+func TestApplyMessageErrors(t *testing.T) {
+	to := BytesToAddr([]byte("recipient"))
+	shanghai := uint64(0)
+	shanghaiConfig := *allEthashProtocolChanges
+	shanghaiConfig.ShanghaiTime = &shanghai
+
+	tests := []struct {
+		name   string
+		msg    Message
+		config *params.ChainConfig
+		gas    uint64
+		want   error
+	}{
+		{"nonce too high", Message{From: stSender, To: &to, Nonce: 1, GasLimit: 21000}, allEthashProtocolChanges, 21000, ErrNonceTooHigh},
+		{"sender not eoa", Message{From: stContract, To: &to, Nonce: 1, GasLimit: 21000}, allEthashProtocolChanges, 21000, ErrSenderNoEOA},
+		{"gas limit reached", Message{From: stSender, To: &to, GasPrice: big.NewInt(2), GasLimit: 21000}, allEthashProtocolChanges, 20999, ErrGasLimitReached},
+		{"intrinsic gas", Message{From: stSender, To: &to, GasPrice: big.NewInt(2), GasLimit: 20999}, allEthashProtocolChanges, 21000, ErrIntrinsicGas},
+		{"insufficient funds", Message{From: stSender, To: &to, GasLimit: 21000, GasPrice: big.NewInt(1e18)}, allEthashProtocolChanges, 21000, ErrInsufficientFunds},
+		{"fee cap too low", Message{From: stSender, To: &to, GasLimit: 21000, GasPrice: big.NewInt(1)}, allEthashProtocolChanges, 21000, ErrFeeCapTooLow},
+		{"tip above fee cap", Message{From: stSender, To: &to, GasLimit: 21000, GasFeeCap: big.NewInt(10), GasTipCap: big.NewInt(11)}, allEthashProtocolChanges, 21000, ErrTipAboveFeeCap},
+		{"initcode too large", Message{From: stSender, GasPrice: big.NewInt(2), GasLimit: 10_000_000, Data: make([]byte, params.MaxInitCodeSize+1)}, &shanghaiConfig, 10_000_000, ErrMaxInitCodeSizeExceeded},
+	}
+	for _, tt := range tests {
+		vm, db := newTransitionEnv(t, tt.config, big.NewInt(2), 0)
+		vm.Reset(TxContext{Origin: tt.msg.From, GasPrice: new(big.Int)}, db)
+		_, err := ApplyMessage(vm, &tt.msg, new(GasPool).AddGas(tt.gas))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestCalcBlobFee(t *testing.T) {
+	cancun, _ := params.ForkConfig("Cancun")
+	prague, _ := params.ForkConfig("Prague")
+	tests := []struct {
+		config *params.ChainConfig
+		excess uint64
+		want   int64
+	}{
+		{cancun, 0, 1},
+		{cancun, 2 * 3338477, 7},
+		{cancun, 10 * 1024 * 1024, 23},
+		{prague, 0, 1},
+		{prague, 2 * 3338477, 3},
+		{prague, 10 * 1024 * 1024, 8},
+	}
+	for _, tt := range tests {
+		rules := tt.config.Rules(big.NewInt(1), true, 0)
+		if have := CalcBlobFee(rules, tt.excess); have.Cmp(big.NewInt(tt.want)) != 0 {
+			t.Errorf("prague %v, excess %d: blob fee mismatch: have %v, want %d", rules.IsPrague, tt.excess, have, tt.want)
+		}
+	}
+}
+
+func TestApplyMessageBlobs(t *testing.T) {
+	cancun, _ := params.ForkConfig("Cancun")
+	london, _ := params.ForkConfig("London")
+	var (
+		to     = BytesToAddr([]byte("recipient"))
+		excess = uint64(10 * 1024 * 1024) // blob gas price of 23
+		hashes = func(n int) []Hash {
+			list := make([]Hash, n)
+			for i := range list {
+				list[i][0] = params.BlobTxHashVersion
+			}
+			return list
+		}
+		blobMsg = func(hashes []Hash, feeCap int64) Message {
+			return Message{
+				From:          stSender,
+				To:            &to,
+				GasLimit:      21000,
+				GasPrice:      big.NewInt(12),
+				GasFeeCap:     big.NewInt(20),
+				GasTipCap:     big.NewInt(2),
+				BlobHashes:    hashes,
+				BlobGasFeeCap: big.NewInt(feeCap),
+			}
+		}
+	)
+	// The blob gas is paid at the block's price, not at the fee cap
+	vm, db := newTransitionEnv(t, cancun, big.NewInt(10), 0)
+	vm.Context.ExcessBlobGas = &excess
+	msg := blobMsg(hashes(2), 100)
+	vm.Reset(NewTxContext(&msg), db)
+	if _, err := ApplyMessage(vm, &msg, new(GasPool).AddGas(vm.Context.GasLimit)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := new(big.Int).Sub(big.NewInt(1e18), big.NewInt(21000*12+2*params.BlobTxBlobGasPerBlob*23))
+	if have := db.GetBalance(stSender); have.Cmp(want) != 0 {
+		t.Errorf("sender balance mismatch: have %v, want %v", have, want)
+	}
+	if have := db.GetBalance(stCoinbase); have.Cmp(big.NewInt(21000*2)) != 0 {
+		t.Errorf("coinbase balance mismatch: have %v, want %v", have, 21000*2)
+	}
+
+	invalidHash := hashes(1)
+	invalidHash[0][0] = 0x02
+	create := blobMsg(hashes(1), 100)
+	create.To = nil
+	tests := []struct {
+		name   string
+		msg    Message
+		config *params.ChainConfig
+		want   error
+	}{
+		{"before cancun", blobMsg(hashes(1), 100), london, ErrTxTypeNotSupported},
+		{"create", create, cancun, ErrBlobTxCreate},
+		{"no blobs", blobMsg([]Hash{}, 100), cancun, ErrMissingBlobHashes},
+		{"hash version", blobMsg(invalidHash, 100), cancun, ErrBlobHashVersion},
+		{"too many blobs", blobMsg(hashes(7), 100), cancun, ErrTooManyBlobs},
+		{"fee cap too low", blobMsg(hashes(1), 22), cancun, ErrBlobFeeCapTooLow},
+		{"insufficient funds", blobMsg(hashes(1), 1e13), cancun, ErrInsufficientFunds},
+	}
+	for _, tt := range tests {
+		vm, db := newTransitionEnv(t, tt.config, big.NewInt(10), 0)
+		vm.Context.ExcessBlobGas = &excess
+		vm.Reset(NewTxContext(&tt.msg), db)
+		_, err := ApplyMessage(vm, &tt.msg, new(GasPool).AddGas(vm.Context.GasLimit))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestIntrinsicGas(t *testing.T) {
+	data := []byte{0, 1, 0, 2}
+	accessList := AccessList{{Address: stContract, StorageKeys: []Hash{{}, {}}}}
+	tests := []struct {
+		create, homestead, eip2028, eip3860 bool
+		accessList                          AccessList
+		want                                uint64
+	}{
+		{false, false, false, false, nil, 21000 + 2*4 + 2*68},
+		{false, true, true, false, nil, 21000 + 2*4 + 2*16},
+		{true, false, true, false, nil, 21000 + 2*4 + 2*16},
+		{true, true, true, false, nil, 53000 + 2*4 + 2*16},
+		{true, true, true, true, nil, 53000 + 2*4 + 2*16 + 2},
+		{false, true, true, false, accessList, 21000 + 2*4 + 2*16 + 2400 + 2*1900},
+	}
+	for i, tt := range tests {
+		have, err := IntrinsicGas(data, tt.accessList, nil, tt.create, tt.homestead, tt.eip2028, tt.eip3860)
+		if err != nil {
+			t.Fatalf("test %d: unexpected error: %v", i, err)
+		}
+		if have != tt.want {
+			t.Errorf("test %d: intrinsic gas mismatch: have %d, want %d", i, have, tt.want)
+		}
+	}
+	// EIP-7702 authorizations are charged as new accounts
+	have, err := IntrinsicGas(nil, nil, make([]SetCodeAuthorization, 2), false, true, true, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := uint64(21000 + 2*25000); have != want {
+		t.Errorf("authorizations: intrinsic gas mismatch: have %d, want %d", have, want)
+	}
+}
+
+func TestFloorDataGas(t *testing.T) {
+	// Two zero bytes count for a token each, two non-zero ones for four
+	have, err := FloorDataGas([]byte{0, 1, 0, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := uint64(21000 + 10*(2+2*4)); have != want {
+		t.Errorf("floor gas mismatch: have %d, want %d", have, want)
+	}
+}
+
+func TestApplyMessageFloorDataGas(t *testing.T) {
+	to := BytesToAddr([]byte("recipient"))
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = 0xff
+	}
+	// 21000 + 100*16 intrinsic gas, 21000 + 100*4*10 floor
+	const intrinsic, floor = 22600, 25000
+
+	tests := []struct {
+		fork     string
+		gasLimit uint64
+		wantUsed uint64
+		wantErr  error
+	}{
+		{"Cancun", floor, intrinsic, nil},
+		{"Prague", floor, floor, nil},
+		{"Prague", floor - 1, 0, ErrFloorDataGas},
+	}
+	for _, tt := range tests {
+		config, err := params.ForkConfig(tt.fork)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vm, db := newTransitionEnv(t, config, big.NewInt(1), 0)
+		msg := &Message{
+			From:     stSender,
+			To:       &to,
+			GasLimit: tt.gasLimit,
+			GasPrice: big.NewInt(1),
+			Data:     data,
+		}
+		vm.Reset(NewTxContext(msg), db)
+		res, err := ApplyMessage(vm, msg, new(GasPool).AddGas(vm.Context.GasLimit))
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: error mismatch: have %v, want %v", tt.fork, err, tt.wantErr)
+		}
+		if err != nil {
+			continue
+		}
+		if res.UsedGas != tt.wantUsed {
+			t.Errorf("%s: gas used mismatch: have %d, want %d", tt.fork, res.UsedGas, tt.wantUsed)
+		}
+		if have, want := db.GetBalance(stSender), new(big.Int).SetUint64(1e18-tt.wantUsed); have.Cmp(want) != 0 {
+			t.Errorf("%s: sender balance mismatch: have %v, want %v", tt.fork, have, want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"math/big"
+)
+
+// systemCallGas is the gas limit of the calls the protocol makes to the
+// system contracts.
+const systemCallGas = 30_000_000
+
+var (
+	// SystemAddress is the caller of the calls the protocol makes to the
+	// system contracts.
+	SystemAddress = HexToAddress("0xfffffffffffffffffffffffffffffffffffffffe")
+
+	// HistoryStorageAddress is the address of the EIP-2935 history storage
+	// contract, which serves the hashes of the last HistoryServeWindow blocks.
+	HistoryStorageAddress = HexToAddress("0x0000F90827F1C53a10cb7A02335B175320002935")
+
+	// HistoryStorageCode is the runtime code of the history storage contract,
+	// to be deployed at HistoryStorageAddress in the genesis of chains starting
+	// on Prague.
+	HistoryStorageCode = FromHex("3373fffffffffffffffffffffffffffffffffffffffe14604657602036036042575f35600143038111604257611fff81430311604257611fff9006545f5260205ff35b5f5ffd5b5f35611fff60014303065500")
+)
+
+// ProcessParentBlockHash stores the hash of the parent block in the history
+// storage contract as specified by EIP-2935. It is to be called at the start
+// of every block before the transactions are applied, and does nothing before
+// Prague. The caller finalises the state afterwards.
+func ProcessParentBlockHash(prevHash Hash, evm *EVM) error {
+	if !evm.chainRules.IsPrague {
+		return nil
+	}
+	evm.Reset(TxContext{Origin: SystemAddress, GasPrice: new(big.Int)}, evm.StateDB)
+	evm.StateDB.AddAddressToAccessList(HistoryStorageAddress)
+	_, _, err := evm.Call(AccountRef(SystemAddress), HistoryStorageAddress, prevHash.Bytes(), systemCallGas, new(big.Int))
+	return err
+}
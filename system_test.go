@@ -0,0 +1,80 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/lyonnee/evm/params"
+)
+
+func TestProcessParentBlockHash(t *testing.T) {
+	config, err := params.ForkConfig("Prague")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, db := newTransitionEnv(t, config, big.NewInt(0), 0)
+	db.SetCode(HistoryStorageAddress, HistoryStorageCode)
+
+	const number = 10000
+	parent := BytesToHash([]byte("parent"))
+	vm.Context.BlockNumber = big.NewInt(number)
+	if err := ProcessParentBlockHash(parent, vm); err != nil {
+		t.Fatalf("system call failed: %v", err)
+	}
+	slot := BytesToHash(big.NewInt((number - 1) % int64(params.HistoryServeWindow)).Bytes())
+	if have := db.GetState(HistoryStorageAddress, slot); have != parent {
+		t.Fatalf("stored hash mismatch: have %x, want %x", have, parent)
+	}
+
+	// The hash is served for HistoryServeWindow blocks
+	query := BytesToHash(big.NewInt(number - 1).Bytes())
+	tests := []struct {
+		number  uint64
+		wantErr error
+	}{
+		{number - 1, ErrExecutionReverted},
+		{number, nil},
+		{number - 1 + params.HistoryServeWindow, nil},
+		{number + params.HistoryServeWindow, ErrExecutionReverted},
+	}
+	for _, tt := range tests {
+		vm.Context.BlockNumber = new(big.Int).SetUint64(tt.number)
+		ret, _, err := vm.Call(AccountRef(stSender), HistoryStorageAddress, query.Bytes(), 100000, new(big.Int))
+		if err != tt.wantErr {
+			t.Errorf("block %d: error mismatch: have %v, want %v", tt.number, err, tt.wantErr)
+		} else if err == nil && BytesToHash(ret) != parent {
+			t.Errorf("block %d: hash mismatch: have %x, want %x", tt.number, ret, parent)
+		}
+	}
+}
+
+func TestProcessParentBlockHashBeforePrague(t *testing.T) {
+	config, err := params.ForkConfig("Cancun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, db := newTransitionEnv(t, config, big.NewInt(0), 0)
+	db.SetCode(HistoryStorageAddress, HistoryStorageCode)
+	if err := ProcessParentBlockHash(BytesToHash([]byte("parent")), vm); err != nil {
+		t.Fatal(err)
+	}
+	if have := db.GetState(HistoryStorageAddress, BytesToHash([]byte{0})); have != (Hash{}) {
+		t.Errorf("hash stored before Prague: %x", have)
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	gethtests "github.com/ethereum/go-ethereum/tests"
+	"github.com/ethereum/go-ethereum/trie"
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/memstate"
+)
+
+// fixtureDir returns the directory holding the fixtures matching the address
+// width the module is built with, the state roots depending on it.
+func fixtureDir() string {
+	if evm.AddressLength == 20 {
+		return filepath.Join("testdata", "ethereum")
+	}
+	return filepath.Join("testdata", "native")
+}
+
+func readStateTests(t *testing.T, path string) map[string]StateTest {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests map[string]StateTest
+	if err := json.Unmarshal(data, &tests); err != nil {
+		t.Fatalf("invalid fixture %s: %v", path, err)
+	}
+	return tests
+}
+
+func TestState(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(fixtureDir(), "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		for name, test := range readStateTests(t, file) {
+			test := test
+			for _, subtest := range test.Subtests() {
+				subtest := subtest
+				key := fmt.Sprintf("%s/%s/%d", name, subtest.Fork, subtest.Index)
+				t.Run(key, func(t *testing.T) {
+					if _, _, err := test.Run(subtest, evm.Config{}); err != nil {
+						t.Error(err)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestStateRootMismatch(t *testing.T) {
+	test := readStateTests(t, filepath.Join(fixtureDir(), "add11.json"))["add11"]
+	subtest := StateSubtest{Fork: "Istanbul", Index: 0}
+	want := test.json.Post[subtest.Fork][subtest.Index].Root
+	test.json.Post[subtest.Fork][subtest.Index].Root = evm.Hash{}
+
+	_, root, err := test.Run(subtest, evm.Config{})
+	if err == nil || !strings.Contains(err.Error(), "post state root mismatch") {
+		t.Fatalf("expected root mismatch, got %v", err)
+	}
+	if root != want {
+		t.Errorf("root mismatch: have %x, want %x", root, want)
+	}
+}
+
+func TestStateUnsupportedFork(t *testing.T) {
+	test := readStateTests(t, filepath.Join(fixtureDir(), "add11.json"))["add11"]
+	test.json.Post["Atlantis"] = test.json.Post["Istanbul"]
+
+	_, _, err := test.Run(StateSubtest{Fork: "Atlantis", Index: 0}, evm.Config{})
+	if _, ok := err.(UnsupportedForkError); !ok {
+		t.Fatalf("expected unsupported fork error, got %v", err)
+	}
+}
+
+func TestStateBlobSchedule(t *testing.T) {
+	test := readStateTests(t, filepath.Join(fixtureDir(), "blobhash.json"))["blobhash"]
+	// The blob limit of go-ethereum before EIP-7691 retuned Cancun
+	test.json.Config.BlobSchedule["Cancun"].Max = 4
+
+	_, _, err := test.Run(StateSubtest{Fork: "Cancun", Index: 0}, evm.Config{})
+	if err == nil || !strings.Contains(err.Error(), "unsupported blob schedule") {
+		t.Fatalf("expected blob schedule error, got %v", err)
+	}
+}
+
+func TestStateAuthorizationParity(t *testing.T) {
+	test := readStateTests(t, filepath.Join(fixtureDir(), "setcode.json"))["setcode"]
+	auth := test.json.Tx.AuthorizationList[0]
+	v := 1 - auth.YParity
+	auth.V = &v
+
+	_, _, err := test.Run(StateSubtest{Fork: "Prague", Index: 0}, evm.Config{})
+	if err == nil || !strings.Contains(err.Error(), "doesn't match y parity") {
+		t.Fatalf("expected parity error, got %v", err)
+	}
+}
+
+// fixtureAccount is an account of a post state, independent of the address
+// width.
+type fixtureAccount struct {
+	addr common.Address
+	*types.StateAccount
+}
+
+// fixtureRoot hashes the accounts into a state root, keying them by their
+// 20-byte or 32-byte address.
+func fixtureRoot(t *testing.T, accounts []fixtureAccount, native bool) common.Hash {
+	t.Helper()
+	tr := trie.NewEmpty(trie.NewDatabase(rawdb.NewMemoryDatabase()))
+	for _, account := range accounts {
+		enc, err := rlp.EncodeToBytes(account.StateAccount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := account.addr.Bytes()
+		if native {
+			key = common.LeftPadBytes(key, 32)
+		}
+		tr.MustUpdate(crypto.Keccak256(key), enc)
+	}
+	return tr.Hash()
+}
+
+// allocAccounts returns the accounts of an explicit post state, the code
+// being the one of the address width of the fixture.
+func allocAccounts(t *testing.T, alloc memstate.GenesisAlloc) []fixtureAccount {
+	t.Helper()
+	var accounts []fixtureAccount
+	for addr, account := range alloc {
+		storage := trie.NewEmpty(trie.NewDatabase(rawdb.NewMemoryDatabase()))
+		for key, val := range account.Storage {
+			if val == (evm.Hash{}) {
+				continue
+			}
+			enc, err := rlp.EncodeToBytes(common.TrimLeftZeroes(val[:]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			storage.MustUpdate(crypto.Keccak256(key[:]), enc)
+		}
+		balance := account.Balance
+		if balance == nil {
+			balance = new(big.Int)
+		}
+		accounts = append(accounts, fixtureAccount{common.BytesToAddress(addr.Bytes()), &types.StateAccount{
+			Nonce:    account.Nonce,
+			Balance:  balance,
+			Root:     storage.Hash(),
+			CodeHash: crypto.Keccak256(account.Code),
+		}})
+	}
+	return accounts
+}
+
+// gethAccounts executes the subtest with go-ethereum, returning the accounts
+// of its post state among addrs and the logs.
+func gethAccounts(t *testing.T, test gethtests.StateTest, subtest gethtests.StateSubtest, addrs []common.Address) ([]fixtureAccount, []*types.Log) {
+	t.Helper()
+	_, statedb, root, _ := test.RunNoVerify(subtest, vm.Config{}, false)
+
+	// Reopen the committed state, go-ethereum dropping its cached objects
+	logs := statedb.Logs()
+	statedb, err := state.New(root, statedb.Database(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var accounts []fixtureAccount
+	for _, addr := range addrs {
+		if !statedb.Exist(addr) {
+			continue
+		}
+		storage, err := statedb.StorageTrie(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		account := &types.StateAccount{
+			Nonce:    statedb.GetNonce(addr),
+			Balance:  statedb.GetBalance(addr),
+			Root:     types.EmptyRootHash,
+			CodeHash: statedb.GetCodeHash(addr).Bytes(),
+		}
+		if storage != nil {
+			account.Root = storage.Hash()
+		}
+		accounts = append(accounts, fixtureAccount{addr, account})
+	}
+	// The accounts must cover the whole post state
+	if have := fixtureRoot(t, accounts, false); have != root {
+		t.Fatalf("%s/%d: accounts missing from the post state", subtest.Fork, subtest.Index)
+	}
+	return accounts, logs
+}
+
+// logsHashes returns the hashes of the logs encoded with 20-byte and 32-byte
+// addresses.
+func logsHashes(t *testing.T, logs []*types.Log) (common.Hash, common.Hash) {
+	t.Helper()
+	type nativeLog struct {
+		Address [32]byte
+		Topics  []common.Hash
+		Data    []byte
+	}
+	enc := make([]nativeLog, len(logs))
+	for i, log := range logs {
+		copy(enc[i].Address[12:], log.Address.Bytes())
+		enc[i].Topics, enc[i].Data = log.Topics, log.Data
+	}
+	rlpLogs, err := rlp.EncodeToBytes(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rlpMainnet, err := rlp.EncodeToBytes(logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crypto.Keccak256Hash(rlpMainnet), crypto.Keccak256Hash(rlpLogs)
+}
+
+// TestFixtureRoots derives the hashes of the fixtures independently of the
+// module. The posts of the forks go-ethereum implements are executed by it,
+// and its post state is hashed with 20-byte and 32-byte addresses. The posts
+// of the later forks list their post state, which is hashed the same way,
+// their logs being only checked by executing them with the module.
+func TestFixtureRoots(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "ethereum", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var geth map[string]gethtests.StateTest
+		if err := json.Unmarshal(data, &geth); err != nil {
+			t.Fatal(err)
+		}
+		var (
+			mainnet = readStateTests(t, file)
+			native  = readStateTests(t, filepath.Join("testdata", "native", filepath.Base(file)))
+		)
+		for name, test := range mainnet {
+			// The accounts of the fixture, the sender being one of the pre-state
+			var addrs []common.Address
+			for addr := range test.json.Pre {
+				addrs = append(addrs, common.BytesToAddress(addr.Bytes()))
+			}
+			addrs = append(addrs, common.BytesToAddress(test.json.Env.Coinbase.Bytes()))
+
+			for _, subtest := range test.Subtests() {
+				var (
+					key        = fmt.Sprintf("%s/%s/%s/%d", filepath.Base(file), name, subtest.Fork, subtest.Index)
+					post       = test.json.Post[subtest.Fork][subtest.Index]
+					nativePost = native[name].json.Post[subtest.Fork][subtest.Index]
+				)
+				var mainnetAccounts, nativeAccounts []fixtureAccount
+				mainnetLogs, nativeLogs := common.Hash(post.Logs), common.Hash(nativePost.Logs)
+				if post.State != nil {
+					mainnetAccounts = allocAccounts(t, post.State)
+					nativeAccounts = allocAccounts(t, nativePost.State)
+				} else {
+					var logs []*types.Log
+					mainnetAccounts, logs = gethAccounts(t, geth[name], gethtests.StateSubtest{Fork: subtest.Fork, Index: subtest.Index}, addrs)
+					nativeAccounts = mainnetAccounts
+					mainnetLogs, nativeLogs = logsHashes(t, logs)
+				}
+				if have := fixtureRoot(t, mainnetAccounts, false); have != common.Hash(post.Root) {
+					t.Errorf("%s: root mismatch: have %x, want %x", key, have, post.Root)
+				}
+				if have := fixtureRoot(t, nativeAccounts, true); have != common.Hash(nativePost.Root) {
+					t.Errorf("%s: native root mismatch: have %x, want %x", key, have, nativePost.Root)
+				}
+				if mainnetLogs != common.Hash(post.Logs) {
+					t.Errorf("%s: logs hash mismatch: have %x, want %x", key, mainnetLogs, post.Logs)
+				}
+				if nativeLogs != common.Hash(nativePost.Logs) {
+					t.Errorf("%s: native logs hash mismatch: have %x, want %x", key, nativeLogs, nativePost.Logs)
+				}
+			}
+		}
+	}
+}
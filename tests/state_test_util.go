@@ -0,0 +1,515 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package tests implements execution of the Ethereum JSON test fixtures.
+package tests
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/holiman/uint256"
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/memstate"
+	"github.com/lyonnee/evm/params"
+)
+
+// UnsupportedForkError is returned when a test requests a fork that isn't implemented.
+type UnsupportedForkError struct {
+	Name string
+}
+
+func (e UnsupportedForkError) Error() string {
+	return fmt.Sprintf("unsupported fork %q", e.Name)
+}
+
+// StateTest checks transaction processing without block context.
+// See https://github.com/ethereum/EIPs/issues/176 for the test format specification.
+type StateTest struct {
+	json stJSON
+}
+
+// StateSubtest selects a specific configuration of a General State Test.
+type StateSubtest struct {
+	Fork  string
+	Index int
+}
+
+// UnmarshalJSON decodes the test, rejecting the fields the runner doesn't
+// know so that a fixture exercising an unsupported feature fails loudly
+// instead of running without it.
+func (t *StateTest) UnmarshalJSON(in []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(in))
+	dec.DisallowUnknownFields()
+	return dec.Decode(&t.json)
+}
+
+type stJSON struct {
+	Info   json.RawMessage          `json:"_info"`
+	Config stConfig                 `json:"config"`
+	Env    stEnv                    `json:"env"`
+	Pre    memstate.GenesisAlloc    `json:"pre"`
+	Tx     stTransaction            `json:"transaction"`
+	Out    hexutil.Bytes            `json:"out"`
+	Post   map[string][]stPostState `json:"post"`
+}
+
+// stConfig is the chain configuration of the test. The blob parameters can't
+// be configured, they are checked against the ones of the fork instead.
+type stConfig struct {
+	ChainID      *math.HexOrDecimal256      `json:"chainid"`
+	BlobSchedule map[string]*stBlobSchedule `json:"blobSchedule"`
+}
+
+// stBlobSchedule holds the blob parameters of a fork, counted in blobs.
+type stBlobSchedule struct {
+	Target                math.HexOrDecimal64 `json:"target"`
+	Max                   math.HexOrDecimal64 `json:"max"`
+	BaseFeeUpdateFraction math.HexOrDecimal64 `json:"baseFeeUpdateFraction"`
+}
+
+type stPostState struct {
+	Root            evm.Hash      `json:"hash"`
+	Logs            evm.Hash      `json:"logs"`
+	TxBytes         hexutil.Bytes `json:"txbytes"`
+	ExpectException string        `json:"expectException"`
+	Indexes         struct {
+		Data  int `json:"data"`
+		Gas   int `json:"gas"`
+		Value int `json:"value"`
+	} `json:"indexes"`
+	// State is the expected post state, checked account by account when set.
+	State memstate.GenesisAlloc `json:"state"`
+}
+
+type stEnv struct {
+	Coinbase   evm.Address           `json:"currentCoinbase"`
+	Difficulty *math.HexOrDecimal256 `json:"currentDifficulty"`
+	Random     *math.HexOrDecimal256 `json:"currentRandom"`
+	GasLimit   math.HexOrDecimal64   `json:"currentGasLimit"`
+	Number     math.HexOrDecimal64   `json:"currentNumber"`
+	Timestamp  math.HexOrDecimal64   `json:"currentTimestamp"`
+	BaseFee    *math.HexOrDecimal256 `json:"currentBaseFee"`
+
+	ExcessBlobGas *math.HexOrDecimal64 `json:"currentExcessBlobGas"`
+	// The beacon and withdrawals roots only matter to block processing, the
+	// state tests don't deploy the EIP-4788 beacon roots contract.
+	BeaconRoot      *evm.Hash `json:"currentBeaconRoot"`
+	WithdrawalsRoot *evm.Hash `json:"currentWithdrawalsRoot"`
+}
+
+type stTransaction struct {
+	GasPrice             *math.HexOrDecimal256 `json:"gasPrice"`
+	MaxFeePerGas         *math.HexOrDecimal256 `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *math.HexOrDecimal256 `json:"maxPriorityFeePerGas"`
+	Nonce                math.HexOrDecimal64   `json:"nonce"`
+	To                   string                `json:"to"`
+	Data                 []string              `json:"data"`
+	AccessLists          []*evm.AccessList     `json:"accessLists,omitempty"`
+	GasLimit             []math.HexOrDecimal64 `json:"gasLimit"`
+	Value                []string              `json:"value"`
+	PrivateKey           hexutil.Bytes         `json:"secretKey"`
+	Sender               *evm.Address          `json:"sender"`
+	BlobVersionedHashes  []evm.Hash            `json:"blobVersionedHashes,omitempty"`
+	MaxFeePerBlobGas     *math.HexOrDecimal256 `json:"maxFeePerBlobGas"`
+	AuthorizationList    []*stAuthorization    `json:"authorizationList"`
+}
+
+// stAuthorization is an EIP-7702 authorization of a set code transaction.
+// The signer is informational, the authority being recovered from the
+// signature. V is the legacy name of YParity, they must agree when both set.
+type stAuthorization struct {
+	ChainID *math.HexOrDecimal256 `json:"chainId"`
+	Address evm.Address           `json:"address"`
+	Nonce   math.HexOrDecimal64   `json:"nonce"`
+	V       *math.HexOrDecimal64  `json:"v"`
+	YParity math.HexOrDecimal64   `json:"yParity"`
+	R       *math.HexOrDecimal256 `json:"r"`
+	S       *math.HexOrDecimal256 `json:"s"`
+	Signer  *evm.Address          `json:"signer"`
+}
+
+// GetChainConfig takes a fork definition and returns a chain config.
+// The fork definition can be
+// - a plain forkname, e.g. `Byzantium`,
+// - a fork basename, and a list of EIPs to enable; e.g. `Byzantium+1884+1283`.
+func GetChainConfig(forkString string) (baseConfig *params.ChainConfig, eips []int, err error) {
+	var (
+		splitForks            = strings.Split(forkString, "+")
+		baseName, eipsStrings = splitForks[0], splitForks[1:]
+	)
+	if baseConfig, err = params.ForkConfig(baseName); err != nil {
+		return nil, nil, UnsupportedForkError{baseName}
+	}
+	for _, eip := range eipsStrings {
+		eipNum, err := strconv.Atoi(eip)
+		if err != nil || !evm.ValidEip(eipNum) {
+			return nil, nil, fmt.Errorf("syntax error, invalid eip number %v", eip)
+		}
+		eips = append(eips, eipNum)
+	}
+	return baseConfig, eips, nil
+}
+
+// Subtests returns all valid subtests of the test, ordered by fork and index.
+func (t *StateTest) Subtests() []StateSubtest {
+	var sub []StateSubtest
+	for fork, pss := range t.json.Post {
+		for i := range pss {
+			sub = append(sub, StateSubtest{fork, i})
+		}
+	}
+	sort.Slice(sub, func(i, j int) bool {
+		if sub[i].Fork != sub[j].Fork {
+			return sub[i].Fork < sub[j].Fork
+		}
+		return sub[i].Index < sub[j].Index
+	})
+	return sub
+}
+
+// checkError checks if the error returned by the state transition matches any expected error.
+// A failing expectation returns a wrapped version of the original error, if any,
+// or a new error detailing the failing expectation.
+func (t *StateTest) checkError(subtest StateSubtest, err error) error {
+	expectedError := t.json.Post[subtest.Fork][subtest.Index].ExpectException
+	if err == nil && expectedError != "" {
+		return fmt.Errorf("expected error %q, got no error", expectedError)
+	}
+	if err != nil && expectedError == "" {
+		return fmt.Errorf("unexpected error: %w", err)
+	}
+	// Expected errors are not matched against their description, the
+	// wording differs between clients.
+	return nil
+}
+
+// Run executes a specific subtest and verifies the post-state and logs. It
+// returns the post-state and its root even if the verification fails.
+func (t *StateTest) Run(subtest StateSubtest, vmconfig evm.Config) (*memstate.StateDB, evm.Hash, error) {
+	statedb, root, err := t.RunNoVerify(subtest, vmconfig)
+	if _, ok := err.(UnsupportedForkError); ok {
+		return statedb, root, err
+	}
+	if checkedErr := t.checkError(subtest, err); checkedErr != nil {
+		return statedb, root, checkedErr
+	}
+	// The error has been checked; if it was unexpected, it's already returned.
+	if err != nil {
+		// Here, an error exists but it was expected.
+		// We do not check the post state or logs.
+		return statedb, root, nil
+	}
+	post := t.json.Post[subtest.Fork][subtest.Index]
+	if root != post.Root {
+		return statedb, root, fmt.Errorf("post state root mismatch: got %x, want %x", root, post.Root)
+	}
+	if logs := LogsHash(statedb.Logs()); logs != post.Logs {
+		return statedb, root, fmt.Errorf("post state logs hash mismatch: got %x, want %x", logs, post.Logs)
+	}
+	if err := checkPostState(statedb, post.State); err != nil {
+		return statedb, root, err
+	}
+	return statedb, root, nil
+}
+
+// checkPostState compares the accounts of the expected post state against
+// the state.
+func checkPostState(statedb *memstate.StateDB, want memstate.GenesisAlloc) error {
+	for addr, account := range want {
+		if !statedb.Exist(addr) {
+			return fmt.Errorf("post state account %x missing", addr)
+		}
+		balance := account.Balance
+		if balance == nil {
+			balance = new(big.Int)
+		}
+		if have := statedb.GetBalance(addr); have.Cmp(balance) != 0 {
+			return fmt.Errorf("post state balance mismatch of %x: got %v, want %v", addr, have, balance)
+		}
+		if have := statedb.GetNonce(addr); have != account.Nonce {
+			return fmt.Errorf("post state nonce mismatch of %x: got %d, want %d", addr, have, account.Nonce)
+		}
+		if have := statedb.GetCode(addr); !bytes.Equal(have, account.Code) {
+			return fmt.Errorf("post state code mismatch of %x: got %x, want %x", addr, have, account.Code)
+		}
+		for key, val := range account.Storage {
+			if have := statedb.GetState(addr, key); have != val {
+				return fmt.Errorf("post state storage mismatch of %x at %x: got %x, want %x", addr, key, have, val)
+			}
+		}
+	}
+	return nil
+}
+
+// checkBlobSchedule checks that the blob parameters of the test match the
+// ones of the fork configured by the rules.
+func checkBlobSchedule(rules params.Rules, schedule *stBlobSchedule) error {
+	target, fraction := uint64(params.BlobTxTargetBlobGasPerBlock), uint64(params.BlobTxBlobGaspriceUpdateFraction)
+	if rules.IsPrague {
+		target, fraction = params.BlobTxTargetBlobGasPerBlockPrague, params.BlobTxBlobGaspriceUpdateFractionPrague
+	}
+	var (
+		wantTarget = target / params.BlobTxBlobGasPerBlob
+		wantMax    = evm.MaxBlobGasPerBlock(rules) / params.BlobTxBlobGasPerBlob
+	)
+	if uint64(schedule.Target) != wantTarget || uint64(schedule.Max) != wantMax || uint64(schedule.BaseFeeUpdateFraction) != fraction {
+		return fmt.Errorf("unsupported blob schedule: target %d, max %d, fraction %d, want %d, %d, %d",
+			schedule.Target, schedule.Max, schedule.BaseFeeUpdateFraction, wantTarget, wantMax, fraction)
+	}
+	return nil
+}
+
+// RunNoVerify runs a specific subtest and returns the statedb and post-state root
+func (t *StateTest) RunNoVerify(subtest StateSubtest, vmconfig evm.Config) (*memstate.StateDB, evm.Hash, error) {
+	config, eips, err := GetChainConfig(subtest.Fork)
+	if err != nil {
+		return nil, evm.Hash{}, UnsupportedForkError{subtest.Fork}
+	}
+	vmconfig.ExtraEips = eips
+	// The chain ID signs the transaction and the set code authorizations
+	if t.json.Config.ChainID != nil {
+		config.ChainID = new(big.Int).Set((*big.Int)(t.json.Config.ChainID))
+	}
+	statedb := memstate.NewFromAlloc(t.json.Pre)
+
+	var (
+		env     = t.json.Env
+		number  = new(big.Int).SetUint64(uint64(env.Number))
+		baseFee *big.Int
+	)
+	if config.IsLondon(number) {
+		baseFee = (*big.Int)(env.BaseFee)
+		if baseFee == nil {
+			// Retesteth uses `0x10` for genesis baseFee. Therefore, it defaults to
+			// parent - 2 : 0xa as the basefee for 'this' context.
+			baseFee = big.NewInt(0x0a)
+		}
+	}
+	post := t.json.Post[subtest.Fork][subtest.Index]
+	msg, err := t.json.Tx.toMessage(post, baseFee)
+	if err != nil {
+		return statedb, evm.Hash{}, err
+	}
+
+	// Try to recover tx with current signer
+	if len(post.TxBytes) != 0 {
+		var ttx types.Transaction
+		if err := ttx.UnmarshalBinary(post.TxBytes); err != nil {
+			return statedb, evm.Hash{}, err
+		}
+		if _, err := types.Sender(types.LatestSignerForChainID(config.ChainID), &ttx); err != nil {
+			return statedb, evm.Hash{}, err
+		}
+	}
+
+	// Prepare the EVM.
+	context := evm.BlockContext{
+		CanTransfer: evm.CanTransfer,
+		Transfer:    evm.Transfer,
+		GetHash:     vmTestBlockHash,
+		Coinbase:    env.Coinbase,
+		GasLimit:    uint64(env.GasLimit),
+		BlockNumber: number,
+		Time:        uint64(env.Timestamp),
+		Difficulty:  new(big.Int),
+		BaseFee:     baseFee,
+	}
+	if env.Difficulty != nil {
+		context.Difficulty = new(big.Int).Set((*big.Int)(env.Difficulty))
+	}
+	if config.IsLondon(number) && env.Random != nil {
+		rnd := evm.BytesToHash((*big.Int)(env.Random).Bytes())
+		context.Random = &rnd
+		context.Difficulty = big.NewInt(0)
+	}
+	if config.IsCancun(number, context.Time) {
+		fork := strings.Split(subtest.Fork, "+")[0]
+		if schedule := t.json.Config.BlobSchedule[fork]; schedule != nil {
+			rules := config.Rules(number, context.Random != nil, context.Time)
+			if err := checkBlobSchedule(rules, schedule); err != nil {
+				return statedb, evm.Hash{}, err
+			}
+		}
+		excess := uint64(0)
+		if env.ExcessBlobGas != nil {
+			excess = uint64(*env.ExcessBlobGas)
+		}
+		context.ExcessBlobGas = &excess
+	}
+	vm := evm.NewEVM(context, evm.NewTxContext(msg), statedb, config, vmconfig)
+
+	// Execute the message.
+	snapshot := statedb.Snapshot()
+	gaspool := new(evm.GasPool).AddGas(context.GasLimit)
+	_, err = evm.ApplyMessage(vm, msg, gaspool)
+	if err != nil {
+		statedb.RevertToSnapshot(snapshot)
+	}
+	// Add 0-value mining reward. This only makes a difference in the cases
+	// where
+	// - the coinbase self-destructed, or
+	// - there are only 'bad' transactions, which aren't executed. In those cases,
+	//   the coinbase gets no txfee, so isn't created, and thus needs to be touched
+	statedb.AddBalance(context.Coinbase, new(big.Int))
+	root := statedb.IntermediateRoot(config.IsEIP158(number))
+	return statedb, root, err
+}
+
+func (tx *stTransaction) toMessage(ps stPostState, baseFee *big.Int) (*evm.Message, error) {
+	// Derive sender from private key if present.
+	var from evm.Address
+	if tx.Sender != nil {
+		from = *tx.Sender
+	} else if len(tx.PrivateKey) > 0 {
+		key, err := crypto.ToECDSA(tx.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %v", err)
+		}
+		from = evm.BytesToAddr(crypto.PubkeyToAddress(key.PublicKey).Bytes())
+	}
+	// Parse recipient if present.
+	var to *evm.Address
+	if tx.To != "" {
+		to = new(evm.Address)
+		if err := to.UnmarshalText([]byte(tx.To)); err != nil {
+			return nil, fmt.Errorf("invalid to address: %v", err)
+		}
+	}
+
+	// Get values specific to this post state.
+	if ps.Indexes.Data >= len(tx.Data) {
+		return nil, fmt.Errorf("tx data index %d out of bounds", ps.Indexes.Data)
+	}
+	if ps.Indexes.Value >= len(tx.Value) {
+		return nil, fmt.Errorf("tx value index %d out of bounds", ps.Indexes.Value)
+	}
+	if ps.Indexes.Gas >= len(tx.GasLimit) {
+		return nil, fmt.Errorf("tx gas limit index %d out of bounds", ps.Indexes.Gas)
+	}
+	dataHex := tx.Data[ps.Indexes.Data]
+	valueHex := tx.Value[ps.Indexes.Value]
+	gasLimit := uint64(tx.GasLimit[ps.Indexes.Gas])
+	// Value, Data hex encoding is messy: https://github.com/ethereum/tests/issues/203
+	value := new(big.Int)
+	if valueHex != "0x" {
+		v, ok := math.ParseBig256(valueHex)
+		if !ok {
+			return nil, fmt.Errorf("invalid tx value %q", valueHex)
+		}
+		value = v
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(dataHex, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid tx data %q", dataHex)
+	}
+	var accessList evm.AccessList
+	if tx.AccessLists != nil && ps.Indexes.Data < len(tx.AccessLists) && tx.AccessLists[ps.Indexes.Data] != nil {
+		accessList = *tx.AccessLists[ps.Indexes.Data]
+	}
+	// If baseFee provided, set gasPrice to effectiveGasPrice.
+	var (
+		gasPrice  = (*big.Int)(tx.GasPrice)
+		gasFeeCap = (*big.Int)(tx.MaxFeePerGas)
+		gasTipCap = (*big.Int)(tx.MaxPriorityFeePerGas)
+	)
+	if baseFee != nil {
+		if gasFeeCap == nil {
+			gasFeeCap = gasPrice
+		}
+		if gasFeeCap == nil {
+			gasFeeCap = new(big.Int)
+		}
+		if gasTipCap == nil {
+			gasTipCap = gasFeeCap
+		}
+		gasPrice = math.BigMin(new(big.Int).Add(gasTipCap, baseFee), gasFeeCap)
+	}
+	if gasPrice == nil {
+		return nil, errors.New("no gas price provided")
+	}
+
+	var authList []evm.SetCodeAuthorization
+	if tx.AuthorizationList != nil {
+		authList = make([]evm.SetCodeAuthorization, len(tx.AuthorizationList))
+		for i, auth := range tx.AuthorizationList {
+			if auth.ChainID == nil || auth.R == nil || auth.S == nil {
+				return nil, fmt.Errorf("incomplete authorization %d", i)
+			}
+			if auth.V != nil && *auth.V != auth.YParity {
+				return nil, fmt.Errorf("authorization %d: v %d doesn't match y parity %d", i, *auth.V, auth.YParity)
+			}
+			authList[i] = evm.SetCodeAuthorization{
+				ChainID: *uint256.MustFromBig((*big.Int)(auth.ChainID)),
+				Address: auth.Address,
+				Nonce:   uint64(auth.Nonce),
+				V:       uint8(auth.YParity),
+				R:       *uint256.MustFromBig((*big.Int)(auth.R)),
+				S:       *uint256.MustFromBig((*big.Int)(auth.S)),
+			}
+		}
+	}
+
+	msg := &evm.Message{
+		From:                  from,
+		To:                    to,
+		Nonce:                 uint64(tx.Nonce),
+		Value:                 value,
+		GasLimit:              gasLimit,
+		GasPrice:              gasPrice,
+		GasFeeCap:             gasFeeCap,
+		GasTipCap:             gasTipCap,
+		Data:                  data,
+		AccessList:            accessList,
+		BlobHashes:            tx.BlobVersionedHashes,
+		BlobGasFeeCap:         (*big.Int)(tx.MaxFeePerBlobGas),
+		SetCodeAuthorizations: authList,
+	}
+	return msg, nil
+}
+
+// consensusLog is the consensus encoding of a log.
+type consensusLog struct {
+	Address evm.Address
+	Topics  []evm.Hash
+	Data    []byte
+}
+
+// LogsHash returns the hash of the RLP encoded consensus fields of the logs.
+func LogsHash(logs []evm.Log) evm.Hash {
+	enc := make([]consensusLog, len(logs))
+	for i, log := range logs {
+		enc[i] = consensusLog{log.Address, log.Topics, log.Data}
+	}
+	data, _ := rlp.EncodeToBytes(enc)
+	return evm.Keccak256Hash(data)
+}
+
+func vmTestBlockHash(n uint64) evm.Hash {
+	return evm.Keccak256Hash([]byte(big.NewInt(int64(n)).String()))
+}
@@ -0,0 +1,313 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/lyonnee/evm"
+)
+
+// CallLog is a log emitted inside a call frame.
+type CallLog struct {
+	Address evm.Address   `json:"address"`
+	Topics  []evm.Hash    `json:"topics"`
+	Data    hexutil.Bytes `json:"data"`
+}
+
+// CallFrame is a single call, create or selfdestruct in the call tree.
+type CallFrame struct {
+	Type         evm.OpCode
+	From         evm.Address
+	Gas          uint64
+	GasUsed      uint64
+	To           *evm.Address
+	Input        []byte
+	Output       []byte
+	Error        string
+	RevertReason string
+	Calls        []CallFrame
+	Logs         []CallLog
+	Value        *big.Int
+}
+
+// MarshalJSON marshals the frame in the format of geth's callTracer.
+func (f CallFrame) MarshalJSON() ([]byte, error) {
+	type callFrame struct {
+		Type         string         `json:"type"`
+		From         evm.Address    `json:"from"`
+		Gas          hexutil.Uint64 `json:"gas"`
+		GasUsed      hexutil.Uint64 `json:"gasUsed"`
+		To           *evm.Address   `json:"to,omitempty"`
+		Input        hexutil.Bytes  `json:"input"`
+		Output       hexutil.Bytes  `json:"output,omitempty"`
+		Error        string         `json:"error,omitempty"`
+		RevertReason string         `json:"revertReason,omitempty"`
+		Calls        []CallFrame    `json:"calls,omitempty"`
+		Logs         []CallLog      `json:"logs,omitempty"`
+		Value        *hexutil.Big   `json:"value,omitempty"`
+	}
+	enc := callFrame{
+		Type:         f.Type.String(),
+		From:         f.From,
+		Gas:          hexutil.Uint64(f.Gas),
+		GasUsed:      hexutil.Uint64(f.GasUsed),
+		To:           f.To,
+		Input:        f.Input,
+		Output:       f.Output,
+		Error:        f.Error,
+		RevertReason: f.RevertReason,
+		Calls:        f.Calls,
+		Logs:         f.Logs,
+		Value:        (*hexutil.Big)(f.Value),
+	}
+	return json.Marshal(&enc)
+}
+
+func (f CallFrame) failed() bool {
+	return len(f.Error) > 0
+}
+
+func (f *CallFrame) processOutput(output []byte, err error, errs evm.ErrorDecoder) {
+	output = evm.CopyBytes(output)
+	if err == nil {
+		f.Output = output
+		return
+	}
+	f.Error = err.Error()
+	if f.Type == evm.CREATE || f.Type == evm.CREATE2 {
+		f.To = nil
+	}
+	if !errors.Is(err, evm.ErrExecutionReverted) || len(output) == 0 {
+		return
+	}
+	f.Output = output
+	var decoders []evm.ErrorDecoder
+	if errs != nil {
+		decoders = append(decoders, errs)
+	}
+	f.RevertReason = evm.NewRevertError(output, decoders...).Reason
+}
+
+// CallTracerConfig are the configuration options for the call tracer.
+type CallTracerConfig struct {
+	OnlyTopCall bool `json:"onlyTopCall"` // If true, call tracer won't collect any subcalls
+	WithLog     bool `json:"withLog"`     // If true, call tracer will collect event logs
+
+	// Errors decodes the custom errors of the traced contracts into the
+	// revert reasons, Error(string) and Panic(uint256) being always decoded.
+	Errors evm.ErrorDecoder `json:"-"`
+}
+
+// CallTracer tracks the call frames of a transaction and implements
+// evm.EVMLogger.
+type CallTracer struct {
+	callstack []CallFrame
+	config    CallTracerConfig
+	gasLimit  uint64
+	interrupt atomic.Bool // Atomic flag to signal execution interruption
+	reason    error       // Textual reason for the interruption
+}
+
+var _ Tracer = (*CallTracer)(nil)
+
+// NewCallTracer returns a call tracer with the given options, nil selects
+// the defaults.
+func NewCallTracer(cfg *CallTracerConfig) *CallTracer {
+	t := &CallTracer{callstack: make([]CallFrame, 1)}
+	if cfg != nil {
+		t.config = *cfg
+	}
+	// First callframe contains tx context info
+	// and is populated on start and end.
+	return t
+}
+
+// CaptureStart implements the EVMLogger interface to initialize the tracing operation.
+func (t *CallTracer) CaptureStart(env *evm.EVM, from evm.Address, to evm.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	toCopy := to
+	t.callstack[0] = CallFrame{
+		Type:  evm.CALL,
+		From:  from,
+		To:    &toCopy,
+		Input: evm.CopyBytes(input),
+		Gas:   t.gasLimit,
+		Value: value,
+	}
+	// Without a transaction around the call, the frame gas is the call gas.
+	if t.gasLimit == 0 {
+		t.callstack[0].Gas = gas
+	}
+	if create {
+		t.callstack[0].Type = evm.CREATE
+	}
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *CallTracer) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	t.callstack[0].GasUsed = gasUsed
+	t.callstack[0].processOutput(output, err, t.config.Errors)
+	if t.config.WithLog {
+		// Logs are not emitted when the call fails
+		clearFailedLogs(&t.callstack[0], false)
+	}
+}
+
+// CaptureState implements the EVMLogger interface to trace a single step of VM execution.
+func (t *CallTracer) CaptureState(pc uint64, op evm.OpCode, gas, cost uint64, scope *evm.ScopeContext, rData []byte, depth int, err error) {
+	// skip if the previous op caused an error
+	if err != nil {
+		return
+	}
+	// Only logs need to be captured via opcode processing
+	if !t.config.WithLog {
+		return
+	}
+	// Avoid processing nested calls when only caring about top call
+	if t.config.OnlyTopCall && depth > 1 {
+		return
+	}
+	// Skip if tracing was interrupted
+	if t.interrupt.Load() {
+		return
+	}
+	switch op {
+	case evm.LOG0, evm.LOG1, evm.LOG2, evm.LOG3, evm.LOG4:
+		size := int(op - evm.LOG0)
+
+		stack := scope.Stack
+		stackData := stack.Data()
+
+		// Don't modify the stack
+		mStart := stackData[len(stackData)-1]
+		mSize := stackData[len(stackData)-2]
+		topics := make([]evm.Hash, size)
+		for i := 0; i < size; i++ {
+			topic := stackData[len(stackData)-2-(i+1)]
+			topics[i] = evm.Hash(topic.Bytes32())
+		}
+
+		data, err := memoryCopyPadded(scope.Memory, int64(mStart.Uint64()), int64(mSize.Uint64()))
+		if err != nil {
+			// mSize was unrealistically large
+			return
+		}
+
+		log := CallLog{Address: scope.Contract.Address(), Topics: topics, Data: hexutil.Bytes(data)}
+		t.callstack[len(t.callstack)-1].Logs = append(t.callstack[len(t.callstack)-1].Logs, log)
+	}
+}
+
+// CaptureFault implements the EVMLogger interface, faults are reported
+// through the frame errors instead.
+func (t *CallTracer) CaptureFault(pc uint64, op evm.OpCode, gas, cost uint64, scope *evm.ScopeContext, depth int, err error) {
+}
+
+// CaptureEnter is called when EVM enters a new scope (via call, create or selfdestruct).
+func (t *CallTracer) CaptureEnter(typ evm.OpCode, from evm.Address, to evm.Address, input []byte, gas uint64, value *big.Int) {
+	if t.config.OnlyTopCall {
+		return
+	}
+	// Skip if tracing was interrupted
+	if t.interrupt.Load() {
+		return
+	}
+
+	toCopy := to
+	call := CallFrame{
+		Type:  typ,
+		From:  from,
+		To:    &toCopy,
+		Input: evm.CopyBytes(input),
+		Gas:   gas,
+		Value: value,
+	}
+	t.callstack = append(t.callstack, call)
+}
+
+// CaptureExit is called when EVM exits a scope, even if the scope didn't
+// execute any code.
+func (t *CallTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if t.config.OnlyTopCall {
+		return
+	}
+	size := len(t.callstack)
+	if size <= 1 {
+		return
+	}
+	// pop call
+	call := t.callstack[size-1]
+	t.callstack = t.callstack[:size-1]
+	size -= 1
+
+	call.GasUsed = gasUsed
+	call.processOutput(output, err, t.config.Errors)
+	t.callstack[size-1].Calls = append(t.callstack[size-1].Calls, call)
+}
+
+func (t *CallTracer) CaptureTxStart(gasLimit uint64) {
+	t.gasLimit = gasLimit
+}
+
+func (t *CallTracer) CaptureTxEnd(restGas uint64) {
+	t.callstack[0].GasUsed = t.gasLimit - restGas
+}
+
+// Result returns the root frame of the call tree.
+func (t *CallTracer) Result() (CallFrame, error) {
+	if len(t.callstack) != 1 {
+		return CallFrame{}, errors.New("incorrect number of top-level calls")
+	}
+	return t.callstack[0], t.reason
+}
+
+// GetResult returns the json-encoded nested list of call traces, and any
+// error arising from the encoding or forceful termination (via `Stop`).
+func (t *CallTracer) GetResult() (json.RawMessage, error) {
+	if len(t.callstack) != 1 {
+		return nil, errors.New("incorrect number of top-level calls")
+	}
+
+	res, err := json.Marshal(t.callstack[0])
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(res), t.reason
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *CallTracer) Stop(err error) {
+	t.reason = err
+	t.interrupt.Store(true)
+}
+
+// clearFailedLogs clears the logs of a callframe and all its children
+// in case of execution failure.
+func clearFailedLogs(cf *CallFrame, parentFailed bool) {
+	failed := cf.failed() || parentFailed
+	// Clear own logs
+	if failed {
+		cf.Logs = nil
+	}
+	for i := range cf.Calls {
+		clearFailedLogs(&cf.Calls[i], failed)
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/memstate"
+	"github.com/lyonnee/evm/params"
+)
+
+var (
+	caller = evm.BytesToAddr([]byte("caller"))
+	outer  = evm.BytesToAddr([]byte{0xaa})
+	inner  = evm.BytesToAddr([]byte{0xbb})
+)
+
+var testChainConfig = &params.ChainConfig{
+	ChainID:             big.NewInt(1),
+	HomesteadBlock:      big.NewInt(0),
+	EIP150Block:         big.NewInt(0),
+	EIP155Block:         big.NewInt(0),
+	EIP158Block:         big.NewInt(0),
+	ByzantiumBlock:      big.NewInt(0),
+	ConstantinopleBlock: big.NewInt(0),
+	PetersburgBlock:     big.NewInt(0),
+	IstanbulBlock:       big.NewInt(0),
+}
+
+var (
+	// PUSH1 0 PUSH1 0 LOG0, then CALL 0xbb with 0xffff gas and STOP
+	outerCode = evm.Hex2Bytes("60006000a06000600060006000600060bb61fffff100")
+	// PUSH1 0 PUSH1 0 LOG0, then copy the trailing Error("no") payload to
+	// memory and revert with it
+	innerCode = evm.Hex2Bytes("60006000a060646011600039606460" + "00fd" +
+		"08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000002" +
+		"6e6f000000000000000000000000000000000000000000000000000000000000")
+)
+
+func newTestEVM(statedb *memstate.StateDB, tracer evm.EVMLogger) *evm.EVM {
+	blockCtx := evm.BlockContext{
+		CanTransfer: evm.CanTransfer,
+		Transfer:    evm.Transfer,
+		BlockNumber: big.NewInt(1),
+		Difficulty:  new(big.Int),
+	}
+	return evm.NewEVM(blockCtx, evm.TxContext{}, statedb, testChainConfig, evm.Config{Tracer: tracer})
+}
+
+func runCallTracer(t *testing.T, cfg *CallTracerConfig) CallFrame {
+	t.Helper()
+
+	statedb := memstate.New()
+	statedb.SetCode(outer, outerCode)
+	statedb.SetCode(inner, innerCode)
+	tracer := NewCallTracer(cfg)
+	vm := newTestEVM(statedb, tracer)
+	if _, _, err := vm.Call(evm.AccountRef(caller), outer, []byte{0x01}, 100000, new(big.Int)); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	frame, err := tracer.Result()
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	return frame
+}
+
+func TestCallTracer(t *testing.T) {
+	frame := runCallTracer(t, &CallTracerConfig{WithLog: true})
+
+	if frame.Type != evm.CALL || frame.From != caller || *frame.To != outer {
+		t.Errorf("top frame mismatch: %+v", frame)
+	}
+	if frame.Gas != 100000 || frame.GasUsed == 0 || frame.Error != "" {
+		t.Errorf("top frame gas/error mismatch: %+v", frame)
+	}
+	if len(frame.Logs) != 1 || frame.Logs[0].Address != outer {
+		t.Errorf("top frame logs mismatch: %+v", frame.Logs)
+	}
+	if len(frame.Calls) != 1 {
+		t.Fatalf("sub call count mismatch: have %d, want 1", len(frame.Calls))
+	}
+	call := frame.Calls[0]
+	if call.Type != evm.CALL || call.From != outer || *call.To != inner || call.Gas != 0xffff {
+		t.Errorf("sub frame mismatch: %+v", call)
+	}
+	if call.Error != evm.ErrExecutionReverted.Error() || call.RevertReason != "no" {
+		t.Errorf("sub frame revert mismatch: error %q, reason %q", call.Error, call.RevertReason)
+	}
+	if len(call.Logs) != 0 {
+		t.Errorf("logs of reverted frame kept: %+v", call.Logs)
+	}
+}
+
+func TestCallTracerOnlyTopCall(t *testing.T) {
+	frame := runCallTracer(t, &CallTracerConfig{OnlyTopCall: true})
+	if len(frame.Calls) != 0 {
+		t.Errorf("sub calls collected: %+v", frame.Calls)
+	}
+	if len(frame.Logs) != 0 {
+		t.Errorf("logs collected without WithLog: %+v", frame.Logs)
+	}
+}
+
+func TestCallTracerJSON(t *testing.T) {
+	frame := runCallTracer(t, nil)
+	blob, err := json.Marshal(frame)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	var res struct {
+		Type  string `json:"type"`
+		Gas   string `json:"gas"`
+		Input string `json:"input"`
+		Calls []struct {
+			Type         string      `json:"type"`
+			To           evm.Address `json:"to"`
+			Error        string      `json:"error"`
+			RevertReason string      `json:"revertReason"`
+		} `json:"calls"`
+	}
+	if err := json.Unmarshal(blob, &res); err != nil {
+		t.Fatalf("failed to unmarshal %s: %v", blob, err)
+	}
+	if res.Type != "CALL" || res.Gas != "0x186a0" || res.Input != "0x01" {
+		t.Errorf("unexpected top frame: %s", blob)
+	}
+	if len(res.Calls) != 1 || res.Calls[0].To != inner || res.Calls[0].RevertReason != "no" {
+		t.Errorf("unexpected sub frame: %s", blob)
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/params"
+)
+
+// State is a set of accounts keyed by address.
+type State = map[evm.Address]*Account
+
+// Account is the state of an account as reported by the prestate tracer.
+type Account struct {
+	Balance *big.Int
+	Code    []byte
+	Nonce   uint64
+	Storage map[evm.Hash]evm.Hash
+}
+
+// MarshalJSON marshals the account in the format of geth's prestateTracer.
+func (a Account) MarshalJSON() ([]byte, error) {
+	type account struct {
+		Balance *hexutil.Big          `json:"balance,omitempty"`
+		Code    hexutil.Bytes         `json:"code,omitempty"`
+		Nonce   uint64                `json:"nonce,omitempty"`
+		Storage map[evm.Hash]evm.Hash `json:"storage,omitempty"`
+	}
+	enc := account{
+		Balance: (*hexutil.Big)(a.Balance),
+		Code:    a.Code,
+		Nonce:   a.Nonce,
+		Storage: a.Storage,
+	}
+	return json.Marshal(&enc)
+}
+
+func (a *Account) exists() bool {
+	return a.Nonce > 0 || len(a.Code) > 0 || len(a.Storage) > 0 || (a.Balance != nil && a.Balance.Sign() != 0)
+}
+
+// PrestateTracerConfig are the configuration options for the prestate tracer.
+type PrestateTracerConfig struct {
+	DiffMode bool `json:"diffMode"` // If true, this tracer will return state modifications
+}
+
+// PrestateTracer records every account and storage slot touched by the
+// execution, together with their values before it. In diff mode only the
+// modified accounts are kept, along with their values after the execution.
+type PrestateTracer struct {
+	env       *evm.EVM
+	pre       State
+	post      State
+	create    bool
+	to        evm.Address
+	inTx      bool   // Whether the call is wrapped in a transaction
+	gasLimit  uint64 // Amount of gas bought for the whole tx
+	config    PrestateTracerConfig
+	interrupt atomic.Bool // Atomic flag to signal execution interruption
+	reason    error       // Textual reason for the interruption
+	created   map[evm.Address]bool
+}
+
+var _ Tracer = (*PrestateTracer)(nil)
+
+// NewPrestateTracer returns a prestate tracer with the given options, nil
+// selects the defaults.
+func NewPrestateTracer(cfg *PrestateTracerConfig) *PrestateTracer {
+	t := &PrestateTracer{
+		pre:     State{},
+		post:    State{},
+		created: make(map[evm.Address]bool),
+	}
+	if cfg != nil {
+		t.config = *cfg
+	}
+	return t
+}
+
+// CaptureStart implements the EVMLogger interface to initialize the tracing operation.
+func (t *PrestateTracer) CaptureStart(env *evm.EVM, from evm.Address, to evm.Address, create bool, input []byte, gas uint64, value *big.Int) {
+	t.env = env
+	t.create = create
+	t.to = to
+
+	t.lookupAccount(from)
+	t.lookupAccount(to)
+	t.lookupAccount(env.Context.Coinbase)
+
+	// The recipient balance includes the value transferred.
+	toBal := new(big.Int).Sub(t.pre[to].Balance, value)
+	t.pre[to].Balance = toBal
+	if create {
+		// The creation has already initialised the new account, a collision
+		// would have aborted it, so nothing but the balance predates it.
+		t.pre[to].Nonce = 0
+		t.pre[to].Code = nil
+		if t.config.DiffMode {
+			t.created[to] = true
+		}
+	}
+
+	// The sender balance is after reducing: value and gasLimit.
+	// We need to re-add them to get the pre-tx balance.
+	fromBal := new(big.Int).Add(t.pre[from].Balance, value)
+	if t.inTx && env.TxContext.GasPrice != nil {
+		consumedGas := new(big.Int).Mul(env.TxContext.GasPrice, new(big.Int).SetUint64(t.gasLimit))
+		fromBal.Add(fromBal, consumedGas)
+	}
+	// Blob transactions also paid their blob gas at the block's blob price.
+	if t.inTx && len(env.TxContext.BlobHashes) > 0 {
+		var excess uint64
+		if env.Context.ExcessBlobGas != nil {
+			excess = *env.Context.ExcessBlobGas
+		}
+		blobFee := new(big.Int).SetUint64(uint64(len(env.TxContext.BlobHashes)) * params.BlobTxBlobGasPerBlob)
+		fromBal.Add(fromBal, blobFee.Mul(blobFee, evm.CalcBlobFee(env.ChainRules(), excess)))
+	}
+	t.pre[from].Balance = fromBal
+	// The nonce is bumped by the creation itself, and by the transaction for
+	// plain calls.
+	if create || t.inTx {
+		t.pre[from].Nonce--
+	}
+}
+
+// CaptureEnd is called after the call finishes to finalize the tracing.
+func (t *PrestateTracer) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	if t.config.DiffMode {
+		// Without a transaction the state is final once the call is over.
+		if !t.inTx {
+			t.processDiffState()
+		}
+		return
+	}
+
+	if t.create {
+		// Keep existing account prior to contract creation at that address
+		if s := t.pre[t.to]; s != nil && !s.exists() {
+			// Exclude newly created contract.
+			delete(t.pre, t.to)
+		}
+	}
+}
+
+// CaptureState implements the EVMLogger interface to trace a single step of VM execution.
+func (t *PrestateTracer) CaptureState(pc uint64, op evm.OpCode, gas, cost uint64, scope *evm.ScopeContext, rData []byte, depth int, err error) {
+	if err != nil {
+		return
+	}
+	// Skip if tracing was interrupted
+	if t.interrupt.Load() {
+		return
+	}
+	stack := scope.Stack
+	stackData := stack.Data()
+	stackLen := len(stackData)
+	caller := scope.Contract.Address()
+	switch {
+	case stackLen >= 1 && (op == evm.SLOAD || op == evm.SSTORE):
+		slot := evm.Hash(stackData[stackLen-1].Bytes32())
+		t.lookupStorage(caller, slot)
+	case stackLen >= 1 && (op == evm.EXTCODECOPY || op == evm.EXTCODEHASH || op == evm.EXTCODESIZE || op == evm.BALANCE || op == evm.SELFDESTRUCT):
+		addr := evm.BytesToAddr(stackData[stackLen-1].Bytes())
+		t.lookupAccount(addr)
+	case stackLen >= 5 && (op == evm.DELEGATECALL || op == evm.CALL || op == evm.STATICCALL || op == evm.CALLCODE):
+		addr := evm.BytesToAddr(stackData[stackLen-2].Bytes())
+		t.lookupAccount(addr)
+	case op == evm.CREATE:
+		nonce := t.env.StateDB.GetNonce(caller)
+		addr := t.env.AddressDeriver().CreateAddress(caller, nonce)
+		t.lookupAccount(addr)
+		t.created[addr] = true
+	case stackLen >= 4 && op == evm.CREATE2:
+		offset := stackData[stackLen-2]
+		size := stackData[stackLen-3]
+		init, err := memoryCopyPadded(scope.Memory, int64(offset.Uint64()), int64(size.Uint64()))
+		if err != nil {
+			// size was unrealistically large
+			return
+		}
+		inithash := evm.HashWith(t.env.Hasher(), init)
+		salt := stackData[stackLen-4]
+		addr := t.env.AddressDeriver().CreateAddress2(caller, salt.Bytes32(), inithash)
+		t.lookupAccount(addr)
+		t.created[addr] = true
+	}
+}
+
+// CaptureFault implements the EVMLogger interface to trace an execution fault.
+func (t *PrestateTracer) CaptureFault(pc uint64, op evm.OpCode, gas, cost uint64, scope *evm.ScopeContext, depth int, err error) {
+}
+
+// CaptureEnter implements the EVMLogger interface, the touched accounts are
+// collected from the calling instruction instead.
+func (t *PrestateTracer) CaptureEnter(typ evm.OpCode, from evm.Address, to evm.Address, input []byte, gas uint64, value *big.Int) {
+}
+
+// CaptureExit implements the EVMLogger interface.
+func (t *PrestateTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+}
+
+func (t *PrestateTracer) CaptureTxStart(gasLimit uint64) {
+	t.inTx = true
+	t.gasLimit = gasLimit
+}
+
+func (t *PrestateTracer) CaptureTxEnd(restGas uint64) {
+	if !t.config.DiffMode {
+		return
+	}
+	t.processDiffState()
+}
+
+// processDiffState compares the recorded pre-state against the current state,
+// keeping the modified accounts and slots only.
+func (t *PrestateTracer) processDiffState() {
+	for addr, state := range t.pre {
+		// The deleted account's state is pruned from `post` but kept in `pre`.
+		// Whether SELFDESTRUCT deleted it is only known from the state, since
+		// EIP-6780 keeps the accounts not created in the same transaction.
+		if t.env.StateDB.HasSelfDestructed(addr) {
+			continue
+		}
+		modified := false
+		postAccount := &Account{Storage: make(map[evm.Hash]evm.Hash)}
+		newBalance := t.env.StateDB.GetBalance(addr)
+		newNonce := t.env.StateDB.GetNonce(addr)
+		newCode := t.env.StateDB.GetCode(addr)
+
+		if newBalance.Cmp(t.pre[addr].Balance) != 0 {
+			modified = true
+			postAccount.Balance = newBalance
+		}
+		if newNonce != t.pre[addr].Nonce {
+			modified = true
+			postAccount.Nonce = newNonce
+		}
+		if !bytes.Equal(newCode, t.pre[addr].Code) {
+			modified = true
+			postAccount.Code = newCode
+		}
+
+		for key, val := range state.Storage {
+			// don't include the empty slot
+			if val == (evm.Hash{}) {
+				delete(t.pre[addr].Storage, key)
+			}
+
+			newVal := t.env.StateDB.GetState(addr, key)
+			if val == newVal {
+				// Omit unchanged slots
+				delete(t.pre[addr].Storage, key)
+			} else {
+				modified = true
+				if newVal != (evm.Hash{}) {
+					postAccount.Storage[key] = newVal
+				}
+			}
+		}
+
+		if modified {
+			t.post[addr] = postAccount
+		} else {
+			// if state is not modified, then no need to include into the pre state
+			delete(t.pre, addr)
+		}
+	}
+	// the new created contracts' prestate were empty, so delete them
+	for a := range t.created {
+		// the created contract maybe exists in statedb before the creating tx
+		if s := t.pre[a]; s != nil && !s.exists() {
+			delete(t.pre, a)
+		}
+	}
+}
+
+// Result returns the recorded pre-state, and in diff mode the post-state of
+// the modified accounts.
+func (t *PrestateTracer) Result() (pre State, post State, err error) {
+	if !t.config.DiffMode {
+		return t.pre, nil, t.reason
+	}
+	return t.pre, t.post, t.reason
+}
+
+// GetResult returns the json-encoded nested list of call traces, and any
+// error arising from the encoding or forceful termination (via `Stop`).
+func (t *PrestateTracer) GetResult() (json.RawMessage, error) {
+	var res []byte
+	var err error
+	if t.config.DiffMode {
+		res, err = json.Marshal(struct {
+			Post State `json:"post"`
+			Pre  State `json:"pre"`
+		}{t.post, t.pre})
+	} else {
+		res, err = json.Marshal(t.pre)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.RawMessage(res), t.reason
+}
+
+// Stop terminates execution of the tracer at the first opportune moment.
+func (t *PrestateTracer) Stop(err error) {
+	t.reason = err
+	t.interrupt.Store(true)
+}
+
+// lookupAccount fetches details of an account and adds it to the prestate
+// if it doesn't exist there.
+func (t *PrestateTracer) lookupAccount(addr evm.Address) {
+	if _, ok := t.pre[addr]; ok {
+		return
+	}
+
+	t.pre[addr] = &Account{
+		Balance: t.env.StateDB.GetBalance(addr),
+		Nonce:   t.env.StateDB.GetNonce(addr),
+		Code:    t.env.StateDB.GetCode(addr),
+		Storage: make(map[evm.Hash]evm.Hash),
+	}
+}
+
+// lookupStorage fetches the requested storage slot and adds
+// it to the prestate of the given contract.
+func (t *PrestateTracer) lookupStorage(addr evm.Address, key evm.Hash) {
+	t.lookupAccount(addr)
+	if _, ok := t.pre[addr].Storage[key]; ok {
+		return
+	}
+	t.pre[addr].Storage[key] = t.env.StateDB.GetState(addr, key)
+}
@@ -0,0 +1,218 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package tracers
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/lyonnee/evm"
+	"github.com/lyonnee/evm/memstate"
+	"github.com/lyonnee/evm/params"
+)
+
+var (
+	coinbase = evm.BytesToAddr([]byte("coinbase"))
+	other    = evm.BytesToAddr([]byte{0xcc})
+
+	slot0 = evm.BytesToHash([]byte{0})
+
+	// PUSH1 0 SLOAD PUSH1 1 ADD PUSH1 0 SSTORE, then BALANCE of 0xcc and STOP
+	counterCode = evm.Hex2Bytes("600054600101600055" + "60cc315000")
+)
+
+func runPrestateTracer(t *testing.T, cfg *PrestateTracerConfig) (*PrestateTracer, *memstate.StateDB) {
+	t.Helper()
+
+	statedb := memstate.New()
+	statedb.SetBalance(caller, big.NewInt(1e18))
+	statedb.SetCode(outer, counterCode)
+	statedb.SetState(outer, slot0, evm.BytesToHash([]byte{5}))
+	statedb.SetBalance(other, big.NewInt(7))
+
+	tracer := NewPrestateTracer(cfg)
+	vm := newTestEVM(statedb, tracer)
+	vm.Context.Coinbase = coinbase
+	msg := &evm.Message{
+		From:     caller,
+		To:       &outer,
+		Value:    big.NewInt(10),
+		GasLimit: 100000,
+		GasPrice: big.NewInt(1),
+	}
+	vm.Reset(evm.NewTxContext(msg), statedb)
+	res, err := evm.ApplyMessage(vm, msg, new(evm.GasPool).AddGas(msg.GasLimit))
+	if err != nil || res.Failed() {
+		t.Fatalf("execution failed: %v %v", err, res)
+	}
+	return tracer, statedb
+}
+
+func TestPrestateTracer(t *testing.T) {
+	tracer, _ := runPrestateTracer(t, nil)
+	pre, post, err := tracer.Result()
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	if post != nil {
+		t.Errorf("post state reported without diff mode")
+	}
+	if len(pre) != 4 {
+		t.Errorf("account count mismatch: have %d, want 4", len(pre))
+	}
+	if acc := pre[caller]; acc == nil || acc.Balance.Cmp(big.NewInt(1e18)) != 0 || acc.Nonce != 0 {
+		t.Errorf("sender pre-state mismatch: %+v", acc)
+	}
+	if acc := pre[outer]; acc == nil || acc.Balance.Sign() != 0 || len(acc.Code) == 0 || acc.Storage[slot0] != evm.BytesToHash([]byte{5}) {
+		t.Errorf("contract pre-state mismatch: %+v", acc)
+	}
+	if acc := pre[other]; acc == nil || acc.Balance.Cmp(big.NewInt(7)) != 0 {
+		t.Errorf("inspected account pre-state mismatch: %+v", acc)
+	}
+	if _, ok := pre[coinbase]; !ok {
+		t.Errorf("coinbase missing from pre-state")
+	}
+}
+
+func TestPrestateTracerDiffMode(t *testing.T) {
+	tracer, statedb := runPrestateTracer(t, &PrestateTracerConfig{DiffMode: true})
+	pre, post, err := tracer.Result()
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	if _, ok := pre[other]; ok {
+		t.Errorf("unmodified account kept in pre-state")
+	}
+	if acc := post[outer]; acc == nil || acc.Balance.Cmp(big.NewInt(10)) != 0 || acc.Storage[slot0] != evm.BytesToHash([]byte{6}) || acc.Code != nil {
+		t.Errorf("contract post-state mismatch: %+v", acc)
+	}
+	if acc := post[caller]; acc == nil || acc.Nonce != 1 || acc.Balance.Cmp(statedb.GetBalance(caller)) != 0 {
+		t.Errorf("sender post-state mismatch: %+v", acc)
+	}
+	if acc := post[coinbase]; acc == nil || acc.Balance.Sign() == 0 {
+		t.Errorf("coinbase post-state mismatch: %+v", acc)
+	}
+
+	blob, err := tracer.GetResult()
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	var res struct {
+		Pre  map[evm.Address]map[string]interface{} `json:"pre"`
+		Post map[evm.Address]map[string]interface{} `json:"post"`
+	}
+	if err := json.Unmarshal(blob, &res); err != nil {
+		t.Fatalf("failed to unmarshal %s: %v", blob, err)
+	}
+	if storage, ok := res.Post[outer]["storage"].(map[string]interface{}); !ok || len(storage) != 1 {
+		t.Errorf("unexpected contract post-state: %s", blob)
+	}
+	if res.Post[outer]["balance"] != "0xa" {
+		t.Errorf("unexpected contract post balance: %s", blob)
+	}
+}
+
+func TestPrestateTracerDiffModeSelfdestruct(t *testing.T) {
+	cancun, err := params.ForkConfig("Cancun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb := memstate.New()
+	// PUSH1 0xcc SELFDESTRUCT
+	statedb.SetCode(outer, evm.Hex2Bytes("60ccff"))
+	statedb.SetBalance(outer, big.NewInt(10))
+	statedb.SetBalance(other, big.NewInt(7))
+	statedb.Finalise(true)
+
+	tracer := NewPrestateTracer(&PrestateTracerConfig{DiffMode: true})
+	blockCtx := evm.BlockContext{
+		CanTransfer: evm.CanTransfer,
+		Transfer:    evm.Transfer,
+		BlockNumber: big.NewInt(1),
+		Difficulty:  new(big.Int),
+		Random:      &evm.Hash{},
+	}
+	vm := evm.NewEVM(blockCtx, evm.TxContext{}, statedb, cancun, evm.Config{Tracer: tracer})
+	if _, _, err := vm.Call(evm.AccountRef(caller), outer, nil, 100000, new(big.Int)); err != nil {
+		t.Fatalf("execution failed: %v", err)
+	}
+	pre, post, err := tracer.Result()
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	// The contract predates the transaction, EIP-6780 only moves its balance
+	if acc := pre[outer]; acc == nil || acc.Balance.Cmp(big.NewInt(10)) != 0 {
+		t.Errorf("contract pre-state mismatch: %+v", acc)
+	}
+	if acc := post[outer]; acc == nil || acc.Balance == nil || acc.Balance.Sign() != 0 || acc.Code != nil {
+		t.Errorf("contract post-state mismatch: %+v", acc)
+	}
+	if acc := post[other]; acc == nil || acc.Balance.Cmp(big.NewInt(17)) != 0 {
+		t.Errorf("beneficiary post-state mismatch: %+v", acc)
+	}
+}
+
+func TestPrestateTracerBlobTx(t *testing.T) {
+	cancun, err := params.ForkConfig("Cancun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	statedb := memstate.New()
+	statedb.SetBalance(caller, big.NewInt(1e18))
+	statedb.SetCode(outer, counterCode)
+	statedb.Finalise(true)
+
+	tracer := NewPrestateTracer(nil)
+	excess := uint64(10 * 1024 * 1024) // blob gas price of 23
+	blockCtx := evm.BlockContext{
+		CanTransfer:   evm.CanTransfer,
+		Transfer:      evm.Transfer,
+		Coinbase:      coinbase,
+		BlockNumber:   big.NewInt(1),
+		GasLimit:      1000000,
+		Difficulty:    new(big.Int),
+		BaseFee:       big.NewInt(1),
+		Random:        &evm.Hash{},
+		ExcessBlobGas: &excess,
+	}
+	vm := evm.NewEVM(blockCtx, evm.TxContext{}, statedb, cancun, evm.Config{Tracer: tracer})
+	blobHash := evm.Hash{params.BlobTxHashVersion}
+	msg := &evm.Message{
+		From:          caller,
+		To:            &outer,
+		GasLimit:      100000,
+		GasPrice:      big.NewInt(2),
+		GasFeeCap:     big.NewInt(2),
+		GasTipCap:     big.NewInt(1),
+		BlobHashes:    []evm.Hash{blobHash, blobHash},
+		BlobGasFeeCap: big.NewInt(100),
+	}
+	vm.Reset(evm.NewTxContext(msg), statedb)
+	res, err := evm.ApplyMessage(vm, msg, new(evm.GasPool).AddGas(msg.GasLimit))
+	if err != nil || res.Failed() {
+		t.Fatalf("execution failed: %v %v", err, res)
+	}
+	pre, _, err := tracer.Result()
+	if err != nil {
+		t.Fatalf("failed to get result: %v", err)
+	}
+	// The blob fee bought before the execution is added back
+	if acc := pre[caller]; acc == nil || acc.Balance.Cmp(big.NewInt(1e18)) != 0 {
+		t.Errorf("sender pre-state mismatch: %+v", acc)
+	}
+}
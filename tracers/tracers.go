@@ -0,0 +1,60 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package tracers provides transaction level tracers implementing
+// evm.EVMLogger, producing results compatible with geth's native tracers.
+package tracers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/lyonnee/evm"
+)
+
+// Tracer is an EVMLogger collecting a JSON encodable result.
+type Tracer interface {
+	evm.EVMLogger
+	// GetResult returns the json-encoded trace, and any error arising from
+	// the encoding or forceful termination (via Stop).
+	GetResult() (json.RawMessage, error)
+	// Stop terminates execution of the tracer at the first opportune moment.
+	Stop(err error)
+}
+
+const memoryPadLimit = 1024 * 1024
+
+// memoryCopyPadded returns offset + size as a new slice. Unlike Memory.GetCopy
+// it zero-pads the slice if it extends beyond the current memory bounds, as
+// memory is expanded only after the tracer has seen the instruction.
+func memoryCopyPadded(m *evm.Memory, offset, size int64) ([]byte, error) {
+	if offset < 0 || size < 0 {
+		return nil, errors.New("offset or size must not be negative")
+	}
+	if int(offset+size) < m.Len() { // slice fully inside memory
+		return m.GetCopy(offset, size), nil
+	}
+	paddingNeeded := int(offset+size) - m.Len()
+	if paddingNeeded > memoryPadLimit {
+		return nil, fmt.Errorf("reached limit for padding memory slice: %d", paddingNeeded)
+	}
+	cpy := make([]byte, size)
+	if overlap := int64(m.Len()) - offset; overlap > 0 {
+		copy(cpy, m.GetPtr(offset, overlap))
+	}
+	return cpy, nil
+}
@@ -0,0 +1,117 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/lyonnee/evm"
+)
+
+// ErrNotFound is returned by MemoryStore for keys it doesn't hold.
+var ErrNotFound = errors.New("not found")
+
+// KeyValueStore is the storage the trie nodes and contract codes are
+// committed to. Its method set matches the key-value store of go-ethereum's
+// ethdb, so any of its databases can be used as a backend.
+type KeyValueStore interface {
+	Has(key []byte) (bool, error)
+	Get(key []byte) ([]byte, error)
+	Put(key []byte, value []byte) error
+	Delete(key []byte) error
+}
+
+// MemoryStore is a concurrency safe, in-memory KeyValueStore.
+type MemoryStore struct {
+	lock sync.RWMutex
+	db   map[string][]byte
+}
+
+// NewMemoryStore creates an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{db: make(map[string][]byte)}
+}
+
+// Has reports whether the key is present in the store.
+func (s *MemoryStore) Has(key []byte) (bool, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	_, ok := s.db[string(key)]
+	return ok, nil
+}
+
+// Get returns a copy of the value stored under the key.
+func (s *MemoryStore) Get(key []byte) ([]byte, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if value, ok := s.db[string(key)]; ok {
+		return evm.CopyBytes(value), nil
+	}
+	return nil, ErrNotFound
+}
+
+// Put stores a copy of the value under the key.
+func (s *MemoryStore) Put(key []byte, value []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.db[string(key)] = evm.CopyBytes(value)
+	return nil
+}
+
+// Delete removes the key from the store.
+func (s *MemoryStore) Delete(key []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.db, string(key))
+	return nil
+}
+
+// Len returns the number of entries in the store.
+func (s *MemoryStore) Len() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	return len(s.db)
+}
+
+// codePrefix separates contract codes from the trie nodes, both being keyed
+// by their hash.
+var codePrefix = []byte("c")
+
+func codeKey(hash evm.Hash) []byte {
+	return append(append([]byte{}, codePrefix...), hash[:]...)
+}
+
+// ReadCode loads the contract code with the given hash from the store. The
+// hasher is the one the codes are hashed with, it identifies the empty code,
+// which is never stored.
+func ReadCode(db KeyValueStore, hasher evm.Hasher, hash evm.Hash) ([]byte, error) {
+	if hash == evm.HashWith(hasher, nil) {
+		return nil, nil
+	}
+	return db.Get(codeKey(hash))
+}
+
+// WriteCode stores the contract code under its hash.
+func WriteCode(db KeyValueStore, hash evm.Hash, code []byte) error {
+	return db.Put(codeKey(hash), code)
+}
@@ -0,0 +1,46 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/lyonnee/evm"
+)
+
+// DerivableList is a list whose elements are hashed into a trie keyed by
+// their RLP encoded index, such as evm.Receipts.
+type DerivableList interface {
+	Len() int
+	EncodeIndex(int, *bytes.Buffer)
+}
+
+// DeriveSha returns the root of the trie of the list elements, i.e. the
+// receipts root of a block for evm.Receipts.
+func DeriveSha(list DerivableList) evm.Hash {
+	var (
+		t   = NewEmpty(nil)
+		buf bytes.Buffer
+	)
+	for i := 0; i < list.Len(); i++ {
+		buf.Reset()
+		list.EncodeIndex(i, &buf)
+		t.Update(rlp.AppendUint64(nil, uint64(i)), bytes.Clone(buf.Bytes()))
+	}
+	return t.Hash()
+}
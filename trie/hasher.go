@@ -0,0 +1,66 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"github.com/lyonnee/evm"
+)
+
+// hash collapses a node down into a hash node, also returning a copy of the
+// original node initialized with the computed hash to replace the original one.
+// Nodes whose encoding is shorter than a hash are not hashed but returned
+// collapsed, to be embedded in their parent, unless force is set.
+func hash(n node, force bool) (hashed node, cached node) {
+	// Return the cached hash if it's available
+	if hash, _ := n.cache(); hash != nil {
+		return hash, n
+	}
+	// Trie not processed yet, walk the children
+	var collapsed node
+	switch n := n.(type) {
+	case *shortNode:
+		cn, cc := n.copy(), n.copy()
+		switch n.Val.(type) {
+		case *shortNode, *fullNode:
+			cn.Val, cc.Val = hash(n.Val, false)
+		}
+		collapsed, cached = cn, cc
+	case *fullNode:
+		cn, cc := n.copy(), n.copy()
+		for i := 0; i < 16; i++ {
+			if child := n.Children[i]; child != nil {
+				cn.Children[i], cc.Children[i] = hash(child, false)
+			}
+		}
+		collapsed, cached = cn, cc
+	default:
+		// Value and hash nodes don't have children, so they're left as were
+		return n, n
+	}
+	enc := encodeNode(collapsed)
+	if len(enc) < hashLen && !force {
+		return collapsed, cached
+	}
+	h := hashNode(evm.Keccak256(enc))
+	switch cn := cached.(type) {
+	case *shortNode:
+		cn.flags.hash = h
+	case *fullNode:
+		cn.flags.hash = h
+	}
+	return h, cached
+}
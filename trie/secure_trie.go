@@ -0,0 +1,149 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/lyonnee/evm"
+)
+
+// StateAccount is the consensus representation of an account in the state
+// trie.
+type StateAccount struct {
+	Nonce    uint64
+	Balance  *big.Int
+	Root     evm.Hash // merkle root of the storage trie
+	CodeHash []byte
+}
+
+// NewStateAccount returns an account without code nor storage, the hash of
+// its empty code being computed with hasher.
+func NewStateAccount(hasher evm.Hasher) *StateAccount {
+	return &StateAccount{
+		Balance:  new(big.Int),
+		Root:     EmptyRootHash,
+		CodeHash: evm.HashWith(hasher, nil).Bytes(),
+	}
+}
+
+// SecureTrie wraps a trie with key hashing. In a secure trie, all access
+// operations hash the key using keccak256. This prevents calling code from
+// creating long chains of nodes that increase the access time.
+//
+// It is used both for the account trie, keyed by address, and for the
+// storage tries, keyed by slot.
+type SecureTrie struct {
+	trie Trie
+}
+
+// NewSecure creates a secure trie with an existing root node from db, see New.
+func NewSecure(root evm.Hash, db KeyValueStore) (*SecureTrie, error) {
+	trie, err := New(root, db)
+	if err != nil {
+		return nil, err
+	}
+	return &SecureTrie{trie: *trie}, nil
+}
+
+// NewEmptySecure creates an empty secure trie committing to db.
+func NewEmptySecure(db KeyValueStore) *SecureTrie {
+	return &SecureTrie{trie: Trie{db: db}}
+}
+
+// Get returns the value stored under the hash of key.
+func (t *SecureTrie) Get(key []byte) ([]byte, error) {
+	return t.trie.Get(evm.Keccak256(key))
+}
+
+// Update associates the hash of key with value, an empty value deletes it.
+func (t *SecureTrie) Update(key, value []byte) error {
+	return t.trie.Update(evm.Keccak256(key), value)
+}
+
+// Delete removes the value stored under the hash of key.
+func (t *SecureTrie) Delete(key []byte) error {
+	return t.trie.Delete(evm.Keccak256(key))
+}
+
+// GetAccount returns the account stored under the address, nil if there is
+// none.
+func (t *SecureTrie) GetAccount(addr evm.Address) (*StateAccount, error) {
+	enc, err := t.Get(addr.Bytes())
+	if err != nil || len(enc) == 0 {
+		return nil, err
+	}
+	account := new(StateAccount)
+	if err := rlp.DecodeBytes(enc, account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// UpdateAccount stores the account under the address.
+func (t *SecureTrie) UpdateAccount(addr evm.Address, account *StateAccount) error {
+	enc, err := rlp.EncodeToBytes(account)
+	if err != nil {
+		return err
+	}
+	return t.Update(addr.Bytes(), enc)
+}
+
+// DeleteAccount removes the account stored under the address.
+func (t *SecureTrie) DeleteAccount(addr evm.Address) error {
+	return t.Delete(addr.Bytes())
+}
+
+// GetStorage returns the value of the storage slot, stored RLP encoded with
+// its leading zeros trimmed.
+func (t *SecureTrie) GetStorage(key evm.Hash) (evm.Hash, error) {
+	enc, err := t.Get(key[:])
+	if err != nil || len(enc) == 0 {
+		return evm.Hash{}, err
+	}
+	_, content, _, err := rlp.Split(enc)
+	if err != nil {
+		return evm.Hash{}, err
+	}
+	return evm.BytesToHash(content), nil
+}
+
+// UpdateStorage sets the value of the storage slot, a zero value deletes it.
+func (t *SecureTrie) UpdateStorage(key, value evm.Hash) error {
+	if value == (evm.Hash{}) {
+		return t.Delete(key[:])
+	}
+	enc, _ := rlp.EncodeToBytes(bytes.TrimLeft(value[:], "\x00"))
+	return t.Update(key[:], enc)
+}
+
+// Hash returns the root hash of the trie.
+func (t *SecureTrie) Hash() evm.Hash {
+	return t.trie.Hash()
+}
+
+// Commit writes the modified nodes to the store and returns the root hash.
+func (t *SecureTrie) Commit() (evm.Hash, error) {
+	return t.trie.Commit()
+}
+
+// Copy returns a copy of the trie, sharing its immutable nodes.
+func (t *SecureTrie) Copy() *SecureTrie {
+	return &SecureTrie{trie: *t.trie.Copy()}
+}
@@ -0,0 +1,457 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package trie implements the Merkle Patricia Trie used for the Ethereum
+// state, storage and receipt roots.
+package trie
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/lyonnee/evm"
+)
+
+// EmptyRootHash is the known root hash of an empty trie.
+var EmptyRootHash = evm.Keccak256Hash(encodeRef(nil))
+
+// MissingNodeError is returned by the trie functions (Get, Update, Delete)
+// in the case where a trie node is not present in the store.
+type MissingNodeError struct {
+	NodeHash evm.Hash // hash of the missing node
+	Err      error    // error reported by the store
+}
+
+func (err *MissingNodeError) Error() string {
+	return fmt.Sprintf("missing trie node %x: %v", err.NodeHash, err.Err)
+}
+
+func (err *MissingNodeError) Unwrap() error {
+	return err.Err
+}
+
+// Trie is a Merkle Patricia Trie. The zero value is an empty trie without a
+// backing store. Use New to create a trie that loads its nodes from, and
+// commits them to, a KeyValueStore.
+//
+// Trie is not safe for concurrent use.
+type Trie struct {
+	root node
+	db   KeyValueStore
+}
+
+// New creates a trie with an existing root node from db.
+//
+// If root is the zero hash or the empty root hash, the trie is initially
+// empty. Otherwise, the root node must be present in the store or a
+// MissingNodeError is returned. db may be nil for a purely in-memory trie.
+func New(root evm.Hash, db KeyValueStore) (*Trie, error) {
+	trie := &Trie{db: db}
+	if root != (evm.Hash{}) && root != EmptyRootHash {
+		rootnode, err := trie.resolveHash(root[:])
+		if err != nil {
+			return nil, err
+		}
+		trie.root = rootnode
+	}
+	return trie, nil
+}
+
+// NewEmpty creates an empty trie committing to db.
+func NewEmpty(db KeyValueStore) *Trie {
+	return &Trie{db: db}
+}
+
+// Copy returns a copy of the trie. Nodes are never modified in place, so the
+// copy shares them with the original.
+func (t *Trie) Copy() *Trie {
+	return &Trie{root: t.root, db: t.db}
+}
+
+// Get returns the value for key stored in the trie.
+// The value bytes must not be modified by the caller.
+func (t *Trie) Get(key []byte) ([]byte, error) {
+	value, newroot, didResolve, err := t.get(t.root, keybytesToHex(key), 0)
+	if err == nil && didResolve {
+		t.root = newroot
+	}
+	return value, err
+}
+
+func (t *Trie) get(origNode node, key []byte, pos int) (value []byte, newnode node, didResolve bool, err error) {
+	switch n := (origNode).(type) {
+	case nil:
+		return nil, nil, false, nil
+	case valueNode:
+		return n, n, false, nil
+	case *shortNode:
+		if len(key)-pos < len(n.Key) || !bytes.Equal(n.Key, key[pos:pos+len(n.Key)]) {
+			// key not found in trie
+			return nil, n, false, nil
+		}
+		value, newnode, didResolve, err = t.get(n.Val, key, pos+len(n.Key))
+		if err == nil && didResolve {
+			n = n.copy()
+			n.Val = newnode
+		}
+		return value, n, didResolve, err
+	case *fullNode:
+		value, newnode, didResolve, err = t.get(n.Children[key[pos]], key, pos+1)
+		if err == nil && didResolve {
+			n = n.copy()
+			n.Children[key[pos]] = newnode
+		}
+		return value, n, didResolve, err
+	case hashNode:
+		child, err := t.resolveHash(n)
+		if err != nil {
+			return nil, n, true, err
+		}
+		value, newnode, _, err := t.get(child, key, pos)
+		return value, newnode, true, err
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", origNode, origNode))
+	}
+}
+
+// Update associates key with value in the trie. Subsequent calls to
+// Get will return value. If value has length zero, any existing value
+// is deleted from the trie and calls to Get will return nil.
+//
+// The value bytes must not be modified by the caller while they are
+// stored in the trie.
+func (t *Trie) Update(key, value []byte) error {
+	k := keybytesToHex(key)
+	if len(value) != 0 {
+		_, n, err := t.insert(t.root, k, valueNode(value))
+		if err != nil {
+			return err
+		}
+		t.root = n
+	} else {
+		_, n, err := t.delete(t.root, k)
+		if err != nil {
+			return err
+		}
+		t.root = n
+	}
+	return nil
+}
+
+func (t *Trie) insert(n node, key []byte, value node) (bool, node, error) {
+	if len(key) == 0 {
+		if v, ok := n.(valueNode); ok {
+			return !bytes.Equal(v, value.(valueNode)), value, nil
+		}
+		return true, value, nil
+	}
+	switch n := n.(type) {
+	case *shortNode:
+		matchlen := prefixLen(key, n.Key)
+		// If the whole key matches, keep this short node as is
+		// and only update the value.
+		if matchlen == len(n.Key) {
+			dirty, nn, err := t.insert(n.Val, key[matchlen:], value)
+			if !dirty || err != nil {
+				return false, n, err
+			}
+			return true, &shortNode{n.Key, nn, newFlag()}, nil
+		}
+		// Otherwise branch out at the index where they differ.
+		branch := &fullNode{flags: newFlag()}
+		var err error
+		_, branch.Children[n.Key[matchlen]], err = t.insert(nil, n.Key[matchlen+1:], n.Val)
+		if err != nil {
+			return false, nil, err
+		}
+		_, branch.Children[key[matchlen]], err = t.insert(nil, key[matchlen+1:], value)
+		if err != nil {
+			return false, nil, err
+		}
+		// Replace this shortNode with the branch if it occurs at index 0.
+		if matchlen == 0 {
+			return true, branch, nil
+		}
+		// Otherwise, replace it with a short node leading up to the branch.
+		return true, &shortNode{key[:matchlen], branch, newFlag()}, nil
+
+	case *fullNode:
+		dirty, nn, err := t.insert(n.Children[key[0]], key[1:], value)
+		if !dirty || err != nil {
+			return false, n, err
+		}
+		n = n.copy()
+		n.flags = newFlag()
+		n.Children[key[0]] = nn
+		return true, n, nil
+
+	case nil:
+		return true, &shortNode{key, value, newFlag()}, nil
+
+	case hashNode:
+		// We've hit a part of the trie that isn't loaded yet. Load
+		// the node and insert into it. This leaves all child nodes on
+		// the path to the value in the trie.
+		rn, err := t.resolveHash(n)
+		if err != nil {
+			return false, nil, err
+		}
+		dirty, nn, err := t.insert(rn, key, value)
+		if !dirty || err != nil {
+			return false, rn, err
+		}
+		return true, nn, nil
+
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v", n, n))
+	}
+}
+
+// Delete removes any existing value for key from the trie.
+func (t *Trie) Delete(key []byte) error {
+	k := keybytesToHex(key)
+	_, n, err := t.delete(t.root, k)
+	if err != nil {
+		return err
+	}
+	t.root = n
+	return nil
+}
+
+// delete returns the new root of the trie with key deleted.
+// It reduces the trie to minimal form by simplifying
+// nodes on the way up after deleting recursively.
+func (t *Trie) delete(n node, key []byte) (bool, node, error) {
+	switch n := n.(type) {
+	case *shortNode:
+		matchlen := prefixLen(key, n.Key)
+		if matchlen < len(n.Key) {
+			return false, n, nil // don't replace n on mismatch
+		}
+		if matchlen == len(key) {
+			return true, nil, nil // remove n entirely for whole matches
+		}
+		// The key is longer than n.Key. Remove the remaining suffix
+		// from the subtrie. Child can never be nil here since the
+		// subtrie must contain at least two other values with keys
+		// longer than n.Key.
+		dirty, child, err := t.delete(n.Val, key[len(n.Key):])
+		if !dirty || err != nil {
+			return false, n, err
+		}
+		switch child := child.(type) {
+		case *shortNode:
+			// Deleting from the subtrie reduced it to another
+			// short node. Merge the nodes to avoid creating a
+			// shortNode{..., shortNode{...}}.
+			return true, &shortNode{concat(n.Key, child.Key...), child.Val, newFlag()}, nil
+		default:
+			return true, &shortNode{n.Key, child, newFlag()}, nil
+		}
+
+	case *fullNode:
+		dirty, nn, err := t.delete(n.Children[key[0]], key[1:])
+		if !dirty || err != nil {
+			return false, n, err
+		}
+		n = n.copy()
+		n.flags = newFlag()
+		n.Children[key[0]] = nn
+
+		// Because n is a full node, it must've contained at least two children
+		// before the delete operation. If the new child value is non-nil, n still
+		// has at least two children after the deletion, and cannot be reduced to
+		// a short node.
+		if nn != nil {
+			return true, n, nil
+		}
+		// Reduction:
+		// Check how many non-nil entries are left after deleting and
+		// reduce the full node to a short node if only one entry is
+		// left. Since n must've contained at least two children
+		// before deletion (otherwise it would not be a full node) n
+		// can never be reduced to nil.
+		//
+		// When the loop is done, pos contains the index of the single
+		// value that is left in n or -2 if n contains at least two
+		// values.
+		pos := -1
+		for i, cld := range &n.Children {
+			if cld != nil {
+				if pos == -1 {
+					pos = i
+				} else {
+					pos = -2
+					break
+				}
+			}
+		}
+		if pos >= 0 {
+			if pos != 16 {
+				// If the remaining entry is a short node, it replaces
+				// n and its key gets the missing nibble tacked to the
+				// front. This avoids creating an invalid
+				// shortNode{..., shortNode{...}}.  Since the entry
+				// might not be loaded yet, resolve it just for this
+				// check.
+				cnode, err := t.resolve(n.Children[pos])
+				if err != nil {
+					return false, nil, err
+				}
+				if cnode, ok := cnode.(*shortNode); ok {
+					k := append([]byte{byte(pos)}, cnode.Key...)
+					return true, &shortNode{k, cnode.Val, newFlag()}, nil
+				}
+			}
+			// Otherwise, n is replaced by a one-nibble short node
+			// containing the child.
+			return true, &shortNode{[]byte{byte(pos)}, n.Children[pos], newFlag()}, nil
+		}
+		// n still contains at least two values and cannot be reduced.
+		return true, n, nil
+
+	case valueNode:
+		return true, nil, nil
+
+	case nil:
+		return false, nil, nil
+
+	case hashNode:
+		// We've hit a part of the trie that isn't loaded yet. Load
+		// the node and delete from it. This leaves all child nodes on
+		// the path to the value in the trie.
+		rn, err := t.resolveHash(n)
+		if err != nil {
+			return false, nil, err
+		}
+		dirty, nn, err := t.delete(rn, key)
+		if !dirty || err != nil {
+			return false, rn, err
+		}
+		return true, nn, nil
+
+	default:
+		panic(fmt.Sprintf("%T: invalid node: %v (%v)", n, n, key))
+	}
+}
+
+func concat(s1 []byte, s2 ...byte) []byte {
+	r := make([]byte, len(s1)+len(s2))
+	copy(r, s1)
+	copy(r[len(s1):], s2)
+	return r
+}
+
+func newFlag() nodeFlag {
+	return nodeFlag{dirty: true}
+}
+
+func (t *Trie) resolve(n node) (node, error) {
+	if n, ok := n.(hashNode); ok {
+		return t.resolveHash(n)
+	}
+	return n, nil
+}
+
+// resolveHash loads the node with the given hash from the store.
+func (t *Trie) resolveHash(n hashNode) (node, error) {
+	hash := evm.BytesToHash(n)
+	if t.db == nil {
+		return nil, &MissingNodeError{NodeHash: hash, Err: ErrNotFound}
+	}
+	blob, err := t.db.Get(n)
+	if err != nil || len(blob) == 0 {
+		if err == nil {
+			err = ErrNotFound
+		}
+		return nil, &MissingNodeError{NodeHash: hash, Err: err}
+	}
+	return decodeNode(n, blob)
+}
+
+// Hash returns the root hash of the trie. It does not write to the
+// store and can be used even if the trie doesn't have one.
+func (t *Trie) Hash() evm.Hash {
+	if t.root == nil {
+		return EmptyRootHash
+	}
+	hashed, cached := hash(t.root, true)
+	t.root = cached
+	return evm.BytesToHash(hashed.(hashNode))
+}
+
+// Commit writes all modified nodes to the store and returns the root hash.
+// Afterwards the trie only holds a reference to its root, the nodes being
+// loaded back from the store when accessed.
+func (t *Trie) Commit() (evm.Hash, error) {
+	if t.db == nil {
+		return evm.Hash{}, fmt.Errorf("trie without store can't be committed")
+	}
+	root := t.Hash()
+	if t.root == nil {
+		return root, nil
+	}
+	if _, err := t.commit(t.root); err != nil {
+		return evm.Hash{}, err
+	}
+	t.root = hashNode(root[:])
+	return root, nil
+}
+
+// commit stores the dirty nodes of the subtrie and returns its reference,
+// the hash or the collapsed node itself if it is embedded in its parent.
+func (t *Trie) commit(n node) (node, error) {
+	hash, dirty := n.cache()
+	if hash != nil && !dirty {
+		return hash, nil
+	}
+	var (
+		collapsed node
+		err       error
+	)
+	switch n := n.(type) {
+	case *shortNode:
+		cn := n.copy()
+		if _, ok := n.Val.(valueNode); !ok {
+			if cn.Val, err = t.commit(n.Val); err != nil {
+				return nil, err
+			}
+		}
+		collapsed = cn
+	case *fullNode:
+		cn := n.copy()
+		for i := 0; i < 16; i++ {
+			if n.Children[i] == nil {
+				continue
+			}
+			if cn.Children[i], err = t.commit(n.Children[i]); err != nil {
+				return nil, err
+			}
+		}
+		collapsed = cn
+	default:
+		// hash and value nodes are stored as part of their parent
+		return n, nil
+	}
+	// Nodes smaller than a hash have no hash, they are embedded in their parent.
+	if hash == nil {
+		return collapsed, nil
+	}
+	if err := t.db.Put(hash, encodeNode(collapsed)); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
@@ -0,0 +1,247 @@
+// Copyright 2023 The evm Authors
+// This file is part of the evm library.
+//
+// The evm library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The evm library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the evm library. If not, see <http://www.gnu.org/licenses/>.
+
+package trie
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"math/rand"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	gethtrie "github.com/ethereum/go-ethereum/trie"
+	"github.com/lyonnee/evm"
+)
+
+func TestEmptyTrie(t *testing.T) {
+	var trie Trie
+	want := evm.BytesToHash(evm.FromHex("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421"))
+	if root := trie.Hash(); root != want {
+		t.Errorf("empty root mismatch: have %x, want %x", root, want)
+	}
+	if EmptyRootHash != want {
+		t.Errorf("EmptyRootHash mismatch: have %x, want %x", EmptyRootHash, want)
+	}
+}
+
+func TestInsert(t *testing.T) {
+	trie := NewEmpty(nil)
+	trie.Update([]byte("doe"), []byte("reindeer"))
+	trie.Update([]byte("dog"), []byte("puppy"))
+	trie.Update([]byte("dogglesworth"), []byte("cat"))
+
+	want := evm.BytesToHash(evm.FromHex("8aad789dff2f538bca5d8ea56e8abe10f4c7ba3a5dea95fea4cd6e7c3a1168d3"))
+	if root := trie.Hash(); root != want {
+		t.Errorf("root mismatch: have %x, want %x", root, want)
+	}
+
+}
+
+func TestGetDelete(t *testing.T) {
+	trie := NewEmpty(nil)
+	vals := map[string]string{
+		"do":     "verb",
+		"ether":  "wookiedoo",
+		"horse":  "stallion",
+		"shaman": "horse",
+		"doge":   "coin",
+		"dog":    "puppy",
+	}
+	for k, v := range vals {
+		trie.Update([]byte(k), []byte(v))
+	}
+	for k, v := range vals {
+		if have, _ := trie.Get([]byte(k)); string(have) != v {
+			t.Errorf("value mismatch for %q: have %q, want %q", k, have, v)
+		}
+	}
+	if have, _ := trie.Get([]byte("unknown")); have != nil {
+		t.Errorf("unexpected value %q", have)
+	}
+	for k := range vals {
+		trie.Delete([]byte(k))
+	}
+	if root := trie.Hash(); root != EmptyRootHash {
+		t.Errorf("root mismatch after deleting all: have %x, want %x", root, EmptyRootHash)
+	}
+}
+
+// TestCompareReference checks the roots against the go-ethereum
+// implementation for random insertions and deletions.
+func TestCompareReference(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	ref := gethtrie.NewEmpty(gethtrie.NewDatabase(rawdb.NewMemoryDatabase()))
+	trie := NewEmpty(NewMemoryStore())
+
+	var keys [][]byte
+	for i := 0; i < 2000; i++ {
+		var key []byte
+		if len(keys) > 0 && rng.Intn(4) == 0 {
+			key = keys[rng.Intn(len(keys))]
+		} else {
+			key = make([]byte, 1+rng.Intn(8))
+			rng.Read(key)
+			keys = append(keys, key)
+		}
+		// Short values end up embedded in their parents.
+		value := make([]byte, rng.Intn(40))
+		rng.Read(value)
+		ref.MustUpdate(key, value)
+		if err := trie.Update(key, value); err != nil {
+			t.Fatal(err)
+		}
+		if i%100 == 0 {
+			if _, err := trie.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if have, want := trie.Hash(), ref.Hash(); have != evm.Hash(want) {
+			t.Fatalf("root mismatch after op %d: have %x, want %x", i, have, want)
+		}
+	}
+}
+
+func TestCommitReopen(t *testing.T) {
+	db := NewMemoryStore()
+	trie := NewEmpty(db)
+	for i := byte(0); i < 100; i++ {
+		trie.Update([]byte{i, i}, bytes.Repeat([]byte{i}, 40))
+	}
+	root, err := trie.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() == 0 {
+		t.Fatal("no nodes committed")
+	}
+
+	reopened, err := New(root, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := byte(0); i < 100; i++ {
+		have, err := reopened.Get([]byte{i, i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(have, bytes.Repeat([]byte{i}, 40)) {
+			t.Errorf("value mismatch for key %d: %x", i, have)
+		}
+	}
+	reopened.Delete([]byte{7, 7})
+	trie.Delete([]byte{7, 7})
+	if reopened.Hash() != trie.Hash() {
+		t.Errorf("root mismatch after delete: have %x, want %x", reopened.Hash(), trie.Hash())
+	}
+}
+
+func TestMissingNode(t *testing.T) {
+	root := evm.Keccak256Hash([]byte("missing"))
+	_, err := New(root, NewMemoryStore())
+	var missing *MissingNodeError
+	if !errors.As(err, &missing) || missing.NodeHash != root {
+		t.Fatalf("expected missing node error, got %v", err)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected wrapped ErrNotFound, got %v", err)
+	}
+}
+
+func TestSecureTrieAccounts(t *testing.T) {
+	db := NewMemoryStore()
+	storage := NewEmptySecure(db)
+	slot := evm.BytesToHash([]byte{1})
+	storage.UpdateStorage(slot, evm.BytesToHash([]byte{0x2a}))
+	storageRoot, err := storage.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	accounts := NewEmptySecure(db)
+	addr := evm.BytesToAddr([]byte{0xaa})
+	account := NewStateAccount(evm.KeccakHasher{})
+	account.Nonce, account.Balance, account.Root = 1, big.NewInt(100), storageRoot
+	if err := accounts.UpdateAccount(addr, account); err != nil {
+		t.Fatal(err)
+	}
+	root, err := accounts.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewSecure(root, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	have, err := reopened.GetAccount(addr)
+	if err != nil || have == nil {
+		t.Fatalf("account not found: %v", err)
+	}
+	if have.Nonce != 1 || have.Balance.Cmp(big.NewInt(100)) != 0 || have.Root != storageRoot {
+		t.Errorf("account mismatch: %+v", have)
+	}
+	reopenedStorage, err := NewSecure(have.Root, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := reopenedStorage.GetStorage(slot); value != evm.BytesToHash([]byte{0x2a}) {
+		t.Errorf("storage mismatch: have %x", value)
+	}
+	if missing, _ := reopened.GetAccount(evm.BytesToAddr([]byte{0xbb})); missing != nil {
+		t.Errorf("unexpected account %+v", missing)
+	}
+}
+
+func TestEmptyCodeHasher(t *testing.T) {
+	var (
+		hasher = evm.NewHasher(sha256.New, 30, 6)
+		empty  = sha256.Sum256(nil)
+		db     = NewMemoryStore()
+	)
+	if have := NewStateAccount(hasher).CodeHash; !bytes.Equal(have, empty[:]) {
+		t.Errorf("empty code hash mismatch: have %x, want %x", have, empty)
+	}
+	if code, err := ReadCode(db, hasher, empty); err != nil || code != nil {
+		t.Errorf("empty code read: have %x (%v), want none", code, err)
+	}
+	if _, err := ReadCode(db, hasher, evm.HashWith(evm.KeccakHasher{}, nil)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("keccak empty code read: have %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestDeriveSha(t *testing.T) {
+	if root := DeriveSha(evm.Receipts{}); root != EmptyRootHash {
+		t.Errorf("empty list root mismatch: have %x, want %x", root, EmptyRootHash)
+	}
+	// Cross the single byte index keys boundary of the RLP encoding
+	var receipts evm.Receipts
+	for i := 0; i < 130; i++ {
+		receipts = append(receipts, &evm.Receipt{
+			Type:              uint8(i % 3),
+			Status:            uint64(i % 2),
+			CumulativeGasUsed: uint64(21000 * (i + 1)),
+			Logs:              []evm.Log{{Address: evm.BytesToAddr([]byte{byte(i)}), Data: []byte{byte(i)}}},
+		})
+	}
+	want := types.DeriveSha(receipts, gethtrie.NewStackTrie(nil))
+	if have := DeriveSha(receipts); !bytes.Equal(have.Bytes(), want.Bytes()) {
+		t.Errorf("root mismatch: have %x, want %x", have, want)
+	}
+}